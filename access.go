@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// AccessRules lists CIDRs (or single addresses) allowed or denied. Deny wins;
+// a non-empty allow list rejects every address it does not cover.
+type AccessRules struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+func (a AccessRules) Empty() bool {
+	return len(a.Allow) == 0 && len(a.Deny) == 0
+}
+
+type AccessList struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+func NewAccessList(rules AccessRules) (*AccessList, error) {
+	allow, err := parseCIDRs(rules.Allow)
+	if err != nil {
+		return nil, err
+	}
+	deny, err := parseCIDRs(rules.Deny)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AccessList{allow: allow, deny: deny}, nil
+}
+
+func (a *AccessList) Allows(ip net.IP) bool {
+	for _, network := range a.deny {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	if len(a.allow) == 0 {
+		return true
+	}
+	for _, network := range a.allow {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseCIDRs(values []string) ([]*net.IPNet, error) {
+	networks := []*net.IPNet{}
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if !strings.Contains(value, "/") {
+			if ip := net.ParseIP(value); ip != nil && ip.To4() != nil {
+				value += "/32"
+			} else {
+				value += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address or CIDR %q", value)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// AccessControl combines the server wide rules with the per stream rules; an
+// address has to pass both.
+type AccessControl struct {
+	global  *AccessList
+	streams map[string]*AccessList
+}
+
+// NewAccessControl builds the viewer (ingest false) or publisher (ingest true)
+// access control from params.
+func NewAccessControl(params *Params, ingest bool) (*AccessControl, error) {
+	rules := AccessRules{
+		Allow: splitList(params.viewerAllow),
+		Deny:  splitList(params.viewerDeny),
+	}
+	if ingest {
+		rules = AccessRules{
+			Allow: splitList(params.ingestAllow),
+			Deny:  splitList(params.ingestDeny),
+		}
+	}
+
+	global, err := NewAccessList(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	control := &AccessControl{
+		global:  global,
+		streams: make(map[string]*AccessList),
+	}
+	for _, stream := range params.streams {
+		streamRules := stream.ViewerAccess
+		if ingest {
+			streamRules = stream.IngestAccess
+		}
+		if streamRules.Empty() {
+			continue
+		}
+
+		list, err := NewAccessList(streamRules)
+		if err != nil {
+			return nil, fmt.Errorf("stream %s: %v", stream.Name, err)
+		}
+		control.streams[stream.Name] = list
+	}
+
+	return control, nil
+}
+
+// Allows reports whether the client of r may use stream. A nil AccessControl,
+// left behind by invalid rules, allows nobody.
+func (c *AccessControl) Allows(r *http.Request, stream string) bool {
+	if c == nil {
+		return false
+	}
+
+	return c.AllowsIP(net.ParseIP(hostname(r.RemoteAddr)), stream)
+}
+
+func (c *AccessControl) AllowsIP(ip net.IP, stream string) bool {
+	if c == nil || ip == nil {
+		return false
+	}
+	if !c.global.Allows(ip) {
+		return false
+	}
+	if list, ok := c.streams[stream]; ok {
+		return list.Allows(ip)
+	}
+	return true
+}
+
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,738 @@
+package main
+
+import (
+	"github.com/gorilla/mux"
+
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AdminHandler serves the management API. Every request needs the admin token
+// as a bearer token.
+type AdminHandler struct {
+	server *Server
+}
+
+type StreamStatus struct {
+	Name       string         `json:"name"`
+	Viewers    int            `json:"viewers"`
+	HasKey     bool           `json:"has_key"`
+	Publisher  *PublisherInfo `json:"publisher,omitempty"`
+	Thumbnail  string         `json:"thumbnail,omitempty"`
+	Video      *VideoInfo     `json:"video,omitempty"`
+	BitrateOut float64        `json:"bitrate_out"`
+
+	SlowClients *SlowClientCounters `json:"slow_clients,omitempty"`
+}
+
+type Ban struct {
+	IP       string    `json:"ip"`
+	Duration string    `json:"duration,omitempty"`
+	Until    time.Time `json:"until"`
+}
+
+type StreamKey struct {
+	Stream     string `json:"stream"`
+	Secret     string `json:"secret"`
+	IngestPath string `json:"ingest_path"`
+
+	// Set by rotations: the previous key keeps working until then.
+	PreviousValidUntil *time.Time `json:"previous_valid_until,omitempty"`
+}
+
+// defaultKeyGrace is how long a rotated-out key stays valid when the rotation
+// does not say.
+const defaultKeyGrace = 5 * time.Minute
+
+func NewAdminHandler(server *Server) *AdminHandler {
+	return &AdminHandler{server: server}
+}
+
+func (a *AdminHandler) Routes(r *mux.Router) {
+	r.Use(a.authenticate)
+	r.HandleFunc("/streams", a.ListStreams).Methods("GET")
+	r.HandleFunc("/streams/{stream}", a.GetStream).Methods("GET")
+	r.HandleFunc("/streams/{stream}/key", a.CreateKey).Methods("POST")
+	r.HandleFunc("/streams/{stream}/key", a.DeleteKey).Methods("DELETE")
+	r.HandleFunc("/streams/{stream}/key/rotate", a.RotateKey).Methods("POST")
+	r.HandleFunc("/streams/{stream}/publisher", a.KickPublisher).Methods("DELETE")
+	r.HandleFunc("/streams/{stream}/viewers", a.ListViewers).Methods("GET")
+	r.HandleFunc("/viewers", a.ListViewers).Methods("GET")
+	r.HandleFunc("/viewers/{id}", a.KickViewer).Methods("DELETE")
+	r.HandleFunc("/shards", a.ListShards).Methods("GET")
+	r.HandleFunc("/egress", a.GetEgress).Methods("GET")
+	r.HandleFunc("/occupancy", a.GetOccupancy).Methods("GET")
+	r.HandleFunc("/drain", a.GetDrain).Methods("GET")
+	r.HandleFunc("/drain", a.StartDrain).Methods("POST")
+	r.HandleFunc("/drain", a.StopDrain).Methods("DELETE")
+	r.HandleFunc("/memory", a.GetMemory).Methods("GET")
+	r.HandleFunc("/encoders", a.ListEncoders).Methods("GET")
+	r.HandleFunc("/restreams", a.ListRestreams).Methods("GET")
+	r.HandleFunc("/streams/{stream}/restreams", a.ListRestreams).Methods("GET")
+	r.HandleFunc("/streams/{stream}/restreams/{name}/start", a.StartRestream).Methods("POST")
+	r.HandleFunc("/streams/{stream}/restreams/{name}/stop", a.StopRestream).Methods("POST")
+	r.HandleFunc("/recordings", a.ListRecordings).Methods("GET")
+	r.HandleFunc("/recordings/{id}", a.GetRecording).Methods("GET")
+	r.HandleFunc("/recordings/{id}", a.StopRecording).Methods("DELETE")
+	r.HandleFunc("/streams/{stream}/recordings", a.StartRecording).Methods("POST")
+	r.HandleFunc("/schedules", a.ListSchedules).Methods("GET")
+	r.HandleFunc("/schedules/{id}", a.DeleteSchedule).Methods("DELETE")
+	r.HandleFunc("/streams/{stream}/schedules", a.CreateSchedule).Methods("POST")
+	r.HandleFunc("/cameras", a.ListCameras).Methods("GET")
+	r.HandleFunc("/cameras/attach", a.AttachCamera).Methods("POST")
+	r.HandleFunc("/bans", a.ListBans).Methods("GET")
+	r.HandleFunc("/bans", a.CreateBan).Methods("POST")
+	r.HandleFunc("/bans/{ip}", a.DeleteBan).Methods("DELETE")
+	r.HandleFunc("/events", a.StreamEvents).Methods("GET")
+	r.HandleFunc("/reload", a.Reload).Methods("POST")
+}
+
+func (a *AdminHandler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := a.server.currentParams().adminToken
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			writeJSONError(w, http.StatusUnauthorized, "invalid admin token")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Streams reports every stream that is configured, published or watched.
+func (a *AdminHandler) Streams() []StreamStatus {
+	viewers := a.server.websocketHandler.ViewerCounts()
+	publishers := a.server.incomingStreamHandler.publisherLock.Publishers()
+	keyed := a.server.incomingStreamHandler.KeyedStreams()
+
+	names := make(map[string]bool)
+	for _, stream := range a.server.currentParams().streams {
+		names[stream.Name] = true
+	}
+	for name := range viewers {
+		names[name] = true
+	}
+	for name := range publishers {
+		names[name] = true
+	}
+	for name := range keyed {
+		names[name] = true
+	}
+
+	streams := []StreamStatus{}
+	for name := range names {
+		status := StreamStatus{
+			Name:       name,
+			Viewers:    viewers[name],
+			HasKey:     keyed[name],
+			Thumbnail:  a.server.websocketHandler.thumbnailPath(name),
+			BitrateOut: a.server.websocketHandler.egress.Stream(name).Bitrate(),
+		}
+		if publisher, ok := publishers[name]; ok {
+			status.Publisher = &publisher
+		}
+		if video, ok := a.server.websocketHandler.media.Video(name); ok {
+			status.Video = &video
+		}
+		if counters, ok := a.server.websocketHandler.SlowClientCounters(name); ok {
+			status.SlowClients = &counters
+		}
+		streams = append(streams, status)
+	}
+	sort.Slice(streams, func(i, j int) bool {
+		return streams[i].Name < streams[j].Name
+	})
+
+	return streams
+}
+
+func (a *AdminHandler) ListStreams(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.Streams())
+}
+
+func (a *AdminHandler) GetStream(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["stream"]
+	for _, stream := range a.Streams() {
+		if stream.Name == name {
+			writeJSON(w, http.StatusOK, stream)
+			return
+		}
+	}
+
+	writeJSONError(w, http.StatusNotFound, "unknown stream")
+}
+
+// CreateKey gives the stream its own ingest secret, replacing the previous
+// one. The secret comes from the JSON body or is generated.
+func (a *AdminHandler) CreateKey(w http.ResponseWriter, r *http.Request) {
+	stream := mux.Vars(r)["stream"]
+
+	key := StreamKey{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&key); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+	}
+
+	key, err := a.SetKey(stream, key.Secret)
+	if err == errInvalidSecret {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	} else if err != nil {
+		writeJSONError(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, key)
+}
+
+// RotateKey replaces the stream's ingest secret while the old one keeps
+// working for {"grace": "10m"} (default 5m), and emits a stream_key_rotated
+// event carrying the new key.
+func (a *AdminHandler) RotateKey(w http.ResponseWriter, r *http.Request) {
+	stream := mux.Vars(r)["stream"]
+
+	req := struct {
+		Secret string `json:"secret"`
+		Grace  string `json:"grace"`
+	}{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+	}
+
+	grace := defaultKeyGrace
+	if req.Grace != "" {
+		var err error
+		if grace, err = time.ParseDuration(req.Grace); err != nil || grace < 0 {
+			writeJSONError(w, http.StatusBadRequest, "grace must be a duration such as 10m")
+			return
+		}
+	}
+
+	key, err := a.RotateStreamKey(stream, req.Secret, grace)
+	if err == errInvalidSecret {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	} else if err != nil {
+		writeJSONError(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, key)
+}
+
+func (a *AdminHandler) RotateStreamKey(stream, secret string, grace time.Duration) (StreamKey, error) {
+	secret, err := checkSecret(secret)
+	if err != nil {
+		return StreamKey{}, err
+	}
+
+	until, err := a.server.incomingStreamHandler.RotateStreamSecret(stream, secret, grace)
+	if err != nil {
+		return StreamKey{}, err
+	}
+
+	key := StreamKey{Stream: stream, Secret: secret, IngestPath: "/" + secret, PreviousValidUntil: &until}
+	a.server.events.Publish(Event{
+		Type:   EventStreamKeyRotated,
+		Stream: stream,
+		Data: map[string]string{
+			"secret":               key.Secret,
+			"ingest_path":          key.IngestPath,
+			"previous_valid_until": until.Format(time.RFC3339),
+		},
+	})
+
+	return key, nil
+}
+
+// StreamEvents sends admin events as server-sent events until the client goes
+// away or the server shuts down.
+func (a *AdminHandler) StreamEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	events := a.server.events.Subscribe()
+	defer a.server.events.Unsubscribe(events)
+	streaming(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			data, _ := json.Marshal(event)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (a *AdminHandler) DeleteKey(w http.ResponseWriter, r *http.Request) {
+	if !a.server.incomingStreamHandler.DeleteStreamSecret(mux.Vars(r)["stream"]) {
+		writeJSONError(w, http.StatusNotFound, "stream has no key")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *AdminHandler) KickPublisher(w http.ResponseWriter, r *http.Request) {
+	if !a.server.incomingStreamHandler.publisherLock.Kick(mux.Vars(r)["stream"]) {
+		writeJSONError(w, http.StatusNotFound, "stream has no publisher")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Viewers lists the viewers of stream, or of every stream when stream is "".
+func (a *AdminHandler) Viewers(stream string) []ViewerInfo {
+	viewers := []ViewerInfo{}
+	for _, viewer := range a.server.websocketHandler.Viewers() {
+		if stream == "" || viewer.Stream == stream {
+			viewers = append(viewers, viewer)
+		}
+	}
+	sort.Slice(viewers, func(i, j int) bool {
+		return viewers[i].Since.Before(viewers[j].Since)
+	})
+
+	return viewers
+}
+
+func (a *AdminHandler) ListViewers(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.Viewers(mux.Vars(r)["stream"]))
+}
+
+func (a *AdminHandler) KickViewer(w http.ResponseWriter, r *http.Request) {
+	if !a.server.websocketHandler.KickViewer(mux.Vars(r)["id"]) {
+		writeJSONError(w, http.StatusNotFound, "unknown viewer")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *AdminHandler) ListShards(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.server.websocketHandler.ShardStats())
+}
+
+func (a *AdminHandler) GetEgress(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.server.websocketHandler.egress.Status())
+}
+
+func (a *AdminHandler) GetOccupancy(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.server.websocketHandler.admission.Occupancy())
+}
+
+func (a *AdminHandler) GetDrain(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.server.DrainStatus())
+}
+
+// StartDrain stops taking viewers and publishers, disconnecting those left
+// after {"deadline": "5m"} if given. Draining again changes the deadline.
+func (a *AdminHandler) StartDrain(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		Deadline string `json:"deadline"`
+	}{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+	}
+
+	var deadline time.Duration
+	if req.Deadline != "" {
+		var err error
+		if deadline, err = time.ParseDuration(req.Deadline); err != nil || deadline <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "deadline must be a positive duration such as 5m")
+			return
+		}
+	}
+
+	a.server.Drain(deadline)
+	writeJSON(w, http.StatusAccepted, a.server.DrainStatus())
+}
+
+func (a *AdminHandler) StopDrain(w http.ResponseWriter, r *http.Request) {
+	if !a.server.websocketHandler.drain.Active() {
+		writeJSONError(w, http.StatusNotFound, "server is not draining")
+		return
+	}
+	a.server.Resume()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetMemory reports the memory in use, whether the server is shedding load
+// and what the buffers of every stream hold. With ?gc=1 it collects garbage
+// first, so figures taken apart can be compared.
+func (a *AdminHandler) GetMemory(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("gc") == "1" {
+		runtime.GC()
+	}
+	writeJSON(w, http.StatusOK, a.server.websocketHandler.memory.Status())
+}
+
+// Encoders lists the ffmpeg processes the server runs, by stream.
+func (a *AdminHandler) Encoders() []EncoderStatus {
+	encoders := a.server.incomingStreamHandler.Encoders()
+	sort.Slice(encoders, func(i, j int) bool {
+		return encoders[i].Stream < encoders[j].Stream
+	})
+
+	return encoders
+}
+
+func (a *AdminHandler) ListEncoders(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.Encoders())
+}
+
+// Restreams lists the restreams of stream, or of every stream when stream is
+// empty, by stream and name.
+func (a *AdminHandler) Restreams(stream string) []RestreamStatus {
+	restreams := []RestreamStatus{}
+	for _, restream := range a.server.incomingStreamHandler.Restreams(stream) {
+		restreams = append(restreams, restream.Status())
+	}
+	sort.Slice(restreams, func(i, j int) bool {
+		if restreams[i].Stream != restreams[j].Stream {
+			return restreams[i].Stream < restreams[j].Stream
+		}
+		return restreams[i].Name < restreams[j].Name
+	})
+
+	return restreams
+}
+
+func (a *AdminHandler) ListRestreams(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.Restreams(mux.Vars(r)["stream"]))
+}
+
+func (a *AdminHandler) StartRestream(w http.ResponseWriter, r *http.Request) {
+	a.controlRestream(w, r, (*Restream).Start)
+}
+
+func (a *AdminHandler) StopRestream(w http.ResponseWriter, r *http.Request) {
+	a.controlRestream(w, r, (*Restream).Stop)
+}
+
+func (a *AdminHandler) controlRestream(w http.ResponseWriter, r *http.Request, control func(*Restream)) {
+	vars := mux.Vars(r)
+	for _, restream := range a.server.incomingStreamHandler.Restreams(vars["stream"]) {
+		if restream.Status().Name == vars["name"] {
+			control(restream)
+			writeJSON(w, http.StatusOK, restream.Status())
+			return
+		}
+	}
+
+	writeJSONError(w, http.StatusNotFound, "unknown restream")
+}
+
+func (a *AdminHandler) ListRecordings(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.server.recorder.Recordings())
+}
+
+func (a *AdminHandler) GetRecording(w http.ResponseWriter, r *http.Request) {
+	recording, ok := a.server.recorder.Recording(mux.Vars(r)["id"])
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, errRecordingUnknown.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, recording)
+}
+
+// StartRecording records the stream on demand until the recording is
+// stopped, returning its ID and folder.
+func (a *AdminHandler) StartRecording(w http.ResponseWriter, r *http.Request) {
+	recording, err := a.server.recorder.StartRecording(mux.Vars(r)["stream"])
+	switch err {
+	case nil:
+		writeJSON(w, http.StatusCreated, recording)
+	case errRecordingExists:
+		writeJSONError(w, http.StatusConflict, fmt.Sprintf("%v as %s", err, recording.ID))
+	default:
+		writeJSONError(w, http.StatusServiceUnavailable, err.Error())
+	}
+}
+
+// StopRecording stops a recording on demand, returning its files once the
+// last one is complete.
+func (a *AdminHandler) StopRecording(w http.ResponseWriter, r *http.Request) {
+	recording, err := a.server.recorder.StopRecording(mux.Vars(r)["id"])
+	switch err {
+	case nil:
+		writeJSON(w, http.StatusOK, recording)
+	case errRecordingConfig:
+		writeJSONError(w, http.StatusConflict, err.Error())
+	default:
+		writeJSONError(w, http.StatusNotFound, err.Error())
+	}
+}
+
+func (a *AdminHandler) ListSchedules(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.server.recorder.Schedules())
+}
+
+// CreateSchedule records the stream from every time matching the cron
+// expression {"start": "0 9 * * 1-5", "duration": "9h"} for the duration.
+func (a *AdminHandler) CreateSchedule(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		Start    string `json:"start"`
+		Duration string `json:"duration"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	duration, err := time.ParseDuration(req.Duration)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "duration must be a duration such as 9h")
+		return
+	}
+
+	schedule, err := a.server.recorder.AddSchedule(mux.Vars(r)["stream"], RecordScheduleConfig{Start: req.Start, Duration: duration})
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, schedule)
+}
+
+func (a *AdminHandler) DeleteSchedule(w http.ResponseWriter, r *http.Request) {
+	if err := a.server.recorder.RemoveSchedule(mux.Vars(r)["id"]); err != nil {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListCameras probes the local network for ONVIF cameras, waiting
+// ?timeout=5s (default 3s) for answers.
+func (a *AdminHandler) ListCameras(w http.ResponseWriter, r *http.Request) {
+	timeout := defaultDiscoveryTimeout
+	if value := r.URL.Query().Get("timeout"); value != "" {
+		var err error
+		if timeout, err = time.ParseDuration(value); err != nil || timeout <= 0 || timeout > time.Minute {
+			writeJSONError(w, http.StatusBadRequest, "timeout must be a duration such as 5s, at most 1m")
+			return
+		}
+	}
+
+	cameras, err := DiscoverCameras(r.Context(), timeout)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	sort.Slice(cameras, func(i, j int) bool {
+		return cameras[i].From < cameras[j].From
+	})
+
+	writeJSON(w, http.StatusOK, cameras)
+}
+
+// CameraAttachment asks for a discovered camera to be pulled into a stream.
+type CameraAttachment struct {
+	XAddr     string `json:"xaddr"`
+	Stream    string `json:"stream"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+	Transport string `json:"transport"`
+}
+
+// AttachCamera looks up the RTSP URI of the camera at the ONVIF device
+// service {"xaddr": "..."} and starts pulling it into {"stream": "..."}.
+func (a *AdminHandler) AttachCamera(w http.ResponseWriter, r *http.Request) {
+	attachment := CameraAttachment{}
+	if err := json.NewDecoder(r.Body).Decode(&attachment); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if attachment.XAddr == "" || attachment.Stream == "" {
+		writeJSONError(w, http.StatusBadRequest, "xaddr and stream are required")
+		return
+	}
+	if attachment.Transport != "" && attachment.Transport != "tcp" && attachment.Transport != "udp" {
+		writeJSONError(w, http.StatusBadRequest, "transport must be tcp or udp")
+		return
+	}
+
+	status, err := a.AttachCameraSource(r.Context(), attachment)
+	if err == errStreamHasSource {
+		writeJSONError(w, http.StatusConflict, err.Error())
+		return
+	} else if err != nil {
+		writeJSONError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, status)
+}
+
+var errStreamHasSource = errors.New("stream already has an encoder")
+
+func (a *AdminHandler) AttachCameraSource(ctx context.Context, attachment CameraAttachment) (EncoderStatus, error) {
+	uri, err := CameraStreamURI(ctx, attachment.XAddr, attachment.Username, attachment.Password)
+	if err != nil {
+		return EncoderStatus{}, err
+	}
+
+	source := NewRTSPSource(attachment.Stream, uri, attachment.Transport, a.server.currentParams(), a.server.incomingStreamHandler)
+	if err := a.server.incomingStreamHandler.AddSource(source); err != nil {
+		return EncoderStatus{}, err
+	}
+
+	return source.Status(), nil
+}
+
+// Bans lists the active viewer bans, ending soonest first.
+func (a *AdminHandler) Bans() []Ban {
+	bans := []Ban{}
+	for ip, until := range a.server.websocketHandler.Bans() {
+		bans = append(bans, Ban{IP: ip, Until: until})
+	}
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].Until.Before(bans[j].Until)
+	})
+
+	return bans
+}
+
+func (a *AdminHandler) ListBans(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.Bans())
+}
+
+// CreateBan bans {"ip": "...", "duration": "1h"} from watching any stream
+// and disconnects its current viewers.
+func (a *AdminHandler) CreateBan(w http.ResponseWriter, r *http.Request) {
+	ban := Ban{}
+	if err := json.NewDecoder(r.Body).Decode(&ban); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	duration, err := time.ParseDuration(ban.Duration)
+	if err != nil || duration <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "duration must be a positive duration such as 1h")
+		return
+	}
+
+	ban, err = a.BanIP(ban.IP, duration)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, ban)
+}
+
+func (a *AdminHandler) BanIP(ip string, duration time.Duration) (Ban, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return Ban{}, fmt.Errorf("invalid IP address %q", ip)
+	}
+
+	ip = parsed.String()
+	until := a.server.websocketHandler.BanIP(ip, duration)
+
+	return Ban{IP: ip, Duration: duration.String(), Until: until}, nil
+}
+
+func (a *AdminHandler) DeleteBan(w http.ResponseWriter, r *http.Request) {
+	if !a.server.websocketHandler.UnbanIP(mux.Vars(r)["ip"]) {
+		writeJSONError(w, http.StatusNotFound, "address is not banned")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *AdminHandler) Reload(w http.ResponseWriter, r *http.Request) {
+	if err := a.server.Reload(); err != nil {
+		writeJSONError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+var errInvalidSecret = errors.New("secret must not contain '/'")
+
+// SetKey gives stream the ingest secret, or a generated one when secret is
+// empty.
+func (a *AdminHandler) SetKey(stream, secret string) (StreamKey, error) {
+	secret, err := checkSecret(secret)
+	if err != nil {
+		return StreamKey{}, err
+	}
+
+	if err := a.server.incomingStreamHandler.SetStreamSecret(stream, secret); err != nil {
+		return StreamKey{}, err
+	}
+
+	return StreamKey{Stream: stream, Secret: secret, IngestPath: "/" + secret}, nil
+}
+
+// checkSecret validates a requested ingest secret, generating one when it is
+// empty.
+func checkSecret(secret string) (string, error) {
+	if secret == "" {
+		return randomSecret(), nil
+	}
+	if strings.Contains(secret, "/") {
+		return "", errInvalidSecret
+	}
+
+	return secret, nil
+}
+
+func randomSecret() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+
+	return hex.EncodeToString(buf)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"github.com/chanshik/jsmpeg-stream-go/adminpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"context"
+	"crypto/subtle"
+	"strings"
+)
+
+// AdminGRPCServer offers the admin API over gRPC for orchestration tooling.
+// Calls carry the admin token as "authorization: Bearer <token>" metadata.
+type AdminGRPCServer struct {
+	adminpb.UnimplementedStreamAdminServer
+
+	admin *AdminHandler
+}
+
+func NewAdminGRPCServer(server *Server) *grpc.Server {
+	admin := &AdminGRPCServer{admin: NewAdminHandler(server)}
+
+	opts := []grpc.ServerOption{grpc.UnaryInterceptor(admin.authenticate)}
+	if tlsConfig := server.params.tlsConfig; tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	srv := grpc.NewServer(opts...)
+	adminpb.RegisterStreamAdminServer(srv, admin)
+
+	return srv
+}
+
+func (a *AdminGRPCServer) authenticate(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	token := a.admin.server.currentParams().adminToken
+
+	given := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get("authorization")) > 0 {
+		given = strings.TrimPrefix(md.Get("authorization")[0], "Bearer ")
+	}
+	if token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+		return nil, status.Error(codes.Unauthenticated, "invalid admin token")
+	}
+
+	return handler(ctx, req)
+}
+
+func (a *AdminGRPCServer) ListStreams(ctx context.Context, req *adminpb.ListStreamsRequest) (*adminpb.ListStreamsResponse, error) {
+	resp := &adminpb.ListStreamsResponse{}
+	for _, stream := range a.admin.Streams() {
+		resp.Streams = append(resp.Streams, streamProto(stream))
+	}
+
+	return resp, nil
+}
+
+func (a *AdminGRPCServer) GetStream(ctx context.Context, req *adminpb.GetStreamRequest) (*adminpb.Stream, error) {
+	for _, stream := range a.admin.Streams() {
+		if stream.Name == req.Stream {
+			return streamProto(stream), nil
+		}
+	}
+
+	return nil, status.Error(codes.NotFound, "unknown stream")
+}
+
+func (a *AdminGRPCServer) SetStreamKey(ctx context.Context, req *adminpb.SetStreamKeyRequest) (*adminpb.StreamKey, error) {
+	key, err := a.admin.SetKey(req.Stream, req.Secret)
+	if err == errInvalidSecret {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	} else if err != nil {
+		return nil, status.Error(codes.AlreadyExists, err.Error())
+	}
+
+	return &adminpb.StreamKey{Stream: key.Stream, Secret: key.Secret, IngestPath: key.IngestPath}, nil
+}
+
+func (a *AdminGRPCServer) RotateStreamKey(ctx context.Context, req *adminpb.RotateStreamKeyRequest) (*adminpb.StreamKey, error) {
+	grace := defaultKeyGrace
+	if req.Grace != nil {
+		grace = req.Grace.AsDuration()
+	}
+	if grace < 0 {
+		return nil, status.Error(codes.InvalidArgument, "grace must not be negative")
+	}
+
+	key, err := a.admin.RotateStreamKey(req.Stream, req.Secret, grace)
+	if err == errInvalidSecret {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	} else if err != nil {
+		return nil, status.Error(codes.AlreadyExists, err.Error())
+	}
+
+	return &adminpb.StreamKey{
+		Stream:             key.Stream,
+		Secret:             key.Secret,
+		IngestPath:         key.IngestPath,
+		PreviousValidUntil: timestamppb.New(*key.PreviousValidUntil),
+	}, nil
+}
+
+func (a *AdminGRPCServer) DeleteStreamKey(ctx context.Context, req *adminpb.DeleteStreamKeyRequest) (*adminpb.DeleteStreamKeyResponse, error) {
+	if !a.admin.server.incomingStreamHandler.DeleteStreamSecret(req.Stream) {
+		return nil, status.Error(codes.NotFound, "stream has no key")
+	}
+
+	return &adminpb.DeleteStreamKeyResponse{}, nil
+}
+
+func (a *AdminGRPCServer) KickPublisher(ctx context.Context, req *adminpb.KickPublisherRequest) (*adminpb.KickPublisherResponse, error) {
+	if !a.admin.server.incomingStreamHandler.publisherLock.Kick(req.Stream) {
+		return nil, status.Error(codes.NotFound, "stream has no publisher")
+	}
+
+	return &adminpb.KickPublisherResponse{}, nil
+}
+
+func (a *AdminGRPCServer) ListViewers(ctx context.Context, req *adminpb.ListViewersRequest) (*adminpb.ListViewersResponse, error) {
+	resp := &adminpb.ListViewersResponse{}
+	for _, viewer := range a.admin.Viewers(req.Stream) {
+		resp.Viewers = append(resp.Viewers, &adminpb.Viewer{
+			Id:         viewer.ID,
+			Stream:     viewer.Stream,
+			RemoteAddr: viewer.RemoteAddr,
+			Since:      timestamppb.New(viewer.Since),
+		})
+	}
+
+	return resp, nil
+}
+
+func (a *AdminGRPCServer) KickViewer(ctx context.Context, req *adminpb.KickViewerRequest) (*adminpb.KickViewerResponse, error) {
+	if !a.admin.server.websocketHandler.KickViewer(req.Id) {
+		return nil, status.Error(codes.NotFound, "unknown viewer")
+	}
+
+	return &adminpb.KickViewerResponse{}, nil
+}
+
+func (a *AdminGRPCServer) ListBans(ctx context.Context, req *adminpb.ListBansRequest) (*adminpb.ListBansResponse, error) {
+	resp := &adminpb.ListBansResponse{}
+	for _, ban := range a.admin.Bans() {
+		resp.Bans = append(resp.Bans, &adminpb.Ban{Ip: ban.IP, Until: timestamppb.New(ban.Until)})
+	}
+
+	return resp, nil
+}
+
+func (a *AdminGRPCServer) BanAddress(ctx context.Context, req *adminpb.BanAddressRequest) (*adminpb.Ban, error) {
+	duration := req.Duration.AsDuration()
+	if duration <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "duration must be positive")
+	}
+
+	ban, err := a.admin.BanIP(req.Ip, duration)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return &adminpb.Ban{Ip: ban.IP, Until: timestamppb.New(ban.Until)}, nil
+}
+
+func (a *AdminGRPCServer) UnbanAddress(ctx context.Context, req *adminpb.UnbanAddressRequest) (*adminpb.UnbanAddressResponse, error) {
+	if !a.admin.server.websocketHandler.UnbanIP(req.Ip) {
+		return nil, status.Error(codes.NotFound, "address is not banned")
+	}
+
+	return &adminpb.UnbanAddressResponse{}, nil
+}
+
+func (a *AdminGRPCServer) Reload(ctx context.Context, req *adminpb.ReloadRequest) (*adminpb.ReloadResponse, error) {
+	if err := a.admin.server.Reload(); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+
+	return &adminpb.ReloadResponse{}, nil
+}
+
+func streamProto(stream StreamStatus) *adminpb.Stream {
+	msg := &adminpb.Stream{
+		Name:    stream.Name,
+		Viewers: int32(stream.Viewers),
+		HasKey:  stream.HasKey,
+	}
+	if publisher := stream.Publisher; publisher != nil {
+		msg.Publisher = &adminpb.Publisher{
+			RemoteAddr: publisher.RemoteAddr,
+			Since:      timestamppb.New(publisher.Since),
+			BytesIn:    publisher.BytesIn,
+			BitrateIn:  publisher.BitrateIn,
+		}
+	}
+
+	return msg
+}
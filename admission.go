@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// admissionRetryAfter is the Retry-After, in seconds, sent to viewers turned
+// away because their stream or the server is full.
+const admissionRetryAfter = 10
+
+// ViewerAdmission caps the viewers of the server and of each stream, counting
+// WebSocket, SSE and HTTP viewers from before their connection is set up, so
+// concurrent upgrades cannot overshoot a limit. A limit of 0 is no limit.
+type ViewerAdmission struct {
+	maxViewers int
+	streamMax  map[string]int // stream name -> limit of its own
+
+	total   int
+	streams map[string]int // stream name -> viewers admitted
+	lock    sync.Mutex
+}
+
+// Occupancy reports the admitted viewers against the limits for the admin
+// API.
+type Occupancy struct {
+	Viewers    int                        `json:"viewers"`
+	MaxViewers int                        `json:"max_viewers"`
+	Streams    map[string]StreamOccupancy `json:"streams"`
+}
+
+type StreamOccupancy struct {
+	Viewers    int `json:"viewers"`
+	MaxViewers int `json:"max_viewers,omitempty"`
+}
+
+// admissionError is the body of the response to a viewer turned away.
+type admissionError struct {
+	Error      string `json:"error"`
+	Stream     string `json:"stream"`
+	Viewers    int    `json:"viewers"`
+	MaxViewers int    `json:"max_viewers"`
+}
+
+func NewViewerAdmission(params *Params) *ViewerAdmission {
+	admission := &ViewerAdmission{streams: make(map[string]int)}
+	admission.ApplyParams(params)
+
+	return admission
+}
+
+// ApplyParams changes the limits; viewers already admitted stay, even over a
+// lowered limit.
+func (a *ViewerAdmission) ApplyParams(params *Params) {
+	streamMax := make(map[string]int)
+	for _, stream := range params.streams {
+		if stream.MaxViewers != 0 {
+			streamMax[stream.Name] = stream.MaxViewers
+		}
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.maxViewers = params.maxViewers
+	a.streamMax = streamMax
+}
+
+// Acquire admits a viewer of stream unless the stream or the server is full.
+// Otherwise it returns the response for the viewer. Every successful Acquire
+// must be paired with a Release.
+func (a *ViewerAdmission) Acquire(stream string) (*admissionError, bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if max := a.streamMax[stream]; max != 0 && a.streams[stream] >= max {
+		return &admissionError{Error: "stream is full", Stream: stream, Viewers: a.streams[stream], MaxViewers: max}, false
+	}
+	if a.maxViewers != 0 && a.total >= a.maxViewers {
+		return &admissionError{Error: "server is full", Stream: stream, Viewers: a.total, MaxViewers: a.maxViewers}, false
+	}
+	a.total++
+	a.streams[stream]++
+
+	return nil, true
+}
+
+func (a *ViewerAdmission) Release(stream string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.total--
+	if a.streams[stream]--; a.streams[stream] <= 0 {
+		delete(a.streams, stream)
+	}
+}
+
+// Occupancy reports the server and every stream with viewers or a limit.
+func (a *ViewerAdmission) Occupancy() Occupancy {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	occupancy := Occupancy{
+		Viewers:    a.total,
+		MaxViewers: a.maxViewers,
+		Streams:    make(map[string]StreamOccupancy),
+	}
+	for stream, viewers := range a.streams {
+		occupancy.Streams[stream] = StreamOccupancy{Viewers: viewers, MaxViewers: a.streamMax[stream]}
+	}
+	for stream, max := range a.streamMax {
+		occupancy.Streams[stream] = StreamOccupancy{Viewers: a.streams[stream], MaxViewers: max}
+	}
+	return occupancy
+}
+
+// admit acquires a place for a viewer of stream, answering the request with
+// 503 and the occupancy when there is none, or while the server is draining.
+func (h *WebSocketHandler) admit(w http.ResponseWriter, r *http.Request, stream string) bool {
+	if h.drain.Active() {
+		h.logger.Printf("Viewer %s rejected: %v\n", r.RemoteAddr, errDraining)
+		w.Header().Set("Retry-After", strconv.Itoa(admissionRetryAfter))
+		writeJSONError(w, http.StatusServiceUnavailable, errDraining.Error())
+		return false
+	}
+	if h.memory != nil && h.memory.Pressure() {
+		h.logger.Printf("Viewer %s rejected: %v\n", r.RemoteAddr, errMemoryPressure)
+		w.Header().Set("Retry-After", strconv.Itoa(admissionRetryAfter))
+		writeJSONError(w, http.StatusServiceUnavailable, errMemoryPressure.Error())
+		return false
+	}
+	full, ok := h.admission.Acquire(stream)
+	if !ok {
+		h.logger.Printf("Viewer %s rejected: %s (%d of %d viewers)\n", r.RemoteAddr, full.Error, full.Viewers, full.MaxViewers)
+		w.Header().Set("Retry-After", strconv.Itoa(admissionRetryAfter))
+		writeJSON(w, http.StatusServiceUnavailable, full)
+	}
+	return ok
+}
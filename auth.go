@@ -0,0 +1,150 @@
+package main
+
+import (
+	"github.com/golang-jwt/jwt/v5"
+
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// jwtSubprotocol is the Sec-WebSocket-Protocol value browsers send in front of
+// the token, as in new WebSocket(url, ["jwt", token]).
+const jwtSubprotocol = "jwt"
+
+// ViewerAuthenticator validates the HMAC signed JWT a viewer presents on the
+// WebSocket upgrade.
+type ViewerAuthenticator struct {
+	key         []byte
+	streamClaim string
+	parser      *jwt.Parser
+}
+
+// NewViewerAuthenticator returns nil when no signing key is configured, which
+// leaves the WebSocket endpoint open.
+func NewViewerAuthenticator(params *Params) *ViewerAuthenticator {
+	if params.jwtKey == "" {
+		return nil
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"HS256", "HS384", "HS512"}),
+		jwt.WithExpirationRequired(),
+	}
+	if params.jwtIssuer != "" {
+		opts = append(opts, jwt.WithIssuer(params.jwtIssuer))
+	}
+	if params.jwtAudience != "" {
+		opts = append(opts, jwt.WithAudience(params.jwtAudience))
+	}
+
+	return &ViewerAuthenticator{
+		key:         []byte(params.jwtKey),
+		streamClaim: params.jwtStreamClaim,
+		parser:      jwt.NewParser(opts...),
+	}
+}
+
+// Authorize checks the token from the "token" query parameter or the
+// Sec-WebSocket-Protocol header against stream. It returns the session limit
+// of the token and the subprotocol the upgrade response has to confirm, if
+// any.
+func (a *ViewerAuthenticator) Authorize(r *http.Request, stream string) (SessionLimit, string, error) {
+	tokenString, subprotocol := viewerToken(r)
+	if tokenString == "" {
+		return SessionLimit{}, "", fmt.Errorf("no token")
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := a.parser.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return a.key, nil
+	})
+	if err != nil {
+		return SessionLimit{}, "", err
+	}
+
+	if a.streamClaim != "" {
+		allowed, _ := claims[a.streamClaim].(string)
+		if allowed != "*" && allowed != stream {
+			return SessionLimit{}, "", fmt.Errorf("token is not valid for stream %s", stream)
+		}
+	}
+
+	limit, err := sessionLimit(tokenString, claims)
+	if err != nil {
+		return SessionLimit{}, "", err
+	}
+
+	return limit, subprotocol, nil
+}
+
+// sessionLimit reads the max_sessions, session_policy and max_bitrate claims.
+// Sessions are counted per jti, or per token when it has none.
+func sessionLimit(tokenString string, claims jwt.MapClaims) (SessionLimit, error) {
+	limit := SessionLimit{}
+	if value, ok := claims[maxSessionsClaim]; ok {
+		max, ok := value.(float64)
+		if !ok || max < 1 || max != float64(int(max)) {
+			return limit, fmt.Errorf("invalid %s claim", maxSessionsClaim)
+		}
+		limit.MaxSessions = int(max)
+	}
+
+	if value, ok := claims[maxBitrateClaim]; ok {
+		bitrate, ok := value.(float64)
+		if !ok || bitrate < 1 || bitrate != float64(int64(bitrate)) {
+			return limit, fmt.Errorf("invalid %s claim", maxBitrateClaim)
+		}
+		limit.MaxBitrate = int64(bitrate)
+	}
+
+	switch policy, _ := claims[sessionPolicyClaim].(string); policy {
+	case "", "reject":
+	case "displace":
+		limit.Displace = true
+	default:
+		return limit, fmt.Errorf("invalid %s claim %q", sessionPolicyClaim, policy)
+	}
+
+	if jti, _ := claims["jti"].(string); jti != "" {
+		limit.TokenID = jti
+	} else {
+		sum := sha256.Sum256([]byte(tokenString))
+		limit.TokenID = hex.EncodeToString(sum[:])
+	}
+
+	return limit, nil
+}
+
+func viewerToken(r *http.Request) (string, string) {
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token, ""
+	}
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && token != "" {
+		return token, ""
+	}
+
+	protocols := websocketSubprotocols(r)
+	for i, protocol := range protocols {
+		if protocol == jwtSubprotocol && i+1 < len(protocols) {
+			return protocols[i+1], jwtSubprotocol
+		}
+	}
+
+	return "", ""
+}
+
+func websocketSubprotocols(r *http.Request) []string {
+	protocols := []string{}
+	for _, header := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(header, ",") {
+			if protocol = strings.TrimSpace(protocol); protocol != "" {
+				protocols = append(protocols, protocol)
+			}
+		}
+	}
+
+	return protocols
+}
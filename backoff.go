@@ -0,0 +1,65 @@
+package main
+
+import (
+	"time"
+)
+
+// Default reconnect policy of sources that connect to a remote publisher.
+const (
+	defaultRetryMinDelay = time.Second
+	defaultRetryMaxDelay = 30 * time.Second
+)
+
+// RetryConfig is the reconnect policy of a source: the delay starts at
+// MinDelay and doubles after every failed attempt up to MaxDelay. The source
+// gives up once MaxAttempts reconnects in a row have failed, or never when it
+// is 0.
+type RetryConfig struct {
+	MinDelay    time.Duration `yaml:"min_delay"`
+	MaxDelay    time.Duration `yaml:"max_delay"`
+	MaxAttempts int           `yaml:"max_attempts"`
+}
+
+// Backoff hands out the delays of a RetryConfig.
+type Backoff struct {
+	policy   RetryConfig
+	delay    time.Duration
+	failures int
+}
+
+func NewBackoff(policy RetryConfig) *Backoff {
+	if policy.MinDelay <= 0 {
+		policy.MinDelay = defaultRetryMinDelay
+	}
+	if policy.MaxDelay <= 0 {
+		policy.MaxDelay = defaultRetryMaxDelay
+	}
+	if policy.MaxDelay < policy.MinDelay {
+		policy.MaxDelay = policy.MinDelay
+	}
+
+	return &Backoff{policy: policy, delay: policy.MinDelay}
+}
+
+// Reset starts over at the minimum delay after an attempt that worked.
+func (b *Backoff) Reset() {
+	b.delay = b.policy.MinDelay
+	b.failures = 0
+}
+
+// Next returns the delay before the next attempt, or false once the
+// attempts are used up. Every call counts as a failure until Reset.
+func (b *Backoff) Next() (time.Duration, bool) {
+	b.failures++
+	if b.policy.MaxAttempts > 0 && b.failures > b.policy.MaxAttempts {
+		return 0, false
+	}
+
+	delay := b.delay
+	b.delay *= 2
+	if b.delay > b.policy.MaxDelay {
+		b.delay = b.policy.MaxDelay
+	}
+
+	return delay, true
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// BanList keeps the viewer addresses banned by an operator, each until its
+// ban expires. Bans live in memory and are gone after a restart.
+type BanList struct {
+	bans map[string]time.Time // ip -> end of the ban
+	lock sync.Mutex
+}
+
+func NewBanList() *BanList {
+	return &BanList{bans: make(map[string]time.Time)}
+}
+
+func (b *BanList) Ban(ip string, duration time.Duration) time.Time {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	until := time.Now().Add(duration)
+	b.bans[ip] = until
+
+	return until
+}
+
+// Unban lifts the ban on ip and reports whether there was one.
+func (b *BanList) Unban(ip string) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	_, ok := b.bans[ip]
+	delete(b.bans, ip)
+
+	return ok
+}
+
+func (b *BanList) Banned(ip string) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	until, ok := b.bans[ip]
+	if ok && time.Now().After(until) {
+		delete(b.bans, ip)
+		return false
+	}
+
+	return ok
+}
+
+// Bans returns the active bans.
+func (b *BanList) Bans() map[string]time.Time {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := time.Now()
+	bans := make(map[string]time.Time)
+	for ip, until := range b.bans {
+		if now.After(until) {
+			delete(b.bans, ip)
+			continue
+		}
+		bans[ip] = until
+	}
+
+	return bans
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// bufferPoolMaxSize bounds the capacity of a buffer kept in a BufferPool, so
+// one unusually large chunk does not stay around for good.
+const bufferPoolMaxSize = maxPublishMessageSize
+
+// Buffer is broadcast data shared by every viewer it is queued for. Whoever
+// holds a Buffer releases it once done with its bytes; the last release
+// returns a pooled Buffer to its pool, after which the bytes must not be
+// used. A Buffer from NewBuffer belongs to no pool and is left to the GC.
+type Buffer struct {
+	data  []byte
+	stamp time.Time // when the Buffer was made, i.e. its data broadcast
+	refs  atomic.Int32
+	pool  *BufferPool
+}
+
+// NewBuffer wraps data, which must not change afterwards, in a Buffer that
+// belongs to no pool.
+func NewBuffer(data []byte) *Buffer {
+	return &Buffer{data: data, stamp: time.Now()}
+}
+
+func (b *Buffer) Bytes() []byte {
+	return b.data
+}
+
+// Retain adds a holder, who has to call Release in turn.
+func (b *Buffer) Retain() {
+	if b.pool != nil {
+		b.refs.Add(1)
+	}
+}
+
+// Release drops a holder, returning the Buffer to its pool when it was the
+// last one.
+func (b *Buffer) Release() {
+	if b.pool != nil && b.refs.Add(-1) == 0 {
+		b.pool.put(b)
+	}
+}
+
+// BufferPool recycles the Buffers chunks are broadcast in, so that neither
+// the publisher's chunks nor the viewers' queues allocate at the stream's
+// rate.
+type BufferPool struct {
+	pool sync.Pool
+}
+
+func NewBufferPool() *BufferPool {
+	return &BufferPool{}
+}
+
+// Copy returns a Buffer holding a copy of data, with the caller as its only
+// holder.
+func (p *BufferPool) Copy(data []byte) *Buffer {
+	b, _ := p.pool.Get().(*Buffer)
+	if b == nil {
+		b = &Buffer{pool: p}
+	}
+	b.data = append(b.data[:0], data...)
+	b.stamp = time.Now()
+	b.refs.Store(1)
+
+	return b
+}
+
+func (p *BufferPool) put(b *Buffer) {
+	if cap(b.data) > bufferPoolMaxSize {
+		return
+	}
+	p.pool.Put(b)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"github.com/gorilla/websocket"
+
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// chaosSettings make the server a bad network on purpose, so the player can
+// be tested against one: every message to a WebSocket viewer is sent latency
+// plus up to jitter after it was broadcast, and with the given probabilities
+// is dropped or sent after the message that follows it.
+type chaosSettings struct {
+	latency time.Duration
+	jitter  time.Duration
+	drop    float64
+	reorder float64
+}
+
+func newChaosSettings(params *Params) chaosSettings {
+	return chaosSettings{
+		latency: params.chaosLatency,
+		jitter:  params.chaosJitter,
+		drop:    params.chaosDrop,
+		reorder: params.chaosReorder,
+	}
+}
+
+func validChaosSettings(params *Params) error {
+	if params.chaosLatency < 0 || params.chaosJitter < 0 {
+		return fmt.Errorf("-chaos-latency and -chaos-jitter must not be negative")
+	}
+	if params.chaosDrop < 0 || params.chaosDrop > 1 || params.chaosReorder < 0 || params.chaosReorder > 1 {
+		return fmt.Errorf("-chaos-drop and -chaos-reorder must be between 0 and 1")
+	}
+	return nil
+}
+
+func (s chaosSettings) enabled() bool {
+	return s != chaosSettings{}
+}
+
+// chaos returns the chaos settings for a new viewer.
+func (h *WebSocketHandler) chaos() chaosSettings {
+	h.settingsLock.RLock()
+	defer h.settingsLock.RUnlock()
+
+	return h.chaosSettings
+}
+
+// writeMessage sends data, broadcast at stamp, to the viewer as one binary
+// message, through the chaos settings if there are any, except for the first
+// message, which may be the jsmpeg header. The delay counts from stamp, so
+// that the messages queued meanwhile wait alongside rather than after each
+// other and the viewer still gets the stream's full throughput. A message
+// held back to be reordered is copied, as data is reused once this returns.
+func (c *Client) writeMessage(data []byte, stamp time.Time) error {
+	if !c.chaos.enabled() || !c.written {
+		return c.ws.WriteMessage(websocket.BinaryMessage, data)
+	}
+
+	due := stamp.Add(c.chaos.latency)
+	if c.chaos.jitter > 0 {
+		due = due.Add(time.Duration(rand.Int63n(int64(c.chaos.jitter))))
+	}
+	if delay := time.Until(due); delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-c.quit:
+			timer.Stop()
+		case <-c.readDone:
+			timer.Stop()
+		}
+	}
+	if rand.Float64() < c.chaos.drop {
+		return nil
+	}
+	if c.held == nil && rand.Float64() < c.chaos.reorder {
+		c.held = append([]byte{}, data...)
+		return nil
+	}
+
+	c.ws.SetWriteDeadline(c.writeDeadline())
+	if err := c.ws.WriteMessage(websocket.BinaryMessage, data); err != nil {
+		return err
+	}
+	if held := c.held; held != nil {
+		c.held = nil
+		return c.ws.WriteMessage(websocket.BinaryMessage, held)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"io"
+)
+
+// defaultIngestChunkSize is the default upper bound of one broadcast chunk,
+// 174 TS packets.
+const defaultIngestChunkSize = 174 * tsPacketSize
+
+// ChunkReader splits a publisher's MPEG-TS into chunks of whole TS packets.
+// It reads into one reused buffer and hands out chunks from another, so
+// reading allocates nothing however large the publisher's writes are; a
+// chunk is only valid until the next call. Bytes before a sync byte are
+// dropped to find the packet boundaries again.
+type ChunkReader struct {
+	reader  io.Reader
+	buf     []byte
+	chunk   []byte
+	pending int
+	err     error
+}
+
+// NewChunkReader returns chunks of at most chunkSize bytes, rounded down to
+// whole packets.
+func NewChunkReader(reader io.Reader, chunkSize int) *ChunkReader {
+	packets := chunkSize / tsPacketSize
+	if packets < 1 {
+		packets = 1
+	}
+
+	return &ChunkReader{
+		reader: reader,
+		buf:    make([]byte, packets*tsPacketSize),
+		chunk:  make([]byte, packets*tsPacketSize),
+	}
+}
+
+// Next returns the whole packets available after at most a few reads. Once
+// the publisher is gone it returns what is left, then the read error. The
+// chunk is overwritten by the next call.
+func (c *ChunkReader) Next() ([]byte, error) {
+	for {
+		c.resync()
+		if n := c.pending / tsPacketSize * tsPacketSize; n > 0 {
+			return c.take(n), nil
+		}
+		if c.err != nil {
+			if c.pending > 0 {
+				return c.take(c.pending), nil
+			}
+			return nil, c.err
+		}
+
+		n, err := c.reader.Read(c.buf[c.pending:])
+		c.pending += n
+		c.err = err
+	}
+}
+
+// resync drops the bytes in front of the first sync byte.
+func (c *ChunkReader) resync() {
+	if c.pending == 0 || c.buf[0] == tsSyncByte {
+		return
+	}
+
+	skip := bytes.IndexByte(c.buf[:c.pending], tsSyncByte)
+	if skip < 0 {
+		skip = c.pending
+	}
+	c.pending = copy(c.buf, c.buf[skip:c.pending])
+}
+
+// take copies out the first n pending bytes and moves the rest to the front
+// of the buffer.
+func (c *ChunkReader) take(n int) []byte {
+	chunk := c.chunk[:n]
+	copy(chunk, c.buf)
+	c.pending = copy(c.buf, c.buf[n:c.pending])
+
+	return chunk
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// ClientCertRule limits the streams an encoder certificate may publish to.
+// The certificate matches by subject common name or by one of its DNS names.
+type ClientCertRule struct {
+	Name    string   `yaml:"name"`
+	Streams []string `yaml:"streams"`
+}
+
+func (c ClientCertRule) Matches(cert *x509.Certificate) bool {
+	if cert.Subject.CommonName == c.Name {
+		return true
+	}
+	for _, name := range cert.DNSNames {
+		if name == c.Name {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Params) loadIngestClientCAs() error {
+	if p.ingestClientCA == "" {
+		return nil
+	}
+	if p.tlsConfig == nil {
+		return fmt.Errorf("-ingest-client-ca requires TLS")
+	}
+
+	pem, err := os.ReadFile(p.ingestClientCA)
+	if err != nil {
+		return err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("%s contains no PEM certificates", p.ingestClientCA)
+	}
+	p.ingestClientCAs = pool
+
+	return nil
+}
+
+// IngestTLSConfig returns the TLS configuration of the ingest listener. With a
+// client CA a dedicated listener requires a certificate from every publisher;
+// a listener shared with viewers only verifies certificates that are offered
+// and leaves the requirement to the ingest handler.
+func (p *Params) IngestTLSConfig(shared bool) *tls.Config {
+	if p.tlsConfig == nil || p.ingestClientCAs == nil {
+		return p.tlsConfig
+	}
+
+	config := p.tlsConfig.Clone()
+	config.ClientCAs = p.ingestClientCAs
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	if shared {
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return config
+}
+
+// CheckClientCert makes sure r comes with a verified client certificate that
+// may publish to stream. Without client certificate rules any certificate
+// signed by the CA may publish to any stream.
+func (s *IncomingStreamHandler) CheckClientCert(r *http.Request, stream string) error {
+	s.secretsLock.RLock()
+	required := s.requireClientCert
+	rules := s.clientCertRules
+	s.secretsLock.RUnlock()
+
+	if !required {
+		return nil
+	}
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return fmt.Errorf("client certificate required")
+	}
+
+	cert := r.TLS.VerifiedChains[0][0]
+	if len(rules) == 0 {
+		return nil
+	}
+	for _, rule := range rules {
+		if !rule.Matches(cert) {
+			continue
+		}
+		for _, name := range rule.Streams {
+			if name == stream {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("certificate %s may not publish to %s", cert.Subject.CommonName, stream)
+}
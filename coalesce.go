@@ -0,0 +1,97 @@
+package main
+
+import (
+	"time"
+)
+
+// defaultCoalesceSize is the default upper bound of a coalesced message.
+const defaultCoalesceSize = 64 * 1024
+
+// coalesceSettings say how long a viewer's writer holds a chunk to send it
+// together with the chunks queued after it, and how large the message may
+// grow. A flush interval of 0 writes every chunk on its own.
+type coalesceSettings struct {
+	interval time.Duration
+	size     int
+	streams  map[string]coalesceSettings // stream name -> settings overriding these
+}
+
+func newCoalesceSettings(params *Params) coalesceSettings {
+	settings := coalesceSettings{
+		interval: params.wsFlushInterval,
+		size:     params.wsCoalesceSize,
+		streams:  make(map[string]coalesceSettings),
+	}
+	for _, stream := range params.streams {
+		if stream.FlushInterval == nil && stream.CoalesceSize == 0 {
+			continue
+		}
+		override := coalesceSettings{interval: settings.interval, size: settings.size}
+		if stream.FlushInterval != nil {
+			override.interval = *stream.FlushInterval
+		}
+		if stream.CoalesceSize != 0 {
+			override.size = stream.CoalesceSize
+		}
+		settings.streams[stream.Name] = override
+	}
+
+	return settings
+}
+
+// coalescing returns the flush interval and message size cap of the viewers
+// of stream.
+func (h *WebSocketHandler) coalescing(stream string) (time.Duration, int) {
+	h.settingsLock.RLock()
+	defer h.settingsLock.RUnlock()
+
+	settings, ok := h.coalesce.streams[stream]
+	if !ok {
+		settings = h.coalesce
+	}
+	return settings.interval, settings.size
+}
+
+// coalesces reports whether the client's chunks are coalesced. Only plain
+// MPEG-TS can be, as every sequenced or fMP4 message has to arrive on its
+// own, and never the first message, which may be the jsmpeg header.
+func (c *Client) coalesces() bool {
+	return c.flushInterval > 0 && c.format == formatTS && c.framing == framingNone && c.written
+}
+
+// writeCoalesced sends first together with the chunks that come within the
+// flush interval, up to the size cap, as one message. It stops early when
+// the client is closed or the viewer gone, leaving the rest to be drained.
+func (c *Client) writeCoalesced(first *Buffer) error {
+	c.batch = append(c.batch[:0], first.Bytes()...)
+	stamp := first.stamp
+	first.Release()
+
+	timer := time.NewTimer(c.flushInterval)
+	defer timer.Stop()
+
+collect:
+	for len(c.batch) < c.coalesceSize {
+		data, updated := c.next()
+		if data == nil {
+			select {
+			case data = <-c.sendChan:
+			case <-updated:
+				continue
+			case <-timer.C:
+				break collect
+			case <-c.quit:
+				break collect
+			case <-c.readDone:
+				break collect
+			}
+		}
+		c.batch = append(c.batch, data.Bytes()...)
+		data.Release()
+	}
+
+	c.limitRate(len(c.batch))
+	c.ws.SetWriteDeadline(c.writeDeadline())
+	c.egress.Add(len(c.batch))
+	return c.writeMessage(c.batch, stamp)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"github.com/gorilla/websocket"
+)
+
+// compressionSettings say which streams are sent to viewers with
+// permessage-deflate, and how hard to compress.
+type compressionSettings struct {
+	enabled bool            // default for streams not in streams
+	streams map[string]bool // stream name -> overrides enabled
+	level   int
+}
+
+func newCompressionSettings(params *Params) compressionSettings {
+	settings := compressionSettings{
+		enabled: params.wsCompression,
+		streams: make(map[string]bool),
+		level:   params.wsCompressionLevel,
+	}
+	for _, stream := range params.streams {
+		if stream.Compression != nil {
+			settings.streams[stream.Name] = *stream.Compression
+		}
+	}
+
+	return settings
+}
+
+// any reports whether some stream is compressed, in which case the upgrader
+// offers the extension.
+func (c compressionSettings) any() bool {
+	if c.enabled {
+		return true
+	}
+	for _, enabled := range c.streams {
+		if enabled {
+			return true
+		}
+	}
+	return false
+}
+
+func (c compressionSettings) of(stream string) bool {
+	if enabled, ok := c.streams[stream]; ok {
+		return enabled
+	}
+	return c.enabled
+}
+
+// applyCompression turns compression of the messages to a viewer of stream
+// on or off. It only takes effect when the viewer negotiated the extension.
+func (h *WebSocketHandler) applyCompression(ws *websocket.Conn, stream string) {
+	h.settingsLock.RLock()
+	settings := h.compression
+	h.settingsLock.RUnlock()
+
+	enabled := settings.of(stream)
+	ws.EnableWriteCompression(enabled)
+	if enabled {
+		ws.SetCompressionLevel(settings.level)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+// A viewer under the decimate slow client policy is congested once half its
+// ring or send queue is waiting for it. It is then sent only the pictures
+// that decode on their own, so it catches up at a lower frame rate instead
+// of losing arbitrary chunks, and gets every picture again from the next
+// keyframe on once no more than an eighth is waiting.
+const (
+	decimateFrom  = 2 // congested at 1/decimateFrom of the ring or queue
+	decimateUntil = 8 // caught up at 1/decimateUntil
+)
+
+// congested returns whether a viewer with backlog of size messages waiting
+// is decimated, given whether it was and whether the next message starts
+// with a keyframe.
+func congested(backlog, size int, decimating, keyframe bool) bool {
+	if !decimating {
+		return backlog*decimateFrom >= size
+	}
+	return !keyframe || backlog*decimateUntil > size
+}
+
+// intra returns the view a decimated viewer reads instead of v.
+func (v ringView) intra() ringView {
+	v.sub.frames = framesIntra
+	return v
+}
+
+// ringViews returns what the client reads of its stream's ring: its own view
+// and, when it can be decimated, the view it reads while it is.
+func (c *Client) ringViews() []ringView {
+	if !c.decimates() {
+		return []ringView{c.cursor.ringView}
+	}
+	return []ringView{c.cursor.ringView, c.cursor.intra()}
+}
+
+// decimates reports whether the client is decimated when congested or over
+// the egress cap.
+func (c *Client) decimates() bool {
+	return c.policy.name == slowClientDecimate || c.throttle
+}
+
+// decimate decides whether a viewer reading at cursor is decimated for the
+// next entry. An exceeded egress cap counts as a full ring. It must be called
+// with the lock held.
+func (r *StreamRing) decimate(cursor *ringCursor) {
+	next := max(cursor.next, r.oldest())
+	keyframe := next < r.head && r.entries[next%uint64(len(r.entries))].keyframe
+	backlog := 0
+	if cursor.decimate {
+		backlog = int(r.head - next)
+	}
+	if cursor.throttled {
+		backlog = len(r.entries)
+	}
+	cursor.decimating = congested(backlog, len(r.entries), cursor.decimating, keyframe)
+}
+
+// decimateFragment reports whether an fMP4 viewer that can be decimated goes
+// without fragment, which it does from the fragment it became congested at
+// to the next keyframe it has caught up by. An exceeded egress cap counts as
+// a full queue.
+func (c *Client) decimateFragment(fragment *FMP4Fragment) bool {
+	backlog := 0
+	if c.policy.name == slowClientDecimate {
+		backlog = len(c.sendChan)
+	}
+	if c.throttle && c.egress.Over() {
+		backlog = cap(c.sendChan)
+	}
+	decimating := congested(backlog, cap(c.sendChan), c.decimating, fragment.keyframe)
+	c.noteDecimating(decimating)
+	if !decimating {
+		return false
+	}
+	c.stats.decimated.Add(1)
+	c.streamStats.decimated.Add(1)
+	return true
+}
+
+// noteDecimating records whether the client is decimated, logging a change.
+func (c *Client) noteDecimating(decimating bool) {
+	if decimating == c.decimating {
+		return
+	}
+	c.decimating = decimating
+	if decimating {
+		c.logger.Printf("Client %s is congested on stream %s, sending intra pictures only\n", c.id, c.stream)
+	} else {
+		c.logger.Printf("Client %s caught up on stream %s, sending every picture again\n", c.id, c.stream)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"github.com/gorilla/websocket"
+
+	"context"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// dialTimeout bounds how long a DialSource waits for the encoder to accept.
+const dialTimeout = 10 * time.Second
+
+// DialSource connects to an encoder that listens rather than publishes, for
+// encoders behind NAT with a forwarded port. tcp://host:port addresses are
+// read as raw MPEG-TS, ws:// and wss:// URLs as binary WebSocket messages.
+// The server reconnects following the stream's dial_retry policy, and only
+// takes the stream once the encoder sends data.
+type DialSource struct {
+	name   string // address without credentials
+	addr   string
+	stream string
+	retry  RetryConfig
+
+	handler *IncomingStreamHandler
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	logger *log.Logger
+}
+
+func NewDialSources(params *Params, handler *IncomingStreamHandler) []Source {
+	sources := []Source{}
+	for _, stream := range params.streams {
+		if stream.Dial == "" {
+			continue
+		}
+
+		name := stream.Dial
+		if u, err := url.Parse(stream.Dial); err == nil {
+			name = u.Redacted()
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		sources = append(sources, &DialSource{
+			name:    name,
+			addr:    stream.Dial,
+			stream:  stream.Name,
+			retry:   stream.DialRetry,
+			handler: handler,
+			ctx:     ctx,
+			cancel:  cancel,
+			logger:  params.logger,
+		})
+	}
+
+	return sources
+}
+
+func (d *DialSource) Run() {
+	backoff := NewBackoff(d.retry)
+	for {
+		received, err := d.runOnce()
+		if err != nil {
+			d.logger.Printf("%s: dial failed: %v\n", d.name, err)
+		}
+		if received {
+			backoff.Reset()
+		}
+
+		delay, ok := backoff.Next()
+		if !ok {
+			d.logger.Printf("%s: giving up after %d failed attempts (stream %s)\n", d.name, d.retry.MaxAttempts, d.stream)
+			return
+		}
+
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// runOnce reads from one connection to the encoder and reports whether any
+// data came through.
+func (d *DialSource) runOnce() (bool, error) {
+	ctx, cancel := context.WithTimeout(d.ctx, dialTimeout)
+	defer cancel()
+
+	var conn net.Conn
+	var read func() ([]byte, error)
+	if strings.HasPrefix(d.addr, "tcp://") {
+		var dialer net.Dialer
+		c, err := dialer.DialContext(ctx, "tcp", strings.TrimPrefix(d.addr, "tcp://"))
+		if err != nil {
+			return false, d.ignoreClosed(err)
+		}
+		conn = c
+		read = d.handler.NewChunkReader(conn).Next
+	} else {
+		ws, _, err := websocket.DefaultDialer.DialContext(ctx, d.addr, nil)
+		if err != nil {
+			return false, d.ignoreClosed(err)
+		}
+		ws.SetReadLimit(maxPublishMessageSize)
+		conn = ws.UnderlyingConn()
+		read = func() ([]byte, error) {
+			for {
+				msgType, data, err := ws.ReadMessage()
+				if err != nil || (msgType == websocket.BinaryMessage && len(data) > 0) {
+					return data, err
+				}
+			}
+		}
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(d.ctx, func() { conn.Close() })
+	defer stop()
+
+	d.logger.Printf("Connected to %s (stream %s)\n", d.name, d.stream)
+
+	var session *PublishSession
+	defer func() {
+		if session != nil {
+			d.handler.endPublish(session)
+		}
+	}()
+
+	for {
+		data, err := read()
+		if len(data) == 0 && err != nil {
+			return session != nil, nil
+		}
+
+		if session == nil {
+			session, err = d.handler.publisherLock.AcquireAddr(d.stream, d.name, conn, false)
+			if err != nil {
+				return false, err
+			}
+			d.handler.publishers.Add(1)
+			d.logger.Printf("IncomingStream connected: %s (stream %s)\n", d.name, d.stream)
+		}
+		if session.Superseded() {
+			d.logger.Printf("IncomingStream %s superseded on stream %s\n", d.name, d.stream)
+			return true, nil
+		}
+		session.meter.Add(len(data))
+
+		d.handler.Broadcast(session, data)
+	}
+}
+
+// ignoreClosed drops the error of an attempt cut short by Close.
+func (d *DialSource) ignoreClosed(err error) error {
+	if d.ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// Close disconnects from the encoder and stops reconnecting.
+func (d *DialSource) Close() {
+	d.cancel()
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"github.com/gorilla/websocket"
+
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errDraining refuses publishers while the server is draining.
+var errDraining = errors.New("server is draining")
+
+// Drain takes a server out of rotation ahead of a deployment: while it is
+// active, new viewers and publishers are turned away and those connected
+// carry on until they leave, or until the deadline, if any, evicts them.
+type Drain struct {
+	active atomic.Bool
+
+	since    time.Time
+	deadline time.Time // zero without a deadline
+	timer    *time.Timer
+	evicted  chan struct{} // closed at the deadline
+	lock     sync.Mutex
+}
+
+// DrainStatus reports the drain and what is still connected for the admin
+// API.
+type DrainStatus struct {
+	Draining   bool       `json:"draining"`
+	Since      *time.Time `json:"since,omitempty"`
+	Deadline   *time.Time `json:"deadline,omitempty"`
+	Evicted    bool       `json:"evicted"`
+	Viewers    int        `json:"viewers"`
+	Publishers int        `json:"publishers"`
+}
+
+func NewDrain() *Drain {
+	return &Drain{evicted: make(chan struct{})}
+}
+
+// Start starts draining, or changes the deadline of a drain under way. With
+// a timeout, evict is called once it passes; 0 lets sessions run their course.
+func (d *Drain) Start(timeout time.Duration, evict func()) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if !d.active.Load() {
+		d.since = time.Now()
+		d.active.Store(true)
+	}
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	d.deadline = time.Time{}
+	if timeout == 0 {
+		return
+	}
+
+	d.deadline = time.Now().Add(timeout)
+	evicted := d.evicted
+	var timer *time.Timer
+	timer = time.AfterFunc(timeout, func() {
+		d.lock.Lock()
+		if d.timer != timer {
+			// Stopped or restarted since.
+			d.lock.Unlock()
+			return
+		}
+		d.timer = nil
+		close(evicted)
+		d.lock.Unlock()
+
+		evict()
+	})
+	d.timer = timer
+}
+
+// Stop ends the drain, taking new viewers and publishers again.
+func (d *Drain) Stop() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	select {
+	case <-d.evicted:
+		d.evicted = make(chan struct{})
+	default:
+	}
+	d.deadline = time.Time{}
+	d.active.Store(false)
+}
+
+func (d *Drain) Active() bool {
+	return d.active.Load()
+}
+
+// Evicted returns a channel closed when the deadline of the current drain
+// passes. HTTP viewers leave on it.
+func (d *Drain) Evicted() <-chan struct{} {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.evicted
+}
+
+func (d *Drain) Status() DrainStatus {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	status := DrainStatus{Draining: d.active.Load()}
+	if !status.Draining {
+		return status
+	}
+	since := d.since
+	status.Since = &since
+	if !d.deadline.IsZero() {
+		deadline := d.deadline
+		status.Deadline = &deadline
+	}
+	select {
+	case <-d.evicted:
+		status.Evicted = true
+	default:
+	}
+	return status
+}
+
+// Drain starts draining the server, evicting every viewer and publisher still
+// connected once timeout passes, unless it is 0.
+func (s *Server) Drain(timeout time.Duration) {
+	h := s.websocketHandler
+	h.drain.Start(timeout, func() {
+		h.logger.Println("Drain deadline passed, disconnecting every viewer and publisher")
+		h.kickClients(kickRequest{all: true, code: websocket.CloseGoingAway, reason: "server draining"})
+		s.incomingStreamHandler.publisherLock.KickAll()
+	})
+	if timeout == 0 {
+		h.logger.Println("Draining: no longer taking viewers or publishers")
+	} else {
+		h.logger.Printf("Draining: no longer taking viewers or publishers, disconnecting the rest in %s\n", timeout)
+	}
+}
+
+// Resume ends a drain.
+func (s *Server) Resume() {
+	s.websocketHandler.drain.Stop()
+	s.websocketHandler.logger.Println("Drain cancelled, taking viewers and publishers again")
+}
+
+func (s *Server) DrainStatus() DrainStatus {
+	status := s.websocketHandler.drain.Status()
+	status.Viewers = s.websocketHandler.admission.Occupancy().Viewers
+	status.Publishers = len(s.incomingStreamHandler.publisherLock.Publishers())
+	return status
+}
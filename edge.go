@@ -0,0 +1,226 @@
+package main
+
+import (
+	"github.com/gorilla/websocket"
+
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// edgeIdleTimeout is how long a stream may go without data before its push
+// to an edge ends, which frees the stream for other publishers on the edge.
+const edgeIdleTimeout = 5 * time.Second
+
+// EdgeConfig is a downstream jsmpeg server this one pushes streams to, for
+// origin to edge fanout. URL is the edge's ingest URL with its secret, e.g.
+// http://edge:8082/<secret> for a streaming POST or
+// ws://edge:8082/publish/<secret> for WebSocket messages; the stream name is
+// appended to it. Streams defaults to every stream in the config file.
+type EdgeConfig struct {
+	URL     string   `yaml:"url"`
+	Streams []string `yaml:"streams"`
+}
+
+func (e *EdgeConfig) Validate() error {
+	for _, scheme := range []string{"http://", "https://", "ws://", "wss://"} {
+		if strings.HasPrefix(e.URL, scheme) {
+			return nil
+		}
+	}
+	return fmt.Errorf("url must be an http://, https://, ws:// or wss:// URL")
+}
+
+// EdgePush pushes what is broadcast on a stream to an edge, using the same
+// ingest protocol publishers use here. The push starts with the stream's
+// data and ends once the stream has been idle for edgeIdleTimeout; failed
+// pushes are retried with exponential backoff.
+type EdgePush struct {
+	name   string // edge address without the secret
+	url    string
+	stream string
+
+	hub    *WebSocketHandler
+	client *http.Client
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	logger *log.Logger
+}
+
+func NewEdgePushes(params *Params, handler *IncomingStreamHandler) []Source {
+	sources := []Source{}
+	for _, edge := range params.edges {
+		name := edge.URL
+		if u, err := url.Parse(edge.URL); err == nil {
+			name = u.Scheme + "://" + u.Host
+		}
+
+		streams := edge.Streams
+		if len(streams) == 0 {
+			for _, stream := range params.streams {
+				streams = append(streams, stream.Name)
+			}
+		}
+
+		for _, stream := range streams {
+			ctx, cancel := context.WithCancel(context.Background())
+			sources = append(sources, &EdgePush{
+				name:   name,
+				url:    strings.TrimSuffix(edge.URL, "/") + "/" + url.PathEscape(stream),
+				stream: stream,
+				hub:    handler.clientManager,
+				client: &http.Client{},
+				ctx:    ctx,
+				cancel: cancel,
+				logger: params.logger,
+			})
+		}
+	}
+
+	return sources
+}
+
+func (e *EdgePush) Run() {
+	backoff := NewBackoff(RetryConfig{})
+	for {
+		tap := e.hub.AddTap(e.stream)
+		pushed, err := e.runOnce(tap)
+		e.hub.RemoveTap(tap)
+		if err != nil {
+			e.logger.Printf("Push of stream %s to %s failed: %v\n", e.stream, e.name, err)
+		}
+		if e.ctx.Err() != nil {
+			return
+		}
+		if pushed && err == nil {
+			backoff.Reset()
+			continue
+		}
+
+		delay, _ := backoff.Next()
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// runOnce waits for the stream's data and pushes it to the edge until the
+// stream goes idle, reporting whether the edge accepted the connection.
+func (e *EdgePush) runOnce(tap *Tap) (bool, error) {
+	var data *Buffer
+	select {
+	case data = <-tap.C:
+	case <-tap.Done():
+		return false, nil
+	case <-e.ctx.Done():
+		return false, nil
+	}
+
+	send, finish, err := e.connect()
+	if err != nil {
+		return false, err
+	}
+	e.logger.Printf("Pushing stream %s to %s\n", e.stream, e.name)
+
+	idle := time.NewTimer(edgeIdleTimeout)
+	defer idle.Stop()
+
+	for {
+		err := send(data.Bytes())
+		data.Release()
+		if err != nil {
+			finish()
+			return true, err
+		}
+		idle.Reset(edgeIdleTimeout)
+
+		select {
+		case data = <-tap.C:
+		case <-tap.Done():
+			finish()
+			return true, fmt.Errorf("edge fell behind the stream")
+		case <-idle.C:
+			e.logger.Printf("Stream %s idle, ending push to %s\n", e.stream, e.name)
+			return true, finish()
+		case <-e.ctx.Done():
+			finish()
+			return true, nil
+		}
+	}
+}
+
+// connect opens a push to the edge and returns functions sending a chunk
+// and ending the push.
+func (e *EdgePush) connect() (func([]byte) error, func() error, error) {
+	if strings.HasPrefix(e.url, "ws://") || strings.HasPrefix(e.url, "wss://") {
+		ctx, cancel := context.WithTimeout(e.ctx, dialTimeout)
+		defer cancel()
+
+		ws, _, err := websocket.DefaultDialer.DialContext(ctx, e.url, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		send := func(data []byte) error {
+			ws.SetWriteDeadline(time.Now().Add(dialTimeout))
+			return ws.WriteMessage(websocket.BinaryMessage, data)
+		}
+		finish := func() error {
+			ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+			return ws.Close()
+		}
+		return send, finish, nil
+	}
+
+	body, writer := io.Pipe()
+	req, err := http.NewRequestWithContext(e.ctx, http.MethodPost, e.url, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "video/mp2t")
+
+	var result error
+	done := make(chan struct{})
+	go func() {
+		resp, err := e.client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				err = fmt.Errorf("edge answered %s", resp.Status)
+			}
+		}
+		result = err
+		body.CloseWithError(err)
+		close(done)
+	}()
+
+	send := func(data []byte) error {
+		if _, err := writer.Write(data); err != nil {
+			<-done
+			if result != nil {
+				return result
+			}
+			return err
+		}
+		return nil
+	}
+	finish := func() error {
+		writer.Close()
+		<-done
+		return result
+	}
+	return send, finish, nil
+}
+
+// Close ends the push and stops pushing again.
+func (e *EdgePush) Close() {
+	e.cancel()
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Policies for when viewers are sent more than the egress bandwidth cap.
+// reject turns new viewers away until the bitrate is back under the cap;
+// decimate lets them in but sends every WebSocket viewer connected with it
+// intra pictures only until then.
+const (
+	egressReject   = "reject"
+	egressDecimate = "decimate"
+)
+
+func validEgressPolicy(policy string) error {
+	switch policy {
+	case egressReject, egressDecimate:
+		return nil
+	}
+	return fmt.Errorf("unknown egress policy %q, expected %s or %s", policy, egressReject, egressDecimate)
+}
+
+// EgressLimiter measures what the server sends its viewers, in total and by
+// stream, against -egress-max-bitrate and the streams' egress_max_bitrate.
+// A cap of 0 is no cap.
+type EgressLimiter struct {
+	total      *RateMeter
+	maxBitrate int64
+	policy     string
+
+	streams   map[string]*StreamEgress
+	streamMax map[string]int64 // stream name -> cap of its own
+	lock      sync.Mutex
+}
+
+// StreamEgress meters what the viewers of one stream are sent.
+type StreamEgress struct {
+	name    string
+	meter   *RateMeter
+	limiter *EgressLimiter
+}
+
+// EgressStatus reports the egress bitrates and caps for the admin API.
+type EgressStatus struct {
+	Bitrate    float64                  `json:"bitrate"`
+	MaxBitrate int64                    `json:"max_bitrate"`
+	Policy     string                   `json:"policy"`
+	Over       bool                     `json:"over"`
+	Streams    map[string]StreamBitrate `json:"streams"`
+}
+
+type StreamBitrate struct {
+	Bitrate    float64 `json:"bitrate"`
+	MaxBitrate int64   `json:"max_bitrate,omitempty"`
+	Over       bool    `json:"over"`
+}
+
+func NewEgressLimiter(params *Params) *EgressLimiter {
+	limiter := &EgressLimiter{
+		total:   NewRateMeter(),
+		streams: make(map[string]*StreamEgress),
+	}
+	limiter.ApplyParams(params)
+
+	return limiter
+}
+
+// ApplyParams changes the caps and the policy; viewers keep the policy they
+// connected with.
+func (e *EgressLimiter) ApplyParams(params *Params) {
+	streamMax := make(map[string]int64)
+	for _, stream := range params.streams {
+		if stream.EgressMaxBitrate != 0 {
+			streamMax[stream.Name] = stream.EgressMaxBitrate
+		}
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.maxBitrate = params.egressMaxBitrate
+	e.policy = params.egressPolicy
+	e.streamMax = streamMax
+}
+
+// Stream returns the meter of stream.
+func (e *EgressLimiter) Stream(stream string) *StreamEgress {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	s, ok := e.streams[stream]
+	if !ok {
+		s = &StreamEgress{name: stream, meter: NewRateMeter(), limiter: e}
+		e.streams[stream] = s
+	}
+	return s
+}
+
+// Admits reports whether a new viewer of stream may connect.
+func (e *EgressLimiter) Admits(stream string) bool {
+	return e.Policy() != egressReject || !e.Stream(stream).Over()
+}
+
+func (e *EgressLimiter) Policy() string {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return e.policy
+}
+
+func (e *EgressLimiter) caps(stream string) (int64, int64) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return e.maxBitrate, e.streamMax[stream]
+}
+
+// Status reports the server's egress and that of every stream sent anything.
+func (e *EgressLimiter) Status() EgressStatus {
+	e.lock.Lock()
+	status := EgressStatus{
+		MaxBitrate: e.maxBitrate,
+		Policy:     e.policy,
+		Streams:    make(map[string]StreamBitrate),
+	}
+	streams := make([]*StreamEgress, 0, len(e.streams))
+	for _, s := range e.streams {
+		streams = append(streams, s)
+	}
+	e.lock.Unlock()
+
+	status.Bitrate = e.total.Bitrate()
+	status.Over = over(status.Bitrate, status.MaxBitrate)
+	for _, s := range streams {
+		_, max := e.caps(s.name)
+		bitrate := s.meter.Bitrate()
+		status.Streams[s.name] = StreamBitrate{
+			Bitrate:    bitrate,
+			MaxBitrate: max,
+			Over:       status.Over || over(bitrate, max),
+		}
+	}
+	return status
+}
+
+// Add counts n bytes sent to a viewer of the stream.
+func (s *StreamEgress) Add(n int) {
+	s.limiter.total.Add(n)
+	s.meter.Add(n)
+}
+
+func (s *StreamEgress) Bitrate() float64 {
+	return s.meter.Bitrate()
+}
+
+// Over reports whether the server or the stream is sending more than its cap.
+func (s *StreamEgress) Over() bool {
+	total, stream := s.limiter.caps(s.name)
+	return over(s.limiter.total.Bitrate(), total) || over(s.meter.Bitrate(), stream)
+}
+
+func over(bitrate float64, max int64) bool {
+	return max != 0 && bitrate > float64(max)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// EncoderConfig has the server run ffmpeg for a stream. Without Args ffmpeg
+// reads Input, after InputArgs, and encodes it for jsmpeg at the given size
+// and bitrates. Args replaces the whole argument list; each argument is a
+// text/template filled in from the encoder settings, e.g. "{{.Input}}", and
+// must make ffmpeg write MPEG-TS to stdout.
+//
+// Restart is the policy for starting ffmpeg again after it exited, and
+// StallTimeout, when set, restarts ffmpeg once it has produced no output for
+// that long.
+type EncoderConfig struct {
+	Input        string   `yaml:"input"`
+	InputArgs    []string `yaml:"input_args"`
+	Size         string   `yaml:"size"`
+	VideoBitrate string   `yaml:"video_bitrate"`
+	AudioBitrate string   `yaml:"audio_bitrate"`
+	Args         []string `yaml:"args"`
+
+	Restart      RetryConfig   `yaml:"restart"`
+	StallTimeout time.Duration `yaml:"stall_timeout"`
+}
+
+// encoderTemplateData is what the templates in EncoderConfig.Args see.
+type encoderTemplateData struct {
+	Stream       string
+	Input        string
+	Size         string
+	VideoBitrate string
+	AudioBitrate string
+}
+
+func (e *EncoderConfig) Validate() error {
+	if len(e.Args) == 0 && e.Input == "" {
+		return fmt.Errorf("needs an input or args")
+	}
+	if e.Restart.MinDelay < 0 || e.Restart.MaxDelay < 0 || e.Restart.MaxAttempts < 0 || e.StallTimeout < 0 {
+		return fmt.Errorf("restart and stall_timeout values must not be negative")
+	}
+
+	_, err := e.CommandArgs("")
+	return err
+}
+
+// CommandArgs returns the ffmpeg arguments for stream.
+func (e *EncoderConfig) CommandArgs(stream string) ([]string, error) {
+	data := encoderTemplateData{
+		Stream:       stream,
+		Input:        e.Input,
+		Size:         e.Size,
+		VideoBitrate: e.VideoBitrate,
+		AudioBitrate: e.AudioBitrate,
+	}
+	if data.VideoBitrate == "" {
+		data.VideoBitrate = "1000k"
+	}
+	if data.AudioBitrate == "" {
+		data.AudioBitrate = "128k"
+	}
+
+	if len(e.Args) == 0 {
+		args := append(append([]string{}, e.InputArgs...), "-i", e.Input, "-f", "mpegts", "-codec:v", "mpeg1video")
+		if e.Size != "" {
+			args = append(args, "-s", e.Size)
+		}
+		return append(args,
+			"-b:v", data.VideoBitrate, "-bf", "0",
+			"-codec:a", "mp2", "-b:a", data.AudioBitrate,
+			"-muxdelay", "0.001",
+			"-",
+		), nil
+	}
+
+	args := []string{}
+	for _, arg := range e.Args {
+		tmpl, err := template.New("arg").Parse(arg)
+		if err != nil {
+			return nil, err
+		}
+		var expanded strings.Builder
+		if err := tmpl.Execute(&expanded, data); err != nil {
+			return nil, err
+		}
+		args = append(args, expanded.String())
+	}
+
+	return args, nil
+}
+
+// NewEncoderSources returns an FFmpegSource for each stream with an encoder
+// in the config file.
+func NewEncoderSources(params *Params, handler *IncomingStreamHandler) []Source {
+	sources := []Source{}
+	for _, stream := range params.streams {
+		if stream.Encoder == nil {
+			continue
+		}
+
+		args, err := stream.Encoder.CommandArgs(stream.Name)
+		if err != nil {
+			params.logger.Printf("Encoder for stream %s: %v\n", stream.Name, err)
+			continue
+		}
+
+		source := newFFmpegSource("encoder:"+stream.Name, stream.Name, args, params, handler)
+		source.retry = stream.Encoder.Restart
+		source.stallTimeout = stream.Encoder.StallTimeout
+		sources = append(sources, source)
+	}
+
+	return sources
+}
+
+// Encoders reports the state of every ffmpeg process the server runs.
+func (s *IncomingStreamHandler) Encoders() []EncoderStatus {
+	s.sourcesLock.Lock()
+	defer s.sourcesLock.Unlock()
+
+	encoders := []EncoderStatus{}
+	for _, source := range s.sources {
+		if encoder, ok := source.(*FFmpegSource); ok {
+			encoders = append(encoders, encoder.Status())
+		}
+	}
+
+	return encoders
+}
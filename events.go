@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	EventStreamKeyRotated  = "stream_key_rotated"
+	EventEncoderRestarting = "encoder_restarting"
+	EventEncoderFailed     = "encoder_failed"
+	EventMemoryPressure    = "memory_pressure"
+	EventMemoryShed        = "memory_shed"
+	EventMemoryRecovered   = "memory_recovered"
+
+	EventRecordingRemuxed      = "recording_remuxed"
+	EventRecordingRemuxFailed  = "recording_remux_failed"
+	EventRecordingUploaded     = "recording_uploaded"
+	EventRecordingUploadFailed = "recording_upload_failed"
+)
+
+// Event tells operators and their tooling about a change they may have to act
+// on, such as reconfiguring a publisher after its key was rotated.
+type Event struct {
+	Type   string            `json:"type"`
+	Stream string            `json:"stream,omitempty"`
+	Time   time.Time         `json:"time"`
+	Data   map[string]string `json:"data,omitempty"`
+}
+
+// EventBus hands events to the admin API subscribers and, if configured,
+// posts them to a webhook. Slow subscribers miss events rather than block the
+// publisher.
+type EventBus struct {
+	subscribers map[chan Event]bool
+	closed      bool
+	webhook     string
+	lock        sync.Mutex
+
+	client *http.Client
+	logger *log.Logger
+}
+
+func NewEventBus(params *Params) *EventBus {
+	bus := &EventBus{
+		subscribers: make(map[chan Event]bool),
+		client:      &http.Client{Timeout: 10 * time.Second},
+		logger:      params.logger,
+	}
+	bus.ApplyParams(params)
+
+	return bus
+}
+
+func (b *EventBus) ApplyParams(params *Params) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.webhook = params.eventWebhook
+}
+
+func (b *EventBus) Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+
+	if b.webhook != "" {
+		go b.post(b.webhook, event)
+	}
+}
+
+func (b *EventBus) post(url string, event Event) {
+	body, _ := json.Marshal(event)
+	resp, err := b.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		b.logger.Printf("Event webhook failed: %v\n", err)
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		b.logger.Printf("Event webhook returned %s\n", resp.Status)
+	}
+}
+
+// Subscribe returns a channel receiving every event published from now on.
+// The channel is closed by Unsubscribe or Close.
+func (b *EventBus) Subscribe() chan Event {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	ch := make(chan Event, 16)
+	if b.closed {
+		close(ch)
+		return ch
+	}
+	b.subscribers[ch] = true
+
+	return ch
+}
+
+func (b *EventBus) Unsubscribe(ch chan Event) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.subscribers[ch] {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// Close ends every subscription, letting streaming admin requests finish
+// during shutdown.
+func (b *EventBus) Close() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for ch := range b.subscribers {
+		close(ch)
+	}
+	b.subscribers = make(map[chan Event]bool)
+	b.closed = true
+}
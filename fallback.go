@@ -0,0 +1,90 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// fallbackPollInterval is how often a StreamFallbackSource checks whether the
+// live publisher is back.
+const fallbackPollInterval = 250 * time.Millisecond
+
+// FallbackConfig is what viewers of a stream get while it has no publisher:
+// a File played on a loop, or the live data of another Stream.
+type FallbackConfig struct {
+	File   string `yaml:"file"`
+	Stream string `yaml:"stream"`
+}
+
+// NewFallbackSources returns a source for each stream with a fallback in the
+// config file. A fallback holds the stream only while nobody else publishes
+// to it, so a returning publisher replaces it right away.
+func NewFallbackSources(params *Params, handler *IncomingStreamHandler) []Source {
+	sources := []Source{}
+	for _, stream := range params.streams {
+		if stream.Fallback == nil {
+			continue
+		}
+
+		if stream.Fallback.File != "" {
+			sources = append(sources, &FileSource{
+				path:     stream.Fallback.File,
+				stream:   stream.Name,
+				fallback: true,
+				handler:  handler,
+				quit:     make(chan struct{}),
+				logger:   params.logger,
+			})
+			continue
+		}
+
+		sources = append(sources, &StreamFallbackSource{
+			from:    stream.Fallback.Stream,
+			stream:  stream.Name,
+			handler: handler,
+			quit:    make(chan struct{}),
+			logger:  params.logger,
+		})
+	}
+
+	return sources
+}
+
+// StreamFallbackSource shows the viewers of a stream another stream while
+// the first one has no publisher.
+type StreamFallbackSource struct {
+	from    string
+	stream  string
+	handler *IncomingStreamHandler
+
+	quit chan struct{}
+
+	logger *log.Logger
+}
+
+func (s *StreamFallbackSource) Run() {
+	for {
+		session, err := s.handler.publisherLock.AcquireFallback(s.stream, "stream:"+s.from)
+		if err == nil {
+			s.handler.publishers.Add(1)
+			s.logger.Printf("Stream %s falls back to stream %s\n", s.stream, s.from)
+
+			s.handler.clientManager.Forward(s.from, s.stream, session)
+			for !session.Superseded() {
+				if !sleepOrQuit(fallbackPollInterval, s.quit) {
+					break
+				}
+			}
+			s.handler.clientManager.StopForward(s.from, session)
+			s.handler.endPublish(session)
+		}
+
+		if !sleepOrQuit(playbackRetryDelay, s.quit) {
+			return
+		}
+	}
+}
+
+func (s *StreamFallbackSource) Close() {
+	close(s.quit)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// fecHeaderSize is the FEC header of SMPTE 2022-1 following the RTP
+	// header: the RFC 2733 header plus the SMPTE extension.
+	fecHeaderSize = 16
+
+	// fecWindowPackets is how many recent media packets are kept for
+	// recovery; 2022-1 matrices span at most a few hundred.
+	fecWindowPackets = 1024
+
+	// fecPendingPackets bounds the FEC packets kept for another try once a
+	// recovery elsewhere in the matrix filled one of their gaps.
+	fecPendingPackets = 64
+)
+
+// FECPacket is an SMPTE 2022-1 (Pro-MPEG) FEC packet protecting the NA media
+// packets SNBase, SNBase+Offset, ... with the XOR of their payloads. Column
+// FEC has the matrix row length as Offset, row FEC an Offset of 1.
+type FECPacket struct {
+	SNBase         uint16
+	LengthRecovery uint16
+	Offset         uint8
+	NA             uint8
+	Payload        []byte
+}
+
+// ParseFEC parses packet as received on an FEC port.
+func ParseFEC(packet []byte) (FECPacket, error) {
+	rtp, err := ParseRTP(packet)
+	if err != nil {
+		return FECPacket{}, err
+	}
+	if len(rtp.Payload) < fecHeaderSize {
+		return FECPacket{}, fmt.Errorf("truncated FEC header")
+	}
+
+	header := rtp.Payload
+	fec := FECPacket{
+		SNBase:         binary.BigEndian.Uint16(header[0:]),
+		LengthRecovery: binary.BigEndian.Uint16(header[2:]),
+		Offset:         header[13],
+		NA:             header[14],
+		Payload:        header[fecHeaderSize:],
+	}
+	if fec.Offset == 0 || fec.NA == 0 {
+		return FECPacket{}, fmt.Errorf("FEC packet protects no media packets")
+	}
+
+	return fec, nil
+}
+
+type fecMediaPacket struct {
+	sequence uint16
+	payload  []byte
+	ok       bool
+}
+
+// FECDecoder recovers single lost media packets of an RTP stream from the
+// SMPTE 2022-1 column and row FEC sent alongside it. Like 2022-1 it assumes
+// media packets without CSRCs, header extension or padding.
+type FECDecoder struct {
+	media   [fecWindowPackets]fecMediaPacket
+	pending []FECPacket
+	ssrc    uint32
+}
+
+func NewFECDecoder() *FECDecoder {
+	return &FECDecoder{}
+}
+
+// Add records a received media packet.
+func (d *FECDecoder) Add(packet RTPPacket) {
+	if packet.SSRC != d.ssrc {
+		*d = FECDecoder{ssrc: packet.SSRC}
+	}
+
+	payload := make([]byte, len(packet.Payload))
+	copy(payload, packet.Payload)
+	d.media[packet.Sequence%fecWindowPackets] = fecMediaPacket{sequence: packet.Sequence, payload: payload, ok: true}
+}
+
+// Repair returns the media packets fec and the FEC packets kept from before
+// can now recover.
+func (d *FECDecoder) Repair(fec FECPacket) []RTPPacket {
+	recovered := []RTPPacket{}
+	pending := append(d.pending, fec)
+	for progress := true; progress; {
+		progress = false
+		kept := pending[:0]
+		for _, fec := range pending {
+			packet, missing := d.recover(fec)
+			if missing == 1 {
+				d.Add(packet)
+				recovered = append(recovered, packet)
+				progress = true
+			}
+			if missing > 1 {
+				kept = append(kept, fec)
+			}
+		}
+		pending = kept
+	}
+
+	if len(pending) > fecPendingPackets {
+		pending = pending[len(pending)-fecPendingPackets:]
+	}
+	d.pending = append([]FECPacket{}, pending...)
+
+	return recovered
+}
+
+// recover counts the media packets fec protects that are missing, and when
+// it is just one rebuilds it.
+func (d *FECDecoder) recover(fec FECPacket) (RTPPacket, int) {
+	missing := 0
+	var lost uint16
+	for i := 0; i < int(fec.NA); i++ {
+		sequence := fec.SNBase + uint16(i)*uint16(fec.Offset)
+		if media := d.media[sequence%fecWindowPackets]; !media.ok || media.sequence != sequence {
+			missing++
+			lost = sequence
+		}
+	}
+	if missing != 1 {
+		return RTPPacket{}, missing
+	}
+
+	payload := make([]byte, len(fec.Payload))
+	copy(payload, fec.Payload)
+	length := fec.LengthRecovery
+	for i := 0; i < int(fec.NA); i++ {
+		sequence := fec.SNBase + uint16(i)*uint16(fec.Offset)
+		if sequence == lost {
+			continue
+		}
+		media := d.media[sequence%fecWindowPackets].payload
+		length ^= uint16(len(media))
+		for j := 0; j < len(media) && j < len(payload); j++ {
+			payload[j] ^= media[j]
+		}
+	}
+	if int(length) > len(payload) {
+		return RTPPacket{}, 0
+	}
+
+	return RTPPacket{Sequence: lost, SSRC: d.ssrc, Payload: payload[:length]}, 1
+}
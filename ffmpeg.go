@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ffmpegOutputArgs make ffmpeg write the MPEG1 video / MP2 audio transport
+// stream jsmpeg plays to stdout.
+var ffmpegOutputArgs = []string{
+	"-f", "mpegts",
+	"-codec:v", "mpeg1video", "-b:v", "1000k", "-bf", "0",
+	"-codec:a", "mp2", "-b:a", "128k",
+	"-muxdelay", "0.001",
+	"-",
+}
+
+// ffmpegStableRun is how long ffmpeg has to run for its exit to count as a
+// normal end rather than a failed start, resetting the restart backoff.
+const ffmpegStableRun = 30 * time.Second
+
+// States of an FFmpegSource reported by Status.
+const (
+	EncoderStarting   = "starting"
+	EncoderRunning    = "running"
+	EncoderRestarting = "restarting"
+	EncoderStopped    = "stopped"
+)
+
+// EncoderStatus describes an ffmpeg process for the admin API.
+type EncoderStatus struct {
+	Name      string    `json:"name"`
+	Stream    string    `json:"stream"`
+	State     string    `json:"state"`
+	Since     time.Time `json:"since"`
+	PID       int       `json:"pid,omitempty"`
+	Restarts  int       `json:"restarts"`
+	LastError string    `json:"last_error,omitempty"`
+	LastLog   string    `json:"last_log,omitempty"`
+}
+
+// ffmpegProcess is what FFmpegSource and Restream share about the ffmpeg
+// process they supervise: its command and status, guarded by lock, and the
+// bookkeeping of its runs. Each of them publishes its own events.
+type ffmpegProcess struct {
+	name  string // prefixes the log lines
+	retry RetryConfig
+
+	cmd    *exec.Cmd
+	status EncoderStatus
+	lock   sync.Mutex
+
+	logger *log.Logger
+}
+
+// FFmpegSource runs ffmpeg with the given arguments and publishes its output
+// to a stream, starting ffmpeg again whenever it exits. The stream is only
+// taken once ffmpeg produces output, so a source waiting for its input does
+// not block other publishers. ffmpeg's stderr goes to the log line by line.
+//
+// Restarts follow the retry policy; runs shorter than ffmpegStableRun count
+// as failures, so a flapping ffmpeg is restarted less and less often and
+// emits an encoder_restarting event every time. With a stall timeout, ffmpeg
+// is also restarted when it stops producing output.
+type FFmpegSource struct {
+	ffmpegProcess // name is shown as the publisher address
+
+	stream       string
+	ffmpeg       string
+	args         []string
+	stallTimeout time.Duration
+	handler      *IncomingStreamHandler
+
+	quit chan struct{}
+}
+
+// NewFFmpegSource returns a source transcoding the input given by inputArgs
+// to the format jsmpeg plays.
+func NewFFmpegSource(name, stream string, inputArgs []string, params *Params, handler *IncomingStreamHandler) *FFmpegSource {
+	args := append(append([]string{}, inputArgs...), ffmpegOutputArgs...)
+	return newFFmpegSource(name, stream, args, params, handler)
+}
+
+func newFFmpegSource(name, stream string, args []string, params *Params, handler *IncomingStreamHandler) *FFmpegSource {
+	return &FFmpegSource{
+		ffmpegProcess: ffmpegProcess{
+			name:   name,
+			status: EncoderStatus{Name: name, Stream: stream, State: EncoderStarting, Since: time.Now()},
+			logger: params.logger,
+		},
+		stream:  stream,
+		ffmpeg:  params.ffmpegPath,
+		args:    args,
+		handler: handler,
+		quit:    make(chan struct{}),
+	}
+}
+
+func (f *FFmpegSource) Run() {
+	backoff := NewBackoff(f.retry)
+	for {
+		started := time.Now()
+		err := f.runOnce()
+		if err != nil {
+			f.logger.Printf("%s: ffmpeg exited: %v\n", f.name, err)
+		}
+
+		stable := time.Since(started) >= ffmpegStableRun
+		if stable {
+			backoff.Reset()
+		}
+		delay, ok := backoff.Next()
+		if !f.exited(err, stable, delay, ok) {
+			return
+		}
+
+		select {
+		case <-f.quit:
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (f *FFmpegSource) runOnce() error {
+	args := append([]string{"-hide_banner", "-loglevel", "error"}, f.args...)
+	cmd := exec.Command(f.ffmpeg, args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	f.lock.Lock()
+	select {
+	case <-f.quit:
+		f.lock.Unlock()
+		return nil
+	default:
+	}
+	if err := cmd.Start(); err != nil {
+		f.lock.Unlock()
+		return err
+	}
+	f.cmd = cmd
+	f.setState(EncoderRunning, cmd.Process.Pid, "")
+	f.lock.Unlock()
+
+	logged := make(chan struct{})
+	go func() {
+		f.logStderr(stderr)
+		close(logged)
+	}()
+
+	var output io.Reader = stdout
+	var stalled atomic.Bool
+	if f.stallTimeout > 0 {
+		output = &stallReader{reader: stdout, timeout: f.stallTimeout, stalled: func() {
+			stalled.Store(true)
+			cmd.Process.Kill()
+		}}
+	}
+
+	if !f.publish(output) {
+		cmd.Process.Kill()
+	}
+	<-logged
+
+	err = cmd.Wait()
+	if stalled.Load() {
+		return fmt.Errorf("no output for %v", f.stallTimeout)
+	}
+
+	return err
+}
+
+// stallReader calls stalled when a Read has been waiting for longer than
+// timeout.
+type stallReader struct {
+	reader  io.Reader
+	timeout time.Duration
+	stalled func()
+}
+
+func (r *stallReader) Read(p []byte) (int, error) {
+	timer := time.AfterFunc(r.timeout, r.stalled)
+	defer timer.Stop()
+
+	return r.reader.Read(p)
+}
+
+// logStderr copies ffmpeg's messages to the log, remembering the last one for
+// Status.
+func (p *ffmpegProcess) logStderr(stderr io.Reader) {
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		p.logger.Printf("%s: ffmpeg: %s\n", p.name, line)
+
+		p.lock.Lock()
+		p.status.LastLog = line
+		p.lock.Unlock()
+	}
+}
+
+// recordExit records the end of an ffmpeg run, which restart says is
+// followed by another one, and reports restart. It must be called with the
+// lock held.
+func (p *ffmpegProcess) recordExit(err error, restart bool) bool {
+	lastError := ""
+	if err != nil {
+		lastError = err.Error()
+	}
+
+	if !restart {
+		p.setState(EncoderStopped, 0, lastError)
+		p.logger.Printf("%s: giving up after %d failed restarts\n", p.name, p.retry.MaxAttempts)
+		return false
+	}
+
+	p.setState(EncoderRestarting, 0, lastError)
+	p.status.Restarts++
+	return true
+}
+
+// setState must be called with the lock held.
+func (p *ffmpegProcess) setState(state string, pid int, lastError string) {
+	p.status.State = state
+	p.status.Since = time.Now()
+	p.status.PID = pid
+	if lastError != "" {
+		p.status.LastError = lastError
+	}
+}
+
+// exited records the end of an ffmpeg run and reports whether ffmpeg is
+// going to be restarted after delay. Failed runs emit an event.
+func (f *FFmpegSource) exited(err error, stable bool, delay time.Duration, restart bool) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	select {
+	case <-f.quit:
+		return false
+	default:
+	}
+
+	if !f.recordExit(err, restart) {
+		f.handler.events.Publish(Event{
+			Type:   EventEncoderFailed,
+			Stream: f.stream,
+			Data: map[string]string{
+				"encoder":    f.name,
+				"restarts":   strconv.Itoa(f.status.Restarts),
+				"last_error": f.status.LastError,
+			},
+		})
+		return false
+	}
+
+	if !stable {
+		f.handler.events.Publish(Event{
+			Type:   EventEncoderRestarting,
+			Stream: f.stream,
+			Data: map[string]string{
+				"encoder":    f.name,
+				"restarts":   strconv.Itoa(f.status.Restarts),
+				"delay":      delay.String(),
+				"last_error": f.status.LastError,
+			},
+		})
+	}
+
+	return true
+}
+
+func (f *FFmpegSource) Status() EncoderStatus {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.status
+}
+
+// publish broadcasts ffmpeg's output until it ends, reporting true, or until
+// the source loses the stream, reporting false.
+func (f *FFmpegSource) publish(stdout io.Reader) bool {
+	var session *PublishSession
+	defer func() {
+		if session != nil {
+			f.handler.endPublish(session)
+		}
+	}()
+
+	chunks := f.handler.NewChunkReader(stdout)
+	for {
+		data, err := chunks.Next()
+		if err != nil {
+			return true
+		}
+
+		if session == nil {
+			session, err = f.handler.publisherLock.AcquireAddr(f.stream, f.name, nil, false)
+			if err != nil {
+				f.logger.Printf("%s: %v\n", f.name, err)
+				return false
+			}
+			f.handler.publishers.Add(1)
+			f.logger.Printf("IncomingStream connected: %s (stream %s)\n", f.name, f.stream)
+		}
+		if session.Superseded() {
+			f.logger.Printf("IncomingStream %s superseded on stream %s\n", f.name, f.stream)
+			return false
+		}
+		session.meter.Add(len(data))
+
+		f.handler.Broadcast(session, data)
+	}
+}
+
+// Close stops ffmpeg and keeps it from being started again.
+func (f *FFmpegSource) Close() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	select {
+	case <-f.quit:
+		return
+	default:
+	}
+	close(f.quit)
+	f.setState(EncoderStopped, 0, "")
+
+	if f.cmd != nil {
+		f.cmd.Process.Kill()
+	}
+}
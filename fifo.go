@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// fifoIdleTimeout is how long a FIFO may stay silent before its stream is
+// released for other publishers.
+const fifoIdleTimeout = 5 * time.Second
+
+// FIFOSource publishes the MPEG-TS written to a named pipe. The pipe is
+// opened for reading and writing, so it never reports end of file: writers
+// may come and go, and the stream is released while the pipe is silent.
+type FIFOSource struct {
+	path    string
+	stream  string
+	handler *IncomingStreamHandler
+
+	file    *os.File
+	session *PublishSession
+	closed  bool
+	lock    sync.Mutex
+
+	logger *log.Logger
+}
+
+func NewFIFOSources(params *Params, handler *IncomingStreamHandler) []Source {
+	sources := []Source{}
+	for _, stream := range params.streams {
+		if stream.FIFO == "" {
+			continue
+		}
+
+		sources = append(sources, &FIFOSource{
+			path:    stream.FIFO,
+			stream:  stream.Name,
+			handler: handler,
+			logger:  params.logger,
+		})
+	}
+
+	return sources
+}
+
+func (f *FIFOSource) Run() {
+	if err := f.open(); err != nil {
+		f.logger.Printf("FIFO %s: %v\n", f.path, err)
+		return
+	}
+	defer f.release()
+
+	f.logger.Printf("Reading FIFO %s (stream %s)\n", f.path, f.stream)
+
+	for {
+		f.file.SetReadDeadline(time.Now().Add(fifoIdleTimeout))
+		data := make([]byte, 4096)
+		n, err := f.file.Read(data)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			f.release()
+			continue
+		}
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) && err != io.EOF {
+				f.logger.Printf("FIFO %s: %v\n", f.path, err)
+			}
+			return
+		}
+		data = data[:n]
+
+		if f.session == nil {
+			session, err := f.handler.publisherLock.AcquireAddr(f.stream, "fifo:"+f.path, nil, false)
+			if err != nil {
+				continue
+			}
+			f.handler.publishers.Add(1)
+			f.logger.Printf("IncomingStream connected: fifo:%s (stream %s)\n", f.path, f.stream)
+			f.session = session
+		}
+		if f.session.Superseded() {
+			continue
+		}
+		f.session.meter.Add(len(data))
+
+		f.handler.Broadcast(f.session, data)
+	}
+}
+
+func (f *FIFOSource) open() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeNamedPipe == 0 {
+		return errors.New("not a named pipe")
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.closed {
+		return os.ErrClosed
+	}
+	f.file, err = os.OpenFile(f.path, os.O_RDWR, 0)
+
+	return err
+}
+
+func (f *FIFOSource) release() {
+	if f.session != nil {
+		f.handler.endPublish(f.session)
+		f.session = nil
+	}
+}
+
+func (f *FIFOSource) Close() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.closed = true
+	if f.file != nil {
+		f.file.Close()
+	}
+}
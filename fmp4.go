@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+)
+
+// Viewer formats, chosen with the format query parameter or the /fmp4
+// endpoint.
+const (
+	formatTS   = "ts"
+	formatFMP4 = "fmp4"
+)
+
+// fmp4Timescale is the MP4 track timescale, the 90 kHz clock of PES
+// timestamps.
+const fmp4Timescale = 90000
+
+// fmp4MaxFrameGap is the largest step between two frames' decode times
+// taken as is. Larger ones, or steps back, come from a new publisher or a
+// clock jump, and the MP4 timeline carries on from the previous frame
+// instead.
+const fmp4MaxFrameGap = 10 * fmp4Timescale
+
+// FMP4Packager keeps an FMP4Muxer for every stream watched by fMP4 viewers.
+type FMP4Packager struct {
+	hub *WebSocketHandler
+
+	muxers map[string]*FMP4Muxer
+	lock   sync.Mutex
+}
+
+func NewFMP4Packager(hub *WebSocketHandler) *FMP4Packager {
+	return &FMP4Packager{
+		hub:    hub,
+		muxers: make(map[string]*FMP4Muxer),
+	}
+}
+
+// Start repackages stream from now on, for its first fMP4 viewer.
+func (p *FMP4Packager) Start(stream string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if _, ok := p.muxers[stream]; !ok {
+		p.muxers[stream] = NewFMP4Muxer(func(fragment *FMP4Fragment) {
+			p.hub.broadcastFragment(stream, fragment)
+		})
+	}
+}
+
+// Write adds data broadcast on stream, unless nobody has asked for the
+// stream as fMP4.
+func (p *FMP4Packager) Write(stream string, data []byte) {
+	p.lock.Lock()
+	muxer := p.muxers[stream]
+	p.lock.Unlock()
+
+	if muxer != nil {
+		muxer.Write(data)
+	}
+}
+
+// FMP4Fragment is one moof/mdat pair and the init segment it needs.
+type FMP4Fragment struct {
+	init     *Buffer
+	data     *Buffer
+	keyframe bool
+}
+
+// FMP4Muxer repackages the H.264 video of an MPEG-TS stream as fragmented
+// MP4 for Media Source Extensions players: an init segment describing the
+// track, then one moof/mdat fragment per frame. Audio and other elementary
+// streams are left out, and so are streams whose video is not H.264.
+type FMP4Muxer struct {
+	emit   func(*FMP4Fragment)
+	reader *VideoPESReader
+
+	sps  []byte
+	pps  []byte
+	init *Buffer
+
+	frame    *fmp4Frame // waits for the next frame to know its duration
+	duration int64      // of the last frame
+	rawDTS   int64      // last 33 bit DTS
+	dts      int64      // last DTS on the MP4 timeline
+	hasDTS   bool
+	seq      uint32
+	lock     sync.Mutex
+}
+
+type fmp4Frame struct {
+	dts      int64
+	cts      int64 // PTS - DTS
+	keyframe bool
+	data     []byte // length prefixed NAL units
+}
+
+func NewFMP4Muxer(emit func(*FMP4Fragment)) *FMP4Muxer {
+	m := &FMP4Muxer{emit: emit, duration: fmp4Timescale / 25}
+	m.reader = NewVideoPESReader(func(streamType byte, dts, pts int64, es []byte) {
+		if streamType == streamTypeH264 {
+			m.addFrame(dts, pts, es)
+		}
+	})
+
+	return m
+}
+
+func (m *FMP4Muxer) Write(data []byte) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.reader.Write(data)
+}
+
+func (m *FMP4Muxer) addFrame(rawDTS, rawPTS int64, es []byte) {
+	frame := &fmp4Frame{cts: wrap33(rawPTS - rawDTS)}
+	sps, pps := m.sps, m.pps
+	var data bytes.Buffer
+	for _, nal := range splitNALs(es) {
+		switch nal[0] & 0x1f {
+		case nalSPS:
+			sps = nal
+			continue
+		case nalPPS:
+			pps = nal
+			continue
+		case nalAUD:
+			continue
+		case nalIDR:
+			frame.keyframe = true
+		}
+		binary.Write(&data, binary.BigEndian, uint32(len(nal)))
+		data.Write(nal)
+	}
+	frame.data = data.Bytes()
+
+	if m.hasDTS {
+		if step := wrap33(rawDTS - m.rawDTS); step > 0 && step <= fmp4MaxFrameGap {
+			m.duration = step
+		}
+		m.dts += m.duration
+	} else {
+		m.dts = rawDTS
+		m.hasDTS = true
+	}
+	m.rawDTS = rawDTS
+	frame.dts = m.dts
+
+	if m.frame != nil {
+		m.fragment(m.frame, frame.dts-m.frame.dts)
+		m.frame = nil
+	}
+
+	if !bytes.Equal(sps, m.sps) || !bytes.Equal(pps, m.pps) {
+		m.sps = append([]byte{}, sps...)
+		m.pps = append([]byte{}, pps...)
+		m.init = nil
+		if parsed, err := ParseSPS(m.sps); err == nil && len(m.pps) > 0 {
+			init := fmp4Init(parsed, m.sps, m.pps)
+			m.init = NewBuffer(init)
+		}
+	}
+	if m.init != nil && len(frame.data) > 0 {
+		m.frame = frame
+	}
+}
+
+// fragment emits frame as one moof/mdat pair.
+func (m *FMP4Muxer) fragment(frame *fmp4Frame, duration int64) {
+	m.seq++
+
+	flags := uint32(0x01010000) // depends on others, not a sync sample
+	if frame.keyframe {
+		flags = 0x02000000
+	}
+
+	moof := func(dataOffset uint32) []byte {
+		return mp4Box("moof",
+			mp4FullBox("mfhd", 0, 0, mp4U32(m.seq)),
+			mp4Box("traf",
+				mp4FullBox("tfhd", 0, 0x020000, mp4U32(1)),
+				mp4FullBox("tfdt", 1, 0, mp4U64(uint64(frame.dts))),
+				mp4FullBox("trun", 1, 0x000f01,
+					mp4U32(1), mp4U32(dataOffset),
+					mp4U32(uint32(duration)), mp4U32(uint32(len(frame.data))), mp4U32(flags), mp4U32(uint32(int32(frame.cts))),
+				),
+			),
+		)
+	}
+	size := len(moof(0))
+	data := append(moof(uint32(size+8)), mp4Box("mdat", frame.data)...)
+
+	m.emit(&FMP4Fragment{init: m.init, data: NewBuffer(data), keyframe: frame.keyframe})
+}
+
+// fmp4Init returns the ftyp and moov boxes of a single H.264 track.
+func fmp4Init(sps H264SPS, spsNAL, ppsNAL []byte) []byte {
+	matrix := [][]byte{mp4U32(0x00010000), mp4U32(0), mp4U32(0), mp4U32(0), mp4U32(0x00010000), mp4U32(0), mp4U32(0), mp4U32(0), mp4U32(0x40000000)}
+	width, height := uint32(sps.Width), uint32(sps.Height)
+
+	avcC := mp4Box("avcC",
+		[]byte{1, sps.Profile, sps.Compatibility, sps.Level, 0xff, 0xe1},
+		mp4U16(uint16(len(spsNAL))), spsNAL,
+		[]byte{1}, mp4U16(uint16(len(ppsNAL))), ppsNAL,
+	)
+	avc1 := mp4Box("avc1",
+		make([]byte, 6), mp4U16(1), // reserved, data_reference_index
+		make([]byte, 16),
+		mp4U16(uint16(width)), mp4U16(uint16(height)),
+		mp4U32(0x00480000), mp4U32(0x00480000), // 72 dpi
+		mp4U32(0), mp4U16(1), // reserved, frame_count
+		make([]byte, 32), // compressorname
+		mp4U16(0x0018), mp4U16(0xffff),
+		avcC,
+	)
+
+	mvhd := [][]byte{mp4U32(0), mp4U32(0), mp4U32(1000), mp4U32(0), mp4U32(0x00010000), mp4U16(0x0100), make([]byte, 10)}
+	mvhd = append(append(mvhd, matrix...), make([]byte, 24), mp4U32(2))
+	tkhd := [][]byte{mp4U32(0), mp4U32(0), mp4U32(1), mp4U32(0), mp4U32(0), make([]byte, 8), mp4U16(0), mp4U16(0), mp4U16(0), mp4U16(0)}
+	tkhd = append(append(tkhd, matrix...), mp4U32(width<<16), mp4U32(height<<16))
+
+	init := mp4Box("ftyp", []byte("isom"), mp4U32(0x200), []byte("isomiso6avc1mp41"))
+	return append(init, mp4Box("moov",
+		mp4FullBox("mvhd", 0, 0, mvhd...),
+		mp4Box("trak",
+			mp4FullBox("tkhd", 0, 0x000003, tkhd...),
+			mp4Box("mdia",
+				mp4FullBox("mdhd", 0, 0, mp4U32(0), mp4U32(0), mp4U32(fmp4Timescale), mp4U32(0), mp4U16(0x55c4), mp4U16(0)),
+				mp4FullBox("hdlr", 0, 0, mp4U32(0), []byte("vide"), make([]byte, 12), []byte("VideoHandler\x00")),
+				mp4Box("minf",
+					mp4FullBox("vmhd", 0, 1, make([]byte, 8)),
+					mp4Box("dinf", mp4FullBox("dref", 0, 0, mp4U32(1), mp4FullBox("url ", 0, 1))),
+					mp4Box("stbl",
+						mp4FullBox("stsd", 0, 0, mp4U32(1), avc1),
+						mp4FullBox("stts", 0, 0, mp4U32(0)),
+						mp4FullBox("stsc", 0, 0, mp4U32(0)),
+						mp4FullBox("stsz", 0, 0, mp4U32(0), mp4U32(0)),
+						mp4FullBox("stco", 0, 0, mp4U32(0)),
+					),
+				),
+			),
+		),
+		mp4Box("mvex", mp4FullBox("trex", 0, 0, mp4U32(1), mp4U32(1), mp4U32(0), mp4U32(0), mp4U32(0))),
+	)...)
+}
+
+func mp4Box(kind string, payload ...[]byte) []byte {
+	size := 8
+	for _, p := range payload {
+		size += len(p)
+	}
+
+	box := make([]byte, 8, size)
+	binary.BigEndian.PutUint32(box, uint32(size))
+	copy(box[4:], kind)
+	for _, p := range payload {
+		box = append(box, p...)
+	}
+
+	return box
+}
+
+func mp4FullBox(kind string, version byte, flags uint32, payload ...[]byte) []byte {
+	header := []byte{version, byte(flags >> 16), byte(flags >> 8), byte(flags)}
+	return mp4Box(kind, append([][]byte{header}, payload...)...)
+}
+
+func mp4U16(v uint16) []byte {
+	return binary.BigEndian.AppendUint16(nil, v)
+}
+
+func mp4U32(v uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, v)
+}
+
+func mp4U64(v uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, v)
+}
+
+// broadcastFragment hands a fragment to every hub shard for its fMP4 viewers
+// of stream.
+func (h *WebSocketHandler) broadcastFragment(stream string, fragment *FMP4Fragment) {
+	for _, shard := range h.shards {
+		select {
+		case shard.fanout <- shardFanout{stream: stream, fragment: fragment}:
+		case <-h.quit:
+			return
+		}
+	}
+}
+
+// deliverFragment sends a fragment to the fMP4 viewers among clients. A
+// viewer gets the init segment, again whenever it changes, and then fragments
+// from the next keyframe on.
+func (h *WebSocketHandler) deliverFragment(clients []*Client, fragment *FMP4Fragment) {
+	for _, client := range clients {
+		if client.format != formatFMP4 {
+			continue
+		}
+		if client.decimates() && client.decimateFragment(fragment) {
+			continue
+		}
+		if client.init != fragment.init {
+			if !fragment.keyframe || !client.deliver(fragment.init) {
+				continue
+			}
+			client.init = fragment.init
+		}
+		client.deliver(fragment.data)
+	}
+}
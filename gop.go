@@ -0,0 +1,183 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Bounds of the GOP cache. A group of pictures longer than gopCacheLimit is
+// not cached, and viewers wait for the next keyframe as usual; nor is the GOP
+// of a stream that has had no data for gopCacheStale, which would show new
+// viewers an old picture. Under memory pressure the limit is halved down to
+// gopCacheMinLimit.
+const (
+	gopCacheLimit    = 8 << 20
+	gopCacheMinLimit = 256 << 10
+	gopCacheStale    = 5 * time.Second
+)
+
+// GOPCache keeps the data of every stream since its last keyframe, so a new
+// viewer can be sent the whole group of pictures and start decoding at once
+// instead of waiting for the next keyframe.
+type GOPCache struct {
+	streams map[string]*gopStream
+	limit   int
+	lock    sync.Mutex
+}
+
+type gopStream struct {
+	pending []byte
+	pat     []byte
+	pmt     []byte
+	pmtPID  uint16
+
+	videoPID  uint16
+	videoType byte
+
+	gop     []byte // PAT, PMT and every packet since the keyframe; nil when too long
+	limit   int
+	updated time.Time
+}
+
+// NewGOPCache returns nil when the GOP cache is disabled.
+func NewGOPCache(params *Params) *GOPCache {
+	if !params.gopCache {
+		return nil
+	}
+
+	return &GOPCache{streams: make(map[string]*gopStream), limit: gopCacheLimit}
+}
+
+// Write adds data broadcast on stream.
+func (c *GOPCache) Write(stream string, data []byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	s, ok := c.streams[stream]
+	if !ok {
+		s = &gopStream{}
+		c.streams[stream] = s
+	}
+	s.updated = time.Now()
+	s.limit = c.limit
+	tsPackets(&s.pending, data, s.packet)
+}
+
+func (s *gopStream) packet(packet []byte) {
+	pid := packetPID(packet)
+	switch {
+	case pid == 0:
+		if pmtPID, ok := parsePAT(packet); ok {
+			s.pat = append([]byte{}, packet...)
+			s.pmtPID = pmtPID
+		}
+	case pid == s.pmtPID && s.pmtPID != 0:
+		if videoPID, videoType, ok := parsePMT(packet); ok {
+			s.pmt = append([]byte{}, packet...)
+			s.videoPID = videoPID
+			s.videoType = videoType
+		}
+	case pid == s.videoPID && s.videoPID != 0 && isKeyframe(packet, s.videoType):
+		// Start a new slice, the old one may still be queued for viewers.
+		s.gop = append(append(make([]byte, 0, 64*tsPacketSize), s.pat...), s.pmt...)
+	}
+
+	if s.gop == nil {
+		return
+	}
+	if len(s.gop)+len(packet) > s.limit {
+		s.gop = nil
+		return
+	}
+	s.gop = append(s.gop, packet...)
+}
+
+// startClient sends a new MPEG-TS viewer the jsmpeg header of its stream,
+// if any, and the cached GOP, or else the PAT and PMT. Viewers of part of the
+// stream wait for the next keyframe. A resuming viewer is sent what it
+// missed instead.
+func (h *WebSocketHandler) startClient(client *Client) {
+	if client.format != formatTS {
+		return
+	}
+	// The ring follows what was queued here.
+	defer client.ring.start(&client.cursor)
+	if h.resumeClient(client) {
+		return
+	}
+
+	if header := h.header(client.stream); header != nil && client.framing == framingNone {
+		client.trySend(NewBuffer(header))
+	}
+
+	var start []byte
+	if h.gops != nil && client.subscription() == (subscription{}) {
+		start = h.gops.GOP(client.stream)
+	}
+	if start == nil {
+		start = h.tables.Tables(client.stream)
+	}
+	if start != nil {
+		if client.framing == framingSeq {
+			start = frameMessage(h.sequences.ID(client.stream), 0, start)
+		}
+		client.trySend(NewBuffer(start))
+	}
+}
+
+// GOP returns the data of stream since its last keyframe, or nil. The data
+// is not changed afterwards.
+func (c *GOPCache) GOP(stream string) []byte {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	s, ok := c.streams[stream]
+	if !ok || s.gop == nil || time.Since(s.updated) > gopCacheStale {
+		return nil
+	}
+	return s.gop[:len(s.gop):len(s.gop)]
+}
+
+// Shrink halves the size of the GOPs kept, dropping those already longer,
+// and returns the new limit.
+func (c *GOPCache) Shrink() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.limit = max(c.limit/2, gopCacheMinLimit)
+	for _, s := range c.streams {
+		if len(s.gop) > c.limit {
+			s.gop = nil
+		}
+	}
+	return c.limit
+}
+
+// Restore keeps GOPs up to gopCacheLimit again. Streams cache theirs from
+// their next keyframe.
+func (c *GOPCache) Restore() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.limit = gopCacheLimit
+}
+
+// Limit returns the size of the longest GOP kept.
+func (c *GOPCache) Limit() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.limit
+}
+
+// Sizes returns the bytes cached for every stream.
+func (c *GOPCache) Sizes() map[string]int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	sizes := make(map[string]int, len(c.streams))
+	for stream, s := range c.streams {
+		sizes[stream] = len(s.gop)
+	}
+	return sizes
+}
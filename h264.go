@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// H.264 NAL unit types the server looks at.
+const (
+	nalIDR = 5
+	nalSPS = 7
+	nalPPS = 8
+	nalAUD = 9
+)
+
+// splitNALs returns the NAL units of an H.264 Annex B byte stream, without
+// their start codes.
+func splitNALs(data []byte) [][]byte {
+	nals := [][]byte{}
+	start := -1
+	for i := 0; i+2 < len(data); {
+		if data[i] != 0 || data[i+1] != 0 || data[i+2] != 1 {
+			i++
+			continue
+		}
+		if start >= 0 {
+			nals = appendNAL(nals, data[start:i])
+		}
+		i += 3
+		start = i
+	}
+	if start >= 0 {
+		nals = appendNAL(nals, data[start:])
+	}
+
+	return nals
+}
+
+// appendNAL drops the trailing zero bytes, which belong to the next start
+// code, and empty units.
+func appendNAL(nals [][]byte, nal []byte) [][]byte {
+	nal = bytes.TrimRight(nal, "\x00")
+	if len(nal) == 0 {
+		return nals
+	}
+	return append(nals, nal)
+}
+
+// H264SPS holds what the server needs from a sequence parameter set.
+type H264SPS struct {
+	Profile       byte
+	Compatibility byte
+	Level         byte
+	Width         int
+	Height        int
+}
+
+// Codec returns the RFC 6381 codec string, e.g. "avc1.64001f".
+func (s H264SPS) Codec() string {
+	return fmt.Sprintf("avc1.%02x%02x%02x", s.Profile, s.Compatibility, s.Level)
+}
+
+// ParseSPS reads the profile and the cropped picture size from an SPS NAL
+// unit.
+func ParseSPS(nal []byte) (H264SPS, error) {
+	if len(nal) < 4 || nal[0]&0x1f != nalSPS {
+		return H264SPS{}, fmt.Errorf("not an SPS")
+	}
+
+	sps := H264SPS{Profile: nal[1], Compatibility: nal[2], Level: nal[3]}
+	r := &bitReader{data: unescapeRBSP(nal[4:])}
+	r.ue() // seq_parameter_set_id
+
+	chromaFormat := uint(1)
+	switch sps.Profile {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		chromaFormat = r.ue()
+		if chromaFormat == 3 {
+			r.bits(1) // separate_colour_plane_flag
+		}
+		r.ue()    // bit_depth_luma_minus8
+		r.ue()    // bit_depth_chroma_minus8
+		r.bits(1) // qpprime_y_zero_transform_bypass_flag
+		if r.bits(1) == 1 {
+			lists := 8
+			if chromaFormat == 3 {
+				lists = 12
+			}
+			for i := 0; i < lists; i++ {
+				if r.bits(1) == 1 {
+					size := 16
+					if i >= 6 {
+						size = 64
+					}
+					r.skipScalingList(size)
+				}
+			}
+		}
+	}
+
+	r.ue() // log2_max_frame_num_minus4
+	switch r.ue() {
+	case 0:
+		r.ue() // log2_max_pic_order_cnt_lsb_minus4
+	case 1:
+		r.bits(1) // delta_pic_order_always_zero_flag
+		r.se()    // offset_for_non_ref_pic
+		r.se()    // offset_for_top_to_bottom_field
+		for n := r.ue(); n > 0 && r.err == nil; n-- {
+			r.se()
+		}
+	}
+	r.ue()    // max_num_ref_frames
+	r.bits(1) // gaps_in_frame_num_value_allowed_flag
+
+	widthInMBs := r.ue() + 1
+	heightInMapUnits := r.ue() + 1
+	frameMBsOnly := r.bits(1)
+	if frameMBsOnly == 0 {
+		r.bits(1) // mb_adaptive_frame_field_flag
+	}
+	r.bits(1) // direct_8x8_inference_flag
+
+	var cropLeft, cropRight, cropTop, cropBottom uint
+	if r.bits(1) == 1 {
+		cropLeft, cropRight, cropTop, cropBottom = r.ue(), r.ue(), r.ue(), r.ue()
+	}
+	if r.err != nil {
+		return H264SPS{}, r.err
+	}
+
+	cropX, cropY := uint(1), 2-frameMBsOnly
+	if chromaFormat == 1 || chromaFormat == 2 {
+		cropX = 2
+	}
+	if chromaFormat == 1 {
+		cropY *= 2
+	}
+	sps.Width = int(widthInMBs*16 - (cropLeft+cropRight)*cropX)
+	sps.Height = int((2-frameMBsOnly)*heightInMapUnits*16 - (cropTop+cropBottom)*cropY)
+
+	return sps, nil
+}
+
+// unescapeRBSP removes the emulation prevention bytes of a NAL unit.
+func unescapeRBSP(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	zeros := 0
+	for _, b := range data {
+		if zeros >= 2 && b == 3 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		out = append(out, b)
+	}
+
+	return out
+}
+
+// bitReader reads the fixed and Exp-Golomb coded fields of a parameter set.
+// Reading past the end sets err and returns zeros.
+type bitReader struct {
+	data []byte
+	pos  int // in bits
+	err  error
+}
+
+func (r *bitReader) bits(n int) uint {
+	value := uint(0)
+	for i := 0; i < n; i++ {
+		if r.pos >= len(r.data)*8 {
+			r.err = fmt.Errorf("parameter set too short")
+			return 0
+		}
+		value = value<<1 | uint(r.data[r.pos/8]>>(7-r.pos%8)&1)
+		r.pos++
+	}
+
+	return value
+}
+
+func (r *bitReader) ue() uint {
+	zeros := 0
+	for r.bits(1) == 0 {
+		if r.err != nil || zeros > 31 {
+			r.err = fmt.Errorf("invalid Exp-Golomb code")
+			return 0
+		}
+		zeros++
+	}
+
+	return 1<<zeros - 1 + r.bits(zeros)
+}
+
+func (r *bitReader) se() int {
+	v := r.ue()
+	if v%2 == 1 {
+		return int(v+1) / 2
+	}
+	return -int(v / 2)
+}
+
+func (r *bitReader) skipScalingList(size int) {
+	last, next := 8, 8
+	for i := 0; i < size && r.err == nil; i++ {
+		if next != 0 {
+			next = (last + r.se() + 256) % 256
+		}
+		if next != 0 {
+			last = next
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// listenFDsEnv tells a server started by an upgrade which listeners it
+// inherits: their "network:address" keys, comma separated, in the order of
+// their descriptors from 3 on.
+const listenFDsEnv = "JSMPEG_LISTEN_FDS"
+
+// handoffPIDEnv tells a server started by an upgrade the PID of the process
+// that handed it the listeners, which it stops once it has taken them over.
+const handoffPIDEnv = "JSMPEG_HANDOFF_PID"
+
+// handoffDrainInterval is how often the old process checks whether its
+// viewers and publishers have left.
+const handoffDrainInterval = time.Second
+
+// handoffReadyTimeout is how long an upgraded server waits for its endpoints
+// to take over the inherited listeners before it lets the old server go
+// anyway, closing the ones it does not use.
+const handoffReadyTimeout = 10 * time.Second
+
+// SocketHandoff opens the server's listeners and hands them to a new process
+// on SIGUSR2, so the binary can be replaced without a moment in which
+// connections are refused. The new process tells the old one to shut down,
+// with SIGTERM, once it has taken every listener over. The old one then stops
+// accepting and gives its publishers and viewers until -handoff-drain-timeout
+// to leave before it exits; they reconnect to the new one.
+type SocketHandoff struct {
+	inherited map[string]*os.File         // "network:address" -> descriptor from the old process
+	listeners map[string]*handoffListener // "network:address" -> listener to hand over
+	parent    int                         // PID of the old process, 0 without one
+	upgrading bool
+	ready     sync.Once
+	lock      sync.Mutex
+
+	logger *log.Logger
+}
+
+// NewSocketHandoff picks up the listeners passed by an old process.
+func NewSocketHandoff(logger *log.Logger) *SocketHandoff {
+	s := &SocketHandoff{
+		inherited: make(map[string]*os.File),
+		listeners: make(map[string]*handoffListener),
+		logger:    logger,
+	}
+
+	keys := os.Getenv(listenFDsEnv)
+	s.parent, _ = strconv.Atoi(os.Getenv(handoffPIDEnv))
+	os.Unsetenv(handoffPIDEnv)
+	if keys == "" {
+		return s
+	}
+	os.Unsetenv(listenFDsEnv)
+	for i, key := range strings.Split(keys, ",") {
+		s.inherited[key] = os.NewFile(uintptr(3+i), key)
+	}
+	logger.Printf("Inherited %d listener(s) from process %d\n", len(s.inherited), s.parent)
+	time.AfterFunc(handoffReadyTimeout, s.takenOver)
+
+	return s
+}
+
+// Listen returns the listener inherited for network and addr or, without one,
+// a new listener. A stale Unix socket left behind by a crash is removed
+// first.
+func (s *SocketHandoff) Listen(network, addr string) (net.Listener, error) {
+	key := network + ":" + addr
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var listener net.Listener
+	var err error
+	if file, ok := s.inherited[key]; ok {
+		delete(s.inherited, key)
+		listener, err = net.FileListener(file)
+		file.Close()
+		if len(s.inherited) == 0 {
+			go s.takenOver()
+		}
+	} else {
+		if network == "unix" {
+			if info, err := os.Stat(addr); err == nil && info.Mode()&os.ModeSocket != 0 {
+				os.Remove(addr)
+			}
+		}
+		listener, err = net.Listen(network, addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	handed := &handoffListener{Listener: listener, released: make(chan struct{}), closed: make(chan struct{})}
+	s.listeners[key] = handed
+	return handed, nil
+}
+
+// takenOver closes the inherited listeners no endpoint wanted and tells the
+// old process to shut down, unless it is gone: once it has exited, the
+// parent is whoever adopted this process, which must be left alone.
+func (s *SocketHandoff) takenOver() {
+	s.ready.Do(func() {
+		s.lock.Lock()
+		for key, file := range s.inherited {
+			s.logger.Printf("Closing inherited listener %s, which is no longer configured\n", key)
+			file.Close()
+		}
+		s.inherited = make(map[string]*os.File)
+		s.lock.Unlock()
+
+		if s.parent == 0 {
+			s.logger.Println("Listeners taken over, without the PID of the process to stop")
+			return
+		}
+		if s.parent != os.Getppid() {
+			s.logger.Printf("Listeners taken over, process %d has already exited\n", s.parent)
+			return
+		}
+		if err := notifySupervisor(fmt.Sprintf("MAINPID=%d", os.Getpid())); err != nil {
+			s.logger.Printf("Telling the supervisor about the upgrade failed: %v\n", err)
+		}
+		s.logger.Printf("Listeners taken over, stopping process %d\n", s.parent)
+		syscall.Kill(s.parent, syscall.SIGTERM)
+	})
+}
+
+// Upgrade starts the server's executable again, with the same arguments and
+// every open listener.
+func (s *SocketHandoff) Upgrade() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.upgrading {
+		return fmt.Errorf("an upgrade is already under way")
+	}
+
+	keys := []string{}
+	files := []*os.File{}
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	for key, listener := range s.listeners {
+		filer, ok := listener.Listener.(interface{ File() (*os.File, error) })
+		if !ok {
+			continue
+		}
+		file, err := filer.File()
+		if err != nil {
+			// Closed, e.g. by a reload.
+			continue
+		}
+		if unix, ok := listener.Listener.(*net.UnixListener); ok {
+			// The socket is the new process's now.
+			unix.SetUnlinkOnClose(false)
+		}
+		keys = append(keys, key)
+		files = append(files, file)
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), listenFDsEnv+"="+strings.Join(keys, ","), handoffPIDEnv+"="+strconv.Itoa(os.Getpid()))
+	cmd.ExtraFiles = files
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	s.upgrading = true
+	s.logger.Printf("Started process %d with %d listener(s)\n", cmd.Process.Pid, len(files))
+
+	go func() {
+		err := cmd.Wait()
+		s.lock.Lock()
+		s.upgrading = false
+		s.lock.Unlock()
+		s.logger.Printf("Upgraded process exited: %v\n", err)
+	}()
+
+	return nil
+}
+
+// UpgradeOnSignal hands the listeners to a new process every time the
+// process receives SIGUSR2.
+func (s *SocketHandoff) UpgradeOnSignal() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR2)
+
+	for range sigs {
+		s.logger.Println("SIGUSR2 received, upgrading")
+		if err := s.Upgrade(); err != nil {
+			s.logger.Printf("Upgrade failed, keeping the current process: %v\n", err)
+		}
+	}
+}
+
+// Upgrading reports whether a process the listeners were handed to is
+// running.
+func (s *SocketHandoff) Upgrading() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.upgrading
+}
+
+// StopAccepting closes this process's descriptors of its listeners, which
+// the upgraded process keeps open, so that only the latter accepts
+// connections from now on. The accept loops wait for their listener to be
+// closed as usual rather than fail.
+func (s *SocketHandoff) StopAccepting() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, listener := range s.listeners {
+		listener.release()
+	}
+}
+
+// handoffListener is a listener of the SocketHandoff. Once released, Accept
+// blocks until Close instead of reporting the listener closed.
+type handoffListener struct {
+	net.Listener
+	released    chan struct{}
+	closed      chan struct{}
+	releaseOnce sync.Once
+	closeOnce   sync.Once
+}
+
+func (l *handoffListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		select {
+		case <-l.released:
+			<-l.closed
+		default:
+		}
+	}
+	return conn, err
+}
+
+func (l *handoffListener) release() {
+	l.releaseOnce.Do(func() {
+		if unix, ok := l.Listener.(*net.UnixListener); ok {
+			unix.SetUnlinkOnClose(false)
+		}
+		close(l.released)
+		l.Listener.Close()
+	})
+}
+
+func (l *handoffListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+	})
+	select {
+	case <-l.released:
+		// Already closed by release.
+		return nil
+	default:
+	}
+	return l.Listener.Close()
+}
+
+// notifySupervisor sends state to systemd when it runs the server and lets
+// it, e.g. with NotifyAccess=all.
+func notifySupervisor(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// handOver runs in the old process once an upgraded one has taken its
+// listeners over: it stops accepting and waits for its viewers and
+// publishers to leave, disconnecting those still there after timeout, before
+// the usual shutdown.
+func (s *Server) handOver(timeout time.Duration) {
+	h := s.websocketHandler
+	h.handoff.StopAccepting()
+	if timeout == 0 {
+		return
+	}
+
+	s.Drain(timeout)
+	evicted := h.drain.Evicted()
+	ticker := time.NewTicker(handoffDrainInterval)
+	defer ticker.Stop()
+	for {
+		status := s.DrainStatus()
+		if status.Viewers == 0 && status.Publishers == 0 {
+			h.logger.Println("Every viewer and publisher has left")
+			return
+		}
+		select {
+		case <-evicted:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// jsmpMagic starts the header message the jsmpeg WebSocket protocol sends
+// ahead of the stream, followed by the video width and height as big endian
+// 16 bit numbers. Players size their canvas from it; the jsmpeg.js served
+// with the demo page skips it while looking for the first TS packet.
+const jsmpMagic = "jsmp"
+
+// videoSize is the picture size of a stream, zero when unknown.
+type videoSize struct {
+	width  int
+	height int
+}
+
+func validVideoSize(width, height int) error {
+	if (width == 0) != (height == 0) {
+		return fmt.Errorf("width and height must be set together")
+	}
+	if width < 0 || width > 4095 || height < 0 || height > 4095 {
+		return fmt.Errorf("width and height must be between 1 and 4095")
+	}
+	return nil
+}
+
+func jsmpHeader(size videoSize) []byte {
+	header := make([]byte, len(jsmpMagic)+4)
+	copy(header, jsmpMagic)
+	binary.BigEndian.PutUint16(header[4:], uint16(size.width))
+	binary.BigEndian.PutUint16(header[6:], uint16(size.height))
+	return header
+}
+
+// header returns the jsmpeg header message of stream, or nil when its size
+// is neither configured nor known from its video. The size configured for
+// the stream comes first, then the detected one, then -width and -height.
+func (h *WebSocketHandler) header(stream string) []byte {
+	h.settingsLock.RLock()
+	size, ok := h.sizes[stream]
+	if !ok {
+		size = h.defaultSize
+	}
+	h.settingsLock.RUnlock()
+
+	if video, detected := h.media.Video(stream); !ok && detected && video.Width != 0 {
+		size = videoSize{width: video.Width, height: video.Height}
+	}
+
+	if size.width == 0 {
+		return nil
+	}
+	return jsmpHeader(size)
+}
@@ -0,0 +1,620 @@
+package main
+
+import (
+	"github.com/gorilla/mux"
+
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of the HLS packager.
+const (
+	defaultHLSSegmentDuration = 2 * time.Second
+	defaultHLSPlaylistSize    = 6
+)
+
+// hlsExtraSegments are kept after they left the playlist, for players still
+// working through an older copy of it.
+const hlsExtraSegments = 2
+
+// hlsPartSegments is the number of completed segments whose partial segments
+// stay in a low-latency playlist.
+const hlsPartSegments = 2
+
+// HLSPackager cuts the MPEG-TS of every stream into segments starting at a
+// keyframe and keeps a live m3u8 playlist of the latest ones, so players
+// without WebSocket support, such as iOS Safari, can follow the same feed.
+// Segments are held in memory, or written to dir when it is set.
+//
+// With a part target the playlist follows Low-Latency HLS: segments are
+// also published in parts of about that duration as they are produced, and
+// players may block on a playlist reload until the next part is there.
+// Parts are always served from memory.
+type HLSPackager struct {
+	dir        string
+	target     time.Duration
+	partTarget time.Duration // 0 without low-latency parts
+	size       int
+
+	streams map[string]*hlsStream
+	lock    sync.Mutex
+
+	logger *log.Logger
+}
+
+type hlsSegment struct {
+	seq           uint64
+	duration      time.Duration
+	discontinuity bool
+	data          []byte // nil when the segment is on disk
+	parts         []*hlsPart
+}
+
+type hlsPart struct {
+	duration    time.Duration
+	independent bool // starts with a keyframe
+	data        []byte
+}
+
+// hlsStream is the segmenter of one stream.
+type hlsStream struct {
+	name string
+	dir  string // "" when segments stay in memory
+
+	pending []byte // start of a packet split across writes
+	pat     []byte
+	pmt     []byte
+	pmtPID  uint16
+
+	videoPID  uint16
+	videoType byte
+
+	current       []byte
+	started       time.Time
+	firstPCR      uint64
+	lastPCR       uint64
+	pcrPID        uint16
+	hasPCR        bool
+	discontinuity bool
+	lastWrite     time.Time
+
+	parts           []*hlsPart // of the current segment
+	partOffset      int        // start of the current part in current
+	partStarted     time.Time
+	partPCR         uint64
+	partIndependent bool
+
+	segments         []*hlsSegment
+	nextSeq          uint64
+	discontinuitySeq uint64
+	updated          chan struct{} // closed when a part or segment is added
+	lock             sync.RWMutex
+}
+
+// NewHLSPackager returns nil when HLS is disabled.
+func NewHLSPackager(params *Params) *HLSPackager {
+	if !params.hls {
+		return nil
+	}
+
+	return &HLSPackager{
+		dir:        params.hlsDir,
+		target:     params.hlsSegmentDuration,
+		partTarget: params.hlsPartDuration,
+		size:       params.hlsPlaylistSize,
+		streams:    make(map[string]*hlsStream),
+		logger:     params.logger,
+	}
+}
+
+// Write adds data broadcast on stream.
+func (p *HLSPackager) Write(stream string, data []byte) {
+	p.lock.Lock()
+	s, ok := p.streams[stream]
+	if !ok {
+		s = &hlsStream{name: stream, updated: make(chan struct{})}
+		if p.dir != "" {
+			s.dir = filepath.Join(p.dir, streamDirName(stream))
+			if err := os.MkdirAll(s.dir, 0755); err != nil {
+				p.logger.Printf("HLS: %v, keeping stream %s in memory\n", err, stream)
+				s.dir = ""
+			}
+		}
+		p.streams[stream] = s
+	}
+	p.lock.Unlock()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	if !s.lastWrite.IsZero() && now.Sub(s.lastWrite) > 3*p.target {
+		// The publisher went away and came back; its clock starts over.
+		p.cut(s, now)
+		s.discontinuity = true
+		s.pending = nil
+	}
+	s.lastWrite = now
+
+	tsPackets(&s.pending, data, func(packet []byte) {
+		p.packet(s, packet, now)
+	})
+}
+
+// streamDirName returns the name of the directory of stream: its escaped
+// name, with "." and ".." escaped as well so that it stays below its parent.
+func streamDirName(stream string) string {
+	name := url.PathEscape(stream)
+	if name == "." || name == ".." {
+		name = strings.ReplaceAll(name, ".", "%2E")
+	}
+	return name
+}
+
+// packet adds one TS packet, first cutting the segment before it when the
+// packet starts a keyframe and the segment is long enough, or else the part
+// when it is long enough.
+func (p *HLSPackager) packet(s *hlsStream, packet []byte, now time.Time) {
+	pid := packetPID(packet)
+	switch {
+	case pid == 0:
+		if pmtPID, ok := parsePAT(packet); ok {
+			s.pat = append(s.pat[:0], packet...)
+			s.pmtPID = pmtPID
+		}
+	case pid == s.pmtPID && s.pmtPID != 0:
+		if videoPID, videoType, ok := parsePMT(packet); ok {
+			s.pmt = append(s.pmt[:0], packet...)
+			s.videoPID = videoPID
+			s.videoType = videoType
+		}
+	}
+
+	if pcr, ok := packetPCR(packet); ok && (!s.hasPCR || pid == s.pcrPID) {
+		if s.hasPCR && (pcr < s.lastPCR || pcr-s.lastPCR > uint64(3*p.target/time.Second+1)*pcrClock) {
+			p.cut(s, now)
+			s.discontinuity = true
+		}
+		if !s.hasPCR {
+			s.firstPCR = pcr
+			s.partPCR = pcr
+		}
+		s.lastPCR = pcr
+		s.pcrPID = pid
+		s.hasPCR = true
+	}
+
+	keyframe := s.videoPID == 0 || pid == s.videoPID && isKeyframe(packet, s.videoType)
+	if len(s.current) > 0 {
+		elapsed := s.since(s.firstPCR, s.started, now)
+		if keyframe && elapsed >= p.target || elapsed >= 3*p.target {
+			p.cut(s, now)
+		} else if p.partTarget > 0 && s.since(s.partPCR, s.partStarted, now) >= p.partTarget {
+			p.cutPart(s, now)
+		}
+	}
+
+	if len(s.current) == 0 {
+		if pid == 0 || pid == s.pmtPID {
+			// The segment gets the latest tables below instead.
+			return
+		}
+		s.started = now
+		s.firstPCR = s.lastPCR
+		s.current = append(append(s.current, s.pat...), s.pmt...)
+		s.partOffset = 0
+		s.partStarted = now
+		s.partPCR = s.lastPCR
+		s.partIndependent = keyframe
+	} else if len(s.current) == s.partOffset {
+		s.partStarted = now
+		s.partPCR = s.lastPCR
+		s.partIndependent = keyframe
+	}
+	s.current = append(s.current, packet...)
+}
+
+// since returns the media time since the given start, by the PCRs when the
+// stream has them.
+func (s *hlsStream) since(pcr uint64, started, now time.Time) time.Duration {
+	if s.hasPCR {
+		return time.Duration((s.lastPCR-pcr)/27) * time.Microsecond
+	}
+	return now.Sub(started)
+}
+
+// notify wakes the requests waiting for the next part or segment.
+func (s *hlsStream) notify() {
+	close(s.updated)
+	s.updated = make(chan struct{})
+}
+
+// cutPart ends the current part of the current segment.
+func (p *HLSPackager) cutPart(s *hlsStream, now time.Time) {
+	if len(s.current) == s.partOffset {
+		return
+	}
+
+	part := &hlsPart{
+		duration:    s.since(s.partPCR, s.partStarted, now),
+		independent: s.partIndependent,
+		// The bytes written so far never change, so the part can share them.
+		data: s.current[s.partOffset:len(s.current):len(s.current)],
+	}
+	if part.duration <= 0 {
+		part.duration = now.Sub(s.partStarted)
+	}
+	s.parts = append(s.parts, part)
+	s.partOffset = len(s.current)
+	s.notify()
+}
+
+// cut ends the current segment and adds it to the playlist.
+func (p *HLSPackager) cut(s *hlsStream, now time.Time) {
+	if len(s.current) == 0 {
+		return
+	}
+
+	if p.partTarget > 0 {
+		p.cutPart(s, now)
+	}
+
+	segment := &hlsSegment{
+		seq:           s.nextSeq,
+		duration:      s.since(s.firstPCR, s.started, now),
+		discontinuity: s.discontinuity,
+		data:          s.current,
+		parts:         s.parts,
+	}
+	if segment.duration <= 0 {
+		segment.duration = now.Sub(s.started)
+	}
+	s.nextSeq++
+	s.discontinuity = false
+	s.current = nil
+	s.parts = nil
+	s.partOffset = 0
+	s.firstPCR = s.lastPCR
+	defer s.notify()
+
+	if s.dir != "" {
+		if err := writeFileAtomic(filepath.Join(s.dir, segment.name()), segment.data); err != nil {
+			p.logger.Printf("HLS: %v\n", err)
+			return
+		}
+		segment.data = nil
+	}
+
+	s.segments = append(s.segments, segment)
+	for len(s.segments) > p.size+hlsExtraSegments {
+		if s.segments[0].discontinuity {
+			s.discontinuitySeq++
+		}
+		if s.dir != "" {
+			os.Remove(filepath.Join(s.dir, s.segments[0].name()))
+		}
+		s.segments = s.segments[1:]
+	}
+	if len(s.segments) > hlsPartSegments {
+		s.segments[len(s.segments)-hlsPartSegments-1].parts = nil
+	}
+
+	if s.dir != "" {
+		// Static servers cannot block playlist reloads, so the playlist on
+		// disk leaves out the parts.
+		if err := writeFileAtomic(filepath.Join(s.dir, "index.m3u8"), []byte(p.playlist(s, "", false))); err != nil {
+			p.logger.Printf("HLS: %v\n", err)
+		}
+	}
+}
+
+func (s *hlsSegment) name() string {
+	return strconv.FormatUint(s.seq, 10) + ".ts"
+}
+
+func partName(seq uint64, index int) string {
+	return fmt.Sprintf("%d.%d.ts", seq, index)
+}
+
+// playlist renders the live playlist of s, with the parts of the latest
+// segments when lowLatency is set. query is appended to the segment URIs so
+// a viewer token reaches the segment requests as well.
+func (p *HLSPackager) playlist(s *hlsStream, query string, lowLatency bool) string {
+	segments := s.segments
+	discontinuitySeq := s.discontinuitySeq
+	for len(segments) > p.size {
+		if segments[0].discontinuity {
+			discontinuitySeq++
+		}
+		segments = segments[1:]
+	}
+
+	target := math.Ceil(p.target.Seconds())
+	for _, segment := range segments {
+		target = math.Max(target, math.Ceil(segment.duration.Seconds()))
+	}
+
+	if query != "" {
+		query = "?" + query
+	}
+
+	var b strings.Builder
+	if !lowLatency {
+		b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+		fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(target))
+	} else {
+		partTarget := p.partTarget
+		for _, segment := range segments {
+			for _, part := range segment.parts {
+				partTarget = max(partTarget, part.duration)
+			}
+		}
+		for _, part := range s.parts {
+			partTarget = max(partTarget, part.duration)
+		}
+
+		b.WriteString("#EXTM3U\n#EXT-X-VERSION:6\n")
+		fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(target))
+		fmt.Fprintf(&b, "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=%.3f\n", 3*partTarget.Seconds())
+		fmt.Fprintf(&b, "#EXT-X-PART-INF:PART-TARGET=%.3f\n", partTarget.Seconds())
+	}
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", segments[0].seq)
+	fmt.Fprintf(&b, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", discontinuitySeq)
+	for _, segment := range segments {
+		if segment.discontinuity {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		if lowLatency {
+			writeParts(&b, segment.seq, segment.parts, query)
+		}
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n%s%s\n", segment.duration.Seconds(), segment.name(), query)
+	}
+	if lowLatency {
+		if s.discontinuity {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		writeParts(&b, s.nextSeq, s.parts, query)
+		fmt.Fprintf(&b, "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"%s%s\"\n", partName(s.nextSeq, len(s.parts)), query)
+	}
+
+	return b.String()
+}
+
+func writeParts(b *strings.Builder, seq uint64, parts []*hlsPart, query string) {
+	for i, part := range parts {
+		fmt.Fprintf(b, "#EXT-X-PART:DURATION=%.3f,URI=\"%s%s\"", part.duration.Seconds(), partName(seq, i), query)
+		if part.independent {
+			b.WriteString(",INDEPENDENT=YES")
+		}
+		b.WriteString("\n")
+	}
+}
+
+// wait blocks until ready, which is called with s read locked, reports true.
+// It gives up after three target durations or when the request is cancelled.
+func (p *HLSPackager) wait(r *http.Request, s *hlsStream, ready func() bool) bool {
+	timeout := time.NewTimer(3 * p.target)
+	defer timeout.Stop()
+
+	for {
+		s.lock.RLock()
+		ok := ready()
+		updated := s.updated
+		s.lock.RUnlock()
+		if ok {
+			return true
+		}
+
+		select {
+		case <-updated:
+		case <-timeout.C:
+			return false
+		case <-r.Context().Done():
+			return false
+		}
+	}
+}
+
+// part returns a part of a segment still held, or of the current segment.
+func (s *hlsStream) part(seq uint64, index int) *hlsPart {
+	parts := s.parts
+	if seq != s.nextSeq {
+		parts = nil
+		for _, segment := range s.segments {
+			if segment.seq == seq {
+				parts = segment.parts
+			}
+		}
+	}
+	if index >= len(parts) {
+		return nil
+	}
+
+	return parts[index]
+}
+
+func (p *HLSPackager) stream(name string) *hlsStream {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.streams[name]
+}
+
+// ServePlaylist serves the live playlist of a stream.
+func (p *HLSPackager) ServePlaylist(w http.ResponseWriter, r *http.Request, stream string) {
+	s := p.stream(stream)
+	if s == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	query := r.URL.RawQuery
+	if p.partTarget > 0 {
+		// A blocking reload waits for the segment, or part of it, the
+		// player asks for.
+		values := r.URL.Query()
+		if msn := values.Get("_HLS_msn"); msn != "" {
+			seq, err := strconv.ParseUint(msn, 10, 64)
+			part, partErr := strconv.Atoi(values.Get("_HLS_part"))
+			if values.Get("_HLS_part") == "" {
+				part, partErr = -1, nil
+			}
+			if err != nil || partErr != nil || part < -1 {
+				http.Error(w, "Invalid _HLS_msn or _HLS_part", http.StatusBadRequest)
+				return
+			}
+
+			s.lock.RLock()
+			tooFar := seq > s.nextSeq+1
+			s.lock.RUnlock()
+			if tooFar {
+				http.Error(w, "_HLS_msn is too far ahead", http.StatusBadRequest)
+				return
+			}
+
+			ready := func() bool {
+				return seq < s.nextSeq || seq == s.nextSeq && part >= 0 && part < len(s.parts)
+			}
+			if !p.wait(r, s, ready) {
+				http.Error(w, "Playlist update timed out", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		values.Del("_HLS_msn")
+		values.Del("_HLS_part")
+		values.Del("_HLS_skip")
+		query = values.Encode()
+	}
+
+	s.lock.RLock()
+	if len(s.segments) == 0 {
+		s.lock.RUnlock()
+		http.NotFound(w, r)
+		return
+	}
+	playlist := p.playlist(s, query, p.partTarget > 0)
+	s.lock.RUnlock()
+
+	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprint(w, playlist)
+}
+
+// ServeSegment serves one segment still held for a stream.
+func (p *HLSPackager) ServeSegment(w http.ResponseWriter, r *http.Request, stream string, seq uint64) {
+	s := p.stream(stream)
+	if s == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var segment *hlsSegment
+	s.lock.RLock()
+	for _, candidate := range s.segments {
+		if candidate.seq == seq {
+			segment = candidate
+		}
+	}
+	dir := s.dir
+	s.lock.RUnlock()
+	if segment == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "video/mp2t")
+	w.Header().Set("Cache-Control", "max-age=60")
+	if segment.data == nil {
+		http.ServeFile(w, r, filepath.Join(dir, segment.name()))
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(segment.data)))
+	w.Write(segment.data)
+}
+
+// ServePart serves a part of a recent segment. The part announced by the
+// playlist's preload hint is sent as soon as it is complete.
+func (p *HLSPackager) ServePart(w http.ResponseWriter, r *http.Request, stream string, seq uint64, index int) {
+	s := p.stream(stream)
+	if s == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var part *hlsPart
+	p.wait(r, s, func() bool {
+		part = s.part(seq, index)
+		return part != nil || seq != s.nextSeq || index > len(s.parts)
+	})
+	if part == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "video/mp2t")
+	w.Header().Set("Cache-Control", "max-age=60")
+	w.Header().Set("Content-Length", strconv.Itoa(len(part.data)))
+	w.Write(part.data)
+}
+
+// writeFileAtomic replaces path through a temporary file, so readers never
+// see a partly written file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// hlsRoutes serves the playlists and segments when HLS is enabled.
+func (h *WebSocketHandler) hlsRoutes(r *mux.Router) {
+	if h.hls == nil {
+		return
+	}
+
+	r.HandleFunc("/hls/{stream}/index.m3u8", h.ServeHLSPlaylist).Methods("GET", "HEAD")
+	r.HandleFunc("/hls/{stream}/{seq:[0-9]+}.ts", h.ServeHLSSegment).Methods("GET", "HEAD")
+	r.HandleFunc("/hls/{stream}/{seq:[0-9]+}.{part:[0-9]+}.ts", h.ServeHLSPart).Methods("GET", "HEAD")
+}
+
+func (h *WebSocketHandler) ServeHLSPlaylist(w http.ResponseWriter, r *http.Request) {
+	stream := streamName(r)
+	if h.allowHTTPViewer(w, r, stream) {
+		h.hls.ServePlaylist(w, r, stream)
+	}
+}
+
+func (h *WebSocketHandler) ServeHLSSegment(w http.ResponseWriter, r *http.Request) {
+	stream := streamName(r)
+	seq, err := strconv.ParseUint(mux.Vars(r)["seq"], 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if h.allowHTTPViewer(w, r, stream) {
+		h.hls.ServeSegment(w, r, stream, seq)
+	}
+}
+
+func (h *WebSocketHandler) ServeHLSPart(w http.ResponseWriter, r *http.Request) {
+	stream := streamName(r)
+	seq, err := strconv.ParseUint(mux.Vars(r)["seq"], 10, 64)
+	index, indexErr := strconv.Atoi(mux.Vars(r)["part"])
+	if err != nil || indexErr != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if h.allowHTTPViewer(w, r, stream) {
+		h.hls.ServePart(w, r, stream, seq, index)
+	}
+}
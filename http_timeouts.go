@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// withTimeouts gives srv the HTTP timeouts of params. Handlers that stream
+// for as long as the client stays call streaming to lift the read and write
+// timeouts off their request.
+func withTimeouts(srv *http.Server, params *Params) *http.Server {
+	srv.ReadHeaderTimeout = params.httpReadHeaderTimeout
+	srv.ReadTimeout = params.httpReadTimeout
+	srv.WriteTimeout = params.httpWriteTimeout
+	srv.IdleTimeout = params.httpIdleTimeout
+	return srv
+}
+
+// streaming clears the deadlines -http-read-timeout and -http-write-timeout
+// put on the connection of w, for publishers and streaming viewers. Reads
+// and writes still time out where the handler sets deadlines of its own.
+func streaming(w http.ResponseWriter) {
+	controller := http.NewResponseController(w)
+	controller.SetReadDeadline(time.Time{})
+	controller.SetWriteDeadline(time.Time{})
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// tapQueue bounds the chunks waiting for a Tap; a viewer that falls this
+// far behind is dropped.
+const tapQueue = 512
+
+// sseKeepAlive is how often an idle Server-Sent Events response gets a
+// comment, so proxies do not time it out.
+const sseKeepAlive = 15 * time.Second
+
+// Tap hands the data broadcast on a stream to a viewer served over
+// something other than a WebSocket. A tap whose queue is full is dropped
+// rather than holding up the publisher, which closes Done. Every Buffer
+// received from C has to be released once written.
+type Tap struct {
+	stream string
+	C      chan *Buffer
+	done   chan struct{}
+	once   sync.Once
+}
+
+// taps is the set of Taps of every stream.
+type taps struct {
+	streams map[string]map[*Tap]bool
+	lock    sync.Mutex
+}
+
+func (t *Tap) Done() <-chan struct{} {
+	return t.done
+}
+
+func (t *Tap) close() {
+	t.once.Do(func() { close(t.done) })
+}
+
+// AddTap starts passing the data of stream to a new Tap until RemoveTap.
+func (h *WebSocketHandler) AddTap(stream string) *Tap {
+	tap := &Tap{stream: stream, C: make(chan *Buffer, tapQueue), done: make(chan struct{})}
+
+	h.taps.lock.Lock()
+	defer h.taps.lock.Unlock()
+
+	if h.taps.streams == nil {
+		h.taps.streams = make(map[string]map[*Tap]bool)
+	}
+	if h.taps.streams[stream] == nil {
+		h.taps.streams[stream] = make(map[*Tap]bool)
+	}
+	h.taps.streams[stream][tap] = true
+
+	return tap
+}
+
+func (h *WebSocketHandler) RemoveTap(tap *Tap) {
+	h.taps.lock.Lock()
+	defer h.taps.lock.Unlock()
+
+	delete(h.taps.streams[tap.stream], tap)
+	if len(h.taps.streams[tap.stream]) == 0 {
+		delete(h.taps.streams, tap.stream)
+	}
+	tap.close()
+}
+
+func (h *WebSocketHandler) feedTaps(stream string, data *Buffer) {
+	h.taps.lock.Lock()
+	defer h.taps.lock.Unlock()
+
+	for tap := range h.taps.streams[stream] {
+		data.Retain()
+		select {
+		case tap.C <- data:
+		default:
+			data.Release()
+			delete(h.taps.streams[stream], tap)
+			tap.close()
+		}
+	}
+}
+
+// closeTaps ends every tap, on shutdown.
+func (h *WebSocketHandler) closeTaps() {
+	h.taps.lock.Lock()
+	defer h.taps.lock.Unlock()
+
+	for stream, streamTaps := range h.taps.streams {
+		for tap := range streamTaps {
+			tap.close()
+		}
+		delete(h.taps.streams, stream)
+	}
+}
+
+// ServeSSE streams to viewers that cannot open a WebSocket as Server-Sent
+// Events, each carrying one base64 encoded chunk of MPEG-TS.
+func (h *WebSocketHandler) ServeSSE(w http.ResponseWriter, r *http.Request) {
+	if !h.allowCORS(w, r, "GET, OPTIONS") || r.Method == "OPTIONS" {
+		return
+	}
+
+	stream := streamName(r)
+	if !h.allowHTTPViewer(w, r, stream) {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	ip, ok := h.acquireHTTPViewer(w, r)
+	if !ok {
+		return
+	}
+	defer h.limiter.Release(ip)
+	if !h.admit(w, r, stream) {
+		return
+	}
+	defer h.admission.Release(stream)
+
+	tap := h.AddTap(stream)
+	defer h.RemoveTap(tap)
+	egress := h.egress.Stream(stream)
+	evicted := h.drain.Evicted()
+	h.logger.Printf("SSE viewer %s connected to stream %s\n", r.RemoteAddr, stream)
+	streaming(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprint(w, "retry: 2000\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case data := <-tap.C:
+			n, _ := fmt.Fprintf(w, "data: %s\n\n", base64.StdEncoding.EncodeToString(data.Bytes()))
+			egress.Add(n)
+			data.Release()
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-tap.Done():
+			return
+		case <-evicted:
+			return
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// ServeLive serves the live MPEG-TS of a stream as one never-ending HTTP
+// response, for players such as VLC, mpv and ffplay.
+func (h *WebSocketHandler) ServeLive(w http.ResponseWriter, r *http.Request) {
+	stream := streamName(r)
+	if !h.allowHTTPViewer(w, r, stream) {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "video/mp2t")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	if r.Method == "HEAD" {
+		return
+	}
+
+	ip, ok := h.acquireHTTPViewer(w, r)
+	if !ok {
+		return
+	}
+	defer h.limiter.Release(ip)
+	if !h.admit(w, r, stream) {
+		return
+	}
+	defer h.admission.Release(stream)
+
+	tap := h.AddTap(stream)
+	defer h.RemoveTap(tap)
+	egress := h.egress.Stream(stream)
+	evicted := h.drain.Evicted()
+	h.logger.Printf("HTTP viewer %s connected to stream %s\n", r.RemoteAddr, stream)
+	defer h.logger.Printf("HTTP viewer %s left stream %s\n", r.RemoteAddr, stream)
+	streaming(w)
+
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case data := <-tap.C:
+			n, err := w.Write(data.Bytes())
+			egress.Add(n)
+			data.Release()
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-tap.Done():
+			return
+		case <-evicted:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// acquireHTTPViewer takes a connection of the per-IP limits for a plain HTTP
+// viewer, answering the request when it is over them. The caller releases
+// the returned IP when the viewer leaves.
+func (h *WebSocketHandler) acquireHTTPViewer(w http.ResponseWriter, r *http.Request) (string, bool) {
+	ip := hostname(r.RemoteAddr)
+	if !h.limiter.Attempt(ip) {
+		http.Error(w, "Too many connection attempts", http.StatusTooManyRequests)
+		return "", false
+	}
+	if !h.limiter.Acquire(ip) {
+		http.Error(w, "Too many connections", http.StatusTooManyRequests)
+		return "", false
+	}
+
+	return ip, true
+}
+
+// allowHTTPViewer applies the viewer access rules, bans and tokens to a
+// plain HTTP request for stream, answering it when the viewer is rejected.
+func (h *WebSocketHandler) allowHTTPViewer(w http.ResponseWriter, r *http.Request, stream string) bool {
+	h.settingsLock.RLock()
+	auth := h.auth
+	access := h.access
+	h.settingsLock.RUnlock()
+
+	if !access.Allows(r, stream) || h.bans.Banned(hostname(r.RemoteAddr)) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	if !h.egress.Admits(stream) {
+		http.Error(w, "Egress bandwidth limit reached", http.StatusServiceUnavailable)
+		return false
+	}
+	if auth != nil {
+		if _, _, err := auth.Authorize(r, stream); err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return false
+		}
+	}
+
+	return true
+}
+
+// allowCORS lets the pages allowed to open a WebSocket call a plain HTTP
+// viewer endpoint from another origin as well, answering requests from other
+// origins with 403.
+func (h *WebSocketHandler) allowCORS(w http.ResponseWriter, r *http.Request, methods string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	h.settingsLock.RLock()
+	upgrader := h.upgrader
+	h.settingsLock.RUnlock()
+	if !upgrader.CheckOrigin(r) {
+		http.Error(w, "Origin not allowed", http.StatusForbidden)
+		return false
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	w.Header().Set("Access-Control-Allow-Methods", methods)
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+	w.Header().Set("Access-Control-Expose-Headers", "Location")
+	w.Header().Add("Vary", "Origin")
+
+	return true
+}
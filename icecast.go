@@ -0,0 +1,117 @@
+package main
+
+import (
+	"github.com/gorilla/mux"
+
+	"net/http"
+)
+
+// icecastUser is the user name Icecast source clients authenticate with.
+const icecastUser = "source"
+
+// isSourceRequest matches the PUT and legacy SOURCE requests Icecast source
+// clients such as libshout and "ffmpeg -f mpegts icecast://" send.
+func isSourceRequest(r *http.Request, rm *mux.RouteMatch) bool {
+	if r.Method != http.MethodPut && r.Method != "SOURCE" {
+		return false
+	}
+	_, _, ok := r.BasicAuth()
+	return ok
+}
+
+// HandleSource publishes an Icecast source client to the stream named by the
+// mount point. The password is the stream's secret, or the global secret for
+// streams without one; streams with basic_auth take their own credentials.
+func (s *IncomingStreamHandler) HandleSource(w http.ResponseWriter, r *http.Request) {
+	stream := mux.Vars(r)["stream"]
+
+	if !s.checkSourceAuth(r, stream) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="jsmpeg ingest", charset="UTF-8"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// A body with a length is read the usual way; net/http answers
+	// "Expect: 100-continue" on the first read.
+	if r.ContentLength > 0 || len(r.TransferEncoding) > 0 {
+		s.Publish(w, r, stream)
+		return
+	}
+
+	s.publishSource(w, r, stream)
+}
+
+func (s *IncomingStreamHandler) checkSourceAuth(r *http.Request, stream string) bool {
+	s.secretsLock.RLock()
+	signedOnly := s.signedOnly
+	credentials, basic := s.basicAuth[stream]
+	secret, keyed := s.streamSecrets[stream]
+	if !keyed {
+		secret = s.secret
+	}
+	s.secretsLock.RUnlock()
+
+	if signedOnly {
+		return false
+	}
+	if basic {
+		return checkBasicAuth(r, credentials)
+	}
+
+	return secret != "" && checkBasicAuth(r, BasicAuthConfig{Username: icecastUser, Password: secret})
+}
+
+// publishSource serves source clients that stream an open-ended body without
+// a length, as Icecast allows. The connection is taken over from net/http,
+// which would otherwise treat the body as empty.
+func (s *IncomingStreamHandler) publishSource(w http.ResponseWriter, r *http.Request, stream string) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Streaming body not supported", http.StatusLengthRequired)
+		return
+	}
+
+	session, ok := s.startPublish(w, r, stream)
+	if !ok {
+		return
+	}
+	defer s.endPublish(session)
+
+	streaming(w)
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		s.logger.Println(err)
+		return
+	}
+	defer conn.Close()
+
+	if r.Header.Get("Expect") == "100-continue" {
+		rw.WriteString("HTTP/1.1 100 Continue\r\n\r\n")
+	} else {
+		rw.WriteString("HTTP/1.0 200 OK\r\n\r\n")
+	}
+	if err := rw.Flush(); err != nil {
+		return
+	}
+
+	limits := s.Limits()
+	chunks := s.NewChunkReader(limits.Reader(rw, conn.SetReadDeadline))
+	for {
+		data, err := chunks.Next()
+		if err != nil {
+			break
+		}
+
+		if session.Superseded() {
+			s.logger.Printf("IncomingStream %s superseded on stream %s\n", r.RemoteAddr, stream)
+			break
+		}
+		session.meter.Add(len(data))
+		if err := limits.Check(session); err != nil {
+			s.logger.Printf("IncomingStream %s disconnected: %v\n", r.RemoteAddr, err)
+			break
+		}
+
+		s.Broadcast(session, data)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Headers of a signed ingest request. The signature is the hex encoded
+// HMAC-SHA256, keyed with the API key secret, of
+//
+//	METHOD "\n" PATH "\n" TIMESTAMP "\n" CONTENT-SHA256
+//
+// where CONTENT-SHA256 is the hex SHA-256 of the body, or UNSIGNED-PAYLOAD for
+// long running streams whose body is not known up front.
+const (
+	ingestKeyHeader         = "X-Jsmpeg-Key"
+	ingestTimestampHeader   = "X-Jsmpeg-Timestamp"
+	ingestContentHashHeader = "X-Jsmpeg-Content-Sha256"
+	ingestSignatureHeader   = "X-Jsmpeg-Signature"
+
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+
+	maxSignedBodySize = 16 << 20
+)
+
+type IngestKey struct {
+	ID      string   `yaml:"id"`
+	Secret  string   `yaml:"secret"`
+	Streams []string `yaml:"streams"` // empty allows every stream
+}
+
+func (k IngestKey) Allows(stream string) bool {
+	if len(k.Streams) == 0 {
+		return true
+	}
+	for _, name := range k.Streams {
+		if name == stream {
+			return true
+		}
+	}
+	return false
+}
+
+// IngestVerifier checks signed ingest requests and remembers the signatures it
+// accepted until they go stale, so a captured request cannot be replayed.
+type IngestVerifier struct {
+	keys    map[string]IngestKey
+	maxSkew time.Duration
+	seen    map[string]time.Time // signature -> time it becomes stale
+	lock    sync.Mutex
+}
+
+func NewIngestVerifier(params *Params) *IngestVerifier {
+	verifier := &IngestVerifier{
+		seen: make(map[string]time.Time),
+	}
+	verifier.ApplyParams(params)
+
+	return verifier
+}
+
+func (v *IngestVerifier) ApplyParams(params *Params) {
+	keys := make(map[string]IngestKey)
+	for _, key := range params.ingestKeys {
+		keys[key.ID] = key
+	}
+
+	v.lock.Lock()
+	v.keys = keys
+	v.maxSkew = params.ingestMaxSkew
+	v.lock.Unlock()
+}
+
+func IngestSignature(secret, method, path, timestamp, contentHash string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s", method, path, timestamp, contentHash)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify authenticates r as a publish to stream. A request with a body hash
+// has its body read and checked here, and r.Body is replaced by the verified
+// copy.
+func (v *IngestVerifier) Verify(r *http.Request, stream string) error {
+	v.lock.Lock()
+	key, ok := v.keys[r.Header.Get(ingestKeyHeader)]
+	maxSkew := v.maxSkew
+	v.lock.Unlock()
+
+	if !ok {
+		return fmt.Errorf("unknown API key")
+	}
+	if !key.Allows(stream) {
+		return fmt.Errorf("API key %s may not publish to %s", key.ID, stream)
+	}
+
+	timestamp := r.Header.Get(ingestTimestampHeader)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp")
+	}
+	signedAt := time.Unix(unix, 0)
+	if skew := time.Since(signedAt); skew > maxSkew || skew < -maxSkew {
+		return fmt.Errorf("stale request signed at %v", signedAt)
+	}
+
+	contentHash := r.Header.Get(ingestContentHashHeader)
+	if contentHash == "" {
+		return fmt.Errorf("missing %s", ingestContentHashHeader)
+	}
+
+	signature := r.Header.Get(ingestSignatureHeader)
+	expected := IngestSignature(key.Secret, r.Method, r.URL.EscapedPath(), timestamp, contentHash)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return fmt.Errorf("signature mismatch")
+	}
+
+	if err := v.markSeen(signature, signedAt.Add(maxSkew)); err != nil {
+		return err
+	}
+
+	if contentHash == unsignedPayload {
+		return nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSignedBodySize+1))
+	if err != nil {
+		return fmt.Errorf("reading body: %v", err)
+	}
+	if len(body) > maxSignedBodySize {
+		return fmt.Errorf("signed body exceeds %d bytes", maxSignedBodySize)
+	}
+	sum := sha256.Sum256(body)
+	if !hmac.Equal([]byte(hex.EncodeToString(sum[:])), []byte(contentHash)) {
+		return fmt.Errorf("body does not match %s", ingestContentHashHeader)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	return nil
+}
+
+func (v *IngestVerifier) markSeen(signature string, staleAt time.Time) error {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	now := time.Now()
+	for seen, expiry := range v.seen {
+		if now.After(expiry) {
+			delete(v.seen, seen)
+		}
+	}
+
+	if _, ok := v.seen[signature]; ok {
+		return fmt.Errorf("replayed request")
+	}
+	v.seen[signature] = staleAt
+
+	return nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"github.com/chanshik/jsmpeg-stream-go/ingestpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"time"
+)
+
+// GRPCIngestSource serves the StreamIngest gRPC service, for publisher
+// programs that want to know what became of their data: every message is
+// acknowledged and the call ends with a status code saying why the server
+// stopped accepting data.
+type GRPCIngestSource struct {
+	ingestpb.UnimplementedStreamIngestServer
+
+	addr    string
+	handler *IncomingStreamHandler
+	srv     *grpc.Server
+
+	logger *log.Logger
+}
+
+func NewGRPCIngestSource(params *Params, handler *IncomingStreamHandler) Source {
+	if params.ingestGRPCPort == 0 {
+		return nil
+	}
+
+	source := &GRPCIngestSource{
+		addr:    fmt.Sprintf("0.0.0.0:%d", params.ingestGRPCPort),
+		handler: handler,
+		logger:  params.logger,
+	}
+
+	opts := []grpc.ServerOption{}
+	if tlsConfig := params.IngestTLSConfig(false); tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	source.srv = grpc.NewServer(opts...)
+	ingestpb.RegisterStreamIngestServer(source.srv, source)
+
+	return source
+}
+
+func (g *GRPCIngestSource) Run() {
+	listener, err := g.handler.handoff.Listen("tcp", g.addr)
+	if err != nil {
+		g.logger.Printf("gRPC ingest: %v\n", err)
+		return
+	}
+
+	g.logger.Println("gRPC ingest listening at " + g.addr)
+	if err := g.srv.Serve(listener); err != nil {
+		g.logger.Printf("gRPC ingest stopped: %v\n", err)
+	}
+}
+
+func (g *GRPCIngestSource) Close() {
+	g.srv.Stop()
+}
+
+func (g *GRPCIngestSource) Publish(stream ingestpb.StreamIngest_PublishServer) error {
+	ctx := stream.Context()
+	remoteAddr := "grpc"
+	if p, ok := peer.FromContext(ctx); ok {
+		remoteAddr = p.Addr.String()
+	}
+
+	req, err := stream.Recv()
+	if err == io.EOF {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	s := g.handler
+	name := req.Stream
+	if name == "" {
+		name = defaultStreamName
+	}
+	if !s.AcceptsSecret(name, req.Secret) {
+		s.logger.Printf("IncomingStream %s rejected: invalid secret for stream %s\n", remoteAddr, name)
+		return status.Error(codes.Unauthenticated, "invalid secret")
+	}
+
+	s.secretsLock.RLock()
+	access := s.access
+	s.secretsLock.RUnlock()
+	if !access.AllowsIP(net.ParseIP(hostname(remoteAddr)), name) {
+		s.logger.Printf("IncomingStream %s not allowed on stream %s\n", remoteAddr, name)
+		return status.Error(codes.PermissionDenied, "publisher not allowed")
+	}
+	if listener, ok := s.listeners[name]; ok {
+		return status.Errorf(codes.PermissionDenied, "stream is published on %s only", listener.addr)
+	}
+
+	session, err := s.publisherLock.AcquireAddr(name, remoteAddr, nil, req.Takeover)
+	if err == errDraining {
+		s.logger.Printf("IncomingStream %s rejected: %v\n", remoteAddr, err)
+		return status.Error(codes.Unavailable, err.Error())
+	} else if err != nil {
+		s.logger.Printf("IncomingStream %s rejected: %v\n", remoteAddr, err)
+		return status.Error(codes.AlreadyExists, err.Error())
+	}
+	s.publishers.Add(1)
+	s.logger.Printf("IncomingStream connected: %s (stream %s, gRPC)\n", remoteAddr, name)
+	defer s.endPublish(session)
+
+	// Receive in the background so a silent publisher runs into the read
+	// timeout; the goroutine ends with the call.
+	requests := make(chan *ingestpb.PublishRequest)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case requests <- req:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	limits := s.Limits()
+	var timeout <-chan time.Time
+	var timer *time.Timer
+	if limits.ReadTimeout > 0 {
+		timer = time.NewTimer(limits.ReadTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var received int64
+	for {
+		if session.Superseded() {
+			s.logger.Printf("IncomingStream %s superseded on stream %s\n", remoteAddr, name)
+			return status.Error(codes.Aborted, "another publisher took the stream over")
+		}
+		if len(req.Data) > 0 {
+			received += int64(len(req.Data))
+			session.meter.Add(len(req.Data))
+			if err := limits.Check(session); err != nil {
+				s.logger.Printf("IncomingStream %s disconnected: %v\n", remoteAddr, err)
+				return status.Error(codes.ResourceExhausted, err.Error())
+			}
+			s.Broadcast(session, req.Data)
+		}
+
+		err := stream.Send(&ingestpb.PublishAck{
+			Sequence:      req.Sequence,
+			BytesReceived: received,
+			Bitrate:       session.meter.Bitrate(),
+		})
+		if err != nil {
+			return err
+		}
+
+		if timer != nil {
+			timer.Reset(limits.ReadTimeout)
+		}
+		select {
+		case req = <-requests:
+		case err := <-recvErr:
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		case <-timeout:
+			s.logger.Printf("IncomingStream %s disconnected: nothing received for %v\n", remoteAddr, limits.ReadTimeout)
+			return status.Errorf(codes.DeadlineExceeded, "nothing received for %v", limits.ReadTimeout)
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// defaultIngestReadTimeout is how long a publisher may stay silent before it
+// is disconnected, unless configured otherwise.
+const defaultIngestReadTimeout = 30 * time.Second
+
+// IngestLimits bounds what a publisher connecting to the server may use. A
+// zero value disables that limit.
+type IngestLimits struct {
+	MaxBitrate  int64         // bits per second, measured over a second
+	MaxDuration time.Duration // of one publish session
+	ReadTimeout time.Duration // without any data from the publisher
+}
+
+func (s *IncomingStreamHandler) Limits() IngestLimits {
+	s.secretsLock.RLock()
+	defer s.secretsLock.RUnlock()
+
+	return s.limits
+}
+
+// Check returns why session has to be disconnected, or nil.
+func (l IngestLimits) Check(session *PublishSession) error {
+	if l.MaxDuration > 0 && time.Since(session.started) > l.MaxDuration {
+		return fmt.Errorf("published for longer than %v", l.MaxDuration)
+	}
+	if l.MaxBitrate > 0 {
+		if bitrate := session.meter.Bitrate(); bitrate > float64(l.MaxBitrate) {
+			return fmt.Errorf("bitrate %.0f bit/s exceeds %d bit/s", bitrate, l.MaxBitrate)
+		}
+	}
+
+	return nil
+}
+
+// Reader makes every read from reader fail once the publisher has been
+// silent for the read timeout. setDeadline sets the read deadline of the
+// publisher's connection.
+func (l IngestLimits) Reader(reader io.Reader, setDeadline func(time.Time) error) io.Reader {
+	if l.ReadTimeout <= 0 {
+		return reader
+	}
+
+	return &deadlineReader{reader: reader, timeout: l.ReadTimeout, setDeadline: setDeadline}
+}
+
+type deadlineReader struct {
+	reader      io.Reader
+	timeout     time.Duration
+	setDeadline func(time.Time) error
+}
+
+func (r *deadlineReader) Read(p []byte) (int, error) {
+	r.setDeadline(time.Now().Add(r.timeout))
+	return r.reader.Read(p)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// jitterBufferPackets bounds how many RTP packets wait for a missing one.
+const jitterBufferPackets = 512
+
+// RTPPacket is the part of an RTP packet the ingest needs.
+type RTPPacket struct {
+	Sequence uint16
+	SSRC     uint32
+	Payload  []byte
+}
+
+// ParseRTP strips the RTP header, CSRCs, extension and padding from packet.
+func ParseRTP(packet []byte) (RTPPacket, error) {
+	if len(packet) < 12 || packet[0]>>6 != 2 {
+		return RTPPacket{}, fmt.Errorf("not an RTP version 2 packet")
+	}
+
+	header := 12 + 4*int(packet[0]&0x0f)
+	if packet[0]&0x10 != 0 {
+		if len(packet) < header+4 {
+			return RTPPacket{}, fmt.Errorf("truncated RTP header extension")
+		}
+		header += 4 + 4*int(binary.BigEndian.Uint16(packet[header+2:]))
+	}
+	end := len(packet)
+	if packet[0]&0x20 != 0 && end > 0 {
+		end -= int(packet[end-1])
+	}
+	if header > end {
+		return RTPPacket{}, fmt.Errorf("truncated RTP packet")
+	}
+
+	return RTPPacket{
+		Sequence: binary.BigEndian.Uint16(packet[2:]),
+		SSRC:     binary.BigEndian.Uint32(packet[8:]),
+		Payload:  packet[header:end],
+	}, nil
+}
+
+type jitterPacket struct {
+	payload []byte
+	arrived time.Time
+}
+
+// JitterBuffer puts RTP payloads back in sequence order. A packet is held
+// until the ones before it arrived or delay has passed, after which the
+// missing ones are given up as lost.
+type JitterBuffer struct {
+	delay   time.Duration
+	packets map[uint16]jitterPacket
+	next    uint16
+	ssrc    uint32
+	started bool
+}
+
+func NewJitterBuffer(delay time.Duration) *JitterBuffer {
+	return &JitterBuffer{
+		delay:   delay,
+		packets: make(map[uint16]jitterPacket),
+	}
+}
+
+// Push adds packet, dropping duplicates and packets that arrive after their
+// place in the sequence has been given up. A new SSRC starts over.
+func (j *JitterBuffer) Push(packet RTPPacket, now time.Time) {
+	if !j.started || packet.SSRC != j.ssrc {
+		j.packets = make(map[uint16]jitterPacket)
+		j.next = packet.Sequence
+		j.ssrc = packet.SSRC
+		j.started = true
+	}
+
+	if int16(packet.Sequence-j.next) < 0 {
+		return
+	}
+	if _, ok := j.packets[packet.Sequence]; ok {
+		return
+	}
+
+	payload := make([]byte, len(packet.Payload))
+	copy(payload, packet.Payload)
+	j.packets[packet.Sequence] = jitterPacket{payload: payload, arrived: now}
+}
+
+// Pop returns the payloads that are ready, in sequence order.
+func (j *JitterBuffer) Pop(now time.Time) [][]byte {
+	ready := [][]byte{}
+	for len(j.packets) > 0 {
+		if packet, ok := j.packets[j.next]; ok {
+			ready = append(ready, packet.payload)
+			delete(j.packets, j.next)
+			j.next++
+			continue
+		}
+
+		// Skip the gap once the packet after it has waited long enough.
+		first, packet := j.first()
+		if now.Sub(packet.arrived) < j.delay && len(j.packets) < jitterBufferPackets {
+			break
+		}
+		j.next = first
+	}
+
+	return ready
+}
+
+// Deadline returns when the packet after the current gap stops waiting for
+// it.
+func (j *JitterBuffer) Deadline() (time.Time, bool) {
+	if len(j.packets) == 0 {
+		return time.Time{}, false
+	}
+
+	_, packet := j.first()
+	return packet.arrived.Add(j.delay), true
+}
+
+func (j *JitterBuffer) first() (uint16, jitterPacket) {
+	var first uint16
+	distance := -1
+	for seq := range j.packets {
+		if d := int(seq - j.next); distance < 0 || d < distance {
+			first, distance = seq, d
+		}
+	}
+
+	return first, j.packets[first]
+}
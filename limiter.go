@@ -0,0 +1,98 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+type attemptWindow struct {
+	start time.Time
+	count int
+}
+
+// ConnectionLimiter caps the concurrent WebSocket connections and the upgrade
+// attempts per window of each source IP. A limit of 0 disables that check.
+type ConnectionLimiter struct {
+	maxConns    int
+	maxAttempts int
+	window      time.Duration
+
+	conns     map[string]int
+	attempts  map[string]*attemptWindow
+	lastSweep time.Time
+	lock      sync.Mutex
+}
+
+func NewConnectionLimiter(params *Params) *ConnectionLimiter {
+	limiter := &ConnectionLimiter{
+		conns:     make(map[string]int),
+		attempts:  make(map[string]*attemptWindow),
+		lastSweep: time.Now(),
+	}
+	limiter.ApplyParams(params)
+
+	return limiter
+}
+
+// ApplyParams changes the limits; connections already counted stay counted.
+func (l *ConnectionLimiter) ApplyParams(params *Params) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.maxConns = params.maxConnsPerIP
+	l.maxAttempts = params.maxUpgradesPerIP
+	l.window = params.upgradeWindow
+}
+
+// Attempt records an upgrade attempt from ip and reports whether it is within
+// the rate limit.
+func (l *ConnectionLimiter) Attempt(ip string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.maxAttempts == 0 {
+		return true
+	}
+
+	now := time.Now()
+	if now.Sub(l.lastSweep) > l.window {
+		for addr, attempts := range l.attempts {
+			if now.Sub(attempts.start) > l.window {
+				delete(l.attempts, addr)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	attempts, ok := l.attempts[ip]
+	if !ok || now.Sub(attempts.start) > l.window {
+		attempts = &attemptWindow{start: now}
+		l.attempts[ip] = attempts
+	}
+	attempts.count++
+
+	return attempts.count <= l.maxAttempts
+}
+
+// Acquire counts a new connection from ip unless ip is at its limit. Every
+// successful Acquire must be paired with a Release.
+func (l *ConnectionLimiter) Acquire(ip string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.maxConns != 0 && l.conns[ip] >= l.maxConns {
+		return false
+	}
+	l.conns[ip]++
+
+	return true
+}
+
+func (l *ConnectionLimiter) Release(ip string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.conns[ip]--; l.conns[ip] <= 0 {
+		delete(l.conns, ip)
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"github.com/gorilla/websocket"
+
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The loadtest publisher sends MPEG-TS packets on loadTestPID, which players
+// ignore. The first of every chunk is a probe: loadTestMagic, the time it was
+// sent in nanoseconds and its sequence number, from which viewers measure
+// the latency and count the chunks they missed.
+const (
+	loadTestPID             = 0x1ff0
+	loadTestPacketsPerChunk = 7
+	loadTestMaxSamples      = 1 << 20
+)
+
+var loadTestMagic = []byte("JSMPEGLT")
+
+// loadTest counts what the synthetic viewers received.
+type loadTest struct {
+	connections atomic.Int64
+	connected   atomic.Int64
+	failed      atomic.Int64
+	lost        atomic.Int64 // disconnected before the end of the test
+	messages    atomic.Int64
+	bytes       atomic.Int64
+	probes      atomic.Int64
+	missed      atomic.Int64
+	published   atomic.Int64
+
+	latencies []time.Duration // since the last report
+	samples   []time.Duration // the whole test, a random sample beyond loadTestMaxSamples
+	seen      int64
+	errors    map[string]bool // connection errors already logged
+	lock      sync.Mutex
+}
+
+// LoadTestCommand implements "stream-server loadtest", connecting synthetic
+// viewers to a server and, with -publish, publishing to it, then reporting
+// the throughput, latency and drops they saw.
+func LoadTestCommand(args []string) error {
+	flags := flag.NewFlagSet("loadtest", flag.ExitOnError)
+	target := flags.String("url", "ws://localhost:8084/ws/"+defaultStreamName, "WebSocket URL the viewers connect to, with any token in its query")
+	viewers := flags.Int("viewers", 100, "Number of viewers")
+	ramp := flags.Duration("ramp", 10*time.Second, "Time over which the viewers connect")
+	duration := flags.Duration("duration", time.Minute, "Length of the test, from the first viewer on")
+	interval := flags.Duration("interval", 5*time.Second, "Time between reports")
+	publish := flags.String("publish", "", "Ingest URL to publish synthetic MPEG-TS to, e.g. http://localhost:8082/secret/lobby; needed to measure latency and drops")
+	bitrate := flags.Int64("bitrate", 2000000, "Bits per second the publisher sends")
+	insecure := flags.Bool("insecure", false, "Accept any TLS certificate")
+	flags.Parse(args)
+
+	if *viewers <= 0 {
+		return fmt.Errorf("-viewers must be positive")
+	}
+	if *bitrate <= 0 {
+		return fmt.Errorf("-bitrate must be positive")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	defer cancel()
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	tlsConfig := &tls.Config{InsecureSkipVerify: *insecure}
+	t := &loadTest{errors: make(map[string]bool)}
+
+	if *publish != "" {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		go func() {
+			if err := t.publish(ctx, client, *publish, *bitrate); err != nil && ctx.Err() == nil {
+				logger.Printf("Publisher stopped: %v\n", err)
+			}
+		}()
+	}
+
+	dialer := &websocket.Dialer{HandshakeTimeout: 10 * time.Second, TLSClientConfig: tlsConfig}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < *viewers && ctx.Err() == nil; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				t.view(ctx, dialer, *target, logger)
+			}()
+			if *viewers > 1 {
+				select {
+				case <-time.After(*ramp / time.Duration(*viewers-1)):
+				case <-ctx.Done():
+				}
+			}
+		}
+	}()
+
+	started := time.Now()
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	var messages, received int64
+	last := started
+	for done := false; !done; {
+		select {
+		case now := <-ticker.C:
+			m, b := t.messages.Load(), t.bytes.Load()
+			fmt.Println(t.report(now.Sub(started), *viewers, m-messages, b-received, now.Sub(last), t.interval()))
+			messages, received, last = m, b, now
+		case <-ctx.Done():
+			done = true
+		}
+	}
+	wg.Wait()
+
+	elapsed := time.Since(started)
+	t.lock.Lock()
+	all := t.samples
+	t.lock.Unlock()
+	fmt.Println("Summary")
+	fmt.Println(t.report(elapsed, *viewers, t.messages.Load(), t.bytes.Load(), elapsed, all))
+	if *publish != "" {
+		fmt.Printf("Published %d chunks\n", t.published.Load())
+	}
+
+	return nil
+}
+
+// report formats what the viewers received in period.
+func (t *loadTest) report(elapsed time.Duration, viewers int, messages, received int64, period time.Duration, latencies []time.Duration) string {
+	seconds := period.Seconds()
+	line := fmt.Sprintf("%6s viewers %d/%d (%d failed, %d lost)  %.1f Mbit/s  %.0f msg/s",
+		elapsed.Round(time.Second), t.connected.Load(), viewers, t.failed.Load(), t.lost.Load(),
+		float64(received)*8/seconds/1e6, float64(messages)/seconds)
+
+	if len(latencies) > 0 {
+		sorted := append([]time.Duration{}, latencies...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		percentile := func(p float64) time.Duration {
+			return sorted[int(p*float64(len(sorted)-1))].Round(100 * time.Microsecond)
+		}
+		line += fmt.Sprintf("  latency p50 %s p95 %s p99 %s max %s", percentile(0.5), percentile(0.95), percentile(0.99), sorted[len(sorted)-1].Round(100*time.Microsecond))
+	}
+	if probes, missed := t.probes.Load(), t.missed.Load(); probes+missed > 0 {
+		line += fmt.Sprintf("  drops %.2f%%", float64(missed)*100/float64(probes+missed))
+	}
+	return line
+}
+
+// interval returns the latencies measured since it was last called.
+func (t *loadTest) interval() []time.Duration {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	latencies := t.latencies
+	t.latencies = nil
+	return latencies
+}
+
+func (t *loadTest) sample(latency time.Duration) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.latencies = append(t.latencies, latency)
+	t.seen++
+	if len(t.samples) < loadTestMaxSamples {
+		t.samples = append(t.samples, latency)
+	} else if i := rand.Int63n(t.seen); i < loadTestMaxSamples {
+		t.samples[i] = latency
+	}
+}
+
+// connectionFailed counts a viewer that could not connect, logging every
+// kind of error once.
+func (t *loadTest) connectionFailed(err error, logger *log.Logger) {
+	t.failed.Add(1)
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if !t.errors[err.Error()] {
+		t.errors[err.Error()] = true
+		logger.Printf("Viewer failed to connect: %v\n", err)
+	}
+}
+
+// view connects one viewer and reads until ctx is done.
+func (t *loadTest) view(ctx context.Context, dialer *websocket.Dialer, target string, logger *log.Logger) {
+	ws, resp, err := dialer.DialContext(ctx, target, nil)
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		if resp != nil {
+			err = fmt.Errorf("%v (%s)", err, resp.Status)
+		}
+		t.connectionFailed(err, logger)
+		return
+	}
+	t.connections.Add(1)
+	t.connected.Add(1)
+	defer t.connected.Add(-1)
+
+	go func() {
+		<-ctx.Done()
+		ws.Close()
+	}()
+
+	var pending []byte
+	var last uint64
+	for {
+		_, msg, err := ws.ReadMessage()
+		if err != nil {
+			if ctx.Err() == nil {
+				t.lost.Add(1)
+			}
+			return
+		}
+		received := time.Now()
+		t.messages.Add(1)
+		t.bytes.Add(int64(len(msg)))
+
+		tsPackets(&pending, msg, func(packet []byte) {
+			payload := packet[4:]
+			if packetPID(packet) != loadTestPID || !bytes.HasPrefix(payload, loadTestMagic) {
+				return
+			}
+			payload = payload[len(loadTestMagic):]
+			sent := int64(binary.BigEndian.Uint64(payload))
+			seq := binary.BigEndian.Uint64(payload[8:])
+			if seq <= last {
+				return
+			}
+			if last != 0 {
+				t.missed.Add(int64(seq - last - 1))
+			}
+			last = seq
+			t.probes.Add(1)
+			t.sample(received.Sub(time.Unix(0, sent)))
+		})
+	}
+}
+
+// publish posts chunks of probe and filler packets to target at bitrate
+// until ctx is done.
+func (t *loadTest) publish(ctx context.Context, client *http.Client, target string, bitrate int64) error {
+	body, writer := io.Pipe()
+	req, err := http.NewRequestWithContext(ctx, "POST", target, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "video/mp2t")
+
+	go func() {
+		chunk := make([]byte, loadTestPacketsPerChunk*tsPacketSize)
+		every := time.Duration(int64(len(chunk)) * 8 * int64(time.Second) / bitrate)
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+
+		var seq uint64
+		var cc byte
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				writer.Close()
+				return
+			}
+			seq++
+			for i := 0; i < loadTestPacketsPerChunk; i++ {
+				packet := chunk[i*tsPacketSize : (i+1)*tsPacketSize]
+				packet[0] = tsSyncByte
+				packet[1] = loadTestPID >> 8
+				packet[2] = loadTestPID & 0xff
+				packet[3] = 0x10 | cc
+				cc = (cc + 1) & 0x0f
+				payload := packet[4:]
+				for j := range payload {
+					payload[j] = 0xff
+				}
+				if i == 0 {
+					copy(payload, loadTestMagic)
+					binary.BigEndian.PutUint64(payload[len(loadTestMagic):], uint64(time.Now().UnixNano()))
+					binary.BigEndian.PutUint64(payload[len(loadTestMagic)+8:], seq)
+				}
+			}
+			if _, err := writer.Write(chunk); err != nil {
+				return
+			}
+			t.published.Add(1)
+		}
+	}()
+
+	resp, err := client.Do(req)
+	if err != nil {
+		body.CloseWithError(err)
+		return err
+	}
+	defer resp.Body.Close()
+	body.CloseWithError(io.ErrClosedPipe)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("ingest answered %s", resp.Status)
+	}
+	return nil
+}
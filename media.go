@@ -0,0 +1,139 @@
+package main
+
+import (
+	"math"
+	"sync"
+)
+
+// MPEG-1/2 frame rates by frame_rate_code.
+var mpegFrameRates = [...]float64{0, 24000.0 / 1001, 24, 25, 30000.0 / 1001, 30, 50, 60000.0 / 1001, 60}
+
+// VideoInfo describes the video of a stream as read from its bitstream: the
+// sequence header of MPEG-1/2 video or the SPS of H.264. The frame rate of
+// other codecs comes from the timestamps of the frames.
+type VideoInfo struct {
+	Codec     string  `json:"codec"`
+	Width     int     `json:"width,omitempty"`
+	Height    int     `json:"height,omitempty"`
+	FrameRate float64 `json:"frame_rate,omitempty"`
+}
+
+// MediaProbe keeps the VideoInfo of every stream being broadcast. Only the
+// first packet of every picture is looked at.
+type MediaProbe struct {
+	streams map[string]*probeStream
+	lock    sync.Mutex
+}
+
+type probeStream struct {
+	pending []byte
+	pmtPID  uint16
+
+	videoPID  uint16
+	videoType byte
+
+	info   VideoInfo
+	lastTS int64 // DTS of the previous picture, -1 when unknown
+}
+
+func NewMediaProbe() *MediaProbe {
+	return &MediaProbe{streams: make(map[string]*probeStream)}
+}
+
+// Write adds data broadcast on stream.
+func (p *MediaProbe) Write(stream string, data []byte) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	s, ok := p.streams[stream]
+	if !ok {
+		s = &probeStream{lastTS: -1}
+		p.streams[stream] = s
+	}
+	tsPackets(&s.pending, data, s.packet)
+}
+
+func (s *probeStream) packet(packet []byte) {
+	pid := packetPID(packet)
+	switch {
+	case pid == 0:
+		if pmtPID, ok := parsePAT(packet); ok {
+			s.pmtPID = pmtPID
+		}
+	case pid == s.pmtPID && s.pmtPID != 0:
+		if videoPID, videoType, ok := parsePMT(packet); ok && (videoPID != s.videoPID || videoType != s.videoType) {
+			s.videoPID = videoPID
+			s.videoType = videoType
+			s.info = VideoInfo{Codec: videoCodecs[videoType]}
+			s.lastTS = -1
+		}
+	case pid == s.videoPID && s.videoPID != 0 && packetPayloadStart(packet):
+		s.picture(packetPayload(packet))
+	}
+}
+
+var videoCodecs = map[byte]string{
+	streamTypeMPEG1Video: "mpeg1video",
+	streamTypeMPEG2Video: "mpeg2video",
+	streamTypeH264:       "h264",
+	streamTypeHEVC:       "hevc",
+}
+
+// picture reads the start of a video PES packet.
+func (s *probeStream) picture(pes []byte) {
+	if len(pes) < 14 || pes[0] != 0 || pes[1] != 0 || pes[2] != 1 || 9+int(pes[8]) > len(pes) {
+		return
+	}
+	es := pes[9+int(pes[8]):]
+
+	switch s.videoType {
+	case streamTypeMPEG1Video, streamTypeMPEG2Video:
+		for i := 0; i+7 < len(es); i++ {
+			if es[i] == 0 && es[i+1] == 0 && es[i+2] == 1 && es[i+3] == 0xb3 {
+				s.info.Width = int(es[i+4])<<4 | int(es[i+5])>>4
+				s.info.Height = int(es[i+5]&0x0f)<<8 | int(es[i+6])
+				if code := int(es[i+7] & 0x0f); code < len(mpegFrameRates) {
+					s.info.FrameRate = mpegFrameRates[code]
+				}
+				return
+			}
+		}
+		return
+	case streamTypeH264:
+		for _, nal := range splitNALs(es) {
+			if nal[0]&0x1f == nalSPS {
+				if sps, err := ParseSPS(nal); err == nil {
+					s.info.Width = sps.Width
+					s.info.Height = sps.Height
+				}
+			}
+		}
+	}
+
+	if pes[7]&0x80 == 0 {
+		return
+	}
+	ts := pesTimestamp(pes[9:])
+	if pes[7]&0xc0 == 0xc0 && len(pes) >= 19 {
+		ts = pesTimestamp(pes[14:])
+	}
+	if s.lastTS >= 0 {
+		if step := wrap33(ts - s.lastTS); step > 0 && step <= fmp4MaxFrameGap {
+			s.info.FrameRate = math.Round(90000/float64(step)*100) / 100
+		}
+	}
+	s.lastTS = ts
+}
+
+// Video returns the VideoInfo of stream, or false before its video has been
+// seen.
+func (p *MediaProbe) Video(stream string) (VideoInfo, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	s, ok := p.streams[stream]
+	if !ok || s.info.Codec == "" {
+		return VideoInfo{}, false
+	}
+	return s.info, true
+}
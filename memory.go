@@ -0,0 +1,246 @@
+package main
+
+import (
+	"github.com/gorilla/websocket"
+
+	"errors"
+	"log"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errMemoryPressure refuses viewers while the server sheds load.
+var errMemoryPressure = errors.New("server is short of memory")
+
+// Bounds of the memory watchdog. It measures the process every
+// memoryCheckInterval; over -memory-limit it shrinks the GOP cache, then
+// disconnects memoryShedPercent of the WebSocket viewers, at least one, on
+// every check until memory falls. It takes new viewers again once under
+// memoryResumePercent of the limit.
+const (
+	memoryCheckInterval = 2 * time.Second
+	memoryShedPercent   = 5
+	memoryResumePercent = 90
+)
+
+// MemoryWatchdog sheds load before the process is killed for running out of
+// memory. The viewers furthest behind go first, since their queues pin the
+// most buffers and they are the least likely to keep up anyway.
+type MemoryWatchdog struct {
+	limit atomic.Int64 // bytes, 0 for none
+
+	pressure atomic.Bool
+	since    time.Time
+	shed     atomic.Int64
+	lock     sync.Mutex
+
+	hub    *WebSocketHandler
+	events *EventBus
+	logger *log.Logger
+}
+
+// MemoryStatus reports the memory of the process and what the streams hold
+// for the admin API.
+type MemoryStatus struct {
+	InUse         uint64         `json:"in_use"`
+	HeapAlloc     uint64         `json:"heap_alloc"`
+	Goroutines    int            `json:"goroutines"`
+	Limit         int64          `json:"limit"`
+	Pressure      bool           `json:"pressure"`
+	Since         *time.Time     `json:"since,omitempty"`
+	Shed          int64          `json:"shed"`
+	GOPCacheLimit int            `json:"gop_cache_limit,omitempty"`
+	Streams       []StreamMemory `json:"streams"`
+}
+
+// StreamMemory is what the buffers of a stream hold.
+type StreamMemory struct {
+	Stream      string `json:"stream"`
+	GOPBytes    int    `json:"gop_bytes"`
+	RingBytes   int    `json:"ring_bytes"`
+	Viewers     int    `json:"viewers"`
+	QueueDepths int    `json:"queue_depths"`
+}
+
+func NewMemoryWatchdog(params *Params, hub *WebSocketHandler, events *EventBus) *MemoryWatchdog {
+	w := &MemoryWatchdog{hub: hub, events: events, logger: params.logger}
+	w.ApplyParams(params)
+
+	return w
+}
+
+func (w *MemoryWatchdog) ApplyParams(params *Params) {
+	w.limit.Store(params.memoryLimit)
+}
+
+// Pressure reports whether the watchdog is shedding load.
+func (w *MemoryWatchdog) Pressure() bool {
+	return w.pressure.Load()
+}
+
+// Run checks the memory of the process until the hub shuts down.
+func (w *MemoryWatchdog) Run() {
+	ticker := time.NewTicker(memoryCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.check()
+		case <-w.hub.quit:
+			return
+		}
+	}
+}
+
+// inUse returns the memory the process holds from the system, as far as the
+// Go runtime knows, and the bytes of live heap objects.
+func inUse() (uint64, uint64) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.Sys - stats.HeapReleased, stats.HeapAlloc
+}
+
+func (w *MemoryWatchdog) check() {
+	limit := w.limit.Load()
+	used, _ := inUse()
+
+	switch {
+	case w.pressure.Load() && (limit == 0 || used < uint64(limit)*memoryResumePercent/100):
+		w.recover(used, limit)
+	case limit != 0 && used > uint64(limit):
+		w.relieve(used, limit)
+	}
+}
+
+// relieve sheds load: the first time around it only shrinks the GOP cache and
+// returns what it can to the system, which may be enough.
+func (w *MemoryWatchdog) relieve(used uint64, limit int64) {
+	data := map[string]string{
+		"in_use": strconv.FormatUint(used, 10),
+		"limit":  strconv.FormatInt(limit, 10),
+	}
+	if gops := w.hub.gops; gops != nil {
+		data["gop_cache_limit"] = strconv.Itoa(gops.Shrink())
+	}
+
+	if !w.pressure.Load() {
+		w.lock.Lock()
+		w.since = time.Now()
+		w.lock.Unlock()
+		w.pressure.Store(true)
+
+		w.logger.Printf("Memory pressure: %d bytes in use over the limit of %d, shedding load\n", used, limit)
+		w.events.Publish(Event{Type: EventMemoryPressure, Data: data})
+		debug.FreeOSMemory()
+		return
+	}
+
+	shed := w.shedViewers()
+	if shed == 0 {
+		return
+	}
+	w.shed.Add(int64(shed))
+	data["viewers"] = strconv.Itoa(shed)
+	w.events.Publish(Event{Type: EventMemoryShed, Data: data})
+}
+
+// shedViewers disconnects the WebSocket viewers furthest behind, those with
+// the fullest queues, returning how many.
+func (w *MemoryWatchdog) shedViewers() int {
+	viewers := w.hub.Viewers()
+	if len(viewers) == 0 {
+		return 0
+	}
+	fill := func(v ViewerInfo) float64 {
+		if v.QueueSize == 0 {
+			return 0
+		}
+		return float64(v.QueueDepth) / float64(v.QueueSize)
+	}
+	sort.SliceStable(viewers, func(i, j int) bool {
+		if fill(viewers[i]) != fill(viewers[j]) {
+			return fill(viewers[i]) > fill(viewers[j])
+		}
+		return viewers[i].QueueDepth > viewers[j].QueueDepth
+	})
+
+	count := max(len(viewers)*memoryShedPercent/100, 1)
+	ids := make(map[string]bool, count)
+	for _, viewer := range viewers[:count] {
+		ids[viewer.ID] = true
+	}
+	return w.hub.kickClients(kickRequest{ids: ids, code: websocket.CloseTryAgainLater, reason: "memory pressure"})
+}
+
+func (w *MemoryWatchdog) recover(used uint64, limit int64) {
+	if gops := w.hub.gops; gops != nil {
+		gops.Restore()
+	}
+	w.pressure.Store(false)
+
+	w.logger.Printf("Memory recovered: %d bytes in use, taking viewers again\n", used)
+	w.events.Publish(Event{
+		Type: EventMemoryRecovered,
+		Data: map[string]string{
+			"in_use": strconv.FormatUint(used, 10),
+			"limit":  strconv.FormatInt(limit, 10),
+		},
+	})
+}
+
+func (w *MemoryWatchdog) Status() MemoryStatus {
+	used, heap := inUse()
+	status := MemoryStatus{
+		InUse:      used,
+		HeapAlloc:  heap,
+		Goroutines: runtime.NumGoroutine(),
+		Limit:      w.limit.Load(),
+		Pressure:   w.pressure.Load(),
+		Shed:       w.shed.Load(),
+		Streams:    []StreamMemory{},
+	}
+	if status.Pressure {
+		w.lock.Lock()
+		since := w.since
+		w.lock.Unlock()
+		status.Since = &since
+	}
+
+	streams := make(map[string]*StreamMemory)
+	stream := func(name string) *StreamMemory {
+		s, ok := streams[name]
+		if !ok {
+			s = &StreamMemory{Stream: name}
+			streams[name] = s
+		}
+		return s
+	}
+	if gops := w.hub.gops; gops != nil {
+		status.GOPCacheLimit = gops.Limit()
+		for name, size := range gops.Sizes() {
+			stream(name).GOPBytes = size
+		}
+	}
+	for name, size := range w.hub.ringBytes() {
+		stream(name).RingBytes = size
+	}
+	for _, viewer := range w.hub.Viewers() {
+		s := stream(viewer.Stream)
+		s.Viewers++
+		s.QueueDepths += viewer.QueueDepth
+	}
+
+	for _, s := range streams {
+		status.Streams = append(status.Streams, *s)
+	}
+	sort.Slice(status.Streams, func(i, j int) bool {
+		return status.Streams[i].Stream < status.Streams[j].Stream
+	})
+	return status
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"golang.org/x/net/ipv4"
+
+	"fmt"
+	"log"
+	"net"
+)
+
+// multicastPackets is the number of TS packets in a datagram, the usual 1316
+// bytes of MPEG-TS over UDP.
+const multicastPackets = 7
+
+// MulticastConfig sends a stream's MPEG-TS to a UDP multicast group, e.g.
+// 239.0.0.1:1234, for set-top boxes and other receivers on the LAN. TTL
+// defaults to 1, which keeps the datagrams on the local network; Interface
+// names the network interface to send from.
+type MulticastConfig struct {
+	Group     string `yaml:"group"`
+	TTL       int    `yaml:"ttl"`
+	Interface string `yaml:"interface"`
+}
+
+func (c *MulticastConfig) Validate() error {
+	addr, err := net.ResolveUDPAddr("udp4", c.Group)
+	if err != nil {
+		return err
+	}
+	if !addr.IP.IsMulticast() {
+		return fmt.Errorf("%s is not a multicast group", addr.IP)
+	}
+	if c.TTL < 0 || c.TTL > 255 {
+		return fmt.Errorf("ttl must be between 0 and 255")
+	}
+
+	return nil
+}
+
+// MulticastOutput sends what is broadcast on a stream to a multicast group
+// as datagrams of whole TS packets. It starts over at the stream's current
+// data whenever it falls behind.
+type MulticastOutput struct {
+	stream string
+	config MulticastConfig
+	hub    *WebSocketHandler
+	quit   chan struct{}
+
+	logger *log.Logger
+}
+
+func NewMulticastOutputs(params *Params, handler *IncomingStreamHandler) []Source {
+	sources := []Source{}
+	for _, stream := range params.streams {
+		if stream.Multicast == nil {
+			continue
+		}
+
+		sources = append(sources, &MulticastOutput{
+			stream: stream.Name,
+			config: *stream.Multicast,
+			hub:    handler.clientManager,
+			quit:   make(chan struct{}),
+			logger: params.logger,
+		})
+	}
+
+	return sources
+}
+
+func (m *MulticastOutput) Run() {
+	conn, err := m.dial()
+	if err != nil {
+		m.logger.Printf("Multicast of stream %s to %s failed: %v\n", m.stream, m.config.Group, err)
+		return
+	}
+	defer conn.Close()
+	m.logger.Printf("Multicasting stream %s to %s\n", m.stream, m.config.Group)
+
+	for {
+		tap := m.hub.AddTap(m.stream)
+		done := m.send(conn, tap)
+		m.hub.RemoveTap(tap)
+		if done {
+			return
+		}
+		m.logger.Printf("Multicast of stream %s fell behind, starting over\n", m.stream)
+	}
+}
+
+func (m *MulticastOutput) dial() (*net.UDPConn, error) {
+	group, err := net.ResolveUDPAddr("udp4", m.config.Group)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUDP("udp4", nil, group)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := m.config.TTL
+	if ttl == 0 {
+		ttl = 1
+	}
+	packetConn := ipv4.NewPacketConn(conn)
+	if err := packetConn.SetMulticastTTL(ttl); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if m.config.Interface != "" {
+		ifi, err := net.InterfaceByName(m.config.Interface)
+		if err == nil {
+			err = packetConn.SetMulticastInterface(ifi)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// send writes the data of tap to conn until the tap is dropped, reporting
+// false, or the output is closed.
+func (m *MulticastOutput) send(conn *net.UDPConn, tap *Tap) bool {
+	var pending []byte
+	datagram := make([]byte, 0, multicastPackets*tsPacketSize)
+	for {
+		select {
+		case data := <-tap.C:
+			tsPackets(&pending, data.Bytes(), func(packet []byte) {
+				datagram = append(datagram, packet...)
+				if len(datagram) == cap(datagram) {
+					conn.Write(datagram)
+					datagram = datagram[:0]
+				}
+			})
+			data.Release()
+			// Send what is left rather than hold it until the next chunk.
+			if len(datagram) > 0 {
+				conn.Write(datagram)
+				datagram = datagram[:0]
+			}
+		case <-tap.Done():
+			select {
+			case <-m.quit:
+				return true
+			default:
+				return false
+			}
+		case <-m.quit:
+			return true
+		}
+	}
+}
+
+// Close stops multicasting.
+func (m *MulticastOutput) Close() {
+	close(m.quit)
+}
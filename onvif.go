@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// wsDiscoveryAddr is the WS-Discovery multicast group ONVIF devices
+	// answer probes on.
+	wsDiscoveryAddr = "239.255.255.250:3702"
+
+	// defaultDiscoveryTimeout is how long a probe collects answers.
+	defaultDiscoveryTimeout = 3 * time.Second
+
+	// onvifTimeout bounds each SOAP call to a camera.
+	onvifTimeout = 10 * time.Second
+)
+
+// Camera is an ONVIF device that answered a WS-Discovery probe.
+type Camera struct {
+	ID       string   `json:"id"`
+	XAddrs   []string `json:"xaddrs"`
+	Name     string   `json:"name,omitempty"`
+	Hardware string   `json:"hardware,omitempty"`
+	From     string   `json:"from"`
+}
+
+const wsDiscoveryProbe = `<?xml version="1.0" encoding="UTF-8"?>
+<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:a="http://schemas.xmlsoap.org/ws/2004/08/addressing">
+<s:Header>
+<a:Action s:mustUnderstand="1">http://schemas.xmlsoap.org/ws/2005/04/discovery/Probe</a:Action>
+<a:MessageID>uuid:%s</a:MessageID>
+<a:ReplyTo><a:Address>http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous</a:Address></a:ReplyTo>
+<a:To s:mustUnderstand="1">urn:schemas-xmlsoap-org:ws:2005:04:discovery</a:To>
+</s:Header>
+<s:Body>
+<Probe xmlns="http://schemas.xmlsoap.org/ws/2005/04/discovery">
+<d:Types xmlns:d="http://schemas.xmlsoap.org/ws/2005/04/discovery" xmlns:dp0="http://www.onvif.org/ver10/network/wsdl">dp0:NetworkVideoTransmitter</d:Types>
+</Probe>
+</s:Body>
+</s:Envelope>`
+
+type probeMatches struct {
+	Matches []struct {
+		Address string `xml:"EndpointReference>Address"`
+		Scopes  string `xml:"Scopes"`
+		XAddrs  string `xml:"XAddrs"`
+	} `xml:"Body>ProbeMatches>ProbeMatch"`
+}
+
+// DiscoverCameras probes the local network for ONVIF cameras and returns
+// those answering within timeout.
+func DiscoverCameras(ctx context.Context, timeout time.Duration) ([]Camera, error) {
+	group, err := net.ResolveUDPAddr("udp4", wsDiscoveryAddr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if _, err := conn.WriteToUDP([]byte(fmt.Sprintf(wsDiscoveryProbe, newUUID())), group); err != nil {
+		return nil, err
+	}
+
+	cameras := []Camera{}
+	seen := make(map[string]bool)
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 65536)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			// The deadline or the context ended the probe.
+			return cameras, nil
+		}
+
+		var matches probeMatches
+		if err := xml.Unmarshal(buf[:n], &matches); err != nil {
+			continue
+		}
+		for _, match := range matches.Matches {
+			if match.Address == "" || seen[match.Address] {
+				continue
+			}
+			seen[match.Address] = true
+
+			camera := Camera{
+				ID:     match.Address,
+				XAddrs: strings.Fields(match.XAddrs),
+				From:   from.IP.String(),
+			}
+			for _, scope := range strings.Fields(match.Scopes) {
+				if name, ok := strings.CutPrefix(scope, "onvif://www.onvif.org/name/"); ok {
+					camera.Name, _ = url.PathUnescape(name)
+				}
+				if hardware, ok := strings.CutPrefix(scope, "onvif://www.onvif.org/hardware/"); ok {
+					camera.Hardware, _ = url.PathUnescape(hardware)
+				}
+			}
+			cameras = append(cameras, camera)
+		}
+	}
+}
+
+// CameraStreamURI asks the ONVIF device service at xaddr for the RTSP URI of
+// the camera's first media profile. The credentials, when given, are sent as
+// a WS-Security username token and added to the returned URI for ffmpeg.
+func CameraStreamURI(ctx context.Context, xaddr, username, password string) (string, error) {
+	var capabilities struct {
+		Media string `xml:"Body>GetCapabilitiesResponse>Capabilities>Media>XAddr"`
+	}
+	err := onvifCall(ctx, xaddr, username, password,
+		`<GetCapabilities xmlns="http://www.onvif.org/ver10/device/wsdl"><Category>Media</Category></GetCapabilities>`,
+		&capabilities)
+	if err != nil {
+		return "", fmt.Errorf("GetCapabilities: %v", err)
+	}
+	if capabilities.Media == "" {
+		return "", fmt.Errorf("camera has no media service")
+	}
+
+	var profiles struct {
+		Profiles []struct {
+			Token string `xml:"token,attr"`
+		} `xml:"Body>GetProfilesResponse>Profiles"`
+	}
+	err = onvifCall(ctx, capabilities.Media, username, password,
+		`<GetProfiles xmlns="http://www.onvif.org/ver10/media/wsdl"/>`,
+		&profiles)
+	if err != nil {
+		return "", fmt.Errorf("GetProfiles: %v", err)
+	}
+	if len(profiles.Profiles) == 0 {
+		return "", fmt.Errorf("camera has no media profiles")
+	}
+
+	var stream struct {
+		URI string `xml:"Body>GetStreamUriResponse>MediaUri>Uri"`
+	}
+	var token bytes.Buffer
+	xml.EscapeText(&token, []byte(profiles.Profiles[0].Token))
+	err = onvifCall(ctx, capabilities.Media, username, password,
+		`<GetStreamUri xmlns="http://www.onvif.org/ver10/media/wsdl">`+
+			`<StreamSetup><Stream xmlns="http://www.onvif.org/ver10/schema">RTP-Unicast</Stream>`+
+			`<Transport xmlns="http://www.onvif.org/ver10/schema"><Protocol>RTSP</Protocol></Transport></StreamSetup>`+
+			`<ProfileToken>`+token.String()+`</ProfileToken></GetStreamUri>`,
+		&stream)
+	if err != nil {
+		return "", fmt.Errorf("GetStreamUri: %v", err)
+	}
+
+	uri, err := url.Parse(strings.TrimSpace(stream.URI))
+	if err != nil || (uri.Scheme != "rtsp" && uri.Scheme != "rtsps") {
+		return "", fmt.Errorf("camera returned no RTSP URI")
+	}
+	if username != "" && uri.User == nil {
+		uri.User = url.UserPassword(username, password)
+	}
+
+	return uri.String(), nil
+}
+
+// onvifCall posts the SOAP body to endpoint and decodes the response into
+// result.
+func onvifCall(ctx context.Context, endpoint, username, password, body string, result interface{}) error {
+	var envelope strings.Builder
+	envelope.WriteString(`<?xml version="1.0" encoding="UTF-8"?><s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope">`)
+	if username != "" {
+		envelope.WriteString(onvifSecurityHeader(username, password))
+	}
+	envelope.WriteString(`<s:Body>` + body + `</s:Body></s:Envelope>`)
+
+	ctx, cancel := context.WithTimeout(ctx, onvifTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, strings.NewReader(envelope.String()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", `application/soap+xml; charset=utf-8`)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var fault struct {
+			Reason string `xml:"Body>Fault>Reason>Text"`
+		}
+		if xml.Unmarshal(data, &fault) == nil && fault.Reason != "" {
+			return fmt.Errorf("%s: %s", resp.Status, fault.Reason)
+		}
+		return fmt.Errorf("%s", resp.Status)
+	}
+
+	return xml.Unmarshal(data, result)
+}
+
+// onvifSecurityHeader returns a WS-Security header with a digest username
+// token, which ONVIF devices require for most calls.
+func onvifSecurityHeader(username, password string) string {
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	created := time.Now().UTC().Format("2006-01-02T15:04:05Z")
+
+	hash := sha1.New()
+	hash.Write(nonce)
+	hash.Write([]byte(created))
+	hash.Write([]byte(password))
+
+	var user bytes.Buffer
+	xml.EscapeText(&user, []byte(username))
+
+	return `<s:Header><Security s:mustUnderstand="1" xmlns="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-secext-1.0.xsd">` +
+		`<UsernameToken><Username>` + user.String() + `</Username>` +
+		`<Password Type="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-username-token-profile-1.0#PasswordDigest">` + base64.StdEncoding.EncodeToString(hash.Sum(nil)) + `</Password>` +
+		`<Nonce EncodingType="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-soap-message-security-1.0#Base64Binary">` + base64.StdEncoding.EncodeToString(nonce) + `</Nonce>` +
+		`<Created xmlns="http://docs.oasis-open.org/wss/2004/01/oasis-200401-wss-wssecurity-utility-1.0.xsd">` + created + `</Created>` +
+		`</UsernameToken></Security></s:Header>`
+}
+
+// newUUID returns a random version 4 UUID.
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	h := hex.EncodeToString(b)
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
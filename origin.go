@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// OriginPolicy decides which pages may open a WebSocket to the server.
+type OriginPolicy struct {
+	allowAny bool
+	patterns []string
+}
+
+func NewOriginPolicy(params *Params) *OriginPolicy {
+	policy := &OriginPolicy{
+		allowAny: params.allowAnyOrigin,
+	}
+	for _, pattern := range strings.Split(params.allowedOrigins, ",") {
+		if pattern = strings.ToLower(strings.TrimSpace(pattern)); pattern != "" {
+			policy.patterns = append(policy.patterns, pattern)
+		}
+	}
+
+	return policy
+}
+
+// CheckOrigin accepts requests without an Origin header (non-browser clients)
+// and origins matching one of the patterns. Patterns are either full origins
+// or bare hosts and may contain wildcards, e.g. "https://*.example.com" or
+// "localhost:*". Without patterns only pages served from the same host name
+// as the WebSocket endpoint, on any port, are accepted.
+func (p *OriginPolicy) CheckOrigin(r *http.Request) bool {
+	if p.allowAny {
+		return true
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(strings.ToLower(origin))
+	if err != nil || u.Host == "" {
+		return false
+	}
+
+	if len(p.patterns) == 0 {
+		return hostname(u.Host) == hostname(strings.ToLower(r.Host))
+	}
+
+	for _, pattern := range p.patterns {
+		subject := u.Host
+		if strings.Contains(pattern, "://") {
+			subject = u.Scheme + "://" + u.Host
+		}
+		if ok, _ := path.Match(pattern, subject); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hostname(hostport string) string {
+	if host, _, err := net.SplitHostPort(hostport); err == nil {
+		return host
+	}
+	return hostport
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"time"
+)
+
+// pacerQueueChunks bounds the chunks an IngestPacer holds before the
+// publisher has to wait.
+const pacerQueueChunks = 1024
+
+// IngestPacer smooths bursty publishers: it holds the incoming MPEG-TS for
+// a short delay and releases it at the pace of its program clock references,
+// so viewers get steady delivery even when an encoder writes large chunks at
+// irregular times. Streams without PCRs pass through after the delay.
+type IngestPacer struct {
+	stream string
+	delay  time.Duration
+	hub    *WebSocketHandler
+
+	queue chan pacedChunk
+	quit  chan struct{}
+	done  chan struct{}
+}
+
+type pacedChunk struct {
+	data    *Buffer
+	arrived time.Time
+}
+
+func NewIngestPacer(stream string, delay time.Duration, hub *WebSocketHandler) *IngestPacer {
+	p := &IngestPacer{
+		stream: stream,
+		delay:  delay,
+		hub:    hub,
+		queue:  make(chan pacedChunk, pacerQueueChunks),
+		quit:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go p.run()
+
+	return p
+}
+
+// Write queues a pooled copy of data, waiting while the queue is full.
+func (p *IngestPacer) Write(data []byte) {
+	p.queue <- pacedChunk{data: p.hub.buffers.Copy(data), arrived: time.Now()}
+}
+
+// Close releases what is still queued at its pace, then stops the pacer.
+func (p *IngestPacer) Close() {
+	close(p.queue)
+	<-p.done
+}
+
+// Stop drops what is still queued, for a publisher that has been replaced.
+func (p *IngestPacer) Stop() {
+	close(p.quit)
+	close(p.queue)
+	<-p.done
+}
+
+func (p *IngestPacer) run() {
+	defer close(p.done)
+
+	clock := &pcrPacer{}
+	for chunk := range p.queue {
+		if !p.release(chunk, clock) {
+			return
+		}
+	}
+}
+
+// release broadcasts chunk at the pace of its PCRs and gives its buffer
+// back, reporting false once the pacer is stopped.
+func (p *IngestPacer) release(chunk pacedChunk, clock *pcrPacer) bool {
+	defer chunk.data.Release()
+
+	data := chunk.data.Bytes()
+	start := 0
+	for offset := 0; offset+tsPacketSize <= len(data); offset += tsPacketSize {
+		packet := data[offset : offset+tsPacketSize]
+		pcr, ok := packetPCR(packet)
+		if !ok {
+			continue
+		}
+
+		if offset > start {
+			p.broadcast(data[start:offset])
+			start = offset
+		}
+
+		// Every PCR is due delay after its arrival at the latest. One
+		// due before its arrival or long after means the publisher's
+		// clock jumped, so start over from this PCR.
+		latest := chunk.arrived.Add(p.delay)
+		due, ok := clock.due(packetPID(packet), pcr)
+		if !ok || due.Before(chunk.arrived) || due.After(latest.Add(p.delay)) {
+			clock.rebase(packetPID(packet), pcr, latest)
+			due = latest
+		}
+		if !sleepOrQuit(time.Until(due), p.quit) {
+			return false
+		}
+	}
+
+	if !sleepOrQuit(time.Until(chunk.arrived.Add(p.delay)), p.quit) {
+		return false
+	}
+	p.broadcast(data[start:])
+	return true
+}
+
+func (p *IngestPacer) broadcast(data []byte) {
+	if len(data) > 0 {
+		p.hub.BroadcastData(p.stream, data)
+	}
+}
+
+// Pacing returns the ingest pacing delay of stream, 0 when it is off.
+func (s *IncomingStreamHandler) Pacing(stream string) time.Duration {
+	s.secretsLock.RLock()
+	defer s.secretsLock.RUnlock()
+
+	if delay, ok := s.streamPacing[stream]; ok {
+		return delay
+	}
+	return s.pacing
+}
+
+// Broadcast hands data published in session to its viewers, after the
+// stream's PID filter and through an IngestPacer when the stream is paced.
+func (s *IncomingStreamHandler) Broadcast(session *PublishSession, data []byte) {
+	if !session.pidsSet {
+		session.pids = s.PIDFilter(session.stream)
+		session.pidsSet = true
+	}
+	if session.pids != nil {
+		if data = session.pids.Filter(data); len(data) == 0 {
+			return
+		}
+	}
+
+	if session.pacer == nil {
+		delay := s.Pacing(session.stream)
+		if delay <= 0 {
+			s.clientManager.BroadcastData(session.stream, data)
+			return
+		}
+		session.pacer = NewIngestPacer(session.stream, delay, s.clientManager)
+	}
+
+	session.pacer.Write(data)
+}
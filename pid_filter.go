@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+)
+
+// nullPID carries the stuffing packets encoders add to keep a constant
+// bitrate.
+const nullPID = 0x1fff
+
+// PIDFilterConfig removes TS PIDs from a stream before it reaches viewers,
+// e.g. the teletext and data PIDs of a broadcast source. Drop lists the PIDs
+// to remove; Pass instead keeps only the PIDs it lists, along with the PAT
+// and the PMT. The PMT is left unchanged.
+type PIDFilterConfig struct {
+	Drop []int `yaml:"drop"`
+	Pass []int `yaml:"pass"`
+}
+
+func (c *PIDFilterConfig) Validate() error {
+	if len(c.Drop) > 0 && len(c.Pass) > 0 {
+		return fmt.Errorf("drop and pass cannot be combined")
+	}
+	for _, pid := range append(append([]int{}, c.Drop...), c.Pass...) {
+		if pid < 0 || pid > 0x1fff {
+			return fmt.Errorf("PID %d is out of range", pid)
+		}
+	}
+	for _, pid := range c.Drop {
+		if pid == 0 {
+			return fmt.Errorf("the PAT cannot be dropped")
+		}
+	}
+
+	return nil
+}
+
+// PIDFilter applies a PIDFilterConfig to the data of one publish session.
+type PIDFilter struct {
+	drop    map[uint16]bool
+	pass    map[uint16]bool // nil unless only the listed PIDs pass
+	pmtPID  uint16
+	pending []byte
+}
+
+func NewPIDFilter(config PIDFilterConfig) *PIDFilter {
+	f := &PIDFilter{drop: make(map[uint16]bool)}
+	for _, pid := range config.Drop {
+		f.drop[uint16(pid)] = true
+	}
+	if len(config.Pass) > 0 {
+		f.pass = map[uint16]bool{0: true}
+		for _, pid := range config.Pass {
+			f.pass[uint16(pid)] = true
+		}
+	}
+
+	return f
+}
+
+// Filter returns the packets of data that pass the filter. A packet split
+// at the end of data is held until the next call.
+func (f *PIDFilter) Filter(data []byte) []byte {
+	filtered := make([]byte, 0, len(data))
+	tsPackets(&f.pending, data, func(packet []byte) {
+		pid := packetPID(packet)
+		if pid == 0 {
+			if pmtPID, ok := parsePAT(packet); ok {
+				f.pmtPID = pmtPID
+			}
+		}
+
+		if f.drop[pid] || (f.pass != nil && !f.pass[pid] && pid != f.pmtPID) {
+			return
+		}
+		filtered = append(filtered, packet...)
+	})
+
+	return filtered
+}
+
+// PIDFilter returns the PID filter of stream, which also strips null
+// packets when -ingest-strip-null is set, or nil when the stream has none.
+func (s *IncomingStreamHandler) PIDFilter(stream string) *PIDFilter {
+	s.secretsLock.RLock()
+	defer s.secretsLock.RUnlock()
+
+	config, ok := s.streamPIDs[stream]
+	if !ok && !s.stripNull {
+		return nil
+	}
+	if s.stripNull {
+		config.Drop = append(append([]int{}, config.Drop...), nullPID)
+	}
+	return NewPIDFilter(config)
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	// playbackChunkPackets is how many TS packets a FileSource broadcasts at
+	// most in one message.
+	playbackChunkPackets = 21
+
+	// playbackFallbackBitrate paces files that carry no PCR, in bits per
+	// second.
+	playbackFallbackBitrate = 2000000
+
+	// playbackMaxPCRJump is the largest PCR step treated as continuous; larger
+	// steps and steps backwards restart the pacing clock.
+	playbackMaxPCRJump = 10 * pcrClock
+
+	// playbackRetryDelay is how long a FileSource waits when its stream is
+	// taken or its file cannot be read.
+	playbackRetryDelay = time.Second
+)
+
+// FileSource plays a local MPEG-TS file on a loop at real-time speed, paced by
+// the program clock references in the file, for demos, standby content and
+// testing players without a camera. It yields the stream to publishers taking
+// it over and takes it back once they are gone. A fallback FileSource only
+// plays while the stream has no other publisher.
+type FileSource struct {
+	path     string
+	stream   string
+	fallback bool
+	handler  *IncomingStreamHandler
+
+	quit chan struct{}
+
+	logger *log.Logger
+}
+
+func NewFileSources(params *Params, handler *IncomingStreamHandler) []Source {
+	sources := []Source{}
+	for _, stream := range params.streams {
+		if stream.File == "" {
+			continue
+		}
+
+		sources = append(sources, &FileSource{
+			path:    stream.File,
+			stream:  stream.Name,
+			handler: handler,
+			quit:    make(chan struct{}),
+			logger:  params.logger,
+		})
+	}
+
+	return sources
+}
+
+func (f *FileSource) Run() {
+	name := "file:" + f.path
+	if !f.fallback {
+		f.logger.Printf("Playing %s on a loop (stream %s)\n", f.path, f.stream)
+	}
+
+	for {
+		session, err := f.acquire(name)
+		if err == nil {
+			f.handler.publishers.Add(1)
+			f.logger.Printf("IncomingStream connected: %s (stream %s)\n", name, f.stream)
+
+			err = f.play(session)
+			f.handler.endPublish(session)
+			if err != nil {
+				f.logger.Printf("%s: %v\n", name, err)
+			}
+		}
+
+		select {
+		case <-f.quit:
+			return
+		case <-time.After(playbackRetryDelay):
+		}
+	}
+}
+
+func (f *FileSource) acquire(name string) (*PublishSession, error) {
+	if f.fallback {
+		return f.handler.publisherLock.AcquireFallback(f.stream, name)
+	}
+	return f.handler.publisherLock.AcquireAddr(f.stream, name, nil, false)
+}
+
+// play loops over the file until the session is superseded or the source is
+// closed.
+func (f *FileSource) play(session *PublishSession) error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	pacer := &pcrPacer{}
+	reader := bufio.NewReaderSize(file, 64*1024)
+	packet := make([]byte, tsPacketSize)
+	chunk := make([]byte, 0, playbackChunkPackets*tsPacketSize)
+
+	played := false
+	for {
+		err := readPacket(reader, packet)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			if !played {
+				return errors.New("no MPEG-TS packets in file")
+			}
+			if _, err := file.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			reader.Reset(file)
+			pacer.restart()
+			played = false
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		played = true
+
+		pcr, hasPCR := packetPCR(packet)
+		if hasPCR && len(chunk) > 0 {
+			// Everything before this clock reference is due now.
+			if !f.send(session, chunk) {
+				return nil
+			}
+			chunk = chunk[:0]
+		}
+		if hasPCR && !pacer.wait(packetPID(packet), pcr, f.quit) {
+			return nil
+		}
+
+		chunk = append(chunk, packet...)
+		if len(chunk) < cap(chunk) {
+			continue
+		}
+		if !f.send(session, chunk) {
+			return nil
+		}
+		chunk = chunk[:0]
+		if !pacer.paced() && !sleepOrQuit(time.Duration(cap(chunk)*8)*time.Second/playbackFallbackBitrate, f.quit) {
+			return nil
+		}
+	}
+}
+
+// readPacket reads the next TS packet, skipping anything before its sync
+// byte.
+func readPacket(reader *bufio.Reader, packet []byte) error {
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b != tsSyncByte {
+			continue
+		}
+
+		packet[0] = b
+		_, err = io.ReadFull(reader, packet[1:])
+		return err
+	}
+}
+
+// send broadcasts chunk, which is free to be reused afterwards, reporting
+// false once the source has to stop.
+func (f *FileSource) send(session *PublishSession, chunk []byte) bool {
+	if session.Superseded() {
+		f.logger.Printf("IncomingStream file:%s superseded on stream %s\n", f.path, f.stream)
+		return false
+	}
+
+	session.meter.Add(len(chunk))
+	f.handler.clientManager.BroadcastData(f.stream, chunk)
+
+	return true
+}
+
+func (f *FileSource) Close() {
+	close(f.quit)
+}
+
+// pcrPacer maps the PCRs of one PID to wall clock time.
+type pcrPacer struct {
+	pid      uint16
+	started  bool
+	basePCR  uint64
+	baseTime time.Time
+	lastPCR  uint64
+}
+
+// restart makes the next PCR the new starting point, e.g. after looping.
+func (p *pcrPacer) restart() {
+	p.started = false
+}
+
+// paced reports whether the pacer has seen a PCR to pace by.
+func (p *pcrPacer) paced() bool {
+	return p.started
+}
+
+// wait sleeps until pcr is due, reporting false when quit closes first.
+func (p *pcrPacer) wait(pid uint16, pcr uint64, quit chan struct{}) bool {
+	due, ok := p.due(pid, pcr)
+	if !ok {
+		p.rebase(pid, pcr, time.Now())
+		return true
+	}
+
+	return sleepOrQuit(time.Until(due), quit)
+}
+
+// due returns the wall clock time of pcr. It fails when there is no starting
+// point yet or the clock jumped; the caller then has to rebase. PCRs of
+// other PIDs are due right away.
+func (p *pcrPacer) due(pid uint16, pcr uint64) (time.Time, bool) {
+	if p.started && pid != p.pid {
+		return time.Time{}, true
+	}
+	if !p.started || pcr < p.lastPCR || pcr-p.lastPCR > playbackMaxPCRJump {
+		return time.Time{}, false
+	}
+	p.lastPCR = pcr
+
+	return p.baseTime.Add(time.Duration((pcr-p.basePCR)/27) * time.Microsecond), true
+}
+
+// rebase makes pcr of pid due at t.
+func (p *pcrPacer) rebase(pid uint16, pcr uint64, t time.Time) {
+	p.pid = pid
+	p.started = true
+	p.basePCR = pcr
+	p.baseTime = t
+	p.lastPCR = pcr
+}
+
+// sleepOrQuit sleeps for d, reporting false when quit closes first.
+func sleepOrQuit(d time.Duration, quit chan struct{}) bool {
+	if d <= 0 {
+		return true
+	}
+
+	select {
+	case <-quit:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
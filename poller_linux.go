@@ -0,0 +1,154 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// pollSweepInterval is how often the poller looks for viewers that stopped
+// answering pings.
+const pollSweepInterval = time.Second
+
+// pollReadSize is what the poller reads from a ready connection at once.
+// Viewers send little more than pongs.
+const pollReadSize = 4096
+
+// Poller reads the connections of the poll WebSocket backend from one
+// goroutine, woken by epoll when one of them has something to read.
+type Poller struct {
+	epfd   int
+	conns  map[int]*pollConn // file descriptor -> connection
+	lock   sync.Mutex
+	closed atomic.Bool
+
+	logger *log.Logger
+}
+
+func NewPoller(logger *log.Logger) (*Poller, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	return &Poller{epfd: epfd, conns: make(map[int]*pollConn), logger: logger}, nil
+}
+
+func (p *Poller) add(conn *pollConn) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var err error
+	controlErr := conn.raw.Control(func(fd uintptr) {
+		conn.fd = int(fd)
+		event := syscall.EpollEvent{Events: syscall.EPOLLIN | syscall.EPOLLRDHUP, Fd: int32(fd)}
+		err = syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_ADD, conn.fd, &event)
+	})
+	if controlErr != nil {
+		return controlErr
+	}
+	if err != nil {
+		return err
+	}
+	p.conns[conn.fd] = conn
+	return nil
+}
+
+// remove stops watching conn, before it is closed, so its descriptor is
+// never mistaken for that of a later connection.
+func (p *Poller) remove(conn *pollConn) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.conns[conn.fd] != conn {
+		return
+	}
+	delete(p.conns, conn.fd)
+	conn.raw.Control(func(fd uintptr) {
+		syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_DEL, int(fd), nil)
+	})
+}
+
+// Run reads the connections that are ready until Close.
+func (p *Poller) Run() {
+	events := make([]syscall.EpollEvent, 128)
+	buf := make([]byte, pollReadSize)
+	swept := time.Now()
+
+	for {
+		n, err := syscall.EpollWait(p.epfd, events, int(pollSweepInterval/time.Millisecond))
+		if err != nil && err != syscall.EINTR {
+			if !p.closed.Load() {
+				p.logger.Printf("WebSocket poller stopped: %v\n", err)
+			}
+			return
+		}
+
+		for _, event := range events[:max(n, 0)] {
+			p.lock.Lock()
+			conn := p.conns[int(event.Fd)]
+			p.lock.Unlock()
+			if conn != nil {
+				conn.readable(buf)
+			}
+		}
+
+		if now := time.Now(); now.Sub(swept) >= pollSweepInterval {
+			p.sweep(now)
+			swept = now
+		}
+	}
+}
+
+// sweep drops the viewers whose read deadline passed, like ReadHandler does
+// when a read times out.
+func (p *Poller) sweep(now time.Time) {
+	expired := []*pollConn{}
+	p.lock.Lock()
+	for _, conn := range p.conns {
+		if conn.expired(now) {
+			expired = append(expired, conn)
+		}
+	}
+	p.lock.Unlock()
+
+	for _, conn := range expired {
+		conn.client.logger.Printf("Client %s stopped answering pings, dropping it\n", conn.client.id)
+		conn.end()
+	}
+}
+
+func (p *Poller) Close() {
+	if p.closed.CompareAndSwap(false, true) {
+		syscall.Close(p.epfd)
+	}
+}
+
+// readable reads what the viewer sent without waiting for more, ending the
+// connection once the viewer closed it or broke the protocol.
+func (p *pollConn) readable(buf []byte) {
+	var n int
+	var readErr error
+	if err := p.raw.Read(func(fd uintptr) bool {
+		n, readErr = syscall.Read(int(fd), buf)
+		return true
+	}); err != nil {
+		p.end()
+		return
+	}
+	if readErr == syscall.EAGAIN || readErr == syscall.EINTR {
+		return
+	}
+	if readErr != nil || n <= 0 {
+		p.end()
+		return
+	}
+
+	if err := p.consume(buf[:n]); err != nil {
+		if err != errPollClosed {
+			p.client.logger.Printf("Client %s: %v\n", p.client.id, err)
+		}
+		p.end()
+	}
+}
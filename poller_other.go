@@ -0,0 +1,25 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"log"
+)
+
+// Poller needs epoll; elsewhere every viewer uses the gorilla backend.
+type Poller struct{}
+
+func NewPoller(logger *log.Logger) (*Poller, error) {
+	return nil, errors.New("the poll WebSocket backend needs Linux")
+}
+
+func (p *Poller) add(conn *pollConn) error {
+	return errors.New("the poll WebSocket backend needs Linux")
+}
+
+func (p *Poller) remove(conn *pollConn) {}
+
+func (p *Poller) Run() {}
+
+func (p *Poller) Close() {}
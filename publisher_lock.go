@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PublishSession is one publisher's hold on a stream. It is superseded when
+// another publisher takes the stream over or an operator kicks it.
+type PublishSession struct {
+	stream     string
+	remoteAddr string
+	started    time.Time
+	superseded atomic.Bool
+	conn       net.Conn
+	meter      *RateMeter
+	fallback   bool // gives way to any other publisher
+	pacer      *IngestPacer
+	pids       *PIDFilter
+	pidsSet    bool // pids has been looked up
+}
+
+func (p *PublishSession) Superseded() bool {
+	return p.superseded.Load()
+}
+
+// supersede stops the session and closes its connection so a publisher stuck
+// in a read lets go as well.
+func (p *PublishSession) supersede() {
+	p.superseded.Store(true)
+	if p.conn != nil {
+		p.conn.Close()
+	}
+}
+
+type PublisherInfo struct {
+	RemoteAddr string    `json:"remote_addr"`
+	Since      time.Time `json:"since"`
+	BytesIn    int64     `json:"bytes_in"`
+	BitrateIn  float64   `json:"bitrate_in"`
+	Fallback   bool      `json:"fallback,omitempty"`
+}
+
+// PublisherLock allows one active publisher per stream, and none new while
+// the server is draining.
+type PublisherLock struct {
+	sessions map[string]*PublishSession // stream name -> active session
+	drain    *Drain
+	lock     sync.Mutex
+}
+
+func NewPublisherLock(drain *Drain) *PublisherLock {
+	return &PublisherLock{
+		sessions: make(map[string]*PublishSession),
+		drain:    drain,
+	}
+}
+
+// Acquire makes the client of r the publisher of stream. When the stream
+// already has a publisher it fails, unless takeover is set or the current
+// session is a fallback, in which case the current session is superseded and
+// stops broadcasting.
+func (l *PublisherLock) Acquire(stream string, r *http.Request, takeover bool) (*PublishSession, error) {
+	return l.AcquireAddr(stream, r.RemoteAddr, requestConn(r), takeover)
+}
+
+// AcquireAddr is Acquire for publishers that do not come in over HTTP. conn
+// may be nil when there is no connection to close on a takeover.
+func (l *PublisherLock) AcquireAddr(stream, remoteAddr string, conn net.Conn, takeover bool) (*PublishSession, error) {
+	if l.drain.Active() {
+		return nil, errDraining
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if current, ok := l.sessions[stream]; ok {
+		if !takeover && !current.fallback {
+			return nil, fmt.Errorf("stream %s is being published by %s since %s", stream, current.remoteAddr, current.started.Format(time.RFC3339))
+		}
+		current.supersede()
+	}
+
+	session := &PublishSession{
+		stream:     stream,
+		remoteAddr: remoteAddr,
+		started:    time.Now(),
+		conn:       conn,
+		meter:      NewRateMeter(),
+	}
+	l.sessions[stream] = session
+
+	return session, nil
+}
+
+// AcquireFallback makes remoteAddr the publisher of stream while the stream
+// has none. Any other publisher supersedes the returned session.
+func (l *PublisherLock) AcquireFallback(stream, remoteAddr string) (*PublishSession, error) {
+	if l.drain.Active() {
+		return nil, errDraining
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if current, ok := l.sessions[stream]; ok {
+		return nil, fmt.Errorf("stream %s is being published by %s", stream, current.remoteAddr)
+	}
+
+	session := &PublishSession{
+		stream:     stream,
+		remoteAddr: remoteAddr,
+		started:    time.Now(),
+		meter:      NewRateMeter(),
+		fallback:   true,
+	}
+	l.sessions[stream] = session
+
+	return session, nil
+}
+
+// Release ends session, leaving a newer session of the same stream alone.
+func (l *PublisherLock) Release(session *PublishSession) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.sessions[session.stream] == session {
+		delete(l.sessions, session.stream)
+	}
+}
+
+// Kick disconnects the publisher of stream, reporting whether there was one.
+func (l *PublisherLock) Kick(stream string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	session, ok := l.sessions[stream]
+	if ok {
+		session.supersede()
+		delete(l.sessions, stream)
+	}
+
+	return ok
+}
+
+// KickAll disconnects every publisher. Shutdown needs it for WebSocket
+// publishers, whose connections the HTTP server no longer tracks.
+func (l *PublisherLock) KickAll() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for stream, session := range l.sessions {
+		session.supersede()
+		delete(l.sessions, stream)
+	}
+}
+
+// Publishers describes the active publisher of every stream.
+func (l *PublisherLock) Publishers() map[string]PublisherInfo {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	publishers := make(map[string]PublisherInfo)
+	for stream, session := range l.sessions {
+		publishers[stream] = PublisherInfo{
+			RemoteAddr: session.remoteAddr,
+			Since:      session.started,
+			BytesIn:    session.meter.Total(),
+			BitrateIn:  session.meter.Bitrate(),
+			Fallback:   session.fallback,
+		}
+	}
+
+	return publishers
+}
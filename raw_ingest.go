@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// sniffTimeout is how long a new socket connection may take to send its
+// first byte.
+const sniffTimeout = 10 * time.Second
+
+// PublishConn broadcasts the raw MPEG-TS read from conn to stream until the
+// publisher disconnects. The caller has checked that it may publish.
+func (s *IncomingStreamHandler) PublishConn(conn net.Conn, remoteAddr, stream string) {
+	defer conn.Close()
+
+	session, err := s.publisherLock.AcquireAddr(stream, remoteAddr, conn, false)
+	if err != nil {
+		s.logger.Printf("IncomingStream %s rejected: %v\n", remoteAddr, err)
+		return
+	}
+	s.publishers.Add(1)
+	s.logger.Printf("IncomingStream connected: %s (stream %s)\n", remoteAddr, stream)
+	defer s.endPublish(session)
+
+	limits := s.Limits()
+	chunks := s.NewChunkReader(limits.Reader(conn, conn.SetReadDeadline))
+	for {
+		data, err := chunks.Next()
+		if err != nil {
+			break
+		}
+
+		if session.Superseded() {
+			s.logger.Printf("IncomingStream %s superseded on stream %s\n", remoteAddr, stream)
+			break
+		}
+		session.meter.Add(len(data))
+		if err := limits.Check(session); err != nil {
+			s.logger.Printf("IncomingStream %s disconnected: %v\n", remoteAddr, err)
+			break
+		}
+
+		s.Broadcast(session, data)
+	}
+}
+
+// serveSocket serves the ingest endpoint on the Unix socket instead of a TCP
+// port. Connections starting with a TS sync byte, as written by
+// "ffmpeg -f mpegts unix:<path>", are published to the socket stream as raw
+// MPEG-TS; everything else is handled as HTTP.
+func (s *IncomingStreamHandler) serveSocket() error {
+	listener, err := s.handoff.Listen("unix", s.socketPath)
+	if err != nil {
+		return err
+	}
+	s.logger.Println("IncomingStreamHandler listening at " + s.socketPath)
+
+	return s.srv.Serve(newSniffListener(listener, func(conn net.Conn) {
+		s.PublishConn(conn, "unix:"+s.socketPath, s.socketStream)
+	}))
+}
+
+// localPublisher marks requests from the Unix socket, which have no remote
+// address, as coming from the loopback address so address rules treat them
+// as local.
+func localPublisher(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.RemoteAddr == "" || r.RemoteAddr == "@" {
+			r.RemoteAddr = "127.0.0.1:0"
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// sniffListener hands raw MPEG-TS connections to raw and returns the others
+// from Accept.
+type sniffListener struct {
+	net.Listener
+	raw      func(net.Conn)
+	accepted chan net.Conn
+	closed   chan struct{}
+	once     sync.Once
+}
+
+func newSniffListener(listener net.Listener, raw func(net.Conn)) *sniffListener {
+	l := &sniffListener{
+		Listener: listener,
+		raw:      raw,
+		accepted: make(chan net.Conn),
+		closed:   make(chan struct{}),
+	}
+	go l.acceptLoop()
+
+	return l
+}
+
+func (l *sniffListener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			l.Close()
+			return
+		}
+		go l.sniff(conn)
+	}
+}
+
+func (l *sniffListener) sniff(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(sniffTimeout))
+	first, err := reader.Peek(1)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	peeked := &peekedConn{Conn: conn, reader: reader}
+	if first[0] == tsSyncByte {
+		l.raw(peeked)
+		return
+	}
+
+	select {
+	case l.accepted <- peeked:
+	case <-l.closed:
+		conn.Close()
+	}
+}
+
+func (l *sniffListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.accepted:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *sniffListener) Close() error {
+	err := l.Listener.Close()
+	l.once.Do(func() { close(l.closed) })
+
+	return err
+}
+
+// peekedConn reads the bytes sniffed from a connection before the rest.
+type peekedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *peekedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
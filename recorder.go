@@ -0,0 +1,1054 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of the recorder.
+const (
+	defaultRecordDir             = "recordings"
+	defaultRecordSegmentDuration = time.Minute
+)
+
+// recordTimeFormat is how segment names give their start and end, in UTC,
+// to the millisecond so that small segments do not share a name.
+const recordTimeFormat = "20060102T150405.000Z"
+
+// recordPartSuffix marks the segment still being written. It is renamed to
+// <stream>-<start>-<end>.ts once complete.
+const recordPartSuffix = ".part"
+
+// recordIdleClose is how long a stream may send nothing before its segment
+// is closed, so a publisher that left does not leave one open.
+const recordIdleClose = 10 * time.Second
+
+// recordBufferSize is what a segment collects before it is written to disk.
+const recordBufferSize = 64 << 10
+
+// recordHistory is how many finished recordings started through the admin
+// API the recorder remembers, so their files can still be looked up.
+const recordHistory = 100
+
+// Origins of a recording.
+const (
+	recordOriginConfig   = "config"
+	recordOriginAPI      = "api"
+	recordOriginSchedule = "schedule"
+)
+
+var (
+	errRecordingExists    = errors.New("stream is already being recorded on demand")
+	errRecordingUnknown   = errors.New("unknown recording")
+	errScheduleUnknown    = errors.New("unknown schedule")
+	errRecordingConfig    = errors.New("recording is set up in the config file")
+	errRecorderNotRunning = errors.New("recorder is not running")
+)
+
+// recordPruneInterval is how often the recorder deletes the segments its
+// retention rules no longer keep.
+const recordPruneInterval = time.Minute
+
+// RecordConfig records a stream to disk as rolling MPEG-TS segments. A new
+// segment starts at the first keyframe after SegmentDuration or SegmentSize
+// bytes, or regardless of keyframes at twice either. Complete segments older
+// than MaxAge are deleted, and the oldest ones while the stream's segments
+// take more than MaxBytes. Zero values take the -record-* flags; an empty
+// "record: {}" records with those. With a Schedule the stream is recorded
+// only within its windows rather than all the time. MP4 overrides -record-mp4.
+type RecordConfig struct {
+	Dir             string                 `yaml:"dir"`
+	SegmentDuration time.Duration          `yaml:"segment_duration"`
+	SegmentSize     int64                  `yaml:"segment_size"`
+	MaxAge          time.Duration          `yaml:"max_age"`
+	MaxBytes        int64                  `yaml:"max_bytes"`
+	Schedule        []RecordScheduleConfig `yaml:"schedule"`
+	MP4             *bool                  `yaml:"mp4"`
+}
+
+func (c *RecordConfig) Validate() error {
+	if c.SegmentDuration < 0 || c.SegmentSize < 0 {
+		return fmt.Errorf("segment_duration and segment_size must not be negative")
+	}
+	if c.MaxAge < 0 || c.MaxBytes < 0 {
+		return fmt.Errorf("max_age and max_bytes must not be negative")
+	}
+	for i := range c.Schedule {
+		if err := c.Schedule[i].Validate(); err != nil {
+			return fmt.Errorf("schedule %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// recordSettings are the settings of a recording, with the defaults applied.
+type recordSettings struct {
+	dir             string
+	segmentDuration time.Duration // 0 for no time limit
+	segmentSize     int64         // 0 for no size limit
+	mp4             bool          // remux every session into an MP4
+}
+
+func newRecordSettings(params *Params, config *RecordConfig) recordSettings {
+	settings := recordSettings{
+		dir:             params.recordDir,
+		segmentDuration: params.recordSegmentDuration,
+		segmentSize:     params.recordSegmentSize,
+		mp4:             params.recordMP4,
+	}
+	if config.Dir != "" {
+		settings.dir = config.Dir
+	}
+	if config.SegmentDuration != 0 {
+		settings.segmentDuration = config.SegmentDuration
+	}
+	if config.SegmentSize != 0 {
+		settings.segmentSize = config.SegmentSize
+	}
+	if config.MP4 != nil {
+		settings.mp4 = *config.MP4
+	}
+	return settings
+}
+
+// recordRetention is how long the segments of a stream are kept.
+type recordRetention struct {
+	dir      string
+	maxAge   time.Duration // 0 to keep segments regardless of age
+	maxBytes int64         // 0 to keep segments regardless of size
+}
+
+func newRecordRetention(params *Params, config *RecordConfig) recordRetention {
+	retention := recordRetention{
+		dir:      newRecordSettings(params, config).dir,
+		maxAge:   params.recordMaxAge,
+		maxBytes: params.recordMaxBytes,
+	}
+	if config.MaxAge != 0 {
+		retention.maxAge = config.MaxAge
+	}
+	if config.MaxBytes != 0 {
+		retention.maxBytes = config.MaxBytes
+	}
+	return retention
+}
+
+// Recorder runs the recordings of the streams that have a record section in
+// the config file. A reload starts and stops them to match. It also prunes
+// the segments of those streams by their retention rules, and runs the
+// recordings operators start through the admin API, each in a folder of its
+// own that retention leaves alone. Recordings on a schedule set through the
+// admin API are kept the same way.
+type Recorder struct {
+	configured map[string]recordSettings  // stream name -> settings from the config file
+	retention  map[string]recordRetention // stream name -> retention from the config file
+	windows    map[string][]recordWindow  // stream name -> schedule from the config file, if any
+	defaults   recordSettings             // of streams without a record section
+	recordings map[string]*Recording      // stream name -> recording the config file asks for
+	onDemand   map[string]*Recording      // ID -> recording started through the admin API or its schedules
+	schedules  map[string]*recordSchedule // ID -> schedule set through the admin API
+	running    bool
+	lock       sync.Mutex
+
+	hub      *WebSocketHandler
+	remuxer  *Remuxer
+	uploader *Uploader
+	quit     chan struct{}
+	logger   *log.Logger
+}
+
+// Recording writes one stream to segment files until it is stopped.
+type Recording struct {
+	id       string
+	stream   string
+	origin   string
+	dir      string
+	settings recordSettings
+	started  time.Time
+	quit     chan struct{}
+	done     chan struct{}
+
+	stopped time.Time
+	current string   // path of the segment being written
+	files   []string // paths of the complete segments, kept for recordings on demand
+	session []string // paths of the complete segments of the session, kept for MP4s
+	lock    sync.Mutex
+
+	hub      *WebSocketHandler
+	remuxer  *Remuxer
+	uploader *Uploader
+	logger   *log.Logger
+}
+
+// RecordingInfo describes a recording for the admin API. Files lists the
+// complete segments of recordings on demand only; those of recordings set up
+// in the config file are in Dir, until retention deletes them.
+type RecordingInfo struct {
+	ID      string     `json:"id"`
+	Stream  string     `json:"stream"`
+	Origin  string     `json:"origin"`
+	Dir     string     `json:"dir"`
+	Started time.Time  `json:"started"`
+	Stopped *time.Time `json:"stopped,omitempty"`
+	Current string     `json:"current,omitempty"`
+	Files   []string   `json:"files,omitempty"`
+}
+
+// RecordSchedule describes a schedule set through the admin API.
+type RecordSchedule struct {
+	ID        string `json:"id"`
+	Stream    string `json:"stream"`
+	Start     string `json:"start"`
+	Duration  string `json:"duration"`
+	Recording string `json:"recording,omitempty"` // ID of the recording under way
+}
+
+type recordSchedule struct {
+	info      RecordSchedule
+	window    recordWindow
+	opened    time.Time  // of the window last recorded
+	recording *Recording // of the window last recorded
+}
+
+func NewRecorder(params *Params, hub *WebSocketHandler, events *EventBus) *Recorder {
+	r := &Recorder{
+		recordings: make(map[string]*Recording),
+		onDemand:   make(map[string]*Recording),
+		schedules:  make(map[string]*recordSchedule),
+		hub:        hub,
+		uploader:   NewUploader(params, events),
+		quit:       make(chan struct{}),
+		logger:     params.logger,
+	}
+	r.remuxer = NewRemuxer(params, r.uploader, events)
+	r.ApplyParams(params)
+
+	return r
+}
+
+// ApplyParams takes the recordings configured by params, starting and
+// stopping recordings to match once the recorder runs.
+func (r *Recorder) ApplyParams(params *Params) {
+	configured := make(map[string]recordSettings)
+	retention := make(map[string]recordRetention)
+	windows := make(map[string][]recordWindow)
+	for _, stream := range params.streams {
+		if stream.Record == nil {
+			continue
+		}
+		configured[stream.Name] = newRecordSettings(params, stream.Record)
+		retention[stream.Name] = newRecordRetention(params, stream.Record)
+		for _, schedule := range stream.Record.Schedule {
+			// Validated with the config file.
+			window, _ := newRecordWindow(schedule)
+			windows[stream.Name] = append(windows[stream.Name], window)
+		}
+	}
+
+	r.remuxer.ApplyParams(params)
+	r.uploader.ApplyParams(params)
+
+	r.lock.Lock()
+	r.configured = configured
+	r.retention = retention
+	r.windows = windows
+	r.defaults = newRecordSettings(params, &RecordConfig{})
+	stopped := r.sync(time.Now())
+	r.lock.Unlock()
+
+	for _, recording := range stopped {
+		<-recording.done
+	}
+}
+
+// sync starts and stops recordings to match the configuration and the
+// schedules at now, returning the ones stopped. It must be called with the
+// lock held.
+func (r *Recorder) sync(now time.Time) []*Recording {
+	if !r.running {
+		return nil
+	}
+
+	stopped := []*Recording{}
+	for stream, recording := range r.recordings {
+		if settings, ok := r.configured[stream]; !ok || settings != recording.settings || !r.scheduled(stream, now) {
+			recording.halt()
+			delete(r.recordings, stream)
+			stopped = append(stopped, recording)
+		}
+	}
+	for stream, settings := range r.configured {
+		if _, ok := r.recordings[stream]; !ok && r.scheduled(stream, now) {
+			r.recordings[stream] = r.start(stream, recordOriginConfig, settings)
+		}
+	}
+
+	for _, schedule := range r.schedules {
+		opened, ok := schedule.window.opened(now)
+		switch {
+		case ok && !opened.Equal(schedule.opened):
+			// A recording stopped within its window stays stopped until the
+			// next one.
+			schedule.opened = opened
+			if schedule.recording == nil || !schedule.recording.Active() {
+				schedule.recording = r.start(schedule.info.Stream, recordOriginSchedule, r.settings(schedule.info.Stream))
+				r.onDemand[schedule.recording.id] = schedule.recording
+				r.forget()
+			}
+		case !ok && schedule.recording != nil && schedule.recording.Active():
+			schedule.recording.halt()
+			stopped = append(stopped, schedule.recording)
+		}
+	}
+	return stopped
+}
+
+// scheduled reports whether the config file has stream recorded at now. It
+// must be called with the lock held.
+func (r *Recorder) scheduled(stream string, now time.Time) bool {
+	windows := r.windows[stream]
+	if len(windows) == 0 {
+		return true
+	}
+	for _, window := range windows {
+		if _, ok := window.opened(now); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// settings returns the settings of recordings of stream besides those the
+// config file asks for. It must be called with the lock held.
+func (r *Recorder) settings(stream string) recordSettings {
+	if settings, ok := r.configured[stream]; ok {
+		return settings
+	}
+	return r.defaults
+}
+
+// start must be called with the lock held. Recordings set up in the config
+// file go to the stream's folder, others to a folder of their own in it.
+func (r *Recorder) start(stream, origin string, settings recordSettings) *Recording {
+	recording := &Recording{
+		id:       newRecordingID(),
+		stream:   stream,
+		origin:   origin,
+		dir:      filepath.Join(settings.dir, streamDirName(stream)),
+		settings: settings,
+		started:  time.Now(),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+		hub:      r.hub,
+		remuxer:  r.remuxer,
+		uploader: r.uploader,
+		logger:   r.logger,
+	}
+	if origin != recordOriginConfig {
+		recording.dir = filepath.Join(recording.dir, recording.id)
+	}
+	go recording.run()
+
+	return recording
+}
+
+func newRecordingID() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+
+	return hex.EncodeToString(buf)
+}
+
+// StartRecording starts recording stream on demand, with the settings of its
+// record section if it has one. A stream has one recording on demand at a
+// time, besides the one the config file may ask for.
+func (r *Recorder) StartRecording(stream string) (RecordingInfo, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if !r.running {
+		return RecordingInfo{}, errRecorderNotRunning
+	}
+	for _, recording := range r.onDemand {
+		if recording.stream == stream && recording.origin == recordOriginAPI && recording.Active() {
+			return recording.Info(), errRecordingExists
+		}
+	}
+
+	recording := r.start(stream, recordOriginAPI, r.settings(stream))
+	r.onDemand[recording.id] = recording
+	r.forget()
+
+	return recording.Info(), nil
+}
+
+// AddSchedule records stream within the windows of config until the schedule
+// is removed, starting now if one is open.
+func (r *Recorder) AddSchedule(stream string, config RecordScheduleConfig) (RecordSchedule, error) {
+	window, err := newRecordWindow(config)
+	if err != nil {
+		return RecordSchedule{}, err
+	}
+	schedule := &recordSchedule{
+		info: RecordSchedule{
+			ID:       newRecordingID(),
+			Stream:   stream,
+			Start:    config.Start,
+			Duration: config.Duration.String(),
+		},
+		window: window,
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.schedules[schedule.info.ID] = schedule
+	r.sync(time.Now())
+
+	return schedule.Info(), nil
+}
+
+// RemoveSchedule removes a schedule set through the admin API, stopping its
+// recording if one is under way.
+func (r *Recorder) RemoveSchedule(id string) error {
+	r.lock.Lock()
+	schedule, ok := r.schedules[id]
+	delete(r.schedules, id)
+	r.lock.Unlock()
+	if !ok {
+		return errScheduleUnknown
+	}
+
+	if schedule.recording != nil {
+		schedule.recording.stop()
+	}
+	return nil
+}
+
+// Schedules lists the schedules set through the admin API by stream.
+func (r *Recorder) Schedules() []RecordSchedule {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	schedules := make([]RecordSchedule, 0, len(r.schedules))
+	for _, schedule := range r.schedules {
+		schedules = append(schedules, schedule.Info())
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		if schedules[i].Stream != schedules[j].Stream {
+			return schedules[i].Stream < schedules[j].Stream
+		}
+		return schedules[i].ID < schedules[j].ID
+	})
+	return schedules
+}
+
+// Info must be called with the recorder's lock held.
+func (s *recordSchedule) Info() RecordSchedule {
+	info := s.info
+	if s.recording != nil && s.recording.Active() {
+		info.Recording = s.recording.id
+	}
+	return info
+}
+
+// StopRecording stops a recording on demand, returning it with its files once
+// its last segment is complete.
+func (r *Recorder) StopRecording(id string) (RecordingInfo, error) {
+	r.lock.Lock()
+	recording, ok := r.onDemand[id]
+	if !ok {
+		for _, configured := range r.recordings {
+			if configured.id == id {
+				r.lock.Unlock()
+				return RecordingInfo{}, errRecordingConfig
+			}
+		}
+	}
+	r.lock.Unlock()
+	if !ok {
+		return RecordingInfo{}, errRecordingUnknown
+	}
+
+	recording.stop()
+	return recording.Info(), nil
+}
+
+// Recording returns the recording with the given ID.
+func (r *Recorder) Recording(id string) (RecordingInfo, bool) {
+	for _, recording := range r.Recordings() {
+		if recording.ID == id {
+			return recording, true
+		}
+	}
+	return RecordingInfo{}, false
+}
+
+// Recordings lists the recordings under way and the finished recordings on
+// demand, oldest first.
+func (r *Recorder) Recordings() []RecordingInfo {
+	r.lock.Lock()
+	recordings := make([]*Recording, 0, len(r.recordings)+len(r.onDemand))
+	for _, recording := range r.recordings {
+		recordings = append(recordings, recording)
+	}
+	for _, recording := range r.onDemand {
+		recordings = append(recordings, recording)
+	}
+	r.lock.Unlock()
+
+	infos := make([]RecordingInfo, 0, len(recordings))
+	for _, recording := range recordings {
+		infos = append(infos, recording.Info())
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Started.Before(infos[j].Started)
+	})
+	return infos
+}
+
+// forget drops the oldest finished recordings on demand beyond
+// recordHistory. It must be called with the lock held.
+func (r *Recorder) forget() {
+	finished := []*Recording{}
+	for _, recording := range r.onDemand {
+		if !recording.Active() {
+			finished = append(finished, recording)
+		}
+	}
+	if len(finished) <= recordHistory {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].started.Before(finished[j].started)
+	})
+	for _, recording := range finished[:len(finished)-recordHistory] {
+		delete(r.onDemand, recording.id)
+	}
+}
+
+// Run starts the configured recordings, follows the schedules and prunes the
+// segments until Close.
+func (r *Recorder) Run() {
+	go r.remuxer.Run()
+	go r.uploader.Run()
+
+	r.lock.Lock()
+	r.running = true
+	r.sync(time.Now())
+	r.lock.Unlock()
+
+	schedule := time.NewTicker(recordScheduleInterval)
+	defer schedule.Stop()
+	prune := time.NewTicker(recordPruneInterval)
+	defer prune.Stop()
+
+	r.prune(time.Now())
+	for {
+		select {
+		case now := <-schedule.C:
+			// Stopped recordings complete their last segment on their own.
+			r.lock.Lock()
+			r.sync(now)
+			r.lock.Unlock()
+		case now := <-prune.C:
+			r.prune(now)
+		case <-r.quit:
+			return
+		}
+	}
+}
+
+// prune deletes the complete segments the retention rules no longer keep.
+func (r *Recorder) prune(now time.Time) {
+	r.lock.Lock()
+	retention := r.retention
+	r.lock.Unlock()
+
+	for stream, rule := range retention {
+		if rule.maxAge == 0 && rule.maxBytes == 0 {
+			continue
+		}
+		count, size, err := pruneSegments(filepath.Join(rule.dir, streamDirName(stream)), stream, rule, now)
+		if err != nil && !os.IsNotExist(err) {
+			r.logger.Printf("Pruning the recordings of stream %s: %v\n", stream, err)
+		}
+		if count > 0 {
+			r.logger.Printf("Pruned %d recorded segment(s) of stream %s, %d bytes\n", count, stream, size)
+		}
+	}
+}
+
+// Close stops every recording, waiting for its last segment to be complete.
+func (r *Recorder) Close() {
+	r.lock.Lock()
+	r.running = false
+	recordings := []*Recording{}
+	for _, recording := range r.recordings {
+		recordings = append(recordings, recording)
+	}
+	for _, recording := range r.onDemand {
+		recordings = append(recordings, recording)
+	}
+	r.recordings = make(map[string]*Recording)
+	r.lock.Unlock()
+
+	for _, recording := range recordings {
+		recording.stop()
+	}
+	r.remuxer.Close()
+	r.uploader.Close()
+	select {
+	case <-r.quit:
+	default:
+		close(r.quit)
+	}
+}
+
+// halt tells the recording to stop.
+func (rec *Recording) halt() {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+
+	if rec.stopped.IsZero() {
+		rec.stopped = time.Now()
+		close(rec.quit)
+	}
+}
+
+// stop stops the recording and waits for its last segment to be complete.
+func (rec *Recording) stop() {
+	rec.halt()
+	<-rec.done
+}
+
+// Active reports whether the recording is under way.
+func (rec *Recording) Active() bool {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+
+	return rec.stopped.IsZero()
+}
+
+func (rec *Recording) Info() RecordingInfo {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+
+	info := RecordingInfo{
+		ID:      rec.id,
+		Stream:  rec.stream,
+		Origin:  rec.origin,
+		Dir:     rec.dir,
+		Started: rec.started,
+		Current: rec.current,
+		Files:   append([]string{}, rec.files...),
+	}
+	if !rec.stopped.IsZero() {
+		stopped := rec.stopped
+		info.Stopped = &stopped
+	}
+	return info
+}
+
+// opened and closed follow the segments of the recording.
+func (rec *Recording) opened(path string) {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+
+	rec.current = path
+}
+
+func (rec *Recording) closed(path string) {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+
+	rec.current = ""
+	if path == "" {
+		return
+	}
+	if rec.origin != recordOriginConfig {
+		rec.files = append(rec.files, path)
+	}
+	if rec.settings.mp4 {
+		// Uploaded once the MP4 of the session is made.
+		rec.session = append(rec.session, path)
+	} else {
+		rec.uploader.Add(rec.stream, rec.settings.dir, path)
+	}
+}
+
+// ended hands the segments of a session that ended to the remuxer.
+func (rec *Recording) ended(videoType byte) {
+	rec.lock.Lock()
+	session := rec.session
+	rec.session = nil
+	rec.lock.Unlock()
+
+	if len(session) > 0 {
+		rec.remuxer.Add(remuxJob{stream: rec.stream, root: rec.settings.dir, dir: rec.dir, files: session, videoType: videoType})
+	}
+}
+
+func (rec *Recording) run() {
+	defer close(rec.done)
+
+	if err := os.MkdirAll(rec.dir, 0755); err != nil {
+		rec.logger.Printf("Recording stream %s failed: %v\n", rec.stream, err)
+		return
+	}
+	rec.logger.Printf("Recording stream %s to %s\n", rec.stream, rec.dir)
+
+	w := &segmentWriter{stream: rec.stream, dir: rec.dir, settings: rec.settings, opened: rec.opened, closed: rec.closed, ended: rec.ended, logger: rec.logger}
+	defer w.endSession()
+	for {
+		w.prime(rec.hub.tables.Tables(rec.stream))
+		tap := rec.hub.AddTap(rec.stream)
+		done := rec.record(tap, w)
+		rec.hub.RemoveTap(tap)
+		if done {
+			rec.logger.Printf("Recording of stream %s stopped\n", rec.stream)
+			return
+		}
+		rec.logger.Printf("Recording of stream %s fell behind, starting a new segment\n", rec.stream)
+		w.close()
+	}
+}
+
+// record writes the data of tap until the tap is dropped, reporting false,
+// or the recording is stopped.
+func (rec *Recording) record(tap *Tap, w *segmentWriter) bool {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case data := <-tap.C:
+			w.write(data.Bytes())
+			data.Release()
+		case now := <-ticker.C:
+			w.idle(now)
+		case <-tap.Done():
+			// What was queued before the tap was closed is still good.
+			for len(tap.C) > 0 {
+				data := <-tap.C
+				w.write(data.Bytes())
+				data.Release()
+			}
+			select {
+			case <-rec.quit:
+				return true
+			case <-rec.hub.quit:
+				return true
+			default:
+				return false
+			}
+		case <-rec.quit:
+			return true
+		}
+	}
+}
+
+// segmentWriter cuts a stream into segment files, each starting with the
+// stream's PAT and PMT and, where it can, at a keyframe.
+type segmentWriter struct {
+	stream   string
+	dir      string
+	settings recordSettings
+
+	pending   []byte
+	pat       []byte
+	pmt       []byte
+	pmtPID    uint16
+	videoPID  uint16
+	videoType byte
+
+	file      *os.File
+	buf       *bufio.Writer
+	path      string // of the segment being written, with recordPartSuffix
+	started   time.Time
+	size      int64
+	lastWrite time.Time
+	failed    bool // the segment could not be written, logged once
+
+	opened func(path string)    // with the path of a new segment
+	closed func(path string)    // with the final path of a segment, or "" if it was empty
+	ended  func(videoType byte) // once the stream stopped or went idle
+	logger *log.Logger
+}
+
+// prime takes the stream's PAT and PMT from tables, for a segment started
+// before the stream repeats them.
+func (w *segmentWriter) prime(tables []byte) {
+	var pending []byte
+	tsPackets(&pending, tables, w.table)
+}
+
+func (w *segmentWriter) write(data []byte) {
+	tsPackets(&w.pending, data, w.packet)
+	w.lastWrite = time.Now()
+}
+
+func (w *segmentWriter) table(packet []byte) {
+	pid := packetPID(packet)
+	switch {
+	case pid == 0:
+		if pmtPID, ok := parsePAT(packet); ok {
+			w.pat = append([]byte{}, packet...)
+			w.pmtPID = pmtPID
+		}
+	case pid == w.pmtPID && w.pmtPID != 0:
+		if videoPID, videoType, ok := parsePMT(packet); ok {
+			w.pmt = append([]byte{}, packet...)
+			w.videoPID = videoPID
+			w.videoType = videoType
+		}
+	}
+}
+
+func (w *segmentWriter) packet(packet []byte) {
+	w.table(packet)
+
+	pid := packetPID(packet)
+	keyframe := pid == w.videoPID && w.videoPID != 0 && isKeyframe(packet, w.videoType)
+	if w.file != nil && w.due(keyframe) {
+		w.close()
+	}
+	if w.file == nil {
+		if !w.open() {
+			return
+		}
+		if pid != 0 {
+			w.put(w.pat)
+			w.put(w.pmt)
+		}
+	}
+	w.put(packet)
+}
+
+// due reports whether the segment is to end before packet.
+func (w *segmentWriter) due(keyframe bool) bool {
+	age := time.Since(w.started)
+	duration, size := w.settings.segmentDuration, w.settings.segmentSize
+	if duration > 0 && age >= 2*duration || size > 0 && w.size >= 2*size {
+		return true
+	}
+	return (keyframe || w.videoPID == 0) && (duration > 0 && age >= duration || size > 0 && w.size >= size)
+}
+
+func (w *segmentWriter) open() bool {
+	w.started = time.Now()
+	w.path = filepath.Join(w.dir, fmt.Sprintf("%s-%s.ts%s", url.PathEscape(w.stream), w.started.UTC().Format(recordTimeFormat), recordPartSuffix))
+	file, err := os.Create(w.path)
+	if err != nil {
+		if !w.failed {
+			w.logger.Printf("Recording stream %s: %v\n", w.stream, err)
+			w.failed = true
+		}
+		return false
+	}
+	w.failed = false
+	w.file = file
+	w.buf = bufio.NewWriterSize(file, recordBufferSize)
+	w.size = 0
+	if w.opened != nil {
+		w.opened(w.path)
+	}
+	return true
+}
+
+func (w *segmentWriter) put(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	w.buf.Write(data)
+	w.size += int64(len(data))
+}
+
+// idle writes out what the segment collected and closes it, ending the
+// session, once the stream has sent nothing for recordIdleClose.
+func (w *segmentWriter) idle(now time.Time) {
+	if w.file == nil {
+		return
+	}
+	if now.Sub(w.lastWrite) >= recordIdleClose {
+		w.endSession()
+		return
+	}
+	w.buf.Flush()
+}
+
+// close completes the segment being written, if any, giving it its final
+// name, or removes it if it is empty.
+func (w *segmentWriter) close() {
+	if w.file == nil {
+		return
+	}
+	err := w.buf.Flush()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	w.file, w.buf = nil, nil
+	if err != nil {
+		w.logger.Printf("Recording stream %s: %v\n", w.stream, err)
+	}
+
+	if w.size == 0 {
+		os.Remove(w.path)
+		w.segmentClosed("")
+		return
+	}
+	final := strings.TrimSuffix(w.path, ".ts"+recordPartSuffix) + "-" + time.Now().UTC().Format(recordTimeFormat) + ".ts"
+	if err := os.Rename(w.path, final); err != nil {
+		w.logger.Printf("Recording stream %s: %v\n", w.stream, err)
+		final = w.path
+	}
+	w.segmentClosed(final)
+}
+
+// endSession closes the segment being written and ends the session.
+func (w *segmentWriter) endSession() {
+	w.close()
+	if w.ended != nil {
+		w.ended(w.videoType)
+	}
+}
+
+func (w *segmentWriter) segmentClosed(path string) {
+	if w.closed != nil {
+		w.closed(path)
+	}
+}
+
+// segmentFile is a complete segment on disk.
+type segmentFile struct {
+	name  string
+	start time.Time
+	end   time.Time
+	size  int64
+}
+
+// parseSegmentName returns the stream, start and end of a complete segment
+// from its name, <stream>-<start>-<end>.ts, or of the MP4 of a session,
+// <stream>-<start>-<end>.mp4.
+func parseSegmentName(name string) (string, time.Time, time.Time, bool) {
+	rest, ok := strings.CutSuffix(name, ".ts")
+	if !ok {
+		if rest, ok = strings.CutSuffix(name, ".mp4"); !ok {
+			return "", time.Time{}, time.Time{}, false
+		}
+	}
+	rest, end, ok := cutSegmentTime(rest)
+	if !ok {
+		return "", time.Time{}, time.Time{}, false
+	}
+	rest, start, ok := cutSegmentTime(rest)
+	if !ok {
+		return "", time.Time{}, time.Time{}, false
+	}
+	stream, err := url.PathUnescape(rest)
+	if err != nil {
+		return "", time.Time{}, time.Time{}, false
+	}
+	return stream, start, end, true
+}
+
+// parsePartName returns the stream of a segment being written from its name,
+// <stream>-<start>.ts.part.
+func parsePartName(name string) (string, bool) {
+	rest, ok := strings.CutSuffix(name, ".ts"+recordPartSuffix)
+	if !ok {
+		return "", false
+	}
+	rest, _, ok = cutSegmentTime(rest)
+	if !ok {
+		return "", false
+	}
+	stream, err := url.PathUnescape(rest)
+	return stream, err == nil
+}
+
+// cutSegmentTime cuts the time after the last dash off name.
+func cutSegmentTime(name string) (string, time.Time, bool) {
+	dash := strings.LastIndex(name, "-")
+	if dash < 0 {
+		return "", time.Time{}, false
+	}
+	t, err := time.Parse(recordTimeFormat, name[dash+1:])
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return name[:dash], t, true
+}
+
+// listSegments returns the complete segments of stream in dir, oldest first,
+// and the bytes of the segments there, including one being written.
+func listSegments(dir, stream string) ([]segmentFile, int64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	segments := []segmentFile{}
+	total := int64(0)
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		name := entry.Name()
+		if partStream, ok := parsePartName(name); ok && partStream == stream {
+			total += info.Size()
+			continue
+		}
+		segmentStream, start, end, ok := parseSegmentName(name)
+		if !ok || segmentStream != stream {
+			continue
+		}
+		segments = append(segments, segmentFile{name: name, start: start, end: end, size: info.Size()})
+		total += info.Size()
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].start.Before(segments[j].start)
+	})
+	return segments, total, nil
+}
+
+// pruneSegments deletes the complete segments of stream in dir that ended
+// more than rule.maxAge ago, then the oldest while the segments take more
+// than rule.maxBytes, returning how many it deleted and their bytes.
+func pruneSegments(dir, stream string, rule recordRetention, now time.Time) (int, int64, error) {
+	segments, total, err := listSegments(dir, stream)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	count, size := 0, int64(0)
+	for _, segment := range segments {
+		expired := rule.maxAge > 0 && now.Sub(segment.end) > rule.maxAge
+		over := rule.maxBytes > 0 && total > rule.maxBytes
+		if !expired && !over {
+			break
+		}
+		if err := os.Remove(filepath.Join(dir, segment.name)); err != nil {
+			return count, size, err
+		}
+		total -= segment.size
+		count++
+		size += segment.size
+	}
+	return count, size, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// RelaySource pulls an MPEG-TS stream from an upstream HTTP(S) URL and
+// republishes it, reconnecting with exponential backoff whenever the upstream
+// fails or ends. The stream is only taken once the upstream sends data.
+type RelaySource struct {
+	name   string // upstream URL without credentials
+	url    string
+	stream string
+
+	handler *IncomingStreamHandler
+	client  *http.Client
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	logger *log.Logger
+}
+
+func NewRelaySources(params *Params, handler *IncomingStreamHandler) []Source {
+	sources := []Source{}
+	for _, stream := range params.streams {
+		if stream.Relay == "" {
+			continue
+		}
+
+		name := stream.Relay
+		if u, err := url.Parse(stream.Relay); err == nil {
+			name = u.Redacted()
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		sources = append(sources, &RelaySource{
+			name:    name,
+			url:     stream.Relay,
+			stream:  stream.Name,
+			handler: handler,
+			client:  &http.Client{},
+			ctx:     ctx,
+			cancel:  cancel,
+			logger:  params.logger,
+		})
+	}
+
+	return sources
+}
+
+func (r *RelaySource) Run() {
+	backoff := NewBackoff(RetryConfig{})
+	for {
+		received, err := r.runOnce()
+		if err != nil {
+			r.logger.Printf("%s: relay failed: %v\n", r.name, err)
+		}
+		if received {
+			backoff.Reset()
+		}
+
+		delay, _ := backoff.Next()
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// runOnce relays a single upstream response and reports whether any data
+// came through.
+func (r *RelaySource) runOnce() (bool, error) {
+	req, err := http.NewRequestWithContext(r.ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		if r.ctx.Err() != nil {
+			return false, nil
+		}
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("upstream answered %s", resp.Status)
+	}
+
+	var session *PublishSession
+	defer func() {
+		if session != nil {
+			r.handler.endPublish(session)
+		}
+	}()
+
+	chunks := r.handler.NewChunkReader(resp.Body)
+	for {
+		data, err := chunks.Next()
+		if err != nil {
+			return session != nil, nil
+		}
+
+		if session == nil {
+			session, err = r.handler.publisherLock.AcquireAddr(r.stream, r.name, nil, false)
+			if err != nil {
+				return false, err
+			}
+			r.handler.publishers.Add(1)
+			r.logger.Printf("IncomingStream connected: %s (stream %s)\n", r.name, r.stream)
+		}
+		if session.Superseded() {
+			r.logger.Printf("IncomingStream %s superseded on stream %s\n", r.name, r.stream)
+			return true, nil
+		}
+		session.meter.Add(len(data))
+
+		r.handler.Broadcast(session, data)
+	}
+}
+
+// Close disconnects from the upstream and stops reconnecting.
+func (r *RelaySource) Close() {
+	r.cancel()
+}
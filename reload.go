@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Reload re-reads the config file on top of the flag and environment values
+// the server was started with. TLS settings are carried over because the
+// listeners cannot pick up new certificates without a restart.
+func (p *Params) Reload() (*Params, error) {
+	reloaded := *p.base
+	reloaded.base = p.base
+	reloaded.tlsConfig = p.tlsConfig
+	reloaded.autocertManager = p.autocertManager
+	reloaded.ingestClientCAs = p.ingestClientCAs
+
+	if err := reloaded.applyConfigFile(); err != nil {
+		return nil, err
+	}
+	if err := reloaded.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &reloaded, nil
+}
+
+// Reload applies the config file again. Viewers and publishers stay connected;
+// settings that need new listeners are reported and ignored until the next
+// restart.
+func (s *Server) Reload() error {
+	s.paramsLock.Lock()
+	defer s.paramsLock.Unlock()
+
+	params := s.params
+	logger := params.logger
+
+	if params.configFile == "" {
+		return fmt.Errorf("no config file to reload")
+	}
+
+	reloaded, err := params.Reload()
+	if err != nil {
+		return err
+	}
+
+	if reloaded.incomingPort != params.incomingPort ||
+		reloaded.websocketPort != params.websocketPort ||
+		reloaded.singlePort != params.singlePort ||
+		reloaded.adminPort != params.adminPort ||
+		reloaded.adminGRPCPort != params.adminGRPCPort ||
+		reloaded.ingestGRPCPort != params.ingestGRPCPort ||
+		reloaded.incomingSocket != params.incomingSocket ||
+		reloaded.incomingSocketStream != params.incomingSocketStream ||
+		reloaded.udpIngest != params.udpIngest ||
+		reloaded.udpStream != params.udpStream ||
+		reloaded.rtpIngest != params.rtpIngest ||
+		reloaded.rtpStream != params.rtpStream ||
+		reloaded.rtpJitter != params.rtpJitter ||
+		reloaded.rtpFEC != params.rtpFEC ||
+		reloaded.rtmpIngest != params.rtmpIngest ||
+		reloaded.rtmpStream != params.rtmpStream ||
+		reloaded.testSource != params.testSource ||
+		reloaded.testSourceStream != params.testSourceStream ||
+		reloaded.hls != params.hls ||
+		reloaded.hlsDir != params.hlsDir ||
+		reloaded.hlsSegmentDuration != params.hlsSegmentDuration ||
+		reloaded.hlsPlaylistSize != params.hlsPlaylistSize ||
+		reloaded.hlsPartDuration != params.hlsPartDuration ||
+		reloaded.whep != params.whep ||
+		reloaded.whepICEServers != params.whepICEServers ||
+		reloaded.whepPublicIP != params.whepPublicIP ||
+		reloaded.webTransportPort != params.webTransportPort ||
+		reloaded.thumbnailInterval != params.thumbnailInterval ||
+		reloaded.thumbnailWidth != params.thumbnailWidth ||
+		reloaded.gopCache != params.gopCache ||
+		reloaded.resumeBufferSize != params.resumeBufferSize ||
+		reloaded.hubShards != params.hubShards ||
+		reloaded.wsBackend != params.wsBackend ||
+		reloaded.httpReadHeaderTimeout != params.httpReadHeaderTimeout ||
+		reloaded.httpReadTimeout != params.httpReadTimeout ||
+		reloaded.httpWriteTimeout != params.httpWriteTimeout ||
+		reloaded.httpIdleTimeout != params.httpIdleTimeout {
+		logger.Println("Listener changes take effect after a restart")
+		reloaded.incomingPort = params.incomingPort
+		reloaded.websocketPort = params.websocketPort
+		reloaded.singlePort = params.singlePort
+		reloaded.adminPort = params.adminPort
+		reloaded.adminGRPCPort = params.adminGRPCPort
+		reloaded.ingestGRPCPort = params.ingestGRPCPort
+		reloaded.incomingSocket = params.incomingSocket
+		reloaded.incomingSocketStream = params.incomingSocketStream
+		reloaded.udpIngest = params.udpIngest
+		reloaded.udpStream = params.udpStream
+		reloaded.rtpIngest = params.rtpIngest
+		reloaded.rtpStream = params.rtpStream
+		reloaded.rtpJitter = params.rtpJitter
+		reloaded.rtpFEC = params.rtpFEC
+		reloaded.rtmpIngest = params.rtmpIngest
+		reloaded.rtmpStream = params.rtmpStream
+		reloaded.testSource = params.testSource
+		reloaded.testSourceStream = params.testSourceStream
+		reloaded.hls = params.hls
+		reloaded.hlsDir = params.hlsDir
+		reloaded.hlsSegmentDuration = params.hlsSegmentDuration
+		reloaded.hlsPlaylistSize = params.hlsPlaylistSize
+		reloaded.hlsPartDuration = params.hlsPartDuration
+		reloaded.whep = params.whep
+		reloaded.whepICEServers = params.whepICEServers
+		reloaded.whepPublicIP = params.whepPublicIP
+		reloaded.webTransportPort = params.webTransportPort
+		reloaded.thumbnailInterval = params.thumbnailInterval
+		reloaded.thumbnailWidth = params.thumbnailWidth
+		reloaded.gopCache = params.gopCache
+		reloaded.resumeBufferSize = params.resumeBufferSize
+		reloaded.hubShards = params.hubShards
+		reloaded.wsBackend = params.wsBackend
+		reloaded.httpReadHeaderTimeout = params.httpReadHeaderTimeout
+		reloaded.httpReadTimeout = params.httpReadTimeout
+		reloaded.httpWriteTimeout = params.httpWriteTimeout
+		reloaded.httpIdleTimeout = params.httpIdleTimeout
+	}
+	if reloaded.tlsCert != params.tlsCert || reloaded.tlsKey != params.tlsKey || reloaded.autocertHosts != params.autocertHosts || reloaded.ingestClientCA != params.ingestClientCA {
+		logger.Println("TLS changes take effect after a restart")
+		reloaded.tlsCert = params.tlsCert
+		reloaded.tlsKey = params.tlsKey
+		reloaded.autocertHosts = params.autocertHosts
+		reloaded.ingestClientCA = params.ingestClientCA
+	}
+
+	if !sameSources(params, reloaded) {
+		logger.Println("Source, fallback and output changes take effect after a restart")
+	}
+
+	s.ApplyParams(reloaded)
+	s.params = reloaded
+
+	logger.Printf("Configuration reloaded, %d stream(s) configured\n", len(reloaded.streams))
+
+	return nil
+}
+
+func sameSources(a, b *Params) bool {
+	sources := func(p *Params) map[string]string {
+		urls := make(map[string]string)
+		for _, stream := range p.streams {
+			if stream.RTSP != "" || stream.FIFO != "" || stream.TCPIngest != "" || stream.IngestListen != "" || stream.Relay != "" || stream.Dial != "" || stream.Encoder != nil || stream.File != "" || stream.Fallback != nil || len(stream.Restream) > 0 || stream.Multicast != nil {
+				urls[stream.Name] = fmt.Sprint(stream.RTSP, " ", stream.RTSPTransport, " ", stream.FIFO, " ", stream.TCPIngest, " ", stream.IngestListen, " ", stream.Relay, " ", stream.Dial, " ", stream.DialRetry, " ", stream.File)
+				if stream.Encoder != nil {
+					urls[stream.Name] += fmt.Sprintf(" %+v", *stream.Encoder)
+				}
+				if stream.Fallback != nil {
+					urls[stream.Name] += fmt.Sprintf(" %+v", *stream.Fallback)
+				}
+				urls[stream.Name] += fmt.Sprintf(" %+v", stream.Restream)
+				if stream.Multicast != nil {
+					urls[stream.Name] += fmt.Sprintf(" %+v", *stream.Multicast)
+				}
+			}
+		}
+		return urls
+	}
+
+	before, after := sources(a), sources(b)
+	if len(before) != len(after) || fmt.Sprint(a.edges) != fmt.Sprint(b.edges) {
+		return false
+	}
+	for name, url := range before {
+		if after[name] != url {
+			return false
+		}
+	}
+	return true
+}
+
+// ReloadOnSignal reloads the config file every time the process receives
+// SIGHUP.
+func (s *Server) ReloadOnSignal() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+
+	for range sigs {
+		params := s.currentParams()
+		params.logger.Println("SIGHUP received, reloading " + params.configFile)
+		if err := s.Reload(); err != nil {
+			params.logger.Printf("Reload failed, keeping current configuration: %v\n", err)
+		}
+	}
+}
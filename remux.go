@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// remuxQueue is how many recording sessions may wait for their MP4.
+const remuxQueue = 64
+
+// ffmpeg codec arguments of an MP4 browsers and phones play: H.264 video is
+// copied, other video transcoded to H.264. Audio is always encoded to AAC,
+// which costs little, as MP4 players rarely take the MP2 of jsmpeg streams.
+var (
+	remuxCopyArgs      = []string{"-c:v", "copy", "-c:a", "aac"}
+	remuxTranscodeArgs = []string{"-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-pix_fmt", "yuv420p", "-c:a", "aac"}
+)
+
+// remuxJob is a recording session to turn into one MP4: the segments of a
+// stream written one after the other until the recording stopped or the
+// stream went idle.
+type remuxJob struct {
+	stream    string
+	root      string // the record directory
+	dir       string
+	files     []string
+	videoType byte
+}
+
+// Remuxer turns recording sessions into MP4 files next to their segments
+// with the managed ffmpeg, one at a time, and emits a recording_remuxed or
+// recording_remux_failed event for each. The segments are left for the
+// retention rules, which apply to the MP4 files too; both are then handed to
+// the uploader.
+type Remuxer struct {
+	ffmpeg string
+	lock   sync.Mutex
+
+	jobs     chan remuxJob
+	ctx      context.Context
+	cancel   context.CancelFunc
+	uploader *Uploader
+	events   *EventBus
+	logger   *log.Logger
+}
+
+func NewRemuxer(params *Params, uploader *Uploader, events *EventBus) *Remuxer {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &Remuxer{
+		jobs:     make(chan remuxJob, remuxQueue),
+		ctx:      ctx,
+		cancel:   cancel,
+		uploader: uploader,
+		events:   events,
+		logger:   params.logger,
+	}
+	m.ApplyParams(params)
+
+	return m
+}
+
+func (m *Remuxer) ApplyParams(params *Params) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.ffmpeg = params.ffmpegPath
+}
+
+// Add queues job, dropping it if the queue is full.
+func (m *Remuxer) Add(job remuxJob) {
+	select {
+	case m.jobs <- job:
+	default:
+		m.logger.Printf("Too many recordings waiting for their MP4, not remuxing %d segment(s) of stream %s\n", len(job.files), job.stream)
+		m.upload(job, "")
+	}
+}
+
+// upload hands the segments of job and its MP4, if made, to the uploader.
+func (m *Remuxer) upload(job remuxJob, mp4 string) {
+	for _, file := range job.files {
+		m.uploader.Add(job.stream, job.root, file)
+	}
+	if mp4 != "" {
+		m.uploader.Add(job.stream, job.root, mp4)
+	}
+}
+
+// Run remuxes the queued sessions until Close.
+func (m *Remuxer) Run() {
+	for {
+		select {
+		case job := <-m.jobs:
+			m.remux(job)
+		case <-m.ctx.Done():
+			if len(m.jobs) > 0 {
+				m.logger.Printf("Shutting down with %d recording(s) waiting for their MP4\n", len(m.jobs))
+			}
+			return
+		}
+	}
+}
+
+// Close stops the remuxing under way, leaving its segments as they are.
+func (m *Remuxer) Close() {
+	m.cancel()
+}
+
+func (m *Remuxer) remux(job remuxJob) {
+	_, start, _, ok := parseSegmentName(filepath.Base(job.files[0]))
+	_, _, end, ok2 := parseSegmentName(filepath.Base(job.files[len(job.files)-1]))
+	if !ok || !ok2 {
+		m.failed(job, fmt.Errorf("segment names %s and %s carry no recording times", filepath.Base(job.files[0]), filepath.Base(job.files[len(job.files)-1])))
+		return
+	}
+	name := fmt.Sprintf("%s-%s-%s.mp4", url.PathEscape(job.stream), start.UTC().Format(recordTimeFormat), end.UTC().Format(recordTimeFormat))
+	path := filepath.Join(job.dir, name)
+	part := path + recordPartSuffix
+
+	codecs := remuxTranscodeArgs
+	if job.videoType == streamTypeH264 {
+		codecs = remuxCopyArgs
+	}
+	args := []string{"-hide_banner", "-loglevel", "error", "-y", "-i", "concat:" + strings.Join(job.files, "|")}
+	args = append(args, codecs...)
+	args = append(args, "-movflags", "+faststart", "-f", "mp4", part)
+
+	m.lock.Lock()
+	cmd := exec.CommandContext(m.ctx, m.ffmpeg, args...)
+	m.lock.Unlock()
+
+	started := time.Now()
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		err = os.Rename(part, path)
+	}
+	if err != nil {
+		os.Remove(part)
+		if m.ctx.Err() != nil {
+			return
+		}
+		if line := lastLine(string(output)); line != "" {
+			err = fmt.Errorf("%v: %s", err, line)
+		}
+		m.failed(job, err)
+		return
+	}
+
+	m.logger.Printf("Remuxed %d segment(s) of stream %s to %s in %s\n", len(job.files), job.stream, path, time.Since(started).Round(time.Millisecond))
+	m.events.Publish(Event{
+		Type:   EventRecordingRemuxed,
+		Stream: job.stream,
+		Data: map[string]string{
+			"file":     path,
+			"segments": strconv.Itoa(len(job.files)),
+			"duration": end.Sub(start).Round(time.Second).String(),
+		},
+	})
+	m.upload(job, path)
+}
+
+// failed reports that job could not be remuxed and uploads its segments
+// alone.
+func (m *Remuxer) failed(job remuxJob, err error) {
+	m.logger.Printf("Remuxing %d segment(s) of stream %s failed: %v\n", len(job.files), job.stream, err)
+	m.upload(job, "")
+	m.events.Publish(Event{
+		Type:   EventRecordingRemuxFailed,
+		Stream: job.stream,
+		Data: map[string]string{
+			"segments": strconv.Itoa(len(job.files)),
+			"error":    err.Error(),
+		},
+	})
+}
+
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
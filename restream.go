@@ -0,0 +1,364 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// restreamOutputArgs encode a stream as the H.264/AAC FLV that RTMP services
+// such as YouTube and Twitch take.
+var restreamOutputArgs = []string{
+	"-codec:v", "libx264", "-preset", "veryfast", "-tune", "zerolatency",
+	"-pix_fmt", "yuv420p", "-g", "50", "-b:v", "2500k",
+	"-codec:a", "aac", "-b:a", "128k", "-ar", "44100",
+	"-f", "flv",
+}
+
+// srtOutputArgs send a stream over SRT as it is.
+var srtOutputArgs = []string{"-codec", "copy", "-f", "mpegts"}
+
+// RestreamConfig pushes a stream to an RTMP URL, or to an srt://host:port
+// ingest point in caller mode. Args replaces the encoding arguments ffmpeg
+// gets between its input and the URL, e.g. to copy H.264 video as is; SRT
+// sends the MPEG-TS unchanged by default. Latency and Passphrase set the SRT
+// receiver latency and encryption. A Manual restream waits to be started
+// through the admin API.
+type RestreamConfig struct {
+	Name       string        `yaml:"name"`
+	URL        string        `yaml:"url"`
+	Args       []string      `yaml:"args"`
+	Latency    time.Duration `yaml:"latency"`
+	Passphrase string        `yaml:"passphrase"`
+	Manual     bool          `yaml:"manual"`
+	Restart    RetryConfig   `yaml:"restart"`
+}
+
+func (c *RestreamConfig) Validate() error {
+	if c.Name == "" || strings.Contains(c.Name, "/") {
+		return fmt.Errorf("needs a name without '/'")
+	}
+	srt := strings.HasPrefix(c.URL, "srt://")
+	if !srt && !strings.HasPrefix(c.URL, "rtmp://") && !strings.HasPrefix(c.URL, "rtmps://") {
+		return fmt.Errorf("url must be an rtmp://, rtmps:// or srt:// URL")
+	}
+	if !srt && (c.Latency != 0 || c.Passphrase != "") {
+		return fmt.Errorf("latency and passphrase need an srt:// URL")
+	}
+	if c.Latency < 0 {
+		return fmt.Errorf("latency must not be negative")
+	}
+	if c.Passphrase != "" && (len(c.Passphrase) < 10 || len(c.Passphrase) > 79) {
+		return fmt.Errorf("passphrase must be 10 to 79 characters long")
+	}
+	if c.Restart.MinDelay < 0 || c.Restart.MaxDelay < 0 || c.Restart.MaxAttempts < 0 {
+		return fmt.Errorf("restart values must not be negative")
+	}
+
+	return nil
+}
+
+// srtURL adds the caller mode, latency and passphrase to an srt:// URL in
+// the form ffmpeg takes them.
+func (c *RestreamConfig) srtURL() string {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return c.URL
+	}
+
+	query := u.Query()
+	query.Set("mode", "caller")
+	if c.Latency > 0 {
+		query.Set("latency", strconv.FormatInt(c.Latency.Microseconds(), 10))
+	}
+	if c.Passphrase != "" {
+		query.Set("passphrase", c.Passphrase)
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+// RestreamStatus describes a restream for the admin API. Destination leaves
+// out the path of the URL, which usually holds the stream key.
+type RestreamStatus struct {
+	EncoderStatus
+	Destination string `json:"destination"`
+}
+
+// Restream feeds what is broadcast on a stream to an ffmpeg process pushing
+// it to an RTMP or SRT URL. ffmpeg is restarted whenever it exits, following the
+// retry policy, until the restream is stopped or gives up; it can then be
+// started again.
+type Restream struct {
+	ffmpegProcess
+
+	stream      string
+	url         string
+	ffmpeg      string
+	args        []string
+	destination string
+	hub         *WebSocketHandler
+
+	stop chan struct{} // set while the restream is started
+	wake chan struct{}
+	quit chan struct{}
+}
+
+// NewRestreams returns a Restream for every restream destination of the
+// streams in the config file.
+func NewRestreams(params *Params, handler *IncomingStreamHandler) []Source {
+	sources := []Source{}
+	for _, stream := range params.streams {
+		for _, config := range stream.Restream {
+			sources = append(sources, NewRestream(stream.Name, config, params, handler.clientManager))
+		}
+	}
+
+	return sources
+}
+
+func NewRestream(stream string, config RestreamConfig, params *Params, hub *WebSocketHandler) *Restream {
+	target, args := config.URL, restreamOutputArgs
+	if strings.HasPrefix(config.URL, "srt://") {
+		target, args = config.srtURL(), srtOutputArgs
+	}
+	if len(config.Args) > 0 {
+		args = config.Args
+	}
+
+	destination := config.URL
+	if u, err := url.Parse(config.URL); err == nil {
+		destination = u.Scheme + "://" + u.Host
+	}
+
+	r := &Restream{
+		ffmpegProcess: ffmpegProcess{
+			name:   "restream:" + stream + "/" + config.Name,
+			retry:  config.Restart,
+			status: EncoderStatus{Name: config.Name, Stream: stream, State: EncoderStopped, Since: time.Now()},
+			logger: params.logger,
+		},
+		stream:      stream,
+		url:         target,
+		ffmpeg:      params.ffmpegPath,
+		args:        args,
+		destination: destination,
+		hub:         hub,
+		wake:        make(chan struct{}, 1),
+		quit:        make(chan struct{}),
+	}
+	if !config.Manual {
+		r.Start()
+	}
+
+	return r
+}
+
+func (r *Restream) Run() {
+	for {
+		stop, ok := r.waitStart()
+		if !ok {
+			return
+		}
+		r.push(stop)
+	}
+}
+
+// waitStart waits for the restream to be started and returns the channel
+// closed when it is stopped, or false once the restream is closed.
+func (r *Restream) waitStart() (chan struct{}, bool) {
+	for {
+		r.lock.Lock()
+		stop := r.stop
+		r.lock.Unlock()
+		if stop != nil {
+			return stop, true
+		}
+
+		select {
+		case <-r.quit:
+			return nil, false
+		case <-r.wake:
+		}
+	}
+}
+
+// push runs ffmpeg until stop is closed or the retry policy gives up.
+func (r *Restream) push(stop chan struct{}) {
+	backoff := NewBackoff(r.retry)
+	for {
+		started := time.Now()
+		err := r.runOnce(stop)
+		if err != nil {
+			r.logger.Printf("%s: ffmpeg exited: %v\n", r.name, err)
+		}
+
+		if time.Since(started) >= ffmpegStableRun {
+			backoff.Reset()
+		}
+		delay, ok := backoff.Next()
+		if !r.exited(stop, err, ok) {
+			return
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (r *Restream) runOnce(stop chan struct{}) error {
+	tap := r.hub.AddTap(r.stream)
+	defer r.hub.RemoveTap(tap)
+
+	args := append([]string{"-hide_banner", "-loglevel", "error", "-f", "mpegts", "-i", "-"}, r.args...)
+	cmd := exec.Command(r.ffmpeg, append(args, r.url)...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+
+	r.lock.Lock()
+	select {
+	case <-stop:
+		r.lock.Unlock()
+		return nil
+	default:
+	}
+	if err := cmd.Start(); err != nil {
+		r.lock.Unlock()
+		return err
+	}
+	r.cmd = cmd
+	r.setState(EncoderRunning, cmd.Process.Pid, "")
+	r.lock.Unlock()
+	r.logger.Printf("%s: pushing to %s\n", r.name, r.destination)
+
+	exited := make(chan error, 1)
+	go func() {
+		r.logStderr(stderr)
+		exited <- cmd.Wait()
+	}()
+
+	for {
+		select {
+		case data := <-tap.C:
+			_, err := stdin.Write(data.Bytes())
+			data.Release()
+			if err != nil {
+				cmd.Process.Kill()
+				return <-exited
+			}
+		case <-tap.Done():
+			cmd.Process.Kill()
+			<-exited
+			return fmt.Errorf("ffmpeg fell behind the stream")
+		case <-stop:
+			cmd.Process.Kill()
+			<-exited
+			return nil
+		case err := <-exited:
+			return err
+		}
+	}
+}
+
+// exited records the end of an ffmpeg run and reports whether ffmpeg is
+// going to be restarted.
+func (r *Restream) exited(stop chan struct{}, err error, restart bool) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	select {
+	case <-stop:
+		return false
+	default:
+	}
+
+	if !r.recordExit(err, restart) {
+		r.stop = nil
+		return false
+	}
+	return true
+}
+
+func (r *Restream) Status() RestreamStatus {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return RestreamStatus{EncoderStatus: r.status, Destination: r.destination}
+}
+
+// Start starts pushing, unless the restream is already started.
+func (r *Restream) Start() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.stop != nil {
+		return
+	}
+	r.stop = make(chan struct{})
+	r.setState(EncoderStarting, 0, "")
+
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Stop stops ffmpeg until the restream is started again.
+func (r *Restream) Stop() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.stop == nil {
+		return
+	}
+	close(r.stop)
+	r.stop = nil
+	r.setState(EncoderStopped, 0, "")
+
+	if r.cmd != nil {
+		r.cmd.Process.Kill()
+	}
+}
+
+// Close stops the restream for good.
+func (r *Restream) Close() {
+	r.Stop()
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	select {
+	case <-r.quit:
+	default:
+		close(r.quit)
+	}
+}
+
+// Restreams reports the restreams of stream, or of every stream when stream
+// is empty.
+func (s *IncomingStreamHandler) Restreams(stream string) []*Restream {
+	s.sourcesLock.Lock()
+	defer s.sourcesLock.Unlock()
+
+	restreams := []*Restream{}
+	for _, source := range s.sources {
+		if restream, ok := source.(*Restream); ok && (stream == "" || restream.stream == stream) {
+			restreams = append(restreams, restream)
+		}
+	}
+
+	return restreams
+}
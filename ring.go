@@ -0,0 +1,313 @@
+package main
+
+import (
+	"sync"
+)
+
+// StreamRing fans an MPEG-TS stream out to its WebSocket viewers. It holds
+// the stream's last messages, as many as the stream's send queue size, and
+// every viewer reads them at its own cursor, so memory is bounded by the ring
+// whatever the number of viewers. A viewer that falls further behind than
+// the ring reaches skips ahead to the last keyframe still in it.
+type StreamRing struct {
+	entries  []ringEntry
+	head     uint64        // number of the next entry pushed
+	keyframe uint64        // number of the last entry with a keyframe, plus 1; 0 for none
+	updated  chan struct{} // closed whenever readers have something new
+
+	// The stream's video PID, from its PAT and PMT, to spot keyframes.
+	pending   []byte
+	pmtPID    uint16
+	videoPID  uint16
+	videoType byte
+
+	lock sync.Mutex
+}
+
+// ringEntry is one broadcast chunk and what viewers of part of the stream or
+// of sequenced messages get instead.
+type ringEntry struct {
+	data     *Buffer
+	keyframe bool                     // data holds the start of a picture a decoder can start from
+	parts    map[subscription]*Buffer // nil for a subscription with nothing in data
+	framed   map[subscription]*Buffer
+}
+
+// message picks what a viewer gets of the entry, nil for nothing.
+func (e *ringEntry) message(sub subscription, framing string) *Buffer {
+	if framing == framingSeq {
+		return e.framed[sub]
+	}
+	if sub != (subscription{}) {
+		return e.parts[sub]
+	}
+	return e.data
+}
+
+// ringView is what a viewer reads of the entries of its StreamRing.
+type ringView struct {
+	sub     subscription
+	framing string
+}
+
+// ringCursor is a viewer's position in its StreamRing. It is only used with
+// the ring's lock held.
+type ringCursor struct {
+	ringView
+	next    uint64
+	started bool
+
+	decimate   bool // the viewer is under the decimate policy
+	throttle   bool // the viewer is decimated over the egress cap
+	throttled  bool // the egress cap is exceeded, set by the viewer
+	decimating bool // the viewer is congested or throttled
+	decimated  int  // entries read while decimating, for the viewer to count
+}
+
+func NewStreamRing(size int) *StreamRing {
+	return &StreamRing{
+		entries: make([]ringEntry, size),
+		updated: make(chan struct{}),
+	}
+}
+
+// push adds an entry, releasing the one it replaces, and wakes the readers.
+func (r *StreamRing) push(entry ringEntry) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	entry.keyframe = r.scan(entry.data.Bytes())
+	slot := &r.entries[r.head%uint64(len(r.entries))]
+	if slot.data != nil {
+		slot.data.Release()
+	}
+	entry.data.Retain()
+	*slot = entry
+	r.head++
+	if entry.keyframe {
+		r.keyframe = r.head
+	}
+	r.wake()
+}
+
+// scan reports whether data starts a picture of the stream's video a decoder
+// can start from.
+func (r *StreamRing) scan(data []byte) bool {
+	keyframe := false
+	tsPackets(&r.pending, data, func(packet []byte) {
+		switch pid := packetPID(packet); {
+		case pid == 0:
+			if pmtPID, ok := parsePAT(packet); ok {
+				r.pmtPID = pmtPID
+			}
+		case pid == r.pmtPID && r.pmtPID != 0:
+			if videoPID, videoType, ok := parsePMT(packet); ok {
+				r.videoPID = videoPID
+				r.videoType = videoType
+			}
+		case pid == r.videoPID && r.videoPID != 0:
+			if isKeyframe(packet, r.videoType) {
+				keyframe = true
+			}
+		}
+	})
+	return keyframe
+}
+
+// wake must be called with the lock held.
+func (r *StreamRing) wake() {
+	close(r.updated)
+	r.updated = make(chan struct{})
+}
+
+// start lets a viewer read from the next entry pushed.
+func (r *StreamRing) start(cursor *ringCursor) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	cursor.next = r.head
+	cursor.started = true
+	r.wake()
+}
+
+// read returns the viewer's next message, retained for it, and the number of
+// entries it skipped because it fell off the ring. With nothing to read it
+// returns a channel closed once there may be.
+func (r *StreamRing) read(cursor *ringCursor) (*Buffer, int, <-chan struct{}) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if !cursor.started {
+		return nil, 0, r.updated
+	}
+
+	skipped := 0
+	if oldest := r.oldest(); cursor.next < oldest {
+		next := r.head
+		if r.keyframe > oldest {
+			next = r.keyframe - 1
+		}
+		skipped = int(next - cursor.next)
+		cursor.next = next
+	}
+
+	for cursor.next < r.head {
+		view := cursor.ringView
+		if cursor.decimate || cursor.throttle {
+			if r.decimate(cursor); cursor.decimating {
+				view = view.intra()
+				cursor.decimated++
+			}
+		}
+		entry := &r.entries[cursor.next%uint64(len(r.entries))]
+		cursor.next++
+		if message := entry.message(view.sub, view.framing); message != nil {
+			message.Retain()
+			return message, skipped, nil
+		}
+	}
+	return nil, skipped, r.updated
+}
+
+// depth returns the number of entries the viewer has yet to read.
+func (r *StreamRing) depth(cursor *ringCursor) int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if !cursor.started {
+		return 0
+	}
+	return int(r.head - max(cursor.next, r.oldest()))
+}
+
+func (r *StreamRing) size() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return len(r.entries)
+}
+
+// bytes returns the size of the messages the ring holds.
+func (r *StreamRing) bytes() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	n := 0
+	for _, entry := range r.entries {
+		if entry.data != nil {
+			n += len(entry.data.Bytes())
+		}
+		for _, buffers := range []map[subscription]*Buffer{entry.parts, entry.framed} {
+			for _, buffer := range buffers {
+				if buffer != nil {
+					n += len(buffer.Bytes())
+				}
+			}
+		}
+	}
+	return n
+}
+
+// oldest must be called with the lock held.
+func (r *StreamRing) oldest() uint64 {
+	if r.head < uint64(len(r.entries)) {
+		return 0
+	}
+	return r.head - uint64(len(r.entries))
+}
+
+// clear releases the entries once the stream has no viewers left, resizing
+// the ring to size for the next ones.
+func (r *StreamRing) clear(size int) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for i := range r.entries {
+		if r.entries[i].data != nil {
+			r.entries[i].data.Release()
+		}
+	}
+	r.entries = make([]ringEntry, size)
+	r.keyframe = 0
+	r.pending = nil
+}
+
+// streamRing returns the ring of stream, making one on the first viewer.
+func (h *WebSocketHandler) streamRing(stream string) *StreamRing {
+	h.ringsLock.Lock()
+	defer h.ringsLock.Unlock()
+
+	ring, ok := h.rings[stream]
+	if !ok {
+		ring = NewStreamRing(h.sendQueueSize(stream, formatTS))
+		h.rings[stream] = ring
+	}
+	return ring
+}
+
+// clearRing releases the ring of stream, whose last viewer left.
+func (h *WebSocketHandler) clearRing(stream string) {
+	h.ringsLock.Lock()
+	ring := h.rings[stream]
+	h.ringsLock.Unlock()
+
+	if ring != nil {
+		ring.clear(h.sendQueueSize(stream, formatTS))
+	}
+}
+
+// ringBytes returns the size of the messages held by the ring of every
+// stream.
+func (h *WebSocketHandler) ringBytes() map[string]int {
+	h.ringsLock.Lock()
+	rings := make(map[string]*StreamRing, len(h.rings))
+	for stream, ring := range h.rings {
+		rings[stream] = ring
+	}
+	h.ringsLock.Unlock()
+
+	sizes := make(map[string]int, len(rings))
+	for stream, ring := range rings {
+		sizes[stream] = ring.bytes()
+	}
+	return sizes
+}
+
+// newRingEntry prepares data for the ring of stream: besides the chunk, the
+// parts of it and the sequenced messages its viewers asked for.
+func (h *WebSocketHandler) newRingEntry(stream string, data *Buffer, id uint32, seq uint64, views []ringView) ringEntry {
+	entry := ringEntry{data: data}
+	var filtered map[subscription][]byte
+	for _, view := range views {
+		out := data
+		if view.sub != (subscription{}) {
+			if filtered == nil {
+				filtered = h.tracks.Filter(stream, data.Bytes(), views)
+				entry.parts = make(map[subscription]*Buffer)
+			}
+			part, ok := entry.parts[view.sub]
+			if !ok {
+				if len(filtered[view.sub]) > 0 {
+					part = NewBuffer(filtered[view.sub])
+				}
+				entry.parts[view.sub] = part
+			}
+			if part == nil {
+				continue
+			}
+			out = part
+		}
+		if view.framing == framingSeq {
+			if entry.framed == nil {
+				entry.framed = make(map[subscription]*Buffer)
+			}
+			if _, ok := entry.framed[view.sub]; !ok {
+				entry.framed[view.sub] = NewBuffer(frameMessage(id, seq, out.Bytes()))
+			}
+		}
+	}
+	if filtered == nil {
+		h.tracks.Skip(stream)
+	}
+	return entry
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"net"
+)
+
+// NewRTMPSource returns an FFmpegSource that lets ffmpeg listen for one RTMP
+// publisher, such as OBS, at rtmp://<addr>/<secret>/<any key>, or nil when
+// RTMP ingest is off. ffmpeg transcodes the feed to MPEG1/MP2 for jsmpeg and
+// is restarted after every publisher, so the next one can connect.
+func NewRTMPSource(params *Params, handler *IncomingStreamHandler) *FFmpegSource {
+	if params.rtmpIngest == "" {
+		return nil
+	}
+
+	secret := params.secret
+	for _, stream := range params.streams {
+		if stream.Name == params.rtmpStream && stream.Secret != "" {
+			secret = stream.Secret
+		}
+	}
+
+	// Validate has checked the address.
+	host, port, _ := net.SplitHostPort(params.rtmpIngest)
+	if host == "" {
+		host = "0.0.0.0"
+	}
+
+	url := "rtmp://" + net.JoinHostPort(host, port) + "/" + secret
+	return NewFFmpegSource("rtmp://"+params.rtmpIngest, params.rtmpStream, []string{"-listen", "1", "-i", url}, params, handler)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/url"
+)
+
+// NewRTSPSources returns an FFmpegSource pulling each stream that has an
+// rtsp URL in the config file, for IP cameras that cannot push.
+func NewRTSPSources(params *Params, handler *IncomingStreamHandler) []Source {
+	sources := []Source{}
+	for _, stream := range params.streams {
+		if stream.RTSP == "" {
+			continue
+		}
+
+		sources = append(sources, NewRTSPSource(stream.Name, stream.RTSP, stream.RTSPTransport, params, handler))
+	}
+
+	return sources
+}
+
+// NewRTSPSource returns an FFmpegSource pulling rtspURL into stream over the
+// "tcp" (default) or "udp" transport.
+func NewRTSPSource(stream, rtspURL, transport string, params *Params, handler *IncomingStreamHandler) *FFmpegSource {
+	if transport == "" {
+		transport = "tcp"
+	}
+
+	name := rtspURL
+	if u, err := url.Parse(rtspURL); err == nil {
+		name = u.Redacted()
+	}
+
+	args := []string{"-rtsp_transport", transport, "-i", rtspURL}
+	return NewFFmpegSource(name, stream, args, params, handler)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// recordScheduleInterval is how often the recorder starts and stops the
+// recordings of its schedules. Schedules start on the minute, so a recording
+// starts at most this late.
+const recordScheduleInterval = 10 * time.Second
+
+// recordScheduleMaxDuration bounds the duration of a scheduled recording, as
+// finding out whether one is under way looks back that far.
+const recordScheduleMaxDuration = 7 * 24 * time.Hour
+
+// RecordScheduleConfig records a stream from every time matching Start, a
+// cron expression, for Duration, e.g. "0 9 * * 1-5" and 9h for business
+// hours on weekdays.
+type RecordScheduleConfig struct {
+	Start    string        `yaml:"start"`
+	Duration time.Duration `yaml:"duration"`
+}
+
+func (c *RecordScheduleConfig) Validate() error {
+	if _, err := parseCron(c.Start); err != nil {
+		return fmt.Errorf("start: %v", err)
+	}
+	if c.Duration <= 0 || c.Duration > recordScheduleMaxDuration {
+		return fmt.Errorf("duration must be positive and at most %s", recordScheduleMaxDuration)
+	}
+	return nil
+}
+
+// recordWindow is a parsed RecordScheduleConfig.
+type recordWindow struct {
+	start    *cronSchedule
+	duration time.Duration
+}
+
+func newRecordWindow(config RecordScheduleConfig) (recordWindow, error) {
+	if err := config.Validate(); err != nil {
+		return recordWindow{}, err
+	}
+	start, _ := parseCron(config.Start)
+	return recordWindow{start: start, duration: config.Duration}, nil
+}
+
+// opened returns when the window that now falls in opened, or false if now
+// is outside the window.
+func (w recordWindow) opened(now time.Time) (time.Time, bool) {
+	minute := now.Truncate(time.Minute)
+	for t := minute; now.Sub(t) < w.duration; t = t.Add(-time.Minute) {
+		if w.start.matches(t) {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// cronSchedule is a cron expression of five fields: minute, hour, day of the
+// month, month and day of the week, in the server's time zone. Fields take
+// "*", numbers, ranges such as "1-5", steps such as "*/15" and lists of
+// those; Sunday is 0 or 7. As in cron, a time matches either day field when
+// both are restricted.
+type cronSchedule struct {
+	minute  uint64
+	hour    uint64
+	dom     uint64
+	month   uint64
+	dow     uint64
+	anyDay  bool // the day of the month is "*"
+	anyWeek bool // the day of the week is "*"
+}
+
+func parseCron(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%q does not have five fields", expr)
+	}
+
+	s := &cronSchedule{anyDay: fields[2] == "*", anyWeek: fields[4] == "*"}
+	bounds := []struct {
+		bits     *uint64
+		min, max int
+	}{
+		{&s.minute, 0, 59},
+		{&s.hour, 0, 23},
+		{&s.dom, 1, 31},
+		{&s.month, 1, 12},
+		{&s.dow, 0, 7},
+	}
+	for i, field := range fields {
+		bits, err := parseCronField(field, bounds[i].min, bounds[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %v", field, err)
+		}
+		*bounds[i].bits = bits
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	bits := uint64(0)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if rng, value, ok := strings.Cut(part, "/"); ok {
+			var err error
+			if step, err = strconv.Atoi(value); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", value)
+			}
+			part = rng
+		}
+
+		low, high := min, max
+		if part != "*" {
+			first, last, isRange := strings.Cut(part, "-")
+			var err error
+			if low, err = strconv.Atoi(first); err != nil {
+				return 0, fmt.Errorf("invalid value %q", first)
+			}
+			high = low
+			if isRange {
+				if high, err = strconv.Atoi(last); err != nil {
+					return 0, fmt.Errorf("invalid value %q", last)
+				}
+			} else if step != 1 {
+				high = max
+			}
+		}
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("values must be between %d and %d", min, max)
+		}
+		for value := low; value <= high; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+	return bits, nil
+}
+
+func (s *cronSchedule) matches(t time.Time) bool {
+	if s.minute&(1<<uint(t.Minute())) == 0 || s.hour&(1<<uint(t.Hour())) == 0 || s.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.anyDay || s.anyWeek {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Framings of the messages to a viewer, chosen with the framing query
+// parameter. framingSeq puts a sequenceHeaderSize byte header in front of
+// every message: the stream ID as a big endian 32 bit number, then the
+// sequence number as a big endian 64 bit number. Sequence numbers count the
+// stream's broadcasts from 1, so a viewer sees from a jump that it missed
+// messages; 0 marks the start-up data of a new viewer, which is outside the
+// sequence. The stream ID is picked at random when the server first sees the
+// stream, so it changes when the server restarts, and the sequence with it.
+const (
+	framingNone = ""
+	framingSeq  = "seq"
+
+	sequenceHeaderSize = 12
+)
+
+// Sequencer numbers the broadcasts of every stream and keeps the latest of
+// them, up to -resume-buffer-size bytes per stream, so a viewer reconnecting
+// with resume=<stream id>:<sequence number> is sent what it missed.
+type Sequencer struct {
+	limit   int
+	streams map[string]*sequenceStream
+	lock    sync.Mutex
+}
+
+type sequenceStream struct {
+	id     uint32
+	seq    uint64
+	replay []sequencedChunk // oldest first
+	size   int
+}
+
+type sequencedChunk struct {
+	seq  uint64
+	data []byte
+}
+
+// resumePoint is the last message a reconnecting viewer received.
+type resumePoint struct {
+	id  uint32
+	seq uint64
+}
+
+func NewSequencer(params *Params) *Sequencer {
+	return &Sequencer{
+		limit:   params.resumeBufferSize,
+		streams: make(map[string]*sequenceStream),
+	}
+}
+
+func parseResumePoint(value string) (*resumePoint, error) {
+	id, seq, ok := strings.Cut(value, ":")
+	if !ok {
+		return nil, fmt.Errorf("expected <stream id>:<sequence number>")
+	}
+	point := &resumePoint{}
+	parsedID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	point.id = uint32(parsedID)
+	if point.seq, err = strconv.ParseUint(seq, 10, 64); err != nil {
+		return nil, err
+	}
+
+	return point, nil
+}
+
+// stream must be called with the lock held.
+func (s *Sequencer) stream(name string) *sequenceStream {
+	stream, ok := s.streams[name]
+	if !ok {
+		stream = &sequenceStream{id: rand.Uint32()}
+		s.streams[name] = stream
+	}
+	return stream
+}
+
+// ID returns the stream ID of stream.
+func (s *Sequencer) ID(stream string) uint32 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.stream(stream).id
+}
+
+// Next numbers data broadcast on stream and returns the stream ID and the
+// sequence number.
+func (s *Sequencer) Next(stream string, data []byte) (uint32, uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	st := s.stream(stream)
+	st.seq++
+	if s.limit > 0 && len(data) > s.limit {
+		// The chunk cannot be replayed, nor anything before it.
+		st.replay = nil
+		st.size = 0
+	} else if s.limit > 0 {
+		st.replay = append(st.replay, sequencedChunk{seq: st.seq, data: append([]byte{}, data...)})
+		st.size += len(data)
+		for st.size > s.limit {
+			st.size -= len(st.replay[0].data)
+			st.replay[0] = sequencedChunk{}
+			st.replay = st.replay[1:]
+		}
+	}
+
+	return st.id, st.seq
+}
+
+// Since returns the messages of stream after point, framed, or false when
+// point is of another stream ID or no longer in the replay buffer.
+func (s *Sequencer) Since(stream string, point resumePoint) ([][]byte, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	st := s.stream(stream)
+	if point.id != st.id || point.seq > st.seq {
+		return nil, false
+	}
+	if point.seq == st.seq {
+		return nil, true
+	}
+	if len(st.replay) == 0 || st.replay[0].seq > point.seq+1 {
+		return nil, false
+	}
+
+	messages := [][]byte{}
+	for _, chunk := range st.replay {
+		if chunk.seq > point.seq {
+			messages = append(messages, frameMessage(st.id, chunk.seq, chunk.data))
+		}
+	}
+	return messages, true
+}
+
+func frameMessage(id uint32, seq uint64, data []byte) []byte {
+	message := make([]byte, sequenceHeaderSize+len(data))
+	binary.BigEndian.PutUint32(message, id)
+	binary.BigEndian.PutUint64(message[4:], seq)
+	copy(message[sequenceHeaderSize:], data)
+	return message
+}
+
+// resumeClient sends a reconnecting viewer the messages it missed, reporting
+// whether it could. A viewer the queue has no room for misses the rest and
+// sees the gap.
+func (h *WebSocketHandler) resumeClient(client *Client) bool {
+	if client.resume == nil || client.subscription() != (subscription{}) {
+		return false
+	}
+	messages, ok := h.sequences.Since(client.stream, *client.resume)
+	if !ok {
+		return false
+	}
+
+	for i := range messages {
+		if !client.trySend(NewBuffer(messages[i])) {
+			break
+		}
+	}
+	return true
+}
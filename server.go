@@ -0,0 +1,410 @@
+package main
+
+import (
+	"github.com/gorilla/mux"
+
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server bundles the WebSocket hub, the ingest endpoint and the demo page so
+// the relay can be embedded without going through command line flags.
+type Server struct {
+	params     *Params
+	paramsLock sync.RWMutex
+
+	websocketHandler      *WebSocketHandler
+	incomingStreamHandler *IncomingStreamHandler
+	events                *EventBus
+	recorder              *Recorder
+}
+
+type Option func(*Params)
+
+// WithWebSocketAddr sets the listen address of the WebSocket endpoint.
+func WithWebSocketAddr(addr string) Option {
+	return func(p *Params) {
+		p.websocketAddr = addr
+	}
+}
+
+// WithIngestAddr sets the listen address of the incoming stream endpoint.
+func WithIngestAddr(addr string) Option {
+	return func(p *Params) {
+		p.incomingAddr = addr
+	}
+}
+
+// WithDemoAddr sets the listen address of the demo page. An empty address
+// disables the demo page.
+func WithDemoAddr(addr string) Option {
+	return func(p *Params) {
+		p.demoAddr = addr
+	}
+}
+
+// WithSingleAddr serves viewers at /ws, publishers at /ingest/{secret} and
+// the demo page from one listener at addr.
+func WithSingleAddr(addr string) Option {
+	return func(p *Params) {
+		p.singleAddr = addr
+	}
+}
+
+// WithIngestSecret sets the global secret publishers put in the ingest URL.
+func WithIngestSecret(secret string) Option {
+	return func(p *Params) {
+		p.secret = secret
+	}
+}
+
+// WithStreams configures streams, including the ones with their own secret.
+func WithStreams(streams ...StreamConfig) Option {
+	return func(p *Params) {
+		p.streams = streams
+	}
+}
+
+// WithEdges pushes streams to downstream servers, see EdgeConfig.
+func WithEdges(edges ...EdgeConfig) Option {
+	return func(p *Params) {
+		p.edges = edges
+	}
+}
+
+// WithAllowedOrigins restricts the pages that may open a WebSocket to the
+// given origin patterns, e.g. "https://*.example.com".
+func WithAllowedOrigins(patterns ...string) Option {
+	return func(p *Params) {
+		p.allowedOrigins = strings.Join(patterns, ",")
+	}
+}
+
+// WithViewerJWT requires viewers to present a JWT signed with key whose
+// "stream" claim names the stream they connect to.
+func WithViewerJWT(key string) Option {
+	return func(p *Params) {
+		p.jwtKey = key
+	}
+}
+
+// WithIngestKeys accepts publishers signing their requests with one of keys.
+func WithIngestKeys(keys ...IngestKey) Option {
+	return func(p *Params) {
+		p.ingestKeys = keys
+	}
+}
+
+// WithAdmin serves the admin API at addr, protected by token.
+func WithAdmin(addr, token string) Option {
+	return func(p *Params) {
+		p.adminAddr = addr
+		p.adminToken = token
+	}
+}
+
+// WithAdminGRPC serves the admin gRPC API at addr. The token is shared with
+// the REST API.
+func WithAdminGRPC(addr, token string) Option {
+	return func(p *Params) {
+		p.adminGRPCAddr = addr
+		p.adminToken = token
+	}
+}
+
+// WithBufferSizes sets the WebSocket read and write buffer sizes.
+func WithBufferSizes(readBufferSize, writeBufferSize int) Option {
+	return func(p *Params) {
+		p.readBufferSize = readBufferSize
+		p.writeBufferSize = writeBufferSize
+	}
+}
+
+// WithTLSConfig serves every endpoint over HTTPS/WSS using config.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(p *Params) {
+		p.tlsConfig = config
+	}
+}
+
+// WithDrainTimeout bounds how long Run waits for viewers to drain on shutdown.
+func WithDrainTimeout(timeout time.Duration) Option {
+	return func(p *Params) {
+		p.drainTimeout = timeout
+	}
+}
+
+func WithLogger(logger *log.Logger) Option {
+	return func(p *Params) {
+		p.logger = logger
+	}
+}
+
+func NewServer(opts ...Option) *Server {
+	params := DefaultParams()
+	for _, opt := range opts {
+		opt(params)
+	}
+
+	return newServer(params)
+}
+
+func newServer(params *Params) *Server {
+	websocketHandler := NewWebSocketHandler(params)
+	events := NewEventBus(params)
+	websocketHandler.memory = NewMemoryWatchdog(params, websocketHandler, events)
+
+	return &Server{
+		params:                params,
+		websocketHandler:      websocketHandler,
+		incomingStreamHandler: NewIncomingStreamHandler(params, websocketHandler, events),
+		events:                events,
+		recorder:              NewRecorder(params, websocketHandler, events),
+	}
+}
+
+func (s *Server) currentParams() *Params {
+	s.paramsLock.RLock()
+	defer s.paramsLock.RUnlock()
+
+	return s.params
+}
+
+// ApplyParams hands reloaded parameters to the running handlers.
+func (s *Server) ApplyParams(params *Params) {
+	s.websocketHandler.ApplyParams(params)
+	s.incomingStreamHandler.ApplyParams(params)
+	s.events.ApplyParams(params)
+	s.websocketHandler.memory.ApplyParams(params)
+	s.recorder.ApplyParams(params)
+}
+
+// Run starts every endpoint and blocks until ctx is cancelled. Shutdown then
+// stops ingest first, drains the viewers' queued data and closes them with a
+// close frame, giving up after the configured drain timeout. After an
+// upgrade, the viewers and publishers are first given time to leave.
+func (s *Server) Run(ctx context.Context) error {
+	logger := s.params.logger
+	servers := []*http.Server{}
+
+	if s.params.autocertManager != nil {
+		challengeSrv := withTimeouts(&http.Server{
+			Handler:  s.params.autocertManager.HTTPHandler(nil),
+			Addr:     "0.0.0.0:80",
+			ErrorLog: logger,
+		}, s.params)
+		servers = append(servers, challengeSrv)
+
+		go func() {
+			logger.Println("ACME HTTP-01 challenge handler listening at port 80")
+			if err := serve(challengeSrv, s.websocketHandler.handoff); err != nil && err != http.ErrServerClosed {
+				logger.Printf("ACME challenge handler stopped: %v\n", err)
+			}
+		}()
+	}
+
+	for _, udp := range s.incomingStreamHandler.udp {
+		if err := udp.Listen(); err != nil {
+			return err
+		}
+	}
+
+	go s.websocketHandler.Run()
+	go s.incomingStreamHandler.Run()
+	go s.websocketHandler.memory.Run()
+	go s.recorder.Run()
+
+	if addr := s.params.AdminAddr(); addr != "" {
+		r := mux.NewRouter()
+		NewAdminHandler(s).Routes(r.PathPrefix("/api").Subrouter())
+		adminSrv := s.newMainServer(addr, r)
+		adminSrv.RegisterOnShutdown(s.events.Close)
+		servers = append(servers, adminSrv)
+
+		go func() {
+			logger.Println("Admin API listening at " + addr)
+			if err := serve(adminSrv, s.websocketHandler.handoff); err != nil && err != http.ErrServerClosed {
+				logger.Printf("Admin API stopped: %v\n", err)
+			}
+		}()
+	}
+
+	if addr := s.params.AdminGRPCAddr(); addr != "" {
+		listener, err := s.websocketHandler.handoff.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		grpcSrv := NewAdminGRPCServer(s)
+		defer grpcSrv.Stop()
+
+		go func() {
+			logger.Println("Admin gRPC API listening at " + addr)
+			if err := grpcSrv.Serve(listener); err != nil {
+				logger.Printf("Admin gRPC API stopped: %v\n", err)
+			}
+		}()
+	}
+
+	if webTransport := s.websocketHandler.webTransport; webTransport != nil {
+		if s.params.tlsConfig == nil {
+			return fmt.Errorf("WebTransport requires TLS")
+		}
+		go webTransport.Run()
+	}
+
+	if thumbnails := s.websocketHandler.thumbnails; thumbnails != nil {
+		go thumbnails.Run()
+	}
+
+	if s.params.configFile != "" {
+		go s.ReloadOnSignal()
+	}
+	go s.websocketHandler.handoff.UpgradeOnSignal()
+
+	mainErr := make(chan error, 1)
+	var mainSrv *http.Server
+	if addr := s.params.SingleAddr(); addr != "" {
+		mainSrv = trackConns(s.newMainServer(addr, s.singlePortRouter()))
+		mainSrv.TLSConfig = s.params.IngestTLSConfig(true)
+	} else if s.params.demoAddr != "" {
+		r := mux.NewRouter()
+		s.demoRoutes(r)
+		mainSrv = s.newMainServer(s.params.demoAddr, r)
+	}
+
+	if mainSrv != nil {
+		go func() {
+			logger.Println("Listening at " + mainSrv.Addr)
+			mainErr <- serve(mainSrv, s.websocketHandler.handoff)
+		}()
+	}
+
+	select {
+	case <-ctx.Done():
+	case err := <-mainErr:
+		if err != http.ErrServerClosed {
+			return err
+		}
+	}
+
+	if s.websocketHandler.handoff.Upgrading() {
+		s.handOver(s.currentParams().handoffDrainTimeout)
+	}
+	s.Shutdown(mainSrv, servers...)
+
+	return nil
+}
+
+func (s *Server) newMainServer(addr string, handler http.Handler) *http.Server {
+	return withTimeouts(&http.Server{
+		Handler:   handler,
+		Addr:      addr,
+		TLSConfig: s.params.tlsConfig,
+		ErrorLog:  s.params.logger,
+	}, s.params)
+}
+
+// singlePortRouter serves viewers, publishers and the demo page from one
+// listener.
+func (s *Server) singlePortRouter() *mux.Router {
+	r := mux.NewRouter()
+	r.HandleFunc("/ws", s.websocketHandler.ServeWS)
+	r.HandleFunc("/ws/{stream}", s.websocketHandler.ServeWS)
+	r.HandleFunc("/fmp4/{stream}", s.websocketHandler.ServeFMP4)
+	r.HandleFunc("/sse", s.websocketHandler.ServeSSE)
+	r.HandleFunc("/sse/{stream}", s.websocketHandler.ServeSSE)
+	r.HandleFunc("/live/{stream}.ts", s.websocketHandler.ServeLive).Methods("GET", "HEAD")
+	r.HandleFunc("/snapshot/{stream}.jpg", s.websocketHandler.ServeSnapshot).Methods("GET")
+	r.HandleFunc("/thumbnail/{stream}.jpg", s.websocketHandler.ServeThumbnail).Methods("GET", "HEAD")
+	s.websocketHandler.hlsRoutes(r)
+	s.websocketHandler.whepRoutes(r)
+
+	s.incomingStreamHandler.Routes(r.PathPrefix("/ingest").Subrouter())
+
+	if s.params.AdminAddr() == "" && s.params.adminToken != "" {
+		NewAdminHandler(s).Routes(r.PathPrefix("/admin/api").Subrouter())
+	}
+
+	if s.params.demoAddr != "" {
+		s.demoRoutes(r)
+	}
+
+	return r
+}
+
+func (s *Server) demoRoutes(r *mux.Router) {
+	r.HandleFunc("/config.js", s.ServeConfigJS)
+	r.PathPrefix("/static").Handler(http.StripPrefix("/static", http.FileServer(http.Dir("static/"))))
+	r.PathPrefix("/").Handler(http.FileServer(http.Dir("./")))
+}
+
+// ServeConfigJS tells the demo page where to find the WebSocket endpoint.
+func (s *Server) ServeConfigJS(w http.ResponseWriter, r *http.Request) {
+	params := s.currentParams()
+	port, path := "", "/ws"
+	if params.SingleAddr() == "" {
+		_, port, _ = net.SplitHostPort(params.WebSocketAddr())
+		path = "/"
+	}
+
+	w.Header().Set("Content-Type", "application/javascript")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprintf(w, "var streamServerConfig = {websocketPort: %q, websocketPath: %q, ssePath: \"/sse\"};\n", port, path)
+}
+
+// Shutdown stops ingest, drains and closes the viewers, then shuts down the
+// remaining servers, all within the drain timeout. In single-port mode mainSrv
+// is closed first since it carries the publishers.
+func (s *Server) Shutdown(mainSrv *http.Server, servers ...*http.Server) {
+	params := s.currentParams()
+	logger := params.logger
+	logger.Printf("Shutting down, draining for up to %v\n", params.drainTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), params.drainTimeout)
+	defer cancel()
+
+	s.events.Close()
+
+	if mainSrv != nil {
+		if params.SingleAddr() != "" {
+			mainSrv.Close()
+		} else {
+			servers = append(servers, mainSrv)
+		}
+	}
+
+	if err := s.incomingStreamHandler.Shutdown(ctx); err != nil {
+		logger.Printf("IncomingStreamHandler shutdown: %v\n", err)
+	}
+	s.recorder.Close()
+	if err := s.websocketHandler.Shutdown(ctx); err != nil {
+		logger.Printf("WebSocketHandler shutdown: %v\n", err)
+	}
+	for _, srv := range servers {
+		srv.Shutdown(ctx)
+	}
+
+	if ctx.Err() != nil {
+		logger.Println("Drain timeout reached, dropping remaining connections")
+	}
+}
+
+// serve runs srv over HTTPS when it has a TLS configuration and over plain
+// HTTP otherwise, on a listener from handoff.
+func serve(srv *http.Server, handoff *SocketHandoff) error {
+	listener, err := handoff.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+	if srv.TLSConfig != nil {
+		return srv.ServeTLS(listener, "", "")
+	}
+	return srv.Serve(listener)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"sync"
+)
+
+// Claims limiting how many viewers may share one token.
+const (
+	maxSessionsClaim   = "max_sessions"
+	sessionPolicyClaim = "session_policy" // "reject" (default) or "displace"
+)
+
+// SessionLimit is what a viewer token allows: at most MaxSessions concurrent
+// connections, with a new one either refused or displacing the oldest, each
+// sent at most MaxBitrate bits per second.
+type SessionLimit struct {
+	TokenID     string
+	MaxSessions int // 0 for unlimited
+	Displace    bool
+	MaxBitrate  int64 // 0 for the stream's cap
+}
+
+// SessionRegistry tracks the connections made with each limited token.
+type SessionRegistry struct {
+	sessions map[string][]*Client // token ID -> clients, oldest first
+	lock     sync.Mutex
+}
+
+func NewSessionRegistry() *SessionRegistry {
+	return &SessionRegistry{sessions: make(map[string][]*Client)}
+}
+
+// Full reports whether a new connection with limit would be refused.
+func (s *SessionRegistry) Full(limit SessionLimit) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return limit.MaxSessions > 0 && !limit.Displace && len(s.sessions[limit.TokenID]) >= limit.MaxSessions
+}
+
+// Acquire counts client against its token's limit. When the token is at its
+// limit, it either refuses client or returns the sessions client displaces,
+// which the caller has to close.
+func (s *SessionRegistry) Acquire(client *Client, limit SessionLimit) ([]*Client, bool) {
+	if limit.MaxSessions <= 0 {
+		return nil, true
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	clients := s.sessions[limit.TokenID]
+	var displaced []*Client
+	if len(clients) >= limit.MaxSessions {
+		if !limit.Displace {
+			return nil, false
+		}
+		n := len(clients) - limit.MaxSessions + 1
+		displaced = append(displaced, clients[:n]...)
+		clients = clients[n:]
+	}
+	s.sessions[limit.TokenID] = append(clients, client)
+
+	return displaced, true
+}
+
+func (s *SessionRegistry) Release(client *Client, limit SessionLimit) {
+	if limit.MaxSessions <= 0 {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	clients := s.sessions[limit.TokenID]
+	for i, c := range clients {
+		if c == client {
+			clients = append(clients[:i:i], clients[i+1:]...)
+			break
+		}
+	}
+	if len(clients) == 0 {
+		delete(s.sessions, limit.TokenID)
+	} else {
+		s.sessions[limit.TokenID] = clients
+	}
+}
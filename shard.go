@@ -0,0 +1,291 @@
+package main
+
+import (
+	"github.com/gorilla/websocket"
+
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// shardFanoutQueue bounds the fMP4 fragments waiting for a shard.
+const shardFanoutQueue = 64
+
+// hubShard holds the viewers whose client ID falls to it. Its goroutine
+// registers and unregisters them and hands them fMP4 fragments, so that
+// with thousands of viewers no single goroutine does it for all of them.
+type hubShard struct {
+	index   int
+	hub     *WebSocketHandler
+	streams map[string]map[*Client]bool // stream name -> viewers of the shard
+	lock    sync.RWMutex                // held by the shard while it changes streams
+
+	register   chan *Client
+	unregister chan *Client
+	fanout     chan shardFanout
+
+	registered   atomic.Int64
+	unregistered atomic.Int64
+	fragments    atomic.Int64
+	fanoutTime   atomic.Int64 // nanoseconds
+}
+
+// shardFanout is an fMP4 fragment for the shard's viewers of stream.
+type shardFanout struct {
+	stream   string
+	fragment *FMP4Fragment
+}
+
+// ShardStats describes a hub shard for the admin API.
+type ShardStats struct {
+	Shard        int     `json:"shard"`
+	Viewers      int     `json:"viewers"`
+	Registered   int64   `json:"registered"`
+	Unregistered int64   `json:"unregistered"`
+	Fragments    int64   `json:"fragments"`
+	FanoutMillis float64 `json:"fanout_ms"`
+	Queued       int     `json:"queued"`
+}
+
+func newHubShards(params *Params, hub *WebSocketHandler) []*hubShard {
+	count := params.hubShards
+	if count == 0 {
+		count = runtime.NumCPU()
+	}
+
+	shards := make([]*hubShard, count)
+	for i := range shards {
+		shards[i] = &hubShard{
+			index:      i,
+			hub:        hub,
+			streams:    make(map[string]map[*Client]bool),
+			register:   make(chan *Client),
+			unregister: make(chan *Client),
+			fanout:     make(chan shardFanout, shardFanoutQueue),
+		}
+	}
+	return shards
+}
+
+// shard returns the shard of the viewer with the numeric client ID id.
+func (h *WebSocketHandler) shard(id uint64) *hubShard {
+	return h.shards[id%uint64(len(h.shards))]
+}
+
+func (s *hubShard) run() {
+	h := s.hub
+	for {
+		select {
+		case client := <-s.register:
+			total := s.add(client)
+			h.logger.Printf("New client registered on stream %s. Total: %d\n", client.stream, total)
+			h.startClient(client)
+
+		case client := <-s.unregister:
+			if total, ok := s.remove(client); ok {
+				h.logger.Printf("Client unregistered from stream %s. Total: %d\n", client.stream, total)
+			}
+
+		case job := <-s.fanout:
+			started := time.Now()
+			h.deliverFragment(s.clients(job.stream), job.fragment)
+			s.fragments.Add(1)
+			s.fanoutTime.Add(int64(time.Since(started)))
+
+		case <-h.quit:
+			s.lock.RLock()
+			for _, clients := range s.streams {
+				for client := range clients {
+					client.Close()
+				}
+			}
+			s.lock.RUnlock()
+			return
+		}
+	}
+}
+
+// add puts client in the shard and returns the viewers of its stream. The
+// hub's counts change with the lock held, so a kick never counts a viewer
+// out before it was counted in.
+func (s *hubShard) add(client *Client) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	clients, ok := s.streams[client.stream]
+	if !ok {
+		clients = make(map[*Client]bool)
+		s.streams[client.stream] = clients
+	}
+	clients[client] = true
+	s.registered.Add(1)
+	return s.hub.joined(client)
+}
+
+// remove takes client out of the shard, returning the viewers left on its
+// stream and whether it was in the shard.
+func (s *hubShard) remove(client *Client) (int, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	clients, ok := s.streams[client.stream]
+	if !ok || !clients[client] {
+		return 0, false
+	}
+	delete(clients, client)
+	if len(clients) == 0 {
+		delete(s.streams, client.stream)
+	}
+	s.unregistered.Add(1)
+	return s.hub.left(client), true
+}
+
+// kick closes and removes the viewers of the shard that match req, returning
+// how many there were.
+func (s *hubShard) kick(req kickRequest) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	code := req.code
+	if code == 0 {
+		code = websocket.ClosePolicyViolation
+	}
+	kicked := 0
+	for stream, clients := range s.streams {
+		for client := range clients {
+			if !req.all && !req.ids[client.id] && client.id != req.id && hostname(client.remoteAddr) != req.ip {
+				continue
+			}
+			delete(clients, client)
+			client.CloseWith(code, req.reason)
+			s.unregistered.Add(1)
+			s.hub.left(client)
+			kicked++
+		}
+		if len(clients) == 0 {
+			delete(s.streams, stream)
+		}
+	}
+	return kicked
+}
+
+func (s *hubShard) clients(stream string) []*Client {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	clients := make([]*Client, 0, len(s.streams[stream]))
+	for client := range s.streams[stream] {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+func (s *hubShard) viewers() []ViewerInfo {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	viewers := []ViewerInfo{}
+	for _, clients := range s.streams {
+		for client := range clients {
+			viewers = append(viewers, client.Info())
+		}
+	}
+	return viewers
+}
+
+func (s *hubShard) stats() ShardStats {
+	s.lock.RLock()
+	viewers := 0
+	for _, clients := range s.streams {
+		viewers += len(clients)
+	}
+	s.lock.RUnlock()
+
+	return ShardStats{
+		Shard:        s.index,
+		Viewers:      viewers,
+		Registered:   s.registered.Load(),
+		Unregistered: s.unregistered.Load(),
+		Fragments:    s.fragments.Load(),
+		FanoutMillis: float64(s.fanoutTime.Load()) / float64(time.Millisecond),
+		Queued:       len(s.fanout),
+	}
+}
+
+// ShardStats reports every shard of the hub.
+func (h *WebSocketHandler) ShardStats() []ShardStats {
+	stats := make([]ShardStats, len(h.shards))
+	for i, shard := range h.shards {
+		stats[i] = shard.stats()
+	}
+	return stats
+}
+
+// streamViewers counts the viewers of every stream over all shards, and
+// which messages of the stream's ring its MPEG-TS viewers read.
+type streamViewers struct {
+	counts map[string]int
+	views  map[string]map[ringView]int
+	lock   sync.Mutex
+}
+
+// joined counts a registered viewer, returning the viewers of its stream.
+// It is called with the lock of the viewer's shard held, as is left.
+func (h *WebSocketHandler) joined(client *Client) int {
+	h.viewerCounts.lock.Lock()
+	defer h.viewerCounts.lock.Unlock()
+
+	h.viewerCounts.counts[client.stream]++
+	if client.ring != nil {
+		views, ok := h.viewerCounts.views[client.stream]
+		if !ok {
+			views = make(map[ringView]int)
+			h.viewerCounts.views[client.stream] = views
+		}
+		for _, view := range client.ringViews() {
+			views[view]++
+		}
+	}
+	return h.viewerCounts.counts[client.stream]
+}
+
+// left counts a viewer out, clearing the ring of its stream after the last
+// MPEG-TS viewer, and returns the viewers left.
+func (h *WebSocketHandler) left(client *Client) int {
+	h.viewerCounts.lock.Lock()
+	defer h.viewerCounts.lock.Unlock()
+
+	if h.viewerCounts.counts[client.stream]--; h.viewerCounts.counts[client.stream] <= 0 {
+		delete(h.viewerCounts.counts, client.stream)
+	}
+	if views, ok := h.viewerCounts.views[client.stream]; ok && client.ring != nil {
+		for _, view := range client.ringViews() {
+			if views[view]--; views[view] <= 0 {
+				delete(views, view)
+			}
+		}
+		if len(views) == 0 {
+			delete(h.viewerCounts.views, client.stream)
+			h.clearRing(client.stream)
+		}
+	}
+	return h.viewerCounts.counts[client.stream]
+}
+
+// ringViews returns what the MPEG-TS viewers of stream read from its ring,
+// nil when it has none.
+func (h *WebSocketHandler) ringViews(stream string) []ringView {
+	h.viewerCounts.lock.Lock()
+	defer h.viewerCounts.lock.Unlock()
+
+	views := h.viewerCounts.views[stream]
+	if len(views) == 0 {
+		return nil
+	}
+	list := make([]ringView, 0, len(views))
+	for view := range views {
+		list = append(list, view)
+	}
+	return list
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestHub starts a hub serving viewers at the returned server. Viewers
+// use the poll backend, whose handshake a test can make over plain TCP.
+func newTestHub(t *testing.T) (*WebSocketHandler, *httptest.Server) {
+	t.Helper()
+
+	params := DefaultParams()
+	params.wsBackend = wsBackendPoll
+	params.sendQueueSize = 16
+	params.logger = log.New(io.Discard, "", 0)
+	h := NewWebSocketHandler(params)
+	if h.poller == nil {
+		t.Skip("the poll backend is not available here")
+	}
+	h.srv = nil
+	go h.Run()
+
+	server := httptest.NewServer(http.HandlerFunc(h.ServeWS))
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := h.Shutdown(ctx); err != nil {
+			t.Errorf("Shutdown: %v", err)
+		}
+		server.Close()
+	})
+	return h, server
+}
+
+// testViewer is a viewer's end of a WebSocket connection.
+type testViewer struct {
+	conn   *net.TCPConn
+	reader *bufio.Reader
+}
+
+// dialViewer connects a viewer to query, e.g. "stream=a&format=fmp4",
+// returning once the handshake completed.
+func dialViewer(server *httptest.Server, query string) (*testViewer, error) {
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(conn, "GET /?%s HTTP/1.1\r\nHost: %s\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n", query, server.Listener.Addr())
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, fmt.Errorf("handshake: %s", resp.Status)
+	}
+	return &testViewer{conn: conn.(*net.TCPConn), reader: reader}, nil
+}
+
+// drop resets the connection instead of closing it in an orderly way, as a
+// viewer whose network went away would.
+func (v *testViewer) drop() {
+	v.conn.SetLinger(0)
+	v.conn.Close()
+}
+
+// waitFor fails the test unless cond holds within five seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// shardTotals sums the registrations and unregistrations of every shard.
+func shardTotals(h *WebSocketHandler) (registered, unregistered int64) {
+	for _, stats := range h.ShardStats() {
+		registered += stats.Registered
+		unregistered += stats.Unregistered
+	}
+	return registered, unregistered
+}
+
+// TestBroadcastWhileViewersComeAndGo publishes MPEG-TS and fMP4 fragments
+// while viewers connect, leave and are kicked. Run it with -race.
+func TestBroadcastWhileViewersComeAndGo(t *testing.T) {
+	h, server := newTestHub(t)
+	const stream = "race"
+
+	stop := make(chan struct{})
+	var background sync.WaitGroup
+	background.Add(3)
+	go func() {
+		defer background.Done()
+		packet := make([]byte, tsPacketSize)
+		packet[0] = tsSyncByte
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			h.BroadcastData(stream, packet)
+		}
+	}()
+	go func() {
+		defer background.Done()
+		init := NewBuffer([]byte("init"))
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			h.broadcastFragment(stream, &FMP4Fragment{init: init, data: NewBuffer([]byte("fragment")), keyframe: true})
+		}
+	}()
+	go func() {
+		defer background.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+			for _, viewer := range h.Viewers() {
+				if viewer.ID[len(viewer.ID)-1]%3 == 0 {
+					h.KickViewer(viewer.ID)
+				}
+			}
+		}
+	}()
+
+	const viewers, rounds = 8, 10
+	var wg sync.WaitGroup
+	for i := 0; i < viewers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			query := "stream=" + stream
+			if i%2 == 1 {
+				query += "&format=fmp4"
+			}
+			for round := 0; round < rounds; round++ {
+				viewer, err := dialViewer(server, query)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				viewer.conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+				viewer.reader.ReadByte()
+				if round%2 == 0 {
+					viewer.drop()
+				} else {
+					viewer.conn.Close()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(stop)
+	background.Wait()
+	if t.Failed() {
+		return
+	}
+
+	waitFor(t, "every viewer to leave", func() bool {
+		registered, unregistered := shardTotals(h)
+		return registered == viewers*rounds && unregistered == registered && len(h.ViewerCounts()) == 0
+	})
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"github.com/gorilla/websocket"
+
+	"fmt"
+	"sync/atomic"
+)
+
+// Policies for an fMP4 viewer whose send queue is full. drop-oldest makes
+// room by discarding the oldest queued message, drop-newest discards the new
+// one, and disconnect discards it too but closes the connection after
+// -slow-client-max-drops drops in a row. MPEG-TS viewers that fall behind
+// skip ahead in their stream's ring instead, unless disconnect closes them.
+// decimate sends congested viewers of either format only their intra
+// pictures before it comes to that, and drops the newest message otherwise.
+const (
+	slowClientDropOldest = "drop-oldest"
+	slowClientDropNewest = "drop-newest"
+	slowClientDisconnect = "disconnect"
+	slowClientDecimate   = "decimate"
+)
+
+func validSlowClientPolicy(policy string) error {
+	switch policy {
+	case slowClientDropOldest, slowClientDropNewest, slowClientDisconnect, slowClientDecimate:
+		return nil
+	}
+	return fmt.Errorf("unknown slow client policy %q, expected %s, %s, %s or %s", policy, slowClientDropOldest, slowClientDropNewest, slowClientDisconnect, slowClientDecimate)
+}
+
+// slowClientLogAfter is the number of messages a viewer drops in a row before
+// it is logged as falling behind.
+const slowClientLogAfter = 10
+
+// slowClientPolicy is the policy a viewer connected with.
+type slowClientPolicy struct {
+	name     string
+	maxDrops int
+}
+
+// SlowClientCounters count what the slow client policy did, for a viewer or
+// for all viewers of a stream.
+type SlowClientCounters struct {
+	Overflows     int64 `json:"overflows"`
+	DroppedOldest int64 `json:"dropped_oldest"`
+	DroppedNewest int64 `json:"dropped_newest"`
+	Disconnected  int64 `json:"disconnected"`
+	Decimated     int64 `json:"decimated"`
+}
+
+// slowClientStats are the counters of one stream or client.
+type slowClientStats struct {
+	overflows     atomic.Int64
+	droppedOldest atomic.Int64
+	droppedNewest atomic.Int64
+	disconnected  atomic.Int64
+	decimated     atomic.Int64
+}
+
+func (s *slowClientStats) Counters() SlowClientCounters {
+	return SlowClientCounters{
+		Overflows:     s.overflows.Load(),
+		DroppedOldest: s.droppedOldest.Load(),
+		DroppedNewest: s.droppedNewest.Load(),
+		Disconnected:  s.disconnected.Load(),
+		Decimated:     s.decimated.Load(),
+	}
+}
+
+// slowClientSettings are the send queue sizes and policies of the hub's
+// streams. A queue size set for a stream comes first, then the size for the
+// viewer's format, then -send-queue-size.
+type slowClientSettings struct {
+	policy   string
+	streams  map[string]string // stream name -> policy overriding policy
+	maxDrops int
+
+	queueSize     int
+	fmp4QueueSize int            // 0 for queueSize
+	streamQueues  map[string]int // stream name -> queue size
+}
+
+func newSlowClientSettings(params *Params) slowClientSettings {
+	settings := slowClientSettings{
+		policy:        params.slowClientPolicy,
+		streams:       make(map[string]string),
+		maxDrops:      params.slowClientMaxDrops,
+		queueSize:     params.sendQueueSize,
+		fmp4QueueSize: params.fmp4SendQueueSize,
+		streamQueues:  make(map[string]int),
+	}
+	for _, stream := range params.streams {
+		if stream.SlowClientPolicy != "" {
+			settings.streams[stream.Name] = stream.SlowClientPolicy
+		}
+		if stream.SendQueueSize != 0 {
+			settings.streamQueues[stream.Name] = stream.SendQueueSize
+		}
+	}
+
+	return settings
+}
+
+func (s slowClientSettings) queueSizeOf(stream, format string) int {
+	if size, ok := s.streamQueues[stream]; ok {
+		return size
+	}
+	if format == formatFMP4 && s.fmp4QueueSize != 0 {
+		return s.fmp4QueueSize
+	}
+	return s.queueSize
+}
+
+func (s slowClientSettings) of(stream string) slowClientPolicy {
+	policy, ok := s.streams[stream]
+	if !ok {
+		policy = s.policy
+	}
+	return slowClientPolicy{name: policy, maxDrops: s.maxDrops}
+}
+
+// slowClientPolicy returns the policy for a new viewer of stream.
+func (h *WebSocketHandler) slowClientPolicy(stream string) slowClientPolicy {
+	h.settingsLock.RLock()
+	defer h.settingsLock.RUnlock()
+
+	return h.slowClients.of(stream)
+}
+
+// sendQueueSize returns the send queue size for a new viewer of stream
+// receiving format.
+func (h *WebSocketHandler) sendQueueSize(stream, format string) int {
+	h.settingsLock.RLock()
+	defer h.settingsLock.RUnlock()
+
+	return h.slowClients.queueSizeOf(stream, format)
+}
+
+// slowClientStats returns the counters of stream.
+func (h *WebSocketHandler) slowClientStats(stream string) *slowClientStats {
+	h.slowClientStatsLock.Lock()
+	defer h.slowClientStatsLock.Unlock()
+
+	stats, ok := h.slowClientStreams[stream]
+	if !ok {
+		stats = &slowClientStats{}
+		h.slowClientStreams[stream] = stats
+	}
+	return stats
+}
+
+// SlowClientCounters returns the counters of stream, or false when its
+// viewers never fell behind.
+func (h *WebSocketHandler) SlowClientCounters(stream string) (SlowClientCounters, bool) {
+	h.slowClientStatsLock.Lock()
+	stats, ok := h.slowClientStreams[stream]
+	h.slowClientStatsLock.Unlock()
+
+	if !ok {
+		return SlowClientCounters{}, false
+	}
+	counters := stats.Counters()
+	return counters, counters != (SlowClientCounters{})
+}
+
+// deliver queues data for an fMP4 viewer without waiting, following its slow
+// client policy when the queue is full, and reports whether data was queued.
+func (c *Client) deliver(data *Buffer) bool {
+	stream := c.streamStats
+	if c.trySend(data) {
+		c.behind.Store(0)
+		return true
+	}
+	select {
+	case <-c.quit:
+		return false
+	default:
+	}
+
+	c.stats.overflows.Add(1)
+	stream.overflows.Add(1)
+	if behind := c.behind.Add(1); behind == slowClientLogAfter {
+		c.logger.Printf("Client %s is falling behind on stream %s, its queue of %d messages is full\n", c.id, c.stream, cap(c.sendChan))
+	}
+
+	switch c.policy.name {
+	case slowClientDropOldest:
+		select {
+		case oldest := <-c.sendChan:
+			oldest.Release()
+		default:
+		}
+		c.stats.droppedOldest.Add(1)
+		stream.droppedOldest.Add(1)
+		return c.trySend(data)
+
+	case slowClientDisconnect:
+		c.stats.droppedNewest.Add(1)
+		stream.droppedNewest.Add(1)
+		if c.behind.Load() == int64(c.policy.maxDrops) {
+			c.stats.disconnected.Add(1)
+			stream.disconnected.Add(1)
+			c.logger.Printf("Client %s fell behind on stream %s, disconnecting\n", c.id, c.stream)
+			c.CloseWith(websocket.ClosePolicyViolation, "too slow")
+		}
+
+	default:
+		c.stats.droppedNewest.Add(1)
+		stream.droppedNewest.Add(1)
+	}
+	return false
+}
+
+// fellBehind follows the slow client policy for an MPEG-TS viewer that fell
+// off its stream's ring and skipped ahead. Skipped entries count as dropped
+// oldest messages; the disconnect policy closes a viewer that skipped at
+// least -slow-client-max-drops of them.
+func (c *Client) fellBehind(skipped int) {
+	n := int64(skipped)
+	c.stats.overflows.Add(n)
+	c.streamStats.overflows.Add(n)
+	c.stats.droppedOldest.Add(n)
+	c.streamStats.droppedOldest.Add(n)
+
+	if c.policy.name == slowClientDisconnect && skipped >= c.policy.maxDrops {
+		c.stats.disconnected.Add(1)
+		c.streamStats.disconnected.Add(1)
+		c.logger.Printf("Client %s fell behind on stream %s, disconnecting\n", c.id, c.stream)
+		c.CloseWith(websocket.ClosePolicyViolation, "too slow")
+		return
+	}
+	c.logger.Printf("Client %s fell behind on stream %s, skipped %d messages\n", c.id, c.stream, skipped)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// snapshotWait bounds how long a snapshot waits for the stream's next
+// keyframe, and then for ffmpeg to turn it into a JPEG.
+const snapshotWait = 10 * time.Second
+
+// SnapshotCache keeps the latest keyframe of every stream someone has taken
+// a snapshot of, and its JPEG once ffmpeg has encoded it, so repeated
+// snapshots of the same picture cost nothing.
+type SnapshotCache struct {
+	ffmpeg string
+
+	streams map[string]*snapshotStream
+	lock    sync.Mutex
+}
+
+type snapshotStream struct {
+	pending []byte
+	pat     []byte
+	pmt     []byte
+	pmtPID  uint16
+
+	videoPID  uint16
+	videoType byte
+
+	frame      []byte // video packets of the keyframe being collected
+	collecting bool
+	keyframe   []byte        // the last whole keyframe, after the tables
+	seq        uint64        // counts keyframes
+	jpeg       []byte        // of keyframe, once encoded
+	updated    chan struct{} // closed when keyframe changes
+	lock       sync.Mutex
+}
+
+func NewSnapshotCache(params *Params) *SnapshotCache {
+	return &SnapshotCache{
+		ffmpeg:  params.ffmpegPath,
+		streams: make(map[string]*snapshotStream),
+	}
+}
+
+// Write adds data broadcast on stream, unless nobody has taken a snapshot of
+// the stream yet.
+func (c *SnapshotCache) Write(stream string, data []byte) {
+	c.lock.Lock()
+	s := c.streams[stream]
+	c.lock.Unlock()
+
+	if s != nil {
+		s.lock.Lock()
+		tsPackets(&s.pending, data, s.packet)
+		s.lock.Unlock()
+	}
+}
+
+// packet collects the video packets from a keyframe to the start of the next
+// picture.
+func (s *snapshotStream) packet(packet []byte) {
+	pid := packetPID(packet)
+	switch {
+	case pid == 0:
+		if pmtPID, ok := parsePAT(packet); ok {
+			s.pat = append(s.pat[:0], packet...)
+			s.pmtPID = pmtPID
+		}
+		return
+	case pid == s.pmtPID && s.pmtPID != 0:
+		if videoPID, videoType, ok := parsePMT(packet); ok {
+			s.pmt = append(s.pmt[:0], packet...)
+			s.videoPID = videoPID
+			s.videoType = videoType
+		}
+		return
+	case pid != s.videoPID || s.videoPID == 0:
+		return
+	}
+
+	if packetPayloadStart(packet) {
+		if s.collecting {
+			s.keyframe = append(append(append([]byte{}, s.pat...), s.pmt...), s.frame...)
+			s.seq++
+			s.jpeg = nil
+			close(s.updated)
+			s.updated = make(chan struct{})
+		}
+		s.collecting = isKeyframe(packet, s.videoType)
+		s.frame = s.frame[:0]
+	}
+	if s.collecting {
+		s.frame = append(s.frame, packet...)
+	}
+}
+
+// Snapshot returns the latest keyframe of stream as a JPEG, waiting for one
+// when the stream has not been cached before.
+func (c *SnapshotCache) Snapshot(ctx context.Context, stream string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, snapshotWait)
+	defer cancel()
+
+	s, keyframe, seq, err := c.keyframe(ctx, stream)
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	jpeg := s.jpeg
+	if s.seq != seq {
+		jpeg = nil
+	}
+	s.lock.Unlock()
+	if jpeg != nil {
+		return jpeg, nil
+	}
+
+	jpeg, err = c.encode(ctx, keyframe, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	if s.seq == seq {
+		s.jpeg = jpeg
+	}
+	s.lock.Unlock()
+
+	return jpeg, nil
+}
+
+// keyframe returns the latest keyframe of stream and its number, starting to
+// cache the stream and waiting for its next keyframe if need be.
+func (c *SnapshotCache) keyframe(ctx context.Context, stream string) (*snapshotStream, []byte, uint64, error) {
+	c.lock.Lock()
+	s, ok := c.streams[stream]
+	if !ok {
+		s = &snapshotStream{updated: make(chan struct{})}
+		c.streams[stream] = s
+	}
+	c.lock.Unlock()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for s.keyframe == nil {
+		updated := s.updated
+		s.lock.Unlock()
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			s.lock.Lock()
+			return nil, nil, 0, fmt.Errorf("no keyframe received")
+		}
+		s.lock.Lock()
+	}
+
+	return s, s.keyframe, s.seq, nil
+}
+
+// encode has ffmpeg decode the picture of a keyframe as a JPEG, scaled down
+// to width unless it is 0.
+func (c *SnapshotCache) encode(ctx context.Context, keyframe []byte, width int) ([]byte, error) {
+	args := []string{"-hide_banner", "-loglevel", "error", "-f", "mpegts", "-i", "-", "-frames:v", "1"}
+	if width > 0 {
+		args = append(args, "-vf", fmt.Sprintf("scale=%d:-2", width))
+	}
+	args = append(args, "-f", "image2pipe", "-codec:v", "mjpeg", "-q:v", "3", "-")
+
+	cmd := exec.CommandContext(ctx, c.ffmpeg, args...)
+	cmd.Stdin = bytes.NewReader(keyframe)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg: %v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("ffmpeg decoded no picture")
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// ServeSnapshot answers with the latest keyframe of a stream as a JPEG.
+func (h *WebSocketHandler) ServeSnapshot(w http.ResponseWriter, r *http.Request) {
+	stream := streamName(r)
+	if !h.allowHTTPViewer(w, r, stream) {
+		return
+	}
+
+	jpeg, err := h.snapshots.Snapshot(r.Context(), stream)
+	if err != nil {
+		h.logger.Printf("Snapshot of stream %s failed: %v\n", stream, err)
+		http.Error(w, "No snapshot available", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(jpeg)
+}
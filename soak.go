@@ -0,0 +1,215 @@
+package main
+
+import (
+	"github.com/gorilla/websocket"
+
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+)
+
+// soakSettle is how long the soak test waits, once its viewers and publisher
+// are gone, for the server to let go of them before it is checked.
+const soakSettle = 10 * time.Second
+
+// soakSnapshot is what the soak test watches of the server.
+type soakSnapshot struct {
+	viewers    int
+	goroutines int
+	heap       uint64
+	inUse      uint64
+}
+
+func (s soakSnapshot) String() string {
+	return fmt.Sprintf("viewers %d, goroutines %d, heap %.1f MB, in use %.1f MB",
+		s.viewers, s.goroutines, float64(s.heap)/1e6, float64(s.inUse)/1e6)
+}
+
+// soakAdmin reads the server's state from its admin API.
+type soakAdmin struct {
+	base   string
+	token  string
+	client *http.Client
+}
+
+func (a soakAdmin) get(path string, v interface{}) error {
+	req, err := http.NewRequest("GET", a.base+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// snapshot takes the server's figures after a garbage collection.
+func (a soakAdmin) snapshot() (soakSnapshot, error) {
+	var memory MemoryStatus
+	if err := a.get("/memory?gc=1", &memory); err != nil {
+		return soakSnapshot{}, err
+	}
+	viewers := []ViewerInfo{}
+	if err := a.get("/viewers", &viewers); err != nil {
+		return soakSnapshot{}, err
+	}
+	return soakSnapshot{
+		viewers:    len(viewers),
+		goroutines: memory.Goroutines,
+		heap:       memory.HeapAlloc,
+		inUse:      memory.InUse,
+	}, nil
+}
+
+// SoakCommand implements "stream-server soak", which keeps viewers connecting
+// and disconnecting and the publisher coming and going for a long time, then
+// checks that the server is back where it started: the same viewers, about
+// as many goroutines and as much heap. It fails if not, so leaks in the hub
+// lifecycle show up before they do in production.
+func SoakCommand(args []string) error {
+	flags := flag.NewFlagSet("soak", flag.ExitOnError)
+	target := flags.String("url", "ws://localhost:8084/ws/soak", "WebSocket URL the viewers connect to, with any token in its query")
+	publish := flags.String("publish", "", "Ingest URL the publisher comes and goes on, e.g. http://localhost:8082/secret/soak")
+	bitrate := flags.Int64("bitrate", 500000, "Bits per second the publisher sends")
+	admin := flags.String("admin", "http://localhost:8090/api", "Admin API of the server")
+	adminToken := flags.String("admin-token", os.Getenv("JSMPEG_ADMIN_TOKEN"), "Admin API token (env JSMPEG_ADMIN_TOKEN)")
+	viewers := flags.Int("viewers", 50, "Number of viewers connected at once")
+	lifetime := flags.Duration("viewer-lifetime", 30*time.Second, "Longest a viewer stays before reconnecting; each stays a random time up to it")
+	publishOn := flags.Duration("publish-on", time.Minute, "Time the publisher stays")
+	publishOff := flags.Duration("publish-off", 10*time.Second, "Time the publisher stays away")
+	duration := flags.Duration("duration", time.Hour, "Length of the test (0 to run until interrupted)")
+	interval := flags.Duration("interval", time.Minute, "Time between reports")
+	goroutineSlack := flags.Int("goroutine-slack", 10, "Goroutines the server may have gained at the end")
+	heapSlack := flags.Int64("heap-slack", 32<<20, "Bytes of heap the server may have gained at the end")
+	insecure := flags.Bool("insecure", false, "Accept any TLS certificate")
+	flags.Parse(args)
+
+	if *viewers <= 0 || *lifetime <= 0 {
+		return fmt.Errorf("-viewers and -viewer-lifetime must be positive")
+	}
+	if *publish != "" && (*bitrate <= 0 || *publishOn <= 0 || *publishOff <= 0) {
+		return fmt.Errorf("-bitrate, -publish-on and -publish-off must be positive")
+	}
+
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	tlsConfig := &tls.Config{InsecureSkipVerify: *insecure}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	server := soakAdmin{base: strings.TrimSuffix(*admin, "/"), token: *adminToken, client: client}
+
+	start, err := server.snapshot()
+	if err != nil {
+		return fmt.Errorf("reading the admin API: %v", err)
+	}
+	fmt.Println("Start:", start)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	t := &loadTest{errors: make(map[string]bool)}
+	dialer := &websocket.Dialer{HandshakeTimeout: 10 * time.Second, TLSClientConfig: tlsConfig}
+	var wg sync.WaitGroup
+	for i := 0; i < *viewers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				stay, cancel := context.WithTimeout(ctx, time.Duration(rand.Int63n(int64(*lifetime)))+time.Second)
+				t.view(stay, dialer, *target, logger)
+				cancel()
+
+				// Spread the reconnections, and do not hammer a server that
+				// turned the viewer away.
+				select {
+				case <-time.After(time.Duration(rand.Int63n(int64(time.Second)))):
+				case <-ctx.Done():
+				}
+			}
+		}()
+	}
+
+	if *publish != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				on, cancel := context.WithTimeout(ctx, *publishOn)
+				if err := t.publish(on, client, *publish, *bitrate); err != nil && on.Err() == nil {
+					logger.Printf("Publisher stopped: %v\n", err)
+				}
+				<-on.Done()
+				cancel()
+
+				select {
+				case <-time.After(*publishOff):
+				case <-ctx.Done():
+				}
+			}
+		}()
+	}
+
+	started := time.Now()
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for done := false; !done; {
+		select {
+		case <-ticker.C:
+			line := fmt.Sprintf("%6s connections %d (%d failed, %d lost), %d connected here",
+				time.Since(started).Round(time.Second), t.connections.Load(), t.failed.Load(), t.lost.Load(), t.connected.Load())
+			if now, err := server.snapshot(); err != nil {
+				line += fmt.Sprintf(", admin API: %v", err)
+			} else {
+				line += "; server " + now.String()
+			}
+			fmt.Println(line)
+		case <-ctx.Done():
+			done = true
+		}
+	}
+	wg.Wait()
+
+	fmt.Printf("Stopped after %d connections; waiting %s for the server to settle\n", t.connections.Load(), soakSettle)
+	time.Sleep(soakSettle)
+	end, err := server.snapshot()
+	if err != nil {
+		return fmt.Errorf("reading the admin API: %v", err)
+	}
+	fmt.Println("End:  ", end)
+
+	leaks := []string{}
+	if end.viewers != start.viewers {
+		leaks = append(leaks, fmt.Sprintf("%d viewer(s) still registered", end.viewers-start.viewers))
+	}
+	if end.goroutines > start.goroutines+*goroutineSlack {
+		leaks = append(leaks, fmt.Sprintf("%d goroutine(s) more", end.goroutines-start.goroutines))
+	}
+	if end.heap > start.heap+uint64(*heapSlack) {
+		leaks = append(leaks, fmt.Sprintf("%.1f MB more heap", float64(end.heap-start.heap)/1e6))
+	}
+	if len(leaks) > 0 {
+		return fmt.Errorf("leaks found: %s", strings.Join(leaks, ", "))
+	}
+	fmt.Println("No leaks found")
+
+	return nil
+}
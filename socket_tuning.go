@@ -0,0 +1,57 @@
+package main
+
+import (
+	"github.com/gorilla/websocket"
+
+	"crypto/tls"
+	"net"
+)
+
+// socketTuning sets up the TCP sockets of WebSocket viewers. Turning
+// -tcp-nodelay off lets the kernel merge small writes, trading latency for
+// fewer packets; a larger -tcp-send-buffer keeps fast viewers fed through
+// bursts, a smaller one makes a slow viewer fall behind sooner.
+type socketTuning struct {
+	noDelay    bool
+	sendBuffer int // bytes, 0 for the system default
+}
+
+func newSocketTuning(params *Params) socketTuning {
+	return socketTuning{noDelay: params.tcpNoDelay, sendBuffer: params.tcpSendBuffer}
+}
+
+// apply tunes the socket under conn, if it is TCP.
+func (t socketTuning) apply(conn net.Conn) error {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	if err := tcp.SetNoDelay(t.noDelay); err != nil {
+		return err
+	}
+	if t.sendBuffer > 0 {
+		return tcp.SetWriteBuffer(t.sendBuffer)
+	}
+	return nil
+}
+
+// tuneSocket applies the hub's socket tuning to the connection of a viewer.
+func (h *WebSocketHandler) tuneSocket(ws viewerConn) {
+	h.settingsLock.RLock()
+	tuning := h.tuning
+	h.settingsLock.RUnlock()
+
+	var conn net.Conn
+	switch ws := ws.(type) {
+	case *websocket.Conn:
+		conn = ws.UnderlyingConn()
+	case *pollConn:
+		conn = ws.conn
+	}
+	if err := tuning.apply(conn); err != nil {
+		h.logger.Printf("Tuning the socket of viewer %s: %v\n", ws.RemoteAddr(), err)
+	}
+}
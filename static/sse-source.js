@@ -0,0 +1,58 @@
+// SSESource is a JSMpeg source reading the MPEG-TS of a stream from the
+// server's Server-Sent Events endpoint, for networks that block WebSockets.
+// Every event carries one base64 encoded chunk.
+var SSESource = function(url, options) {
+	this.url = url;
+	this.options = options;
+	this.events = null;
+
+	this.destination = null;
+
+	this.established = false;
+	this.completed = false;
+	this.progress = 0;
+};
+
+SSESource.prototype.connect = function(destination) {
+	this.destination = destination;
+};
+
+SSESource.prototype.destroy = function() {
+	if (this.events) {
+		this.events.close();
+		this.events = null;
+	}
+};
+
+SSESource.prototype.start = function() {
+	this.events = new EventSource(this.url);
+	this.events.onopen = this.onOpen.bind(this);
+	this.events.onmessage = this.onMessage.bind(this);
+};
+
+SSESource.prototype.resume = function(secondsHeadroom) {
+	// Nothing to do here
+};
+
+SSESource.prototype.onOpen = function() {
+	this.progress = 1;
+};
+
+SSESource.prototype.onMessage = function(ev) {
+	var text = atob(ev.data);
+	var bytes = new Uint8Array(text.length);
+	for (var i = 0; i < text.length; i++) {
+		bytes[i] = text.charCodeAt(i);
+	}
+
+	var isFirstChunk = !this.established;
+	this.established = true;
+
+	if (isFirstChunk && this.options.onSourceEstablished) {
+		this.options.onSourceEstablished(this);
+	}
+
+	if (this.destination) {
+		this.destination.write(bytes.buffer);
+	}
+};
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RateMeter counts bytes and reports the bitrate of the last full second.
+type RateMeter struct {
+	total       int64
+	windowStart time.Time
+	windowBytes int64
+	bitrate     float64
+	lock        sync.Mutex
+}
+
+func NewRateMeter() *RateMeter {
+	return &RateMeter{windowStart: time.Now()}
+}
+
+func (m *RateMeter) Add(n int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.roll(time.Now())
+	m.total += int64(n)
+	m.windowBytes += int64(n)
+}
+
+// Bitrate returns bits per second; it drops to 0 once no data has arrived for
+// a full window.
+func (m *RateMeter) Bitrate() float64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.roll(time.Now())
+	return m.bitrate
+}
+
+func (m *RateMeter) Total() int64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.total
+}
+
+func (m *RateMeter) roll(now time.Time) {
+	elapsed := now.Sub(m.windowStart)
+	if elapsed < time.Second {
+		return
+	}
+
+	m.bitrate = float64(m.windowBytes*8) / elapsed.Seconds()
+	if elapsed >= 2*time.Second {
+		// The previous window ended long ago, nothing arrived since.
+		m.bitrate = 0
+	}
+	m.windowStart = now
+	m.windowBytes = 0
+}
+
+type connContextKey struct{}
+
+// trackConns stores each connection in its requests' context so handlers can
+// close a connection from outside the request, e.g. to kick a publisher.
+func trackConns(srv *http.Server) *http.Server {
+	srv.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+		return context.WithValue(ctx, connContextKey{}, c)
+	}
+	return srv
+}
+
+func requestConn(r *http.Request) net.Conn {
+	conn, _ := r.Context().Value(connContextKey{}).(net.Conn)
+	return conn
+}
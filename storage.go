@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// storageQueue is how many recording files may wait for their upload.
+const storageQueue = 1024
+
+// storageUploadTimeout bounds the upload of one file.
+const storageUploadTimeout = 10 * time.Minute
+
+// storageRetry is how uploads are retried before a file is given up on; it
+// then stays on local disk.
+var storageRetry = RetryConfig{MinDelay: 5 * time.Second, MaxDelay: 5 * time.Minute, MaxAttempts: 10}
+
+// RecordingStorage keeps the complete files of recordings: segments and
+// MP4s.
+type RecordingStorage interface {
+	// Store keeps the file at path under key, a slash-separated path below
+	// the record directory, returning where it went.
+	Store(ctx context.Context, path, key string) (string, error)
+}
+
+// NewRecordingStorage returns the storage -record-storage names, or nil to
+// leave the files where they are recorded for "" and "local".
+// "file:///path" copies them to another directory, such as a network share,
+// and "s3://bucket/prefix" uploads them to an S3-compatible object store. An
+// S3 URL may give the endpoint of a store other than AWS and the region, as
+// in s3://recordings?endpoint=https://minio:9000&region=eu-west-1; the
+// credentials are those of the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN environment variables.
+func NewRecordingStorage(location string) (RecordingStorage, error) {
+	if location == "" || location == "local" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case u.Scheme == "file" && u.Path != "":
+		return &LocalStorage{dir: filepath.FromSlash(u.Path)}, nil
+	case u.Scheme != "s3" || u.Host == "":
+		return nil, fmt.Errorf("%q is none of local, file:///path and s3://bucket/prefix", location)
+	}
+
+	s := &S3Storage{
+		bucket:       u.Host,
+		prefix:       strings.Trim(u.Path, "/"),
+		region:       u.Query().Get("region"),
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		client:       &http.Client{},
+	}
+	if s.region == "" {
+		s.region = "us-east-1"
+	}
+	if endpoint := u.Query().Get("endpoint"); endpoint != "" {
+		if s.endpoint, err = url.Parse(endpoint); err != nil || s.endpoint.Host == "" {
+			return nil, fmt.Errorf("invalid endpoint %q", endpoint)
+		}
+		s.pathStyle = true
+	} else {
+		s.endpoint = &url.URL{Scheme: "https", Host: fmt.Sprintf("%s.s3.%s.amazonaws.com", s.bucket, s.region)}
+	}
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set")
+	}
+
+	return s, nil
+}
+
+// LocalStorage copies recording files to a directory on local disk.
+type LocalStorage struct {
+	dir string
+}
+
+func (s *LocalStorage) Store(ctx context.Context, file, key string) (string, error) {
+	target := filepath.Join(s.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return "", err
+	}
+
+	src, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	part := target + recordPartSuffix
+	dst, err := os.Create(part)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(part, target)
+	}
+	if err != nil {
+		os.Remove(part)
+		return "", err
+	}
+
+	return target, nil
+}
+
+// S3Storage uploads recording files to a bucket of an S3-compatible object
+// store with signature version 4. Stores outside AWS, such as MinIO or
+// Google Cloud Storage with HMAC keys, are addressed by path.
+type S3Storage struct {
+	endpoint     *url.URL
+	pathStyle    bool
+	bucket       string
+	prefix       string
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+
+	client *http.Client
+}
+
+func (s *S3Storage) Store(ctx context.Context, file, key string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	key = path.Join(s.prefix, key)
+	target := *s.endpoint
+	target.Path = "/" + key
+	if s.pathStyle {
+		target.Path = path.Join("/", s.endpoint.Path, s.bucket, key)
+	}
+	target.RawPath = s3EscapePath(target.Path)
+
+	req, err := http.NewRequestWithContext(ctx, "PUT", target.String(), f)
+	if err != nil {
+		return "", err
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", recordingContentType(file))
+	s.sign(req, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("PUT %s: %s", key, resp.Status)
+	}
+
+	return fmt.Sprintf("s3://%s/%s", s.bucket, key), nil
+}
+
+// s3EscapePath escapes every byte of p but the unreserved characters and
+// slashes, as signature version 4 expects.
+func s3EscapePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-._~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func recordingContentType(file string) string {
+	if strings.HasSuffix(file, ".mp4") {
+		return "video/mp4"
+	}
+	return "video/mp2t"
+}
+
+// sign adds the signature version 4 headers to req, leaving the payload
+// unsigned so that files are streamed rather than hashed first.
+func (s *S3Storage) sign(req *http.Request, now time.Time) {
+	const payload = "UNSIGNED-PAYLOAD"
+	stamp := now.UTC().Format("20060102T150405Z")
+	date := stamp[:8]
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	names := []string{}
+	for name := range req.Header {
+		names = append(names, strings.ToLower(name))
+	}
+	sort.Strings(names)
+	headers := ""
+	for _, name := range names {
+		headers += name + ":" + strings.TrimSpace(req.Header.Get(name)) + "\n"
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{req.Method, req.URL.EscapedPath(), req.URL.RawQuery, headers, signed, payload}, "\n")
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := []byte("AWS4" + s.secretKey)
+	for _, part := range []string{date, s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.accessKey, scope, signed, signature))
+	req.Header.Del("Host")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// storageUpload is a complete recording file to store.
+type storageUpload struct {
+	stream string
+	path   string
+	key    string
+}
+
+// Uploader hands the complete files of recordings to the RecordingStorage in
+// the background, retrying failed uploads, and emits a recording_uploaded or
+// recording_upload_failed event for each. With -record-storage-delete the
+// local copy is deleted once stored.
+type Uploader struct {
+	storage RecordingStorage
+	remove  bool
+	lock    sync.Mutex
+
+	jobs   chan storageUpload
+	ctx    context.Context
+	cancel context.CancelFunc
+	events *EventBus
+	logger *log.Logger
+}
+
+func NewUploader(params *Params, events *EventBus) *Uploader {
+	ctx, cancel := context.WithCancel(context.Background())
+	u := &Uploader{
+		jobs:   make(chan storageUpload, storageQueue),
+		ctx:    ctx,
+		cancel: cancel,
+		events: events,
+		logger: params.logger,
+	}
+	u.ApplyParams(params)
+
+	return u
+}
+
+func (u *Uploader) ApplyParams(params *Params) {
+	// Validated with the parameters.
+	storage, _ := NewRecordingStorage(params.recordStorage)
+
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	u.storage = storage
+	u.remove = params.recordStorageDelete
+}
+
+// Enabled reports whether files are stored anywhere but where they are
+// recorded.
+func (u *Uploader) Enabled() bool {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	return u.storage != nil
+}
+
+// Add queues the file at path, recorded under root, for upload.
+func (u *Uploader) Add(stream, root, file string) {
+	if !u.Enabled() {
+		return
+	}
+	key, err := filepath.Rel(root, file)
+	if err != nil {
+		key = filepath.Base(file)
+	}
+
+	select {
+	case u.jobs <- storageUpload{stream: stream, path: file, key: filepath.ToSlash(key)}:
+	default:
+		u.logger.Printf("Too many recording files waiting for their upload, keeping %s on local disk\n", file)
+	}
+}
+
+// Run uploads the queued files until Close.
+func (u *Uploader) Run() {
+	for {
+		select {
+		case upload := <-u.jobs:
+			u.upload(upload)
+		case <-u.ctx.Done():
+			if len(u.jobs) > 0 {
+				u.logger.Printf("Shutting down with %d recording file(s) waiting for their upload\n", len(u.jobs))
+			}
+			return
+		}
+	}
+}
+
+// Close stops the uploads, leaving the files waiting on local disk.
+func (u *Uploader) Close() {
+	u.cancel()
+}
+
+func (u *Uploader) upload(upload storageUpload) {
+	backoff := NewBackoff(storageRetry)
+	for {
+		u.lock.Lock()
+		storage, remove := u.storage, u.remove
+		u.lock.Unlock()
+		if storage == nil {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(u.ctx, storageUploadTimeout)
+		location, err := storage.Store(ctx, upload.path, upload.key)
+		cancel()
+		if err == nil {
+			if remove {
+				os.Remove(upload.path)
+			}
+			u.events.Publish(Event{
+				Type:   EventRecordingUploaded,
+				Stream: upload.stream,
+				Data:   map[string]string{"file": upload.path, "location": location},
+			})
+			return
+		}
+		if u.ctx.Err() != nil {
+			return
+		}
+
+		delay, ok := backoff.Next()
+		if !ok {
+			u.logger.Printf("Giving up uploading %s: %v\n", upload.path, err)
+			u.events.Publish(Event{
+				Type:   EventRecordingUploadFailed,
+				Stream: upload.stream,
+				Data:   map[string]string{"file": upload.path, "error": err.Error()},
+			})
+			return
+		}
+		u.logger.Printf("Uploading %s failed, retrying in %s: %v\n", upload.path, delay, err)
+
+		select {
+		case <-time.After(delay):
+		case <-u.ctx.Done():
+			return
+		}
+	}
+}
@@ -3,268 +3,2287 @@ package main
 import (
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/acme/autocert"
 
+	"compress/flate"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
+const defaultStreamName = "default"
+
+// maxPublishMessageSize bounds one WebSocket message from a publisher.
+const maxPublishMessageSize = 1 << 20
+
 type Client struct {
-	ws       *websocket.Conn
-	sendChan chan *[]byte
+	id         string
+	ws         viewerConn
+	stream     string
+	remoteAddr string
+	connected  time.Time
+	sendChan   chan *Buffer  // every queued Buffer is retained for the client
+	ring       *StreamRing  // what is broadcast to MPEG-TS viewers, nil for fMP4
+	cursor     ringCursor
+	streamStats *slowClientStats
+	format     string   // formatTS or formatFMP4
+	tracks     string   // tracksAll, tracksAudio or tracksVideo
+	frames     string   // framesAll or framesIntra
+	framing    string   // framingNone or framingSeq
+	resume     *resumePoint
+	policy     slowClientPolicy
+	stats      slowClientStats
+	behind     atomic.Int64  // messages that found the queue full in a row
+	init       *Buffer  // fMP4 init segment last sent
+	writeTimeout time.Duration  // 0 for none
+	closeTimeout time.Duration  // how long to wait for the viewer's close frame
+	pingInterval time.Duration  // 0 for no pings
+	pongTimeout time.Duration  // 0 to never evict a silent viewer, unused without pings
+	flushInterval time.Duration  // 0 to write every chunk on its own
+	coalesceSize int
+	batch []byte  // reused for coalesced messages
+	written bool  // a message has been written
+	decimating bool  // congested under the decimate policy
+	egress *StreamEgress  // what the stream's viewers are sent
+	throttle bool  // decimated while the egress cap is exceeded
+	bucket *TokenBucket  // nil for no throughput cap
+	chaos chaosSettings
+	held []byte  // a message chaos sends after the next one
+
+	closeCode   int
+	closeReason string
+	quit chan struct{}  // closed by CloseWith
+	closeOnce sync.Once
+	readDone chan struct{}  // closed once ReadHandler returns
+
+	shard *hubShard  // the hub shard the client is registered with
+	hubDone chan struct{}
+	writers *sync.WaitGroup
+	onClose func()  // called by finish once both handlers have returned
+	logger *log.Logger
+}
 
-	unregisterChan chan *Client
+// ViewerInfo describes a connected viewer for the admin API.
+type ViewerInfo struct {
+	ID         string    `json:"id"`
+	Stream     string    `json:"stream"`
+	RemoteAddr string    `json:"remote_addr"`
+	Since      time.Time `json:"since"`
+	QueueDepth int       `json:"queue_depth"`
+	QueueSize  int       `json:"queue_size"`
+	MaxBitrate int64     `json:"max_bitrate,omitempty"`
+	SlowClientCounters
 }
 
-func NewClient(ws *websocket.Conn, unregisterChan chan *Client) *Client {
+func NewClient(ws viewerConn, stream string, queueSize int, hub *WebSocketHandler) *Client {
+	id := atomic.AddUint64(&hub.lastClientID, 1)
 	client := &Client{
+		id: strconv.FormatUint(id, 10),
 		ws: ws,
-		sendChan: make(chan *[]byte, 512),
-		unregisterChan: unregisterChan,
+		stream: stream,
+		remoteAddr: ws.RemoteAddr().String(),
+		connected: time.Now(),
+		sendChan: make(chan *Buffer, queueSize),
+		quit: make(chan struct{}),
+		readDone: make(chan struct{}),
+		format: formatTS,
+		shard: hub.shard(id),
+		hubDone: hub.done,
+		writers: &hub.writers,
+		egress: hub.egress.Stream(stream),
+		logger: hub.logger,
 	}
 
 	return client
 }
 
+// unregister tells the hub the client is gone, unless the hub has already
+// stopped. Only finish calls it.
+func (c *Client) unregister() {
+	select {
+	case c.shard.unregister <- c:
+	case <-c.hubDone:
+	}
+}
+
 func (c *Client) Close() {
-	log.Println("Closing client's send channel")
-	close(c.sendChan)
+	c.CloseWith(websocket.CloseGoingAway, "server shutting down")
 }
 
-func (c *Client) ReadHandler() {
-	defer func() {
-		c.unregisterChan <- c
-	}()
+// CloseWith closes the connection with the given close frame once the data
+// already queued has been written. Only the first call counts.
+func (c *Client) CloseWith(code int, reason string) {
+	c.closeOnce.Do(func() {
+		c.logger.Printf("Closing client %s: %s\n", c.id, reason)
+		c.closeCode = code
+		c.closeReason = reason
+		close(c.quit)
+	})
+}
+
+// send queues data for the client, waiting for room unless the client is
+// closed.
+func (c *Client) send(data *Buffer) {
+	data.Retain()
+	select {
+	case c.sendChan <- data:
+	case <-c.quit:
+		data.Release()
+	}
+}
+
+// trySend queues data for the client unless its queue is full or the client
+// is closed, reporting whether it did.
+func (c *Client) trySend(data *Buffer) bool {
+	select {
+	case <-c.quit:
+		return false
+	default:
+	}
+
+	data.Retain()
+	select {
+	case c.sendChan <- data:
+		return true
+	default:
+	}
+	data.Release()
+	return false
+}
+
+func (c *Client) Info() ViewerInfo {
+	return ViewerInfo{
+		ID: c.id,
+		Stream: c.stream,
+		RemoteAddr: c.remoteAddr,
+		Since: c.connected,
+		QueueDepth: c.queueDepth(),
+		QueueSize: c.queueSize(),
+		MaxBitrate: c.maxBitrate(),
+		SlowClientCounters: c.stats.Counters(),
+	}
+}
+
+// ReadHandler reads until the viewer goes away or the connection is closed.
+// The connection belongs to WriteHandler, which stops once ReadHandler has
+// returned and cleans up after both. Under the poll backend the poller reads
+// instead.
+func (c *Client) ReadHandler(ws *websocket.Conn) {
+	defer close(c.readDone)
+
+	// Every pong or message from the viewer moves the read deadline, so a
+	// viewer that stopped answering pings times out.
+	c.extendReadDeadline()
+	ws.SetPongHandler(func(string) error {
+		c.extendReadDeadline()
+		return nil
+	})
 
 	for {
-		msgType, msg, err := c.ws.ReadMessage()
+		msgType, msg, err := ws.ReadMessage()
 		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				c.logger.Printf("Client %s stopped answering pings, dropping it\n", c.id)
+			}
 			break
 		}
+		c.extendReadDeadline()
 
 		if msgType == websocket.CloseMessage {
 			break
 		}
 
-		log.Println("Received from client: " + string(msg))
+		c.logger.Println("Received from client: " + string(msg))
 	}
 }
 
 func (c *Client) WriteHandler() {
-	defer func() {
-		c.unregisterChan <- c
-	}()
+	defer c.writers.Done()
+	defer c.finish()
+
+	var ping <-chan time.Time
+	if c.pingInterval > 0 {
+		ticker := time.NewTicker(c.pingInterval)
+		defer ticker.Stop()
+		ping = ticker.C
+	}
 
 	for {
+		var err error
+		data, updated := c.next()
+		if data == nil {
+			select {
+			case data = <- c.sendChan:
+			case <-updated:
+			case <-ping:
+				err = c.ping()
+			case <-c.quit:
+			case <-c.readDone:
+				// The viewer closed the connection or dropped it.
+				return
+			}
+		} else {
+			// Keep pinging while there is always data to write.
+			select {
+			case <-ping:
+				err = c.ping()
+			default:
+			}
+		}
+
+		if data != nil && err == nil {
+			if c.coalesces() {
+				err = c.writeCoalesced(data)
+			} else {
+				err = c.write(data)
+			}
+			c.written = true
+		}
+
 		select {
-		case data, ok := <- c.sendChan:
-			if !ok {
-				log.Println("Client send failed")
-				c.ws.WriteMessage(websocket.CloseMessage, []byte{})
+		case <-c.quit:
+			if err == nil {
+				c.drain()
+				c.closeHandshake()
 				return
 			}
+		case <-c.readDone:
+			return
+		default:
+		}
+
+		if err != nil {
+			c.logger.Printf("Writing to client %s failed: %v\n", c.id, err)
+			return
+		}
+	}
+}
+
+// finish is the one way out for a client, run once WriteHandler is done. It
+// closes the connection, which ends ReadHandler too, waits for ReadHandler to
+// return, takes the client off the hub and releases what is still queued.
+// Closing the client first keeps the hub from queueing more; a Buffer queued
+// in the meantime anyway is left to the GC.
+func (c *Client) finish() {
+	c.CloseWith(websocket.CloseNormalClosure, "")
+	c.ws.Close()
+	<-c.readDone
+	c.unregister()
+
+	for {
+		select {
+		case data := <-c.sendChan:
+			data.Release()
+		default:
+			if c.onClose != nil {
+				c.onClose()
+			}
+			return
+		}
+	}
+}
+
+func (c *Client) extendReadDeadline() {
+	if c.pingInterval > 0 && c.pongTimeout > 0 {
+		c.ws.SetReadDeadline(time.Now().Add(c.pongTimeout))
+	}
+}
+
+// next returns the client's next message without waiting: queued messages
+// first, then what its stream's ring holds. With none, it returns a channel
+// closed once the ring may have more.
+func (c *Client) next() (*Buffer, <-chan struct{}) {
+	select {
+	case data := <-c.sendChan:
+		return data, nil
+	default:
+	}
+
+	if c.ring == nil {
+		return nil, nil
+	}
+	if c.cursor.throttle {
+		c.cursor.throttled = c.egress.Over()
+	}
+	data, skipped, updated := c.ring.read(&c.cursor)
+	if skipped > 0 {
+		c.fellBehind(skipped)
+	}
+	if c.cursor.decimate || c.cursor.throttle {
+		c.noteDecimating(c.cursor.decimating)
+		c.stats.decimated.Add(int64(c.cursor.decimated))
+		c.streamStats.decimated.Add(int64(c.cursor.decimated))
+		c.cursor.decimated = 0
+	}
+	return data, updated
+}
 
-			c.ws.WriteMessage(websocket.BinaryMessage, *data)
+// drain writes what was queued for the client, and what its ring held,
+// before Close.
+func (c *Client) drain() {
+	for pending := c.queueDepth(); pending > 0; pending-- {
+		data, _ := c.next()
+		if data == nil || c.write(data) != nil {
+			return
 		}
 	}
 }
 
+func (c *Client) queueDepth() int {
+	depth := len(c.sendChan)
+	if c.ring != nil {
+		depth += c.ring.depth(&c.cursor)
+	}
+	return depth
+}
+
+func (c *Client) queueSize() int {
+	if c.ring != nil {
+		return c.ring.size()
+	}
+	return cap(c.sendChan)
+}
+
+func (c *Client) ping() error {
+	return c.ws.WriteControl(websocket.PingMessage, nil, c.writeDeadline())
+}
+
+// write sends data as one message, giving up once the write timeout passes,
+// and releases it.
+func (c *Client) write(data *Buffer) error {
+	defer data.Release()
+
+	c.limitRate(len(data.Bytes()))
+	c.ws.SetWriteDeadline(c.writeDeadline())
+	c.egress.Add(len(data.Bytes()))
+	return c.writeMessage(data.Bytes(), data.stamp)
+}
+
+func (c *Client) writeDeadline() time.Time {
+	if c.writeTimeout == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(c.writeTimeout)
+}
+
+// closeHandshake sends the close frame and gives the viewer up to the close
+// timeout to answer with its own, which ends ReadHandler, before closing the
+// connection. When the viewer closed first, its close frame has already been
+// answered and the connection is closed at once.
+func (c *Client) closeHandshake() {
+	defer c.ws.Close()
+
+	message := websocket.FormatCloseMessage(c.closeCode, c.closeReason)
+	if err := c.ws.WriteControl(websocket.CloseMessage, message, c.writeDeadline()); err != nil {
+		return
+	}
+
+	timer := time.NewTimer(c.closeTimeout)
+	defer timer.Stop()
+	select {
+	case <-c.readDone:
+	case <-timer.C:
+	}
+}
+
 func (c *Client) Run() {
-	go c.ReadHandler()
+	switch ws := c.ws.(type) {
+	case *pollConn:
+		ws.watch(c)
+	case *websocket.Conn:
+		go c.ReadHandler(ws)
+	}
 	go c.WriteHandler()
 }
 
 type WebSocketHandler struct {
-	clients map[*Client]bool  // *client -> is connected (true/false)
-	register chan *Client
-	unregister chan *Client
-	broadcast chan *[]byte
+	shards []*hubShard  // the viewers, split by client ID
+	viewerCounts streamViewers
+	lastClientID uint64
+	bans *BanList
+	sessions *SessionRegistry
+	quit chan struct{}  // closed by Shutdown
+	done chan struct{}  // closed once every hub shard has returned
+	writers sync.WaitGroup
 
 	upgrader *websocket.Upgrader
+	writeBuffers *sync.Pool  // write buffers of the viewers' connections with -ws-write-buffer-pool
+	tuning socketTuning
+	poller *Poller  // nil unless viewers use the poll backend
+	auth *ViewerAuthenticator
+	access *AccessControl
+	sizes map[string]videoSize  // stream name -> size announced in the jsmpeg header
+	defaultSize videoSize
+	compression compressionSettings
+	slowClients slowClientSettings
+	coalesce coalesceSettings
+	chaosSettings chaosSettings
+	writeTimeout time.Duration
+	closeTimeout time.Duration
+	pingInterval time.Duration
+	pongTimeout time.Duration
+	maxBitrate int64
+	streamMaxBitrates map[string]int64  // stream name -> viewer cap overriding maxBitrate
+	settingsLock sync.RWMutex
+	limiter *ConnectionLimiter
+	egress *EgressLimiter
+	admission *ViewerAdmission
+	drain *Drain
+	memory *MemoryWatchdog  // set by the Server
+	handoff *SocketHandoff
+	buffers *BufferPool
+	rings map[string]*StreamRing
+	ringsLock sync.Mutex
+
+	slowClientStreams map[string]*slowClientStats
+	slowClientStatsLock sync.Mutex
+
+	forwards map[string]map[*PublishSession]string  // stream -> session -> stream it is also broadcast to
+	forwardsLock sync.RWMutex
 
-	portNum int
+	hls *HLSPackager
+	fmp4 *FMP4Packager
+	whep *WHEPServer
+	webTransport *WebTransportServer
+	snapshots *SnapshotCache
+	tracks *TrackFilters
+	thumbnails *ThumbnailService
+	gops *GOPCache
+	tables *TableCache
+	media *MediaProbe
+	sequences *Sequencer
+	taps taps
+
+	srv *http.Server
+	logger *log.Logger
 }
 
 func NewWebSocketHandler(params *Params) *WebSocketHandler {
 	clientManager := &WebSocketHandler{
-		clients: make(map[*Client]bool),
-		register: make(chan *Client),
-		unregister: make(chan *Client),
-		broadcast: make(chan *[]byte),
-		portNum: params.websocketPort,
-		upgrader: &websocket.Upgrader{
-			ReadBufferSize: params.readBufferSize,
-			WriteBufferSize: params.writeBufferSize,
-			CheckOrigin: func(r *http.Request) bool {
-				return true
-			},
+		viewerCounts: streamViewers{
+			counts: make(map[string]int),
+			views: make(map[string]map[ringView]int),
 		},
+		bans: NewBanList(),
+		sessions: NewSessionRegistry(),
+		quit: make(chan struct{}),
+		done: make(chan struct{}),
+		limiter: NewConnectionLimiter(params),
+		egress: NewEgressLimiter(params),
+		admission: NewViewerAdmission(params),
+		drain: NewDrain(),
+		writeBuffers: &sync.Pool{},
+		handoff: NewSocketHandoff(params.logger),
+		buffers: NewBufferPool(),
+		rings: make(map[string]*StreamRing),
+		forwards: make(map[string]map[*PublishSession]string),
+		slowClientStreams: make(map[string]*slowClientStats),
+		hls: NewHLSPackager(params),
+		snapshots: NewSnapshotCache(params),
+		tracks: NewTrackFilters(),
+		tables: NewTableCache(),
+		media: NewMediaProbe(),
+		sequences: NewSequencer(params),
+		logger: params.logger,
+	}
+	clientManager.shards = newHubShards(params, clientManager)
+	clientManager.fmp4 = NewFMP4Packager(clientManager)
+	clientManager.whep = NewWHEPServer(params, clientManager)
+	clientManager.webTransport = NewWebTransportServer(params, clientManager)
+	clientManager.thumbnails = NewThumbnailService(params, clientManager.snapshots)
+	clientManager.gops = NewGOPCache(params)
+	if params.wsBackend == wsBackendPoll {
+		poller, err := NewPoller(params.logger)
+		if err != nil {
+			params.logger.Printf("Using the gorilla WebSocket backend: %v\n", err)
+		} else {
+			clientManager.poller = poller
+		}
+	}
+	clientManager.ApplyParams(params)
+
+	// In single-port mode the Server routes viewers to ServeWS itself.
+	if params.SingleAddr() == "" {
+		r := mux.NewRouter()
+		r.HandleFunc("/", clientManager.ServeWS)
+		r.HandleFunc("/ws/{stream}", clientManager.ServeWS)
+		r.HandleFunc("/fmp4/{stream}", clientManager.ServeFMP4)
+		r.HandleFunc("/sse", clientManager.ServeSSE)
+		r.HandleFunc("/sse/{stream}", clientManager.ServeSSE)
+		r.HandleFunc("/live/{stream}.ts", clientManager.ServeLive).Methods("GET", "HEAD")
+		r.HandleFunc("/snapshot/{stream}.jpg", clientManager.ServeSnapshot).Methods("GET")
+		r.HandleFunc("/thumbnail/{stream}.jpg", clientManager.ServeThumbnail).Methods("GET", "HEAD")
+		clientManager.hlsRoutes(r)
+		clientManager.whepRoutes(r)
+
+		clientManager.srv = withTimeouts(&http.Server{
+			Handler: r,
+			Addr: params.WebSocketAddr(),
+			TLSConfig: params.tlsConfig,
+			ErrorLog: params.logger,
+		}, params)
 	}
 
 	return clientManager
 }
 
-func (h *WebSocketHandler) BroadcastData(data *[]byte) {
-	for client := range h.clients {
-		select {
-		case client.sendChan <- data:
-			break
+// ApplyParams updates the settings used for new connections. Connected clients
+// keep the settings they were upgraded with.
+func (h *WebSocketHandler) ApplyParams(params *Params) {
+	// The upgrader offers permessage-deflate whenever some stream is
+	// compressed, so one turned on by a reload works for its new viewers.
+	compression := newCompressionSettings(params)
+	upgrader := &websocket.Upgrader{
+		ReadBufferSize: params.readBufferSize,
+		WriteBufferSize: params.writeBufferSize,
+		CheckOrigin: NewOriginPolicy(params).CheckOrigin,
+		EnableCompression: compression.any(),
+	}
+	if params.wsWriteBufferPool {
+		// Viewers only hold a write buffer while a message is written to them.
+		upgrader.WriteBufferPool = h.writeBuffers
+	}
+
+	auth := NewViewerAuthenticator(params)
+	access, err := NewAccessControl(params, false)
+	if err != nil {
+		h.logger.Printf("Invalid viewer access rules, rejecting every viewer: %v\n", err)
+	}
+
+	sizes := make(map[string]videoSize)
+	maxBitrates := make(map[string]int64)
+	for _, stream := range params.streams {
+		if stream.Width != 0 {
+			sizes[stream.Name] = videoSize{width: stream.Width, height: stream.Height}
+		}
+		if stream.ViewerMaxBitrate != 0 {
+			maxBitrates[stream.Name] = stream.ViewerMaxBitrate
 		}
 	}
+
+	h.settingsLock.Lock()
+	h.upgrader = upgrader
+	h.auth = auth
+	h.access = access
+	h.sizes = sizes
+	h.defaultSize = videoSize{width: params.width, height: params.height}
+	h.compression = compression
+	h.tuning = newSocketTuning(params)
+	h.slowClients = newSlowClientSettings(params)
+	h.coalesce = newCoalesceSettings(params)
+	h.chaosSettings = newChaosSettings(params)
+	h.writeTimeout = params.wsWriteTimeout
+	h.closeTimeout = params.wsCloseTimeout
+	h.pingInterval = params.wsPingInterval
+	h.pongTimeout = params.wsPongTimeout
+	h.maxBitrate = params.viewerMaxBitrate
+	h.streamMaxBitrates = maxBitrates
+	h.settingsLock.Unlock()
+
+	h.limiter.ApplyParams(params)
+	h.egress.ApplyParams(params)
+	h.admission.ApplyParams(params)
 }
 
-func (h *WebSocketHandler) Run() {
-	go h.RunHTTPServer()
+// BroadcastData hands data published on stream to everyone watching it. The
+// data is only read during the call, so the caller is free to reuse it once
+// BroadcastData returns: what outlives the call, such as the viewers' queues
+// and the caches, is copied, the viewers' share into one pooled Buffer.
+func (h *WebSocketHandler) BroadcastData(stream string, data []byte) {
+	if tables := h.tables.Write(stream, data); tables != nil {
+		data = append(tables, data...)
+	}
+	if h.hls != nil {
+		h.hls.Write(stream, data)
+	}
 
-	for {
-		select {
-		case client := <-h.register:
-			h.clients[client] = true
-			log.Printf("New client registered. Total: %d\n", len(h.clients))
-			break
+	h.media.Write(stream, data)
+	if h.gops != nil {
+		h.gops.Write(stream, data)
+	}
 
-		case client := <- h.unregister:
-			_, ok := h.clients[client]
-			if ok {
-				delete(h.clients, client)
-			}
-			log.Printf("Client unregistered.   Total: %d\n", len(h.clients))
-			break
+	id, seq := h.sequences.Next(stream, data)
+	// Viewers, taps and WebTransport sessions share one pooled copy of data,
+	// which goes back to the pool once the last of them is done with it.
+	buffer := h.buffers.Copy(data)
+	defer buffer.Release()
+	if views := h.ringViews(stream); views != nil {
+		h.streamRing(stream).push(h.newRingEntry(stream, buffer, id, seq, views))
+	} else {
+		h.tracks.Skip(stream)
+	}
+	h.fmp4.Write(stream, data)
+	h.snapshots.Write(stream, data)
+	if h.thumbnails != nil {
+		h.thumbnails.Seen(stream)
+	}
+	if h.whep != nil {
+		h.whep.Write(stream, data)
+	}
+	if h.webTransport != nil {
+		h.webTransport.Write(stream, buffer)
+	}
+	h.feedTaps(stream, buffer)
 
-		case data := <- h.broadcast:
-			h.BroadcastData(data)
-			break
+	h.forwardsLock.RLock()
+	forwards := h.forwards[stream]
+	h.forwardsLock.RUnlock()
+	for session, to := range forwards {
+		if !session.Superseded() {
+			session.meter.Add(len(data))
+			h.BroadcastData(to, data)
+		}
+	}
+}
+
+// Forward broadcasts the data of stream from to the viewers of stream to as
+// well, until StopForward is called or session is superseded.
+func (h *WebSocketHandler) Forward(from, to string, session *PublishSession) {
+	h.forwardsLock.Lock()
+	defer h.forwardsLock.Unlock()
+
+	forwards := make(map[*PublishSession]string)
+	for other, stream := range h.forwards[from] {
+		forwards[other] = stream
+	}
+	forwards[session] = to
+	h.forwards[from] = forwards
+}
+
+func (h *WebSocketHandler) StopForward(from string, session *PublishSession) {
+	h.forwardsLock.Lock()
+	defer h.forwardsLock.Unlock()
+
+	forwards := make(map[*PublishSession]string)
+	for other, stream := range h.forwards[from] {
+		if other != session {
+			forwards[other] = stream
 		}
 	}
+	if len(forwards) == 0 {
+		delete(h.forwards, from)
+	} else {
+		h.forwards[from] = forwards
+	}
+}
+
+func (h *WebSocketHandler) Run() {
+	if h.srv != nil {
+		go h.RunHTTPServer()
+	}
+	if h.poller != nil {
+		go h.poller.Run()
+	}
+
+	var shards sync.WaitGroup
+	for _, shard := range h.shards {
+		shards.Add(1)
+		go func(shard *hubShard) {
+			defer shards.Done()
+			shard.run()
+		}(shard)
+	}
+	shards.Wait()
+	close(h.done)
+}
+
+// ViewerCounts returns the number of viewers of every stream.
+func (h *WebSocketHandler) ViewerCounts() map[string]int {
+	h.viewerCounts.lock.Lock()
+	defer h.viewerCounts.lock.Unlock()
+
+	counts := make(map[string]int, len(h.viewerCounts.counts))
+	for stream, count := range h.viewerCounts.counts {
+		counts[stream] = count
+	}
+	return counts
+}
+
+// Viewers lists the connected viewers of every stream.
+func (h *WebSocketHandler) Viewers() []ViewerInfo {
+	viewers := []ViewerInfo{}
+	for _, shard := range h.shards {
+		viewers = append(viewers, shard.viewers()...)
+	}
+	return viewers
+}
+
+type kickRequest struct {
+	id     string          // client ID, or "" to match by ip only
+	ip     string          // client address, or "" to match by id only
+	ids    map[string]bool // further client IDs
+	all    bool            // every client, whatever its id and ip
+	code   int             // close code, websocket.ClosePolicyViolation when 0
+	reason string
+}
+
+func (h *WebSocketHandler) kickClients(req kickRequest) int {
+	kicked := 0
+	for _, shard := range h.shards {
+		kicked += shard.kick(req)
+	}
+	h.logger.Printf("Kicked %d client(s): %s\n", kicked, req.reason)
+	return kicked
+}
+
+// KickViewer disconnects the viewer with the given client ID and reports
+// whether it was connected.
+func (h *WebSocketHandler) KickViewer(id string) bool {
+	return h.kickClients(kickRequest{id: id, reason: "kicked"}) > 0
+}
+
+// BanIP disconnects every viewer from ip and rejects new ones until the ban
+// expires. It returns the end of the ban.
+func (h *WebSocketHandler) BanIP(ip string, duration time.Duration) time.Time {
+	until := h.bans.Ban(ip, duration)
+	h.kickClients(kickRequest{ip: ip, reason: "banned"})
+
+	return until
+}
+
+func (h *WebSocketHandler) UnbanIP(ip string) bool {
+	return h.bans.Unban(ip)
+}
+
+func (h *WebSocketHandler) Bans() map[string]time.Time {
+	return h.bans.Bans()
 }
 
 func (h *WebSocketHandler) RunHTTPServer() {
-	r := mux.NewRouter()
-	r.HandleFunc("/", h.ServeWS)
+	h.logger.Println("WebSocketHandler starting")
+
+	if err := serve(h.srv, h.handoff); err != nil && err != http.ErrServerClosed {
+		h.logger.Printf("WebSocketHandler stopped: %v\n", err)
+	}
+}
+
+// Shutdown stops accepting viewers, then closes every client once its queued
+// data has been written. It returns early with ctx's error if the clients do
+// not finish in time.
+func (h *WebSocketHandler) Shutdown(ctx context.Context) error {
+	// End the plain HTTP viewers first, the server waits for their responses.
+	h.closeTaps()
+
+	var err error
+	if h.srv != nil {
+		err = h.srv.Shutdown(ctx)
+	}
 
-	srv := &http.Server{
-		Handler: r,
-		Addr: fmt.Sprintf("0.0.0.0:%d", h.portNum),
+	if h.whep != nil {
+		h.whep.Close()
+	}
+	if h.webTransport != nil {
+		h.webTransport.Close()
+	}
+	if h.thumbnails != nil {
+		h.thumbnails.Close()
 	}
 
-	log.Println("WebSocketHandler starting")
+	close(h.quit)
+	<-h.done
 
-	srv.ListenAndServe()
+	err = waitGroupContext(ctx, &h.writers, err)
+	if h.poller != nil {
+		h.poller.Close()
+	}
+	return err
 }
 
 func (h *WebSocketHandler) ServeWS(w http.ResponseWriter, r *http.Request) {
+	h.serveWS(w, r, r.URL.Query().Get("format"))
+}
+
+// ServeFMP4 connects a viewer receiving fragmented MP4 instead of MPEG-TS.
+func (h *WebSocketHandler) ServeFMP4(w http.ResponseWriter, r *http.Request) {
+	h.serveWS(w, r, formatFMP4)
+}
+
+func (h *WebSocketHandler) serveWS(w http.ResponseWriter, r *http.Request, format string) {
 	if r.Method != "GET" {
 		http.Error(w, "Method not allowed", 405)
 		return
 	}
+	if format == "" {
+		format = formatTS
+	}
+	if format != formatTS && format != formatFMP4 {
+		http.Error(w, "Unknown format", http.StatusBadRequest)
+		return
+	}
+	tracks := r.URL.Query().Get("tracks")
+	if !validTracks(tracks) {
+		http.Error(w, "Unknown tracks", http.StatusBadRequest)
+		return
+	}
+	frames := r.URL.Query().Get("frames")
+	if !validFrames(frames) {
+		http.Error(w, "Unknown frames", http.StatusBadRequest)
+		return
+	}
+	framing := r.URL.Query().Get("framing")
+	if framing != framingNone && framing != framingSeq {
+		http.Error(w, "Unknown framing", http.StatusBadRequest)
+		return
+	}
+	var resume *resumePoint
+	if value := r.URL.Query().Get("resume"); value != "" {
+		var err error
+		if resume, err = parseResumePoint(value); err != nil || framing != framingSeq {
+			http.Error(w, "Invalid resume, it needs framing=seq and <stream id>:<sequence number>", http.StatusBadRequest)
+			return
+		}
+	}
+	if (tracks != tracksAll || frames != framesAll || framing != framingNone) && format != formatTS {
+		http.Error(w, "Tracks, frames and framing can only be chosen with the ts format", http.StatusBadRequest)
+		return
+	}
+
+	h.settingsLock.RLock()
+	upgrader := h.upgrader
+	auth := h.auth
+	access := h.access
+	writeTimeout := h.writeTimeout
+	closeTimeout := h.closeTimeout
+	pingInterval := h.pingInterval
+	pongTimeout := h.pongTimeout
+	h.settingsLock.RUnlock()
+
+	stream := streamName(r)
+
+	if !access.Allows(r, stream) {
+		h.logger.Printf("Viewer %s not allowed on stream %s\n", r.RemoteAddr, stream)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	ip := hostname(r.RemoteAddr)
+	if h.bans.Banned(ip) {
+		h.logger.Printf("Banned viewer %s rejected\n", r.RemoteAddr)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var responseHeader http.Header
+	var limit SessionLimit
+	if auth != nil {
+		var subprotocol string
+		var err error
+		limit, subprotocol, err = auth.Authorize(r, stream)
+		if err != nil {
+			h.logger.Printf("Viewer %s rejected: %v\n", r.RemoteAddr, err)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if subprotocol != "" {
+			responseHeader = http.Header{"Sec-Websocket-Protocol": {subprotocol}}
+		}
+	}
+	if h.sessions.Full(limit) {
+		h.logger.Printf("Viewer %s rejected: token is at its session limit\n", r.RemoteAddr)
+		http.Error(w, "Too many sessions for this token", http.StatusTooManyRequests)
+		return
+	}
+
+	if !h.egress.Admits(stream) {
+		h.logger.Printf("Viewer %s rejected: egress bandwidth cap reached on stream %s\n", r.RemoteAddr, stream)
+		http.Error(w, "Egress bandwidth limit reached", http.StatusServiceUnavailable)
+		return
+	}
+
+	if !h.limiter.Attempt(ip) {
+		h.logger.Printf("Viewer %s exceeded the upgrade rate\n", r.RemoteAddr)
+		http.Error(w, "Too many connection attempts", http.StatusTooManyRequests)
+		return
+	}
+	if !h.limiter.Acquire(ip) {
+		h.logger.Printf("Viewer %s exceeded the connection limit\n", r.RemoteAddr)
+		http.Error(w, "Too many connections", http.StatusTooManyRequests)
+		return
+	}
+	if !h.admit(w, r, stream) {
+		h.limiter.Release(ip)
+		return
+	}
 
-	ws, err := h.upgrader.Upgrade(w, r, nil)
+	ws, err := h.upgrade(w, r, upgrader, responseHeader, stream)
 	if err != nil {
-		log.Println(err)
+		h.limiter.Release(ip)
+		h.admission.Release(stream)
+		h.logger.Println(err)
 		return
 	}
 
-	log.Println("New client connected")
-	client := NewClient(ws, h.unregister)
+	client := NewClient(ws, stream, h.sendQueueSize(stream, format), h)
+	client.format = format
+	client.tracks = tracks
+	client.frames = frames
+	client.framing = framing
+	client.resume = resume
+	client.policy = h.slowClientPolicy(stream)
+	client.streamStats = h.slowClientStats(stream)
+	client.throttle = h.egress.Policy() == egressDecimate
+	if limit.MaxBitrate > 0 {
+		client.bucket = NewTokenBucket(limit.MaxBitrate)
+	} else {
+		client.bucket = NewTokenBucket(h.viewerMaxBitrate(stream))
+	}
+	if format == formatTS {
+		client.ring = h.streamRing(stream)
+		client.cursor = ringCursor{
+			ringView: ringView{sub: client.subscription(), framing: framing},
+			decimate: client.policy.name == slowClientDecimate,
+			throttle: client.throttle,
+		}
+	}
+	client.writeTimeout = writeTimeout
+	client.closeTimeout = closeTimeout
+	client.pingInterval = pingInterval
+	client.pongTimeout = pongTimeout
+	client.flushInterval, client.coalesceSize = h.coalescing(stream)
+	client.chaos = h.chaos()
+	if format == formatFMP4 {
+		h.fmp4.Start(stream)
+	}
+	h.logger.Printf("New client %s connected to stream %s\n", client.id, stream)
+	client.onClose = func() {
+		h.sessions.Release(client, limit)
+		h.limiter.Release(ip)
+		h.admission.Release(stream)
+	}
+
+	displaced, ok := h.sessions.Acquire(client, limit)
+	if !ok {
+		// Another session took the last slot since the check above.
+		h.limiter.Release(ip)
+		h.admission.Release(stream)
+		ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "too many sessions"), client.writeDeadline())
+		ws.Close()
+		return
+	}
+	for _, old := range displaced {
+		h.kickClients(kickRequest{id: old.id, reason: "session displaced"})
+	}
 
-	h.register <- client
+	select {
+	case client.shard.register <- client:
+	case <-h.done:
+		// onClose only runs for a started client.
+		h.sessions.Release(client, limit)
+		h.limiter.Release(ip)
+		h.admission.Release(stream)
+		ws.Close()
+		return
+	}
 
+	h.writers.Add(1)
 	go client.Run()
 }
 
+// streamName picks the stream from the {stream} route variable, falling back
+// to the "stream" query parameter and then to the default stream.
+func streamName(r *http.Request) string {
+	if stream := mux.Vars(r)["stream"]; stream != "" {
+		return stream
+	}
+	if stream := r.URL.Query().Get("stream"); stream != "" {
+		return stream
+	}
+	return defaultStreamName
+}
+
+// Source is a stream input the server runs itself rather than one that
+// connects to it.
+type Source interface {
+	Run()
+	Close()
+}
+
 type IncomingStreamHandler struct {
 	clientManager *WebSocketHandler
-	width uint16
-	height uint16
 
 	secret string
-	portNum int
+	streamSecrets map[string]string  // stream name -> secret
+	retiredSecrets map[string]retiredSecret  // stream name -> secret replaced by a rotation
+	basicAuth map[string]BasicAuthConfig  // stream name -> credentials
+	upgrader *websocket.Upgrader
+	signedOnly bool
+	requireClientCert bool
+	clientCertRules []ClientCertRule
+	access *AccessControl
+	secretsLock sync.RWMutex
+	verifier *IngestVerifier
+	publisherLock *PublisherLock
+	handoff *SocketHandoff
+	publishers sync.WaitGroup
+	chunkSize int
+	limits IngestLimits
+	pacing time.Duration
+	streamPacing map[string]time.Duration  // stream name -> pacing overriding the default
+	streamPIDs map[string]PIDFilterConfig
+	stripNull bool
+	udp []*UDPIngest
+	socketPath string
+	socketStream string
+	sources []Source
+	listeners map[string]*StreamListener  // stream name -> its own ingest listener
+	sourcesLock sync.Mutex
+	running bool
+	events *EventBus
+	srv *http.Server
+	logger *log.Logger
 }
 
-func NewIncomingStreamHandler(params *Params, clientManager *WebSocketHandler) *IncomingStreamHandler {
+func NewIncomingStreamHandler(params *Params, clientManager *WebSocketHandler, events *EventBus) *IncomingStreamHandler {
 	incomingStreamHandler := &IncomingStreamHandler{
 		clientManager: clientManager,
-		secret: params.secret,
-		portNum: params.incomingPort,
+		events: events,
+		verifier: NewIngestVerifier(params),
+		publisherLock: NewPublisherLock(clientManager.drain),
+		handoff: clientManager.handoff,
+		retiredSecrets: make(map[string]retiredSecret),
+		logger: params.logger,
+	}
+	incomingStreamHandler.ApplyParams(params)
+	incomingStreamHandler.udp = NewUDPIngests(params, incomingStreamHandler)
+	incomingStreamHandler.sources = append(NewRTSPSources(params, incomingStreamHandler), NewFIFOSources(params, incomingStreamHandler)...)
+	incomingStreamHandler.sources = append(incomingStreamHandler.sources, NewTCPSources(params, incomingStreamHandler)...)
+	incomingStreamHandler.listeners = NewStreamListeners(params, incomingStreamHandler)
+	for _, listener := range incomingStreamHandler.listeners {
+		incomingStreamHandler.sources = append(incomingStreamHandler.sources, listener)
+	}
+	incomingStreamHandler.sources = append(incomingStreamHandler.sources, NewRelaySources(params, incomingStreamHandler)...)
+	incomingStreamHandler.sources = append(incomingStreamHandler.sources, NewDialSources(params, incomingStreamHandler)...)
+	incomingStreamHandler.sources = append(incomingStreamHandler.sources, NewEncoderSources(params, incomingStreamHandler)...)
+	incomingStreamHandler.sources = append(incomingStreamHandler.sources, NewFileSources(params, incomingStreamHandler)...)
+	incomingStreamHandler.sources = append(incomingStreamHandler.sources, NewFallbackSources(params, incomingStreamHandler)...)
+	incomingStreamHandler.sources = append(incomingStreamHandler.sources, NewRestreams(params, incomingStreamHandler)...)
+	incomingStreamHandler.sources = append(incomingStreamHandler.sources, NewEdgePushes(params, incomingStreamHandler)...)
+	incomingStreamHandler.sources = append(incomingStreamHandler.sources, NewMulticastOutputs(params, incomingStreamHandler)...)
+	if rtmp := NewRTMPSource(params, incomingStreamHandler); rtmp != nil {
+		incomingStreamHandler.sources = append(incomingStreamHandler.sources, rtmp)
+	}
+	if grpcSource := NewGRPCIngestSource(params, incomingStreamHandler); grpcSource != nil {
+		incomingStreamHandler.sources = append(incomingStreamHandler.sources, grpcSource)
+	}
+	if testSource := NewTestSource(params, incomingStreamHandler); testSource != nil {
+		incomingStreamHandler.sources = append(incomingStreamHandler.sources, testSource)
 	}
 
-	return incomingStreamHandler
-}
-
-func (s *IncomingStreamHandler) HandlePost(w http.ResponseWriter, r *http.Request) {
-	log.Printf("IncomingStream connected: %s\n", r.RemoteAddr)
+	if params.SingleAddr() == "" {
+		r := mux.NewRouter()
+		incomingStreamHandler.Routes(r)
 
-	for {
-		data, err := ioutil.ReadAll(io.LimitReader(r.Body, 1024))
-		if err != nil || len(data) == 0 {
-			break
+		var handler http.Handler = r
+		if params.incomingSocket != "" {
+			incomingStreamHandler.socketPath = params.incomingSocket
+			incomingStreamHandler.socketStream = params.incomingSocketStream
+			handler = localPublisher(r)
 		}
 
-		s.clientManager.BroadcastData(&data)
+		incomingStreamHandler.srv = trackConns(withTimeouts(&http.Server{
+			Handler: handler,
+			Addr: params.IncomingAddr(),
+			TLSConfig: params.IngestTLSConfig(false),
+			ErrorLog: params.logger,
+		}, params))
 	}
 
-	log.Printf("IncomingStream disconnected: %s\n", r.RemoteAddr)
+	return incomingStreamHandler
 }
 
-func (s *IncomingStreamHandler) Run() {
-	log.Println("IncomingStreamHandler starting")
+// ApplyParams replaces the accepted secrets. Publishers already streaming are
+// not disconnected.
+func (s *IncomingStreamHandler) ApplyParams(params *Params) {
+	streamSecrets := make(map[string]string)
+	basicAuth := make(map[string]BasicAuthConfig)
+	streamPacing := make(map[string]time.Duration)
+	streamPIDs := make(map[string]PIDFilterConfig)
+	for _, stream := range params.streams {
+		if stream.Secret != "" {
+			streamSecrets[stream.Name] = stream.Secret
+		}
+		if stream.BasicAuth != nil {
+			basicAuth[stream.Name] = *stream.BasicAuth
+		}
+		if stream.Pacing != nil {
+			streamPacing[stream.Name] = *stream.Pacing
+		}
+		if stream.PIDs != nil {
+			streamPIDs[stream.Name] = *stream.PIDs
+		}
+	}
 
-	r := mux.NewRouter()
-	r.HandleFunc(fmt.Sprintf("/%s", s.secret), s.HandlePost)
+	access, err := NewAccessControl(params, true)
+	if err != nil {
+		s.logger.Printf("Invalid ingest access rules, rejecting every publisher: %v\n", err)
+	}
 
-	srv := &http.Server{
-		Handler: r,
-		Addr: fmt.Sprintf("0.0.0.0:%d", s.portNum),
+	s.secretsLock.Lock()
+	s.secret = params.secret
+	s.streamSecrets = streamSecrets
+	s.basicAuth = basicAuth
+	s.upgrader = &websocket.Upgrader{
+		ReadBufferSize: params.readBufferSize,
+		WriteBufferSize: params.writeBufferSize,
+		CheckOrigin: NewOriginPolicy(params).CheckOrigin,
 	}
+	s.signedOnly = params.signedIngestOnly
+	s.requireClientCert = params.ingestClientCAs != nil
+	s.clientCertRules = params.ingestClientCerts
+	s.access = access
+	s.chunkSize = params.ingestChunkSize
+	s.limits = IngestLimits{
+		MaxBitrate: params.ingestMaxBitrate,
+		MaxDuration: params.ingestMaxDuration,
+		ReadTimeout: params.ingestReadTimeout,
+	}
+	s.pacing = params.ingestPacing
+	s.streamPacing = streamPacing
+	s.streamPIDs = streamPIDs
+	s.stripNull = params.ingestStripNull
+	s.secretsLock.Unlock()
 
-	srv.ListenAndServe()
+	s.verifier.ApplyParams(params)
 }
 
-type Params struct {
-	secret string
-	websocketPort int
-	incomingPort int
-
-	readBufferSize int
-	writeBufferSize int
+// Routes registers the publish endpoints on r. Requests carrying an API key
+// are signed publishes to "/{stream}"; the rest use the secret in the path.
+func (s *IncomingStreamHandler) Routes(r *mux.Router) {
+	r.HandleFunc("/publish/{secret}", s.HandleWebSocketPublish)
+	r.HandleFunc("/publish/{secret}/{stream}", s.HandleWebSocketPublish)
+	r.HandleFunc("/{stream}", s.HandleSignedPost).Headers(ingestKeyHeader, "")
+	r.HandleFunc("/{stream}", s.HandleSource).MatcherFunc(isSourceRequest)
+	r.HandleFunc("/{secret}", s.HandlePost)
+	r.HandleFunc("/{secret}/{stream}", s.HandlePost)
 }
 
-func ParseParams() *Params {
-	params := &Params{}
+// KeyedStreams returns the streams that have their own secret.
+func (s *IncomingStreamHandler) KeyedStreams() map[string]bool {
+	s.secretsLock.RLock()
+	defer s.secretsLock.RUnlock()
 
-	flag.StringVar(&params.secret, "secret", "secret", "SECRET code for distinct incoming stream data")
-	flag.IntVar(&params.incomingPort, "incoming", 8082, "Incoming stream port number")
-	flag.IntVar(&params.websocketPort, "websocket", 8084, "WebSocket port number")
-	flag.IntVar(&params.readBufferSize, "readbuffer", 8192, "ReadBufferSize used by WebSocket")
-	flag.IntVar(&params.writeBufferSize, "writebuffer", 8192, "WriteBufferSize used by WebSocket")
-
-	flag.Parse()
+	keyed := make(map[string]bool)
+	for name := range s.streamSecrets {
+		keyed[name] = true
+	}
 
-	return params
+	return keyed
 }
 
-func main() {
-	params := ParseParams()
+// SetStreamSecret gives stream its own secret until the next reload. The old
+// secret stops working right away.
+func (s *IncomingStreamHandler) SetStreamSecret(stream, secret string) error {
+	s.secretsLock.Lock()
+	defer s.secretsLock.Unlock()
 
-	log.Println("StreamServer parameters")
-	log.Println("  SECRET: " + params.secret)
-	log.Println("  IncomingPort: " + strconv.Itoa(params.incomingPort))
-	log.Println("  WebSocketPort: " + strconv.Itoa(params.websocketPort))
+	return s.setStreamSecret(stream, secret)
+}
+
+type retiredSecret struct {
+	secret string
+	until time.Time
+}
+
+// RotateStreamSecret gives stream a new secret like SetStreamSecret, but keeps
+// accepting the previous one (or the global secret, if the stream had none)
+// until the returned time.
+func (s *IncomingStreamHandler) RotateStreamSecret(stream, secret string, grace time.Duration) (time.Time, error) {
+	s.secretsLock.Lock()
+	defer s.secretsLock.Unlock()
+
+	previous, ok := s.streamSecrets[stream]
+	if !ok {
+		previous = s.secret
+	}
+	for name, retired := range s.retiredSecrets {
+		if name != stream && retired.secret == secret {
+			return time.Time{}, fmt.Errorf("secret is still accepted for stream %s", name)
+		}
+	}
+
+	if err := s.setStreamSecret(stream, secret); err != nil {
+		return time.Time{}, err
+	}
+
+	now := time.Now()
+	for name, retired := range s.retiredSecrets {
+		if now.After(retired.until) {
+			delete(s.retiredSecrets, name)
+		}
+	}
+	until := now.Add(grace)
+	s.retiredSecrets[stream] = retiredSecret{secret: previous, until: until}
+
+	return until, nil
+}
+
+func (s *IncomingStreamHandler) setStreamSecret(stream, secret string) error {
+	if secret == s.secret {
+		return fmt.Errorf("secret is the global secret")
+	}
+	for name, streamSecret := range s.streamSecrets {
+		if name != stream && streamSecret == secret {
+			return fmt.Errorf("secret is used by stream %s", name)
+		}
+	}
+
+	streamSecrets := make(map[string]string)
+	for name, streamSecret := range s.streamSecrets {
+		streamSecrets[name] = streamSecret
+	}
+	streamSecrets[stream] = secret
+	s.streamSecrets = streamSecrets
+
+	return nil
+}
+
+// DeleteStreamSecret makes stream fall back to the global secret.
+func (s *IncomingStreamHandler) DeleteStreamSecret(stream string) bool {
+	s.secretsLock.Lock()
+	defer s.secretsLock.Unlock()
+
+	if _, ok := s.streamSecrets[stream]; !ok {
+		return false
+	}
+
+	streamSecrets := make(map[string]string)
+	for name, streamSecret := range s.streamSecrets {
+		if name != stream {
+			streamSecrets[name] = streamSecret
+		}
+	}
+	s.streamSecrets = streamSecrets
+
+	return true
+}
+
+// ResolveStream maps the secret in the ingest URL to the stream being
+// published. A stream with its own secret only accepts that secret, either as
+// "/{secret}" or "/{secret}/{stream}"; every other stream accepts the global
+// secret.
+func (s *IncomingStreamHandler) ResolveStream(r *http.Request) (string, bool) {
+	secret := mux.Vars(r)["secret"]
+	requested := mux.Vars(r)["stream"]
+
+	s.secretsLock.RLock()
+	defer s.secretsLock.RUnlock()
+
+	for name, streamSecret := range s.streamSecrets {
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(streamSecret)) == 1 {
+			return name, requested == "" || requested == name
+		}
+	}
+
+	// A rotated-out global secret only covers the stream it was rotated on,
+	// so the publisher must name that stream.
+	now := time.Now()
+	for name, retired := range s.retiredSecrets {
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(retired.secret)) != 1 || now.After(retired.until) {
+			continue
+		}
+		if retired.secret != s.secret {
+			return name, requested == "" || requested == name
+		}
+		if requested == name {
+			return name, true
+		}
+	}
+
+	stream := streamName(r)
+	if _, ok := s.streamSecrets[stream]; ok || subtle.ConstantTimeCompare([]byte(secret), []byte(s.secret)) != 1 {
+		return "", false
+	}
+	if _, ok := s.basicAuth[stream]; ok {
+		return "", false
+	}
+
+	return stream, true
+}
+
+// AcceptsSecret reports whether secret publishes stream for publishers that
+// name the stream themselves: the stream's own secret, the global one for a
+// stream without, or a secret still in its rotation grace period.
+func (s *IncomingStreamHandler) AcceptsSecret(stream, secret string) bool {
+	s.secretsLock.RLock()
+	defer s.secretsLock.RUnlock()
+
+	if s.signedOnly {
+		return false
+	}
+
+	expected, ok := s.streamSecrets[stream]
+	if !ok {
+		if _, basic := s.basicAuth[stream]; basic {
+			return false
+		}
+		expected = s.secret
+	}
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(expected)) == 1 {
+		return true
+	}
+
+	retired, ok := s.retiredSecrets[stream]
+	return ok && time.Now().Before(retired.until) && subtle.ConstantTimeCompare([]byte(secret), []byte(retired.secret)) == 1
+}
+
+func (s *IncomingStreamHandler) HandlePost(w http.ResponseWriter, r *http.Request) {
+	s.secretsLock.RLock()
+	signedOnly := s.signedOnly
+	credentials, basic := s.basicAuth[mux.Vars(r)["secret"]]
+	s.secretsLock.RUnlock()
+
+	if signedOnly {
+		http.Error(w, "Signed request required", http.StatusUnauthorized)
+		return
+	}
+
+	// A stream with Basic credentials is published at /<stream>; the first
+	// request usually comes without credentials to learn the scheme.
+	if basic && mux.Vars(r)["stream"] == "" {
+		if !checkBasicAuth(r, credentials) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="jsmpeg ingest", charset="UTF-8"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		s.Publish(w, r, mux.Vars(r)["secret"])
+		return
+	}
+
+	stream, ok := s.ResolveStream(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.Publish(w, r, stream)
+}
+
+func (s *IncomingStreamHandler) HandleSignedPost(w http.ResponseWriter, r *http.Request) {
+	stream := mux.Vars(r)["stream"]
+	if err := s.verifier.Verify(r, stream); err != nil {
+		s.logger.Printf("Signed publish from %s rejected: %v\n", r.RemoteAddr, err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	s.Publish(w, r, stream)
+}
+
+// startPublish checks that the publisher may publish to stream and takes the
+// stream's publisher lock. On failure it has already answered the request.
+// A successful call must be paired with endPublish.
+func (s *IncomingStreamHandler) startPublish(w http.ResponseWriter, r *http.Request, stream string) (*PublishSession, bool) {
+	s.secretsLock.RLock()
+	access := s.access
+	s.secretsLock.RUnlock()
+
+	if !access.Allows(r, stream) {
+		s.logger.Printf("IncomingStream %s not allowed on stream %s\n", r.RemoteAddr, stream)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil, false
+	}
+
+	if listener, ok := s.listeners[stream]; ok && r.Context().Value(streamListenerKey{}) != listener {
+		s.logger.Printf("IncomingStream %s rejected: stream %s is published on %s only\n", r.RemoteAddr, stream, listener.addr)
+		http.NotFound(w, r)
+		return nil, false
+	}
+
+	if err := s.CheckClientCert(r, stream); err != nil {
+		s.logger.Printf("IncomingStream %s rejected: %v\n", r.RemoteAddr, err)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil, false
+	}
+
+	session, err := s.publisherLock.Acquire(stream, r, r.URL.Query().Get("takeover") == "1")
+	if err == errDraining {
+		s.logger.Printf("IncomingStream %s rejected: %v\n", r.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return nil, false
+	} else if err != nil {
+		s.logger.Printf("IncomingStream %s rejected: %v\n", r.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusConflict)
+		return nil, false
+	}
+
+	s.publishers.Add(1)
+	s.logger.Printf("IncomingStream connected: %s (stream %s)\n", r.RemoteAddr, stream)
+
+	return session, true
+}
+
+// NewChunkReader splits a publisher's data into chunks of the configured
+// size.
+func (s *IncomingStreamHandler) NewChunkReader(reader io.Reader) *ChunkReader {
+	s.secretsLock.RLock()
+	chunkSize := s.chunkSize
+	s.secretsLock.RUnlock()
+
+	return NewChunkReader(reader, chunkSize)
+}
+
+func (s *IncomingStreamHandler) endPublish(session *PublishSession) {
+	s.logger.Printf("IncomingStream disconnected: %s\n", session.remoteAddr)
+	if session.pacer != nil {
+		if session.Superseded() {
+			session.pacer.Stop()
+		} else {
+			session.pacer.Close()
+		}
+	}
+	s.publisherLock.Release(session)
+	s.publishers.Done()
+}
+
+// Publish broadcasts the request body to the viewers of stream until the
+// publisher disconnects or another publisher takes the stream over with
+// "?takeover=1".
+func (s *IncomingStreamHandler) Publish(w http.ResponseWriter, r *http.Request, stream string) {
+	session, ok := s.startPublish(w, r, stream)
+	if !ok {
+		return
+	}
+	defer s.endPublish(session)
+	streaming(w)
+
+	limits := s.Limits()
+	chunks := s.NewChunkReader(limits.Reader(r.Body, http.NewResponseController(w).SetReadDeadline))
+	for {
+		data, err := chunks.Next()
+		if err != nil {
+			break
+		}
+
+		if session.Superseded() {
+			s.logger.Printf("IncomingStream %s superseded on stream %s\n", r.RemoteAddr, stream)
+			break
+		}
+		session.meter.Add(len(data))
+		if err := limits.Check(session); err != nil {
+			s.logger.Printf("IncomingStream %s disconnected: %v\n", r.RemoteAddr, err)
+			break
+		}
+
+		s.Broadcast(session, data)
+	}
+}
+
+// HandleWebSocketPublish accepts a publisher sending MPEG-TS as binary
+// WebSocket messages to /publish/{secret}[/{stream}], for browser based
+// encoders and encoders that can only open outgoing WebSocket connections.
+func (s *IncomingStreamHandler) HandleWebSocketPublish(w http.ResponseWriter, r *http.Request) {
+	s.secretsLock.RLock()
+	signedOnly := s.signedOnly
+	upgrader := s.upgrader
+	credentials, basic := s.basicAuth[mux.Vars(r)["secret"]]
+	s.secretsLock.RUnlock()
+
+	if signedOnly {
+		http.Error(w, "Signed request required", http.StatusUnauthorized)
+		return
+	}
+
+	// A stream with Basic credentials is published at /publish/<stream>,
+	// checked before the upgrade like a POST to /<stream>.
+	if basic && mux.Vars(r)["stream"] == "" {
+		if !checkBasicAuth(r, credentials) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="jsmpeg ingest", charset="UTF-8"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		s.PublishWebSocket(w, r, mux.Vars(r)["secret"], upgrader)
+		return
+	}
 
-	websocketHandler := NewWebSocketHandler(params)
-	incomingStreamHandler := NewIncomingStreamHandler(params, websocketHandler)
+	stream, ok := s.ResolveStream(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.PublishWebSocket(w, r, stream, upgrader)
+}
 
-	go websocketHandler.Run()
-	go incomingStreamHandler.Run()
+// PublishWebSocket upgrades the request and broadcasts the binary messages
+// of the publisher to the viewers of stream.
+func (s *IncomingStreamHandler) PublishWebSocket(w http.ResponseWriter, r *http.Request, stream string, upgrader *websocket.Upgrader) {
+	session, ok := s.startPublish(w, r, stream)
+	if !ok {
+		return
+	}
+	defer s.endPublish(session)
 
-	r := mux.NewRouter()
-	r.PathPrefix("/static").Handler(http.StripPrefix("/static", http.FileServer(http.Dir("static/"))))
-	r.PathPrefix("/").Handler(http.FileServer(http.Dir("./")))
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		s.logger.Println(err)
+		return
+	}
+	defer ws.Close()
+	ws.SetReadLimit(maxPublishMessageSize)
+
+	limits := s.Limits()
+	for {
+		if limits.ReadTimeout > 0 {
+			ws.SetReadDeadline(time.Now().Add(limits.ReadTimeout))
+		}
+		msgType, data, err := ws.ReadMessage()
+		if err != nil {
+			break
+		}
+
+		if session.Superseded() {
+			s.logger.Printf("IncomingStream %s superseded on stream %s\n", r.RemoteAddr, stream)
+			break
+		}
+		if msgType != websocket.BinaryMessage || len(data) == 0 {
+			continue
+		}
+		session.meter.Add(len(data))
+		if err := limits.Check(session); err != nil {
+			s.logger.Printf("IncomingStream %s disconnected: %v\n", r.RemoteAddr, err)
+			break
+		}
+
+		s.Broadcast(session, data)
+	}
+}
+
+func (s *IncomingStreamHandler) Run() {
+	for _, udp := range s.udp {
+		go udp.Run()
+	}
+	s.sourcesLock.Lock()
+	for _, source := range s.sources {
+		go source.Run()
+	}
+	s.running = true
+	s.sourcesLock.Unlock()
+	if s.srv == nil {
+		return
+	}
+
+	s.logger.Println("IncomingStreamHandler starting")
+
+	var err error
+	if s.socketPath != "" {
+		err = s.serveSocket()
+	} else {
+		err = serve(s.srv, s.handoff)
+	}
+	if err != nil && err != http.ErrServerClosed {
+		s.logger.Printf("IncomingStreamHandler stopped: %v\n", err)
+	}
+}
+
+// AddSource starts source while the server runs, for sources set up through
+// the admin API. It is closed on shutdown like the configured ones. An encoder
+// is refused with errStreamHasSource if its stream already has one.
+func (s *IncomingStreamHandler) AddSource(source Source) error {
+	s.sourcesLock.Lock()
+	defer s.sourcesLock.Unlock()
+
+	if encoder, ok := source.(*FFmpegSource); ok {
+		for _, other := range s.sources {
+			if existing, ok := other.(*FFmpegSource); ok && existing.stream == encoder.stream {
+				return errStreamHasSource
+			}
+		}
+	}
+
+	s.sources = append(s.sources, source)
+	if s.running {
+		go source.Run()
+	}
+	return nil
+}
+
+// Shutdown closes the ingest listener together with every publisher
+// connection and waits for the publish handlers to return. In single-port mode
+// the Server closes the shared listener before calling Shutdown.
+func (s *IncomingStreamHandler) Shutdown(ctx context.Context) error {
+	var err error
+	if s.srv != nil {
+		err = s.srv.Close()
+	}
+	for _, udp := range s.udp {
+		udp.Close()
+	}
+	s.sourcesLock.Lock()
+	for _, source := range s.sources {
+		source.Close()
+	}
+	s.running = false
+	s.sourcesLock.Unlock()
+	s.publisherLock.KickAll()
+
+	return waitGroupContext(ctx, &s.publishers, err)
+}
+
+func checkBasicAuth(r *http.Request, credentials BasicAuthConfig) bool {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+
+	usernameOK := subtle.ConstantTimeCompare([]byte(username), []byte(credentials.Username)) == 1
+	passwordOK := subtle.ConstantTimeCompare([]byte(password), []byte(credentials.Password)) == 1
+
+	return usernameOK && passwordOK
+}
 
-	log.Println("Demo web page listening at port 8080")
-	http.ListenAndServe("0.0.0.0:8080", r)
+// waitGroupContext waits for wg, giving up when ctx is done, and returns err
+// unless waiting failed.
+func waitGroupContext(ctx context.Context, wg *sync.WaitGroup, err error) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type Params struct {
+	secret string
+	websocketPort int
+	incomingPort int
+
+	websocketAddr string
+	incomingAddr string
+	demoAddr string
+
+	singlePort int
+	singleAddr string
+
+	adminPort int
+	adminAddr string
+	adminGRPCPort int
+	ingestGRPCPort int
+	adminGRPCAddr string
+	adminToken string
+	eventWebhook string
+
+	incomingSocket string
+	incomingSocketStream string
+
+	udpIngest string
+	udpStream string
+	rtpIngest string
+	rtpStream string
+	rtpJitter time.Duration
+	rtpFEC bool
+	rtmpIngest string
+	rtmpStream string
+	ffmpegPath string
+
+	testSource bool
+	testSourceStream string
+
+	drainTimeout time.Duration
+	handoffDrainTimeout time.Duration
+
+	httpReadHeaderTimeout time.Duration
+	httpReadTimeout time.Duration
+	httpWriteTimeout time.Duration
+	httpIdleTimeout time.Duration
+
+	allowedOrigins string
+	allowAnyOrigin bool
+
+	maxConnsPerIP int
+	maxUpgradesPerIP int
+	upgradeWindow time.Duration
+
+	ingestAllow string
+	ingestDeny string
+	viewerAllow string
+	viewerDeny string
+
+	jwtKey string
+	jwtStreamClaim string
+	jwtIssuer string
+	jwtAudience string
+
+	ingestKeys []IngestKey
+	signedIngestOnly bool
+	ingestMaxSkew time.Duration
+
+	ingestClientCA string
+	ingestClientCAs *x509.CertPool
+	ingestClientCerts []ClientCertRule
+
+	readBufferSize int
+	writeBufferSize int
+	wsWriteBufferPool bool
+	tcpNoDelay bool
+	tcpSendBuffer int
+	ingestChunkSize int
+
+	ingestMaxBitrate int64
+	ingestMaxDuration time.Duration
+	ingestReadTimeout time.Duration
+	ingestPacing time.Duration
+	ingestStripNull bool
+
+	hls bool
+	hlsDir string
+	hlsSegmentDuration time.Duration
+	hlsPlaylistSize int
+	hlsPartDuration time.Duration
+
+	recordDir string
+	recordSegmentDuration time.Duration
+	recordSegmentSize int64
+	recordMaxAge time.Duration
+	recordMaxBytes int64
+	recordMP4 bool
+	recordStorage string
+	recordStorageDelete bool
+
+	whep bool
+	whepICEServers string
+	whepPublicIP string
+
+	webTransportPort int
+
+	thumbnailInterval time.Duration
+	thumbnailWidth int
+	gopCache bool
+	width int
+	height int
+	resumeBufferSize int
+	wsCompression bool
+	wsCompressionLevel int
+	slowClientPolicy string
+	slowClientMaxDrops int
+	sendQueueSize int
+	fmp4SendQueueSize int
+	wsWriteTimeout time.Duration
+	wsCloseTimeout time.Duration
+	wsPingInterval time.Duration
+	wsPongTimeout time.Duration
+	wsBackend string
+	wsFlushInterval time.Duration
+	wsCoalesceSize int
+	hubShards int
+	egressMaxBitrate int64
+	egressPolicy string
+	viewerMaxBitrate int64
+	maxViewers int
+	memoryLimit int64
+	chaosLatency time.Duration
+	chaosJitter time.Duration
+	chaosDrop float64
+	chaosReorder float64
+
+	tlsCert string
+	tlsKey string
+	tlsConfig *tls.Config
+
+	autocertHosts string
+	autocertCacheDir string
+	autocertEmail string
+	autocertManager *autocert.Manager
+
+	configFile string
+	streams []StreamConfig
+	edges []EdgeConfig
+
+	logger *log.Logger
+
+	base *Params  // flags and environment only, before the config file is applied
+}
+
+func DefaultParams() *Params {
+	return &Params{
+		secret: "secret",
+		websocketPort: 8084,
+		incomingPort: 8082,
+		demoAddr: "0.0.0.0:8080",
+		drainTimeout: 10 * time.Second,
+		handoffDrainTimeout: 5 * time.Minute,
+		recordDir: defaultRecordDir,
+		recordSegmentDuration: defaultRecordSegmentDuration,
+		httpReadHeaderTimeout: 10 * time.Second,
+		httpIdleTimeout: 2 * time.Minute,
+		readBufferSize: 8192,
+		writeBufferSize: 8192,
+		tcpNoDelay: true,
+		ingestChunkSize: defaultIngestChunkSize,
+		ingestReadTimeout: defaultIngestReadTimeout,
+		autocertCacheDir: "autocert-cache",
+		jwtStreamClaim: "stream",
+		incomingSocketStream: defaultStreamName,
+		udpStream: defaultStreamName,
+		thumbnailWidth: 160,
+		wsCompressionLevel: flate.BestSpeed,
+		slowClientPolicy: slowClientDropNewest,
+		egressPolicy: egressReject,
+		slowClientMaxDrops: 50,
+		sendQueueSize: 512,
+		wsWriteTimeout: 10 * time.Second,
+		wsCloseTimeout: time.Second,
+		wsPingInterval: 20 * time.Second,
+		wsPongTimeout: 60 * time.Second,
+		wsBackend: wsBackendGorilla,
+		wsCoalesceSize: defaultCoalesceSize,
+		rtpStream: defaultStreamName,
+		rtpJitter: 50 * time.Millisecond,
+		rtmpStream: defaultStreamName,
+		ffmpegPath: "ffmpeg",
+		testSourceStream: defaultStreamName,
+		ingestMaxSkew: 5 * time.Minute,
+		upgradeWindow: time.Minute,
+		hlsSegmentDuration: defaultHLSSegmentDuration,
+		hlsPlaylistSize: defaultHLSPlaylistSize,
+		logger: log.Default(),
+	}
+}
+
+func ParseParams() (*Params, error) {
+	params := DefaultParams()
+
+	flag.StringVar(&params.configFile, "config", params.configFile, "YAML configuration file; flags given on the command line override its values")
+
+	flag.StringVar(&params.secret, "secret", params.secret, "SECRET code for distinct incoming stream data")
+	flag.IntVar(&params.incomingPort, "incoming", params.incomingPort, "Incoming stream port number")
+	flag.IntVar(&params.websocketPort, "websocket", params.websocketPort, "WebSocket port number")
+	flag.IntVar(&params.adminPort, "admin-port", params.adminPort, "Admin API port number (0 disables it; in single-port mode the API is served under /admin)")
+	flag.IntVar(&params.adminGRPCPort, "admin-grpc-port", params.adminGRPCPort, "Admin gRPC API port number (0 disables it)")
+	flag.IntVar(&params.ingestGRPCPort, "ingest-grpc-port", params.ingestGRPCPort, "gRPC ingest API port number (0 disables it)")
+	flag.StringVar(&params.adminToken, "admin-token", params.adminToken, "Bearer token required by the admin API")
+	flag.StringVar(&params.eventWebhook, "event-webhook", params.eventWebhook, "URL receiving admin events such as key rotations as JSON POST requests")
+	flag.StringVar(&params.incomingSocket, "incoming-socket", params.incomingSocket, "Unix socket path the incoming stream server listens at instead of -incoming")
+	flag.StringVar(&params.incomingSocketStream, "incoming-socket-stream", params.incomingSocketStream, "Stream raw MPEG-TS written to -incoming-socket is published to")
+	flag.StringVar(&params.udpIngest, "udp-ingest", params.udpIngest, "UDP address receiving raw MPEG-TS datagrams, e.g. :1234")
+	flag.StringVar(&params.udpStream, "udp-stream", params.udpStream, "Stream the UDP ingest publishes to")
+	flag.StringVar(&params.rtpIngest, "rtp-ingest", params.rtpIngest, "UDP address receiving MPEG-TS over RTP, e.g. :5004")
+	flag.StringVar(&params.rtpStream, "rtp-stream", params.rtpStream, "Stream the RTP ingest publishes to")
+	flag.DurationVar(&params.rtpJitter, "rtp-jitter", params.rtpJitter, "How long RTP packets wait for a missing earlier packet")
+	flag.BoolVar(&params.rtpFEC, "rtp-fec", params.rtpFEC, "Recover lost RTP packets from SMPTE 2022-1 FEC received two and four ports above -rtp-ingest")
+	flag.StringVar(&params.rtmpIngest, "rtmp-ingest", params.rtmpIngest, "Address ffmpeg listens at for an RTMP publisher, e.g. :1935")
+	flag.StringVar(&params.rtmpStream, "rtmp-stream", params.rtmpStream, "Stream the RTMP ingest publishes to")
+	flag.StringVar(&params.ffmpegPath, "ffmpeg", params.ffmpegPath, "Path of the ffmpeg binary used for transcoding")
+	flag.BoolVar(&params.testSource, "testsrc", params.testSource, "Publish a generated test pattern with ffmpeg")
+	flag.StringVar(&params.testSourceStream, "testsrc-stream", params.testSourceStream, "Stream the test pattern is published to")
+	flag.IntVar(&params.singlePort, "single-port", params.singlePort, "Serve /ws, /ingest/{secret} and the demo page on this one port instead")
+	flag.IntVar(&params.readBufferSize, "readbuffer", params.readBufferSize, "ReadBufferSize used by WebSocket")
+	flag.IntVar(&params.writeBufferSize, "writebuffer", params.writeBufferSize, "WriteBufferSize used by WebSocket")
+	flag.BoolVar(&params.wsWriteBufferPool, "ws-write-buffer-pool", params.wsWriteBufferPool, "Share write buffers between viewers instead of giving each its own")
+	flag.BoolVar(&params.tcpNoDelay, "tcp-nodelay", params.tcpNoDelay, "Send small writes to viewers at once; false lets the kernel merge them")
+	flag.IntVar(&params.tcpSendBuffer, "tcp-send-buffer", params.tcpSendBuffer, "Socket send buffer of each viewer in bytes (0 for the system default)")
+	flag.Int64Var(&params.ingestMaxBitrate, "ingest-max-bitrate", params.ingestMaxBitrate, "Disconnect publishers sending more bits per second than this (0 for unlimited)")
+	flag.DurationVar(&params.ingestMaxDuration, "ingest-max-duration", params.ingestMaxDuration, "Disconnect publishers after publishing this long (0 for unlimited)")
+	flag.DurationVar(&params.ingestReadTimeout, "ingest-read-timeout", params.ingestReadTimeout, "Disconnect publishers sending nothing for this long (0 to wait forever)")
+	flag.DurationVar(&params.ingestPacing, "ingest-pacing", params.ingestPacing, "Buffer published data this long and release it at the pace of the stream clock (0 to disable)")
+	flag.BoolVar(&params.ingestStripNull, "ingest-strip-null", params.ingestStripNull, "Remove null packets (PID 0x1fff) from published MPEG-TS")
+	flag.IntVar(&params.ingestChunkSize, "ingest-chunk-size", params.ingestChunkSize, "Largest chunk of incoming MPEG-TS broadcast at once, rounded down to whole 188 byte packets")
+	flag.BoolVar(&params.hls, "hls", params.hls, "Serve every stream as HLS at /hls/{stream}/index.m3u8 on the WebSocket port")
+	flag.StringVar(&params.hlsDir, "hls-dir", params.hlsDir, "Directory HLS segments and playlists are written to instead of memory")
+	flag.DurationVar(&params.hlsSegmentDuration, "hls-segment-duration", params.hlsSegmentDuration, "Target duration of an HLS segment; segments start at the next keyframe")
+	flag.IntVar(&params.hlsPlaylistSize, "hls-playlist-size", params.hlsPlaylistSize, "Number of segments listed in an HLS playlist")
+	flag.DurationVar(&params.hlsPartDuration, "hls-part-duration", params.hlsPartDuration, "Publish HLS segments in parts of this duration with blocking playlist reloads (Low-Latency HLS; 0 to disable)")
+	flag.StringVar(&params.recordDir, "record-dir", params.recordDir, "Directory the streams with a record section in the config file are recorded to, in a folder per stream")
+	flag.DurationVar(&params.recordSegmentDuration, "record-segment-duration", params.recordSegmentDuration, "Duration of a recorded segment; segments start at the next keyframe (0 for no limit)")
+	flag.Int64Var(&params.recordSegmentSize, "record-segment-size", params.recordSegmentSize, "Bytes of a recorded segment; segments start at the next keyframe (0 for no limit)")
+	flag.DurationVar(&params.recordMaxAge, "record-max-age", params.recordMaxAge, "Age after which recorded segments are deleted (0 to keep them)")
+	flag.Int64Var(&params.recordMaxBytes, "record-max-bytes", params.recordMaxBytes, "Bytes the recorded segments of a stream may take before the oldest are deleted (0 for no limit)")
+	flag.BoolVar(&params.recordMP4, "record-mp4", params.recordMP4, "Remux every recording session into an MP4 with ffmpeg once it ends")
+	flag.StringVar(&params.recordStorage, "record-storage", params.recordStorage, "Where complete recording files are stored: local, or uploaded to s3://bucket/prefix")
+	flag.BoolVar(&params.recordStorageDelete, "record-storage-delete", params.recordStorageDelete, "Delete the local copy of a recording file once it is uploaded")
+	flag.BoolVar(&params.whep, "whep", params.whep, "Play the H.264 video of every stream over WebRTC, negotiated through WHEP at /whep/{stream} on the WebSocket port")
+	flag.StringVar(&params.whepICEServers, "whep-ice-servers", params.whepICEServers, "Comma separated STUN/TURN URLs offered to WHEP viewers, e.g. stun:stun.l.google.com:19302")
+	flag.StringVar(&params.whepPublicIP, "whep-public-ip", params.whepPublicIP, "Public IP announced in WebRTC candidates when the server is behind 1:1 NAT")
+	flag.IntVar(&params.webTransportPort, "webtransport-port", params.webTransportPort, "UDP port serving streams over WebTransport at /wt/{stream} (0 disables it; needs TLS)")
+	flag.DurationVar(&params.thumbnailInterval, "thumbnail-interval", params.thumbnailInterval, "Renew a thumbnail of every stream this often, served at /thumbnail/{stream}.jpg (0 to disable)")
+	flag.IntVar(&params.thumbnailWidth, "thumbnail-width", params.thumbnailWidth, "Width of the stream thumbnails in pixels")
+	flag.BoolVar(&params.gopCache, "gop-cache", params.gopCache, "Send new viewers the stream's data since its last keyframe so they start decoding at once")
+	flag.IntVar(&params.width, "width", params.width, "Video width sent to viewers in a jsmpeg header message (0 to send none)")
+	flag.IntVar(&params.height, "height", params.height, "Video height sent to viewers in a jsmpeg header message (0 to send none)")
+	flag.IntVar(&params.resumeBufferSize, "resume-buffer-size", params.resumeBufferSize, "Bytes of every stream kept for viewers resuming a sequenced connection (0 to disable)")
+	flag.BoolVar(&params.wsCompression, "ws-compression", params.wsCompression, "Compress the messages to viewers that negotiate permessage-deflate")
+	flag.IntVar(&params.wsCompressionLevel, "ws-compression-level", params.wsCompressionLevel, "Deflate level of compressed messages, from 1 (fastest) to 9 (smallest)")
+	flag.StringVar(&params.slowClientPolicy, "slow-client-policy", params.slowClientPolicy, "What to do when an fMP4 viewer's send queue is full: drop-oldest, drop-newest, disconnect or decimate")
+	flag.IntVar(&params.slowClientMaxDrops, "slow-client-max-drops", params.slowClientMaxDrops, "Messages dropped in a row before the disconnect policy closes a viewer")
+	flag.IntVar(&params.sendQueueSize, "send-queue-size", params.sendQueueSize, "Messages kept in the ring a stream's MPEG-TS viewers read from")
+	flag.IntVar(&params.fmp4SendQueueSize, "fmp4-send-queue-size", params.fmp4SendQueueSize, "Send queue size of fMP4 viewers (0 for -send-queue-size)")
+	flag.DurationVar(&params.wsWriteTimeout, "ws-write-timeout", params.wsWriteTimeout, "Disconnect viewers when writing one message takes longer than this (0 to wait forever)")
+	flag.DurationVar(&params.wsCloseTimeout, "ws-close-timeout", params.wsCloseTimeout, "Time a closed viewer gets to answer the close frame before the connection is torn down")
+	flag.DurationVar(&params.wsPingInterval, "ws-ping-interval", params.wsPingInterval, "Interval between pings to viewers (0 to send none and never drop silent viewers)")
+	flag.DurationVar(&params.wsPongTimeout, "ws-pong-timeout", params.wsPongTimeout, "Drop viewers that answer no ping for this long (0 to keep them)")
+	flag.StringVar(&params.wsBackend, "ws-backend", params.wsBackend, "WebSocket implementation for viewers: gorilla, or poll for many idle viewers on Linux")
+	flag.DurationVar(&params.wsFlushInterval, "ws-flush-interval", params.wsFlushInterval, "Time a viewer's chunk waits to be sent together with the next ones (0 to send every chunk on its own)")
+	flag.IntVar(&params.wsCoalesceSize, "ws-coalesce-size", params.wsCoalesceSize, "Bytes after which coalesced chunks are sent without waiting for -ws-flush-interval")
+	flag.IntVar(&params.hubShards, "hub-shards", params.hubShards, "Number of goroutines sharing the viewers between them (0 for one per CPU)")
+	flag.Int64Var(&params.egressMaxBitrate, "egress-max-bitrate", params.egressMaxBitrate, "Bits per second all viewers together may be sent before -egress-policy applies (0 for unlimited)")
+	flag.StringVar(&params.egressPolicy, "egress-policy", params.egressPolicy, "What to do while viewers are sent more than the egress cap: reject new viewers or decimate")
+	flag.IntVar(&params.maxViewers, "max-viewers", params.maxViewers, "Maximum concurrent viewers of all streams together, turning further ones away with 503 (0 for unlimited)")
+	flag.Int64Var(&params.viewerMaxBitrate, "viewer-max-bitrate", params.viewerMaxBitrate, "Bits per second each WebSocket viewer may be sent, smoothing bursts (0 for unlimited)")
+	flag.Int64Var(&params.memoryLimit, "memory-limit", params.memoryLimit, "Bytes of memory in use above which the slowest viewers are disconnected and new ones turned away (0 for unlimited)")
+	flag.DurationVar(&params.chaosLatency, "chaos-latency", params.chaosLatency, "Delay every message to WebSocket viewers by this much, to test players (0 for none)")
+	flag.DurationVar(&params.chaosJitter, "chaos-jitter", params.chaosJitter, "Delay every message to WebSocket viewers by a random time up to this much more")
+	flag.Float64Var(&params.chaosDrop, "chaos-drop", params.chaosDrop, "Share of messages to WebSocket viewers to drop, between 0 and 1")
+	flag.Float64Var(&params.chaosReorder, "chaos-reorder", params.chaosReorder, "Share of messages to WebSocket viewers to send after the next one, between 0 and 1")
+	flag.DurationVar(&params.drainTimeout, "drain-timeout", params.drainTimeout, "Time allowed for viewers to receive queued data on shutdown")
+	flag.DurationVar(&params.handoffDrainTimeout, "handoff-drain-timeout", params.handoffDrainTimeout, "Time the old process gives its viewers and publishers to leave after an upgrade before disconnecting them (0 to disconnect them at once)")
+	flag.DurationVar(&params.httpReadHeaderTimeout, "http-read-header-timeout", params.httpReadHeaderTimeout, "Time a client gets to send the headers of a request (0 to wait forever)")
+	flag.DurationVar(&params.httpReadTimeout, "http-read-timeout", params.httpReadTimeout, "Time a client gets to send a request, except for publishers (0 to wait forever)")
+	flag.DurationVar(&params.httpWriteTimeout, "http-write-timeout", params.httpWriteTimeout, "Time allowed to write a response, except to streaming viewers (0 to wait forever)")
+	flag.DurationVar(&params.httpIdleTimeout, "http-idle-timeout", params.httpIdleTimeout, "Time an idle keep-alive connection is kept open (0 to use the read timeout)")
+
+	flag.StringVar(&params.allowedOrigins, "allowed-origins", params.allowedOrigins, "Comma separated origins allowed to open a WebSocket, wildcards allowed (default: same host name)")
+	flag.BoolVar(&params.allowAnyOrigin, "allow-any-origin", params.allowAnyOrigin, "Accept WebSocket connections from any origin")
+
+	flag.IntVar(&params.maxConnsPerIP, "max-conns-per-ip", params.maxConnsPerIP, "Maximum concurrent WebSocket connections per source IP (0 for unlimited)")
+	flag.IntVar(&params.maxUpgradesPerIP, "max-upgrades-per-ip", params.maxUpgradesPerIP, "Maximum WebSocket upgrade attempts per source IP within -upgrade-window (0 for unlimited)")
+	flag.DurationVar(&params.upgradeWindow, "upgrade-window", params.upgradeWindow, "Window over which -max-upgrades-per-ip is counted")
+
+	flag.StringVar(&params.ingestAllow, "ingest-allow", params.ingestAllow, "Comma separated CIDRs allowed to publish")
+	flag.StringVar(&params.ingestDeny, "ingest-deny", params.ingestDeny, "Comma separated CIDRs never allowed to publish")
+	flag.StringVar(&params.viewerAllow, "viewer-allow", params.viewerAllow, "Comma separated CIDRs allowed to view")
+	flag.StringVar(&params.viewerDeny, "viewer-deny", params.viewerDeny, "Comma separated CIDRs never allowed to view")
+
+	flag.StringVar(&params.jwtKey, "jwt-key", params.jwtKey, "HMAC key for viewer JWTs; viewers need a valid token when set")
+	flag.StringVar(&params.jwtStreamClaim, "jwt-stream-claim", params.jwtStreamClaim, "JWT claim naming the stream a viewer may watch (\"*\" for any); empty disables the check")
+	flag.StringVar(&params.jwtIssuer, "jwt-issuer", params.jwtIssuer, "Required JWT issuer (iss)")
+	flag.StringVar(&params.jwtAudience, "jwt-audience", params.jwtAudience, "Required JWT audience (aud)")
+
+	flag.BoolVar(&params.signedIngestOnly, "signed-ingest-only", params.signedIngestOnly, "Reject publishers that do not sign their requests with an API key")
+	flag.DurationVar(&params.ingestMaxSkew, "ingest-max-skew", params.ingestMaxSkew, "Maximum age of a signed ingest request")
+
+	flag.StringVar(&params.ingestClientCA, "ingest-client-ca", params.ingestClientCA, "CA bundle; publishers must present a client certificate signed by it")
+
+	flag.StringVar(&params.tlsCert, "tls-cert", params.tlsCert, "TLS certificate file; serves HTTPS/WSS when set with -tls-key")
+	flag.StringVar(&params.tlsKey, "tls-key", params.tlsKey, "TLS private key file")
+	flag.StringVar(&params.autocertHosts, "autocert-host", params.autocertHosts, "Comma separated hostnames to obtain Let's Encrypt certificates for")
+	flag.StringVar(&params.autocertCacheDir, "autocert-cache", params.autocertCacheDir, "Directory storing certificates obtained by -autocert-host")
+	flag.StringVar(&params.autocertEmail, "autocert-email", params.autocertEmail, "Contact email registered with the ACME account")
+
+	describeEnvFlags()
+	flag.Parse()
+
+	if err := applyEnvironment(); err != nil {
+		return nil, err
+	}
+
+	base := *params
+	params.base = &base
+
+	if err := params.applyConfigFile(); err != nil {
+		return nil, err
+	}
+
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+
+	return params, nil
+}
+
+// Validate catches settings that would otherwise only fail once a handler
+// applies them.
+func (p *Params) Validate() error {
+	if p.incomingSocket != "" && p.SingleAddr() != "" {
+		return fmt.Errorf("-incoming-socket cannot be combined with -single-port")
+	}
+	if p.ingestMaxBitrate < 0 || p.ingestMaxDuration < 0 || p.ingestReadTimeout < 0 {
+		return fmt.Errorf("ingest limits must not be negative")
+	}
+	if p.ingestChunkSize < tsPacketSize {
+		return fmt.Errorf("-ingest-chunk-size must be at least %d bytes", tsPacketSize)
+	}
+	if p.hlsSegmentDuration < time.Second || p.hlsPlaylistSize < 1 {
+		return fmt.Errorf("-hls-segment-duration must be at least 1s and -hls-playlist-size at least 1")
+	}
+	if p.hlsPartDuration < 0 || p.hlsPartDuration > p.hlsSegmentDuration/2 {
+		return fmt.Errorf("-hls-part-duration must be at most half of -hls-segment-duration")
+	}
+	if p.thumbnailInterval != 0 && p.thumbnailInterval < time.Second || p.thumbnailWidth < 16 {
+		return fmt.Errorf("-thumbnail-interval must be 0 or at least 1s and -thumbnail-width at least 16")
+	}
+	if err := validSlowClientPolicy(p.slowClientPolicy); err != nil {
+		return fmt.Errorf("-slow-client-policy: %v", err)
+	}
+	if p.slowClientMaxDrops < 1 {
+		return fmt.Errorf("-slow-client-max-drops must be at least 1")
+	}
+	if p.sendQueueSize < 1 || p.fmp4SendQueueSize < 0 {
+		return fmt.Errorf("-send-queue-size must be at least 1 and -fmp4-send-queue-size not negative")
+	}
+	if p.wsWriteTimeout < 0 || p.wsCloseTimeout < 0 {
+		return fmt.Errorf("-ws-write-timeout and -ws-close-timeout must not be negative")
+	}
+	if p.wsPingInterval < 0 || p.wsPongTimeout < 0 {
+		return fmt.Errorf("-ws-ping-interval and -ws-pong-timeout must not be negative")
+	}
+	if p.wsFlushInterval < 0 || p.wsCoalesceSize < tsPacketSize {
+		return fmt.Errorf("-ws-flush-interval must not be negative and -ws-coalesce-size must be at least %d", tsPacketSize)
+	}
+	if p.wsPingInterval > 0 && p.wsPongTimeout > 0 && p.wsPongTimeout <= p.wsPingInterval {
+		return fmt.Errorf("-ws-pong-timeout must be longer than -ws-ping-interval")
+	}
+	if p.handoffDrainTimeout < 0 {
+		return fmt.Errorf("-handoff-drain-timeout must not be negative")
+	}
+	if p.httpReadHeaderTimeout < 0 || p.httpReadTimeout < 0 || p.httpWriteTimeout < 0 || p.httpIdleTimeout < 0 {
+		return fmt.Errorf("HTTP timeouts must not be negative")
+	}
+	if err := validWSBackend(p.wsBackend); err != nil {
+		return err
+	}
+	if p.tcpSendBuffer < 0 {
+		return fmt.Errorf("-tcp-send-buffer must not be negative")
+	}
+	if p.hubShards < 0 {
+		return fmt.Errorf("-hub-shards must not be negative")
+	}
+	if p.maxViewers < 0 {
+		return fmt.Errorf("-max-viewers must not be negative")
+	}
+	if p.memoryLimit < 0 {
+		return fmt.Errorf("-memory-limit must not be negative")
+	}
+	if err := validChaosSettings(p); err != nil {
+		return err
+	}
+	if p.recordSegmentDuration < 0 || p.recordSegmentSize < 0 {
+		return fmt.Errorf("-record-segment-duration and -record-segment-size must not be negative")
+	}
+	if p.recordSegmentDuration == 0 && p.recordSegmentSize == 0 {
+		return fmt.Errorf("-record-segment-duration or -record-segment-size must be set")
+	}
+	if p.recordMaxAge < 0 || p.recordMaxBytes < 0 {
+		return fmt.Errorf("-record-max-age and -record-max-bytes must not be negative")
+	}
+	if _, err := NewRecordingStorage(p.recordStorage); err != nil {
+		return fmt.Errorf("-record-storage: %v", err)
+	}
+	if p.egressMaxBitrate < 0 || p.viewerMaxBitrate < 0 {
+		return fmt.Errorf("-egress-max-bitrate and -viewer-max-bitrate must not be negative")
+	}
+	if err := validEgressPolicy(p.egressPolicy); err != nil {
+		return fmt.Errorf("-egress-policy: %v", err)
+	}
+	if p.wsCompressionLevel < flate.BestSpeed || p.wsCompressionLevel > flate.BestCompression {
+		return fmt.Errorf("-ws-compression-level must be between 1 and 9")
+	}
+	if p.resumeBufferSize < 0 {
+		return fmt.Errorf("-resume-buffer-size must not be negative")
+	}
+	if err := validVideoSize(p.width, p.height); err != nil {
+		return fmt.Errorf("-width and -height: %v", err)
+	}
+	if p.webTransportPort != 0 && p.tlsCert == "" && p.autocertHosts == "" {
+		return fmt.Errorf("-webtransport-port requires TLS (-tls-cert/-tls-key or -autocert-host)")
+	}
+	if p.rtmpIngest != "" {
+		if _, _, err := net.SplitHostPort(p.rtmpIngest); err != nil {
+			return fmt.Errorf("invalid -rtmp-ingest address: %v", err)
+		}
+	}
+	if (p.AdminAddr() != "" || p.AdminGRPCAddr() != "") && p.adminToken == "" {
+		return fmt.Errorf("the admin API requires -admin-token")
+	}
+	if _, err := NewAccessControl(p, true); err != nil {
+		return fmt.Errorf("ingest access: %v", err)
+	}
+	if _, err := NewAccessControl(p, false); err != nil {
+		return fmt.Errorf("viewer access: %v", err)
+	}
+
+	return nil
+}
+
+func (p *Params) applyConfigFile() error {
+	if p.configFile == "" {
+		return nil
+	}
+
+	config, err := LoadConfigFile(p.configFile)
+	if err != nil {
+		return err
+	}
+	config.Apply(p, explicitFlags())
+
+	return nil
+}
+
+func (p *Params) WebSocketAddr() string {
+	if p.websocketAddr != "" {
+		return p.websocketAddr
+	}
+	return fmt.Sprintf("0.0.0.0:%d", p.websocketPort)
+}
+
+func (p *Params) IncomingAddr() string {
+	if p.incomingAddr != "" {
+		return p.incomingAddr
+	}
+	return fmt.Sprintf("0.0.0.0:%d", p.incomingPort)
+}
+
+// AdminAddr returns the listen address of a dedicated admin API listener, or
+// "" when there is none.
+func (p *Params) AdminAddr() string {
+	if p.adminAddr != "" {
+		return p.adminAddr
+	}
+	if p.adminPort != 0 {
+		return fmt.Sprintf("0.0.0.0:%d", p.adminPort)
+	}
+	return ""
+}
+
+// AdminGRPCAddr returns the listen address of the admin gRPC API, or "" when
+// it is disabled.
+func (p *Params) AdminGRPCAddr() string {
+	if p.adminGRPCAddr != "" {
+		return p.adminGRPCAddr
+	}
+	if p.adminGRPCPort != 0 {
+		return fmt.Sprintf("0.0.0.0:%d", p.adminGRPCPort)
+	}
+	return ""
+}
+
+// WebTransportAddr returns the UDP listen address of WebTransport delivery,
+// or "" when it is disabled.
+func (p *Params) WebTransportAddr() string {
+	if p.webTransportPort != 0 {
+		return fmt.Sprintf("0.0.0.0:%d", p.webTransportPort)
+	}
+	return ""
+}
+
+// SingleAddr returns the shared listen address in single-port mode, or "" when
+// every endpoint has its own port.
+func (p *Params) SingleAddr() string {
+	if p.singleAddr != "" {
+		return p.singleAddr
+	}
+	if p.singlePort != 0 {
+		return fmt.Sprintf("0.0.0.0:%d", p.singlePort)
+	}
+	return ""
+}
+
+func (p *Params) LoadTLSConfig() error {
+	if err := p.loadServerTLSConfig(); err != nil {
+		return err
+	}
+
+	return p.loadIngestClientCAs()
+}
+
+func (p *Params) loadServerTLSConfig() error {
+	if p.autocertHosts != "" {
+		if p.tlsCert != "" || p.tlsKey != "" {
+			return fmt.Errorf("-autocert-host cannot be combined with -tls-cert/-tls-key")
+		}
+
+		p.autocertManager = &autocert.Manager{
+			Prompt: autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(strings.Split(p.autocertHosts, ",")...),
+			Cache: autocert.DirCache(p.autocertCacheDir),
+			Email: p.autocertEmail,
+		}
+		p.tlsConfig = p.autocertManager.TLSConfig()
+
+		return nil
+	}
+
+	if p.tlsCert == "" && p.tlsKey == "" {
+		return nil
+	}
+	if p.tlsCert == "" || p.tlsKey == "" {
+		return fmt.Errorf("both -tls-cert and -tls-key are required for TLS")
+	}
+
+	cert, err := tls.LoadX509KeyPair(p.tlsCert, p.tlsKey)
+	if err != nil {
+		return fmt.Errorf("loading TLS key pair: %v", err)
+	}
+
+	p.tlsConfig = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+
+	return nil
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "token" {
+		if err := TokenCommand(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "loadtest" {
+		if err := LoadTestCommand(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "soak" {
+		if err := SoakCommand(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	params, err := ParseParams()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := params.LoadTLSConfig(); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Println("StreamServer parameters")
+	log.Println("  SECRET: " + params.secret)
+	if params.singlePort != 0 {
+		log.Println("  SinglePort: " + strconv.Itoa(params.singlePort))
+	} else {
+		log.Println("  IncomingPort: " + strconv.Itoa(params.incomingPort))
+		log.Println("  WebSocketPort: " + strconv.Itoa(params.websocketPort))
+	}
+	log.Println("  TLS: " + strconv.FormatBool(params.tlsConfig != nil))
+	log.Println("  ViewerJWT: " + strconv.FormatBool(params.jwtKey != ""))
+	if params.testSource {
+		log.Println("  TestSource: " + params.testSourceStream)
+	}
+	for _, stream := range params.streams {
+		log.Println("  Stream: " + stream.Name)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server := newServer(params)
+	if err := server.Run(ctx); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("StreamServer stopped")
 }
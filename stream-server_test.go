@@ -0,0 +1,114 @@
+package main
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+// testClient returns the one viewer of stream once it is registered.
+func testClient(t *testing.T, h *WebSocketHandler, stream string) *Client {
+	t.Helper()
+
+	var client *Client
+	waitFor(t, "the viewer to register", func() bool {
+		for _, shard := range h.shards {
+			if clients := shard.clients(stream); len(clients) == 1 {
+				client = clients[0]
+				return true
+			}
+		}
+		return false
+	})
+	return client
+}
+
+// broadcastTestFragments hands stream's fMP4 viewers an init segment of
+// initSize bytes and count fragments of size bytes, returning the Buffers
+// after dropping the test's own hold on them.
+func broadcastTestFragments(h *WebSocketHandler, stream string, initSize, size, count int) []*Buffer {
+	pool := NewBufferPool()
+	init := pool.Copy(make([]byte, initSize))
+	buffers := []*Buffer{init}
+	for i := 0; i < count; i++ {
+		data := pool.Copy(make([]byte, size))
+		h.broadcastFragment(stream, &FMP4Fragment{init: init, data: data, keyframe: true})
+		buffers = append(buffers, data)
+	}
+	for _, buffer := range buffers {
+		buffer.Release()
+	}
+	return buffers
+}
+
+// checkClientGone fails the test unless the viewer of stream unregistered
+// exactly once and every Buffer it held was released.
+func checkClientGone(t *testing.T, h *WebSocketHandler, stream string, buffers []*Buffer) {
+	t.Helper()
+
+	waitFor(t, "the viewer to leave and release its Buffers", func() bool {
+		for _, buffer := range buffers {
+			if buffer.refs.Load() != 0 {
+				return false
+			}
+		}
+		return len(h.ViewerCounts()) == 0
+	})
+	// Give a second unregistration the time to show.
+	time.Sleep(50 * time.Millisecond)
+	if registered, unregistered := shardTotals(h); registered != 1 || unregistered != 1 {
+		t.Errorf("registered %d time(s) and unregistered %d time(s), want once each", registered, unregistered)
+	}
+}
+
+// TestViewerDroppedMidWrite drops a viewer while it is written a message too
+// large for the socket buffers, with more queued behind it.
+func TestViewerDroppedMidWrite(t *testing.T) {
+	h, server := newTestHub(t)
+	const stream = "write"
+
+	viewer, err := dialViewer(server, "stream="+stream+"&format=fmp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := testClient(t, h, stream)
+
+	buffers := broadcastTestFragments(h, stream, 16<<20, 1<<10, 4)
+	viewer.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := viewer.reader.ReadByte(); err != nil {
+		t.Fatalf("reading the init segment: %v", err)
+	}
+	waitFor(t, "the fragments to be queued", func() bool {
+		return len(client.sendChan) == 4
+	})
+
+	viewer.drop()
+	checkClientGone(t, h, stream, buffers)
+}
+
+// TestViewerDroppedMidRead drops a viewer halfway through a frame it sends.
+func TestViewerDroppedMidRead(t *testing.T) {
+	h, server := newTestHub(t)
+	const stream = "read"
+
+	viewer, err := dialViewer(server, "stream="+stream+"&format=fmp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testClient(t, h, stream)
+	buffers := broadcastTestFragments(h, stream, 1<<10, 1<<10, 4)
+	// Five binary frames with a 4 byte header each.
+	viewer.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(viewer.reader, make([]byte, 5*(4+1<<10))); err != nil {
+		t.Fatalf("reading the fragments: %v", err)
+	}
+
+	// A masked binary frame of 100 bytes, cut off after 10 of them.
+	frame := append([]byte{0x82, 0x80 | 100, 1, 2, 3, 4}, make([]byte, 10)...)
+	if _, err := viewer.conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+
+	viewer.drop()
+	checkClientGone(t, h, stream, buffers)
+}
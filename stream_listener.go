@@ -0,0 +1,148 @@
+package main
+
+import (
+	"github.com/gorilla/mux"
+
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// streamListenerKey marks requests that came in on a StreamListener.
+type streamListenerKey struct{}
+
+// StreamListener is the ingest endpoint of a single stream on an address or
+// Unix socket of its own, so each camera can get its own firewall rule. It
+// only accepts that stream's credentials: "/<secret>" for HTTP publishers,
+// "/publish/<secret>" for WebSocket publishers and signed requests to "/".
+// A Unix socket also takes raw MPEG-TS like the shared one.
+type StreamListener struct {
+	addr    string
+	stream  string
+	handler *IncomingStreamHandler
+
+	srv    *http.Server
+	closed bool
+	lock   sync.Mutex
+
+	logger *log.Logger
+}
+
+func NewStreamListeners(params *Params, handler *IncomingStreamHandler) map[string]*StreamListener {
+	listeners := make(map[string]*StreamListener)
+	for _, stream := range params.streams {
+		if stream.IngestListen == "" {
+			continue
+		}
+
+		listener := &StreamListener{
+			addr:    stream.IngestListen,
+			stream:  stream.Name,
+			handler: handler,
+			logger:  params.logger,
+		}
+
+		r := mux.NewRouter()
+		r.HandleFunc("/", listener.HandleSignedPost).Headers(ingestKeyHeader, "")
+		r.HandleFunc("/publish/{secret}", listener.HandleWebSocketPublish)
+		r.HandleFunc("/{secret}", listener.HandlePost)
+
+		var h http.Handler = r
+		if strings.HasPrefix(listener.addr, "unix:") {
+			h = localPublisher(r)
+		}
+		listener.srv = trackConns(withTimeouts(&http.Server{
+			Handler:   h,
+			TLSConfig: params.IngestTLSConfig(false),
+			ErrorLog:  params.logger,
+			BaseContext: func(net.Listener) context.Context {
+				return context.WithValue(context.Background(), streamListenerKey{}, listener)
+			},
+		}, params))
+		listeners[stream.Name] = listener
+	}
+
+	return listeners
+}
+
+func (l *StreamListener) Run() {
+	l.lock.Lock()
+	if l.closed {
+		l.lock.Unlock()
+		return
+	}
+	listener, err := l.listen()
+	l.lock.Unlock()
+	if err != nil {
+		l.logger.Printf("Ingest listener for stream %s: %v\n", l.stream, err)
+		return
+	}
+
+	l.logger.Printf("Ingest listening at %s (stream %s)\n", l.addr, l.stream)
+
+	if path, ok := strings.CutPrefix(l.addr, "unix:"); ok {
+		listener = newSniffListener(listener, func(conn net.Conn) {
+			l.handler.PublishConn(conn, "unix:"+path, l.stream)
+		})
+	}
+	if l.srv.TLSConfig != nil {
+		err = l.srv.ServeTLS(listener, "", "")
+	} else {
+		err = l.srv.Serve(listener)
+	}
+	if err != nil && err != http.ErrServerClosed {
+		l.logger.Printf("Ingest listener for stream %s stopped: %v\n", l.stream, err)
+	}
+}
+
+func (l *StreamListener) listen() (net.Listener, error) {
+	path, ok := strings.CutPrefix(l.addr, "unix:")
+	if !ok {
+		return l.handler.handoff.Listen("tcp", l.addr)
+	}
+	return l.handler.handoff.Listen("unix", path)
+}
+
+func (l *StreamListener) HandlePost(w http.ResponseWriter, r *http.Request) {
+	if !l.handler.AcceptsSecret(l.stream, mux.Vars(r)["secret"]) {
+		http.NotFound(w, r)
+		return
+	}
+
+	l.handler.Publish(w, r, l.stream)
+}
+
+func (l *StreamListener) HandleWebSocketPublish(w http.ResponseWriter, r *http.Request) {
+	if !l.handler.AcceptsSecret(l.stream, mux.Vars(r)["secret"]) {
+		http.NotFound(w, r)
+		return
+	}
+
+	l.handler.secretsLock.RLock()
+	upgrader := l.handler.upgrader
+	l.handler.secretsLock.RUnlock()
+
+	l.handler.PublishWebSocket(w, r, l.stream, upgrader)
+}
+
+func (l *StreamListener) HandleSignedPost(w http.ResponseWriter, r *http.Request) {
+	if err := l.handler.verifier.Verify(r, l.stream); err != nil {
+		l.logger.Printf("Signed publish from %s rejected: %v\n", r.RemoteAddr, err)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	l.handler.Publish(w, r, l.stream)
+}
+
+// Close stops the listener together with its publisher connections.
+func (l *StreamListener) Close() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.closed = true
+	l.srv.Close()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// tableRepeat is how long a stream may go without a PAT and PMT before the
+// cached ones are repeated in its broadcast.
+const tableRepeat = time.Second
+
+// TableCache keeps the latest PAT and PMT packets of every stream. New
+// viewers are sent them first, and they are repeated when the publisher
+// sends its tables less often than tableRepeat, so demuxers joining mid-stream
+// find the program without waiting for the encoder. Repeated packets keep
+// their continuity counter and count as duplicates for demuxers that already
+// have the tables.
+type TableCache struct {
+	streams map[string]*tableStream
+	lock    sync.Mutex
+}
+
+type tableStream struct {
+	pending []byte
+	pat     []byte
+	pmt     []byte
+	pmtPID  uint16
+	sent    time.Time // the tables last went out
+}
+
+func NewTableCache() *TableCache {
+	return &TableCache{streams: make(map[string]*tableStream)}
+}
+
+// Write adds data broadcast on stream and returns the tables to send ahead
+// of it when they are due, or nil. Tables only go ahead of data starting
+// with a whole packet.
+func (c *TableCache) Write(stream string, data []byte) []byte {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	s, ok := c.streams[stream]
+	if !ok {
+		s = &tableStream{sent: time.Now()}
+		c.streams[stream] = s
+	}
+
+	aligned := len(s.pending) == 0 && len(data) > 0 && data[0] == tsSyncByte
+	seen := false
+	tsPackets(&s.pending, data, func(packet []byte) {
+		pid := packetPID(packet)
+		switch {
+		case pid == 0:
+			if pmtPID, ok := parsePAT(packet); ok {
+				s.pat = append(s.pat[:0], packet...)
+				s.pmtPID = pmtPID
+			}
+		case pid == s.pmtPID && s.pmtPID != 0:
+			if _, ok := parsePMTStreams(packet); ok {
+				s.pmt = append(s.pmt[:0], packet...)
+				seen = true
+			}
+		}
+	})
+
+	if seen {
+		s.sent = time.Now()
+		return nil
+	}
+	if !aligned || s.pmt == nil || time.Since(s.sent) < tableRepeat {
+		return nil
+	}
+	s.sent = time.Now()
+	return s.tables()
+}
+
+// tables must be called with the lock held.
+func (s *tableStream) tables() []byte {
+	if s.pat == nil || s.pmt == nil {
+		return nil
+	}
+	return append(append([]byte{}, s.pat...), s.pmt...)
+}
+
+// Tables returns the PAT and PMT of stream, or nil before both are known.
+func (c *TableCache) Tables(stream string) []byte {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if s, ok := c.streams[stream]; ok {
+		return s.tables()
+	}
+	return nil
+}
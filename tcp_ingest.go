@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxPreambleSize bounds the token line a TCP publisher sends first.
+const maxPreambleSize = 256
+
+// TCPSource listens on a stream's own TCP port for raw MPEG-TS, as written by
+// "ffmpeg -f mpegts tcp://host:port". The publisher first sends the stream's
+// secret followed by a newline, then the transport stream.
+type TCPSource struct {
+	addr    string
+	stream  string
+	handler *IncomingStreamHandler
+
+	listener net.Listener
+	closed   bool
+	lock     sync.Mutex
+
+	logger *log.Logger
+}
+
+func NewTCPSources(params *Params, handler *IncomingStreamHandler) []Source {
+	sources := []Source{}
+	for _, stream := range params.streams {
+		if stream.TCPIngest == "" {
+			continue
+		}
+
+		sources = append(sources, &TCPSource{
+			addr:    stream.TCPIngest,
+			stream:  stream.Name,
+			handler: handler,
+			logger:  params.logger,
+		})
+	}
+
+	return sources
+}
+
+func (t *TCPSource) Run() {
+	t.lock.Lock()
+	if t.closed {
+		t.lock.Unlock()
+		return
+	}
+	listener, err := t.handler.handoff.Listen("tcp", t.addr)
+	if err != nil {
+		t.lock.Unlock()
+		t.logger.Printf("TCP ingest for stream %s: %v\n", t.stream, err)
+		return
+	}
+	t.listener = listener
+	t.lock.Unlock()
+
+	t.logger.Printf("TCP ingest listening at %s (stream %s)\n", t.addr, t.stream)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go t.serve(conn)
+	}
+}
+
+func (t *TCPSource) serve(conn net.Conn) {
+	remoteAddr := conn.RemoteAddr().String()
+
+	t.handler.secretsLock.RLock()
+	access := t.handler.access
+	secret, ok := t.handler.streamSecrets[t.stream]
+	if !ok {
+		secret = t.handler.secret
+	}
+	t.handler.secretsLock.RUnlock()
+
+	if !access.AllowsIP(net.ParseIP(hostname(remoteAddr)), t.stream) {
+		t.logger.Printf("IncomingStream %s not allowed on stream %s\n", remoteAddr, t.stream)
+		conn.Close()
+		return
+	}
+
+	reader := bufio.NewReaderSize(conn, maxPreambleSize)
+	conn.SetReadDeadline(time.Now().Add(sniffTimeout))
+	token, err := reader.ReadSlice('\n')
+	conn.SetReadDeadline(time.Time{})
+	if err != nil || subtle.ConstantTimeCompare([]byte(strings.TrimRight(string(token), "\r\n")), []byte(secret)) != 1 {
+		t.logger.Printf("IncomingStream %s rejected: invalid preamble token\n", remoteAddr)
+		conn.Close()
+		return
+	}
+
+	t.handler.PublishConn(&peekedConn{Conn: conn, reader: reader}, remoteAddr, t.stream)
+}
+
+func (t *TCPSource) Close() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.closed = true
+	if t.listener != nil {
+		t.listener.Close()
+	}
+}
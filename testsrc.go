@@ -0,0 +1,20 @@
+package main
+
+// testSourceArgs make ffmpeg generate a 640x480 test pattern with a running
+// timestamp and a 440 Hz tone, at real-time speed.
+var testSourceArgs = []string{
+	"-re",
+	"-f", "lavfi", "-i", "testsrc=size=640x480:rate=30",
+	"-f", "lavfi", "-i", "sine=frequency=440:sample_rate=44100",
+}
+
+// NewTestSource returns an FFmpegSource publishing a generated test pattern
+// to the test source stream, or nil when it is off. It gives a stream to
+// check the setup with before any camera is connected.
+func NewTestSource(params *Params, handler *IncomingStreamHandler) *FFmpegSource {
+	if !params.testSource {
+		return nil
+	}
+
+	return NewFFmpegSource("testsrc", params.testSourceStream, testSourceArgs, params, handler)
+}
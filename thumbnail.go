@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Thumbnail is the latest preview image of a stream.
+type Thumbnail struct {
+	JPEG    []byte
+	Updated time.Time
+	ETag    string
+}
+
+// ThumbnailService renews a small JPEG of every stream being broadcast each
+// interval, from the stream's latest keyframe.
+type ThumbnailService struct {
+	snapshots *SnapshotCache
+	interval  time.Duration
+	width     int
+
+	seen       map[string]time.Time // stream -> last broadcast
+	thumbnails map[string]*Thumbnail
+	lock       sync.Mutex
+	quit       chan struct{}
+
+	logger *log.Logger
+}
+
+// NewThumbnailService returns nil when thumbnails are disabled.
+func NewThumbnailService(params *Params, snapshots *SnapshotCache) *ThumbnailService {
+	if params.thumbnailInterval == 0 {
+		return nil
+	}
+
+	return &ThumbnailService{
+		snapshots:  snapshots,
+		interval:   params.thumbnailInterval,
+		width:      params.thumbnailWidth,
+		seen:       make(map[string]time.Time),
+		thumbnails: make(map[string]*Thumbnail),
+		quit:       make(chan struct{}),
+		logger:     params.logger,
+	}
+}
+
+// Seen notes that data was broadcast on stream.
+func (t *ThumbnailService) Seen(stream string) {
+	t.lock.Lock()
+	t.seen[stream] = time.Now()
+	t.lock.Unlock()
+}
+
+// Run renews the thumbnails until Close.
+func (t *ThumbnailService) Run() {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			t.update()
+		case <-t.quit:
+			return
+		}
+	}
+}
+
+// update renews the thumbnails of the streams broadcast since the last one.
+func (t *ThumbnailService) update() {
+	t.lock.Lock()
+	streams := []string{}
+	for stream, seen := range t.seen {
+		if time.Since(seen) > t.interval {
+			delete(t.seen, stream)
+			continue
+		}
+		streams = append(streams, stream)
+	}
+	t.lock.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), snapshotWait)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, stream := range streams {
+		wg.Add(1)
+		go func(stream string) {
+			defer wg.Done()
+			if err := t.render(ctx, stream); err != nil {
+				t.logger.Printf("Thumbnail of stream %s failed: %v\n", stream, err)
+			}
+		}(stream)
+	}
+	wg.Wait()
+}
+
+func (t *ThumbnailService) render(ctx context.Context, stream string) error {
+	_, keyframe, seq, err := t.snapshots.keyframe(ctx, stream)
+	if err != nil {
+		return err
+	}
+	jpeg, err := t.snapshots.encode(ctx, keyframe, t.width)
+	if err != nil {
+		return err
+	}
+
+	t.lock.Lock()
+	t.thumbnails[stream] = &Thumbnail{
+		JPEG:    jpeg,
+		Updated: time.Now(),
+		ETag:    fmt.Sprintf(`"%x-%d"`, seq, len(jpeg)),
+	}
+	t.lock.Unlock()
+
+	return nil
+}
+
+// Thumbnail returns the latest thumbnail of stream, or nil.
+func (t *ThumbnailService) Thumbnail(stream string) *Thumbnail {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.thumbnails[stream]
+}
+
+// Close stops renewing the thumbnails.
+func (t *ThumbnailService) Close() {
+	close(t.quit)
+}
+
+// thumbnailPath returns where the thumbnail of stream is served, or "" when
+// it has none.
+func (h *WebSocketHandler) thumbnailPath(stream string) string {
+	if h.thumbnails == nil || h.thumbnails.Thumbnail(stream) == nil {
+		return ""
+	}
+	return "/thumbnail/" + url.PathEscape(stream) + ".jpg"
+}
+
+// ServeThumbnail answers with the thumbnail of a stream, which browsers and
+// proxies may cache until the next one is due.
+func (h *WebSocketHandler) ServeThumbnail(w http.ResponseWriter, r *http.Request) {
+	stream := streamName(r)
+	if !h.allowHTTPViewer(w, r, stream) {
+		return
+	}
+
+	var thumbnail *Thumbnail
+	if h.thumbnails != nil {
+		thumbnail = h.thumbnails.Thumbnail(stream)
+	}
+	if thumbnail == nil {
+		http.Error(w, "No thumbnail available", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(h.thumbnails.interval/time.Second)))
+	w.Header().Set("ETag", thumbnail.ETag)
+	http.ServeContent(w, r, "", thumbnail.Updated, bytes.NewReader(thumbnail.JPEG))
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"github.com/golang-jwt/jwt/v5"
+
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+)
+
+// NewViewerToken signs a token that lets its holder watch stream until ttl
+// has passed, on at most limit.MaxSessions connections at once, each sent at
+// most limit.MaxBitrate bits per second. The
+// ViewerAuthenticator configured with the same key accepts it.
+func NewViewerToken(key, streamClaim, stream string, ttl time.Duration, limit SessionLimit) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iat": now.Unix(),
+		"exp": now.Add(ttl).Unix(),
+	}
+	if streamClaim != "" {
+		claims[streamClaim] = stream
+	}
+	if limit.TokenID != "" {
+		claims["jti"] = limit.TokenID
+	}
+	if limit.MaxBitrate > 0 {
+		claims[maxBitrateClaim] = limit.MaxBitrate
+	}
+	if limit.MaxSessions > 0 {
+		claims[maxSessionsClaim] = limit.MaxSessions
+		if limit.Displace {
+			claims[sessionPolicyClaim] = "displace"
+		}
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(key))
+}
+
+// TokenCommand implements "stream-server token", printing a time limited
+// viewer token and, with -url, the link to hand out.
+func TokenCommand(args []string) error {
+	flags := flag.NewFlagSet("token", flag.ExitOnError)
+	key := flags.String("jwt-key", os.Getenv("JSMPEG_JWT_KEY"), "HMAC key the server was started with (env JSMPEG_JWT_KEY)")
+	streamClaim := flags.String("jwt-stream-claim", "stream", "JWT claim naming the stream")
+	stream := flags.String("stream", defaultStreamName, "Stream the token grants access to (\"*\" for every stream)")
+	ttl := flags.Duration("ttl", time.Hour, "How long the token stays valid")
+	maxSessions := flags.Int("max-sessions", 0, "How many connections may use the token at once (0 for unlimited)")
+	displace := flags.Bool("displace", false, "Let a new connection over -max-sessions close the oldest one instead of being refused")
+	maxBitrate := flags.Int64("max-bitrate", 0, "Bits per second each connection using the token may be sent (0 for the server's cap)")
+	pageURL := flags.String("url", "", "Demo page URL, e.g. https://stream.example.com/; prints a ready viewer link")
+	flags.Parse(args)
+
+	if *key == "" {
+		return fmt.Errorf("-jwt-key is required")
+	}
+
+	limit := SessionLimit{MaxSessions: *maxSessions, Displace: *displace, MaxBitrate: *maxBitrate}
+	if limit.MaxSessions > 0 {
+		limit.TokenID = randomSecret()
+	}
+
+	token, err := NewViewerToken(*key, *streamClaim, *stream, *ttl, limit)
+	if err != nil {
+		return err
+	}
+
+	if *pageURL == "" {
+		fmt.Println(token)
+		return nil
+	}
+
+	link, err := url.Parse(*pageURL)
+	if err != nil {
+		return err
+	}
+	query := link.Query()
+	if *stream != "*" {
+		query.Set("stream", *stream)
+	}
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+	fmt.Println(link.String())
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"time"
+)
+
+// maxBitrateClaim caps the throughput of each connection made with a viewer
+// token, overriding the stream's cap so tiers can be granted more or less.
+const maxBitrateClaim = "max_bitrate"
+
+// TokenBucket smooths what is written to one viewer to a bitrate. It holds up
+// to a second's worth of bytes, so short bursts go through at once; a write
+// larger than what is left waits for the bucket to refill, while what the
+// viewer has yet to read stays in its ring or bounded queue. Only the
+// viewer's writer uses it.
+type TokenBucket struct {
+	rate   float64 // bytes per second
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket returns a bucket for bitrate bits per second, or nil for 0.
+func NewTokenBucket(bitrate int64) *TokenBucket {
+	if bitrate <= 0 {
+		return nil
+	}
+	rate := float64(bitrate) / 8
+	return &TokenBucket{rate: rate, tokens: rate, last: time.Now()}
+}
+
+// Wait takes n bytes from the bucket, first waiting for what the bucket owes
+// unless quit or gone closes. A write larger than the bucket leaves it in
+// debt, which the next write waits out.
+func (b *TokenBucket) Wait(n int, quit, gone <-chan struct{}) {
+	now := time.Now()
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*b.rate, b.rate)
+	b.last = now
+
+	if b.tokens < 0 {
+		timer := time.NewTimer(time.Duration(-b.tokens / b.rate * float64(time.Second)))
+		select {
+		case <-timer.C:
+		case <-quit:
+		case <-gone:
+		}
+		timer.Stop()
+		b.tokens = 0
+		b.last = time.Now()
+	}
+	b.tokens -= float64(n)
+}
+
+// viewerMaxBitrate returns the throughput cap of a new viewer of stream
+// without a max_bitrate claim, 0 for none.
+func (h *WebSocketHandler) viewerMaxBitrate(stream string) int64 {
+	h.settingsLock.RLock()
+	defer h.settingsLock.RUnlock()
+
+	if bitrate, ok := h.streamMaxBitrates[stream]; ok {
+		return bitrate
+	}
+	return h.maxBitrate
+}
+
+// limitRate waits until the client may be sent n more bytes. A closed
+// client is no longer held back, so it drains at once.
+func (c *Client) limitRate(n int) {
+	if c.bucket != nil {
+		c.bucket.Wait(n, c.quit, c.readDone)
+	}
+}
+
+// maxBitrate returns the client's throughput cap, 0 for none.
+func (c *Client) maxBitrate() int64 {
+	if c.bucket == nil {
+		return 0
+	}
+	return int64(c.bucket.rate * 8)
+}
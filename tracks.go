@@ -0,0 +1,171 @@
+package main
+
+import (
+	"sync"
+)
+
+// Track selections of a viewer, chosen with the tracks query parameter.
+const (
+	tracksAll   = ""
+	tracksAudio = "audio"
+	tracksVideo = "video"
+)
+
+// Frame selections of a viewer, chosen with the frames query parameter.
+// framesIntra keeps only the pictures a decoder can show on their own.
+const (
+	framesAll   = ""
+	framesIntra = "intra"
+)
+
+// Stream types in a PMT that carry audio.
+var audioStreamTypes = map[byte]bool{
+	0x03: true, // MPEG-1 audio
+	0x04: true, // MPEG-2 audio
+	0x0f: true, // AAC
+	0x11: true, // AAC LATM
+	0x81: true, // AC-3
+	0x87: true, // E-AC-3
+}
+
+// validTracks reports whether tracks is a track selection viewers may ask
+// for.
+func validTracks(tracks string) bool {
+	return tracks == tracksAll || tracks == tracksAudio || tracks == tracksVideo
+}
+
+func validFrames(frames string) bool {
+	return frames == framesAll || frames == framesIntra
+}
+
+// subscription is the part of a stream a viewer asked for.
+type subscription struct {
+	tracks string
+	frames string
+}
+
+func (c *Client) subscription() subscription {
+	return subscription{tracks: c.tracks, frames: c.frames}
+}
+
+// TrackFilters cut the data broadcast on a stream down to the tracks and
+// frames some of its viewers asked for. The PAT and PMT are always kept,
+// unchanged.
+type TrackFilters struct {
+	streams map[string]*trackFilter
+	lock    sync.Mutex
+}
+
+type trackFilter struct {
+	pending []byte
+	pmtPID  uint16
+	audio   map[uint16]bool
+
+	videoPID  uint16
+	videoType byte
+	intra     bool // the current picture stands on its own
+}
+
+func NewTrackFilters() *TrackFilters {
+	return &TrackFilters{streams: make(map[string]*trackFilter)}
+}
+
+// Filter returns data cut down to the subscription of each view that has
+// one.
+func (f *TrackFilters) Filter(stream string, data []byte, views []ringView) map[subscription][]byte {
+	filtered := make(map[subscription][]byte)
+	for _, view := range views {
+		if view.sub != (subscription{}) {
+			filtered[view.sub] = make([]byte, 0, len(data))
+		}
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	s, ok := f.streams[stream]
+	if !ok {
+		s = &trackFilter{}
+		f.streams[stream] = s
+	}
+
+	tsPackets(&s.pending, data, func(packet []byte) {
+		pid := packetPID(packet)
+		tables := pid == 0 || (pid == s.pmtPID && s.pmtPID != 0)
+		switch {
+		case pid == 0:
+			if pmtPID, ok := parsePAT(packet); ok {
+				s.pmtPID = pmtPID
+			}
+		case tables:
+			if streams, ok := parsePMTStreams(packet); ok {
+				s.audio = make(map[uint16]bool)
+				for pid, streamType := range streams {
+					if audioStreamTypes[streamType] {
+						s.audio[pid] = true
+					}
+				}
+			}
+			if videoPID, videoType, ok := parsePMT(packet); ok {
+				s.videoPID = videoPID
+				s.videoType = videoType
+			}
+		case pid == s.videoPID && s.videoPID != 0 && packetPayloadStart(packet):
+			s.intra = isIntra(packet, s.videoType)
+		}
+
+		for sub, out := range filtered {
+			if tables || s.keeps(sub, pid) {
+				filtered[sub] = append(out, packet...)
+			}
+		}
+	})
+
+	return filtered
+}
+
+// keeps reports whether the packets of pid belong to sub. Audio-only keeps
+// the audio PIDs; video-only drops them and keeps the rest, such as
+// subtitles. Intra-only drops the video of other pictures. Nothing is kept
+// before the PMT is known.
+func (s *trackFilter) keeps(sub subscription, pid uint16) bool {
+	if s.audio == nil {
+		return false
+	}
+	switch {
+	case sub.tracks == tracksAudio && !s.audio[pid]:
+		return false
+	case sub.tracks == tracksVideo && s.audio[pid]:
+		return false
+	case sub.frames == framesIntra && pid == s.videoPID && !s.intra:
+		return false
+	}
+	return true
+}
+
+// isIntra reports whether the PES packet starting in packet holds a picture
+// that decodes without the ones before it: an MPEG-1/2 I picture, going by
+// its picture header, or a keyframe of another codec. An MPEG picture whose
+// header is not in the first packet counts as intra when a sequence header
+// precedes it.
+func isIntra(packet []byte, streamType byte) bool {
+	if streamType != streamTypeMPEG1Video && streamType != streamTypeMPEG2Video {
+		return isKeyframe(packet, streamType)
+	}
+
+	if pictureType, ok := mpegPictureType(packet); ok {
+		return pictureType == mpegPictureI
+	}
+	return isKeyframe(packet, streamType)
+}
+
+// Skip tells the filter of stream that data went by unfiltered, so a packet
+// split across chunks is not put together wrongly.
+func (f *TrackFilters) Skip(stream string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if s, ok := f.streams[stream]; ok {
+		s.pending = nil
+	}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+)
+
+// pcrClock is the frequency of MPEG-TS program clock references.
+const pcrClock = 27000000
+
+// packetPID returns the PID of a transport stream packet.
+func packetPID(packet []byte) uint16 {
+	return uint16(packet[1]&0x1f)<<8 | uint16(packet[2])
+}
+
+// packetPCR returns the program clock reference carried in the adaptation
+// field of a transport stream packet, in 27 MHz ticks.
+func packetPCR(packet []byte) (uint64, bool) {
+	if len(packet) < 12 || packet[3]&0x20 == 0 || packet[4] < 7 || packet[5]&0x10 == 0 {
+		return 0, false
+	}
+
+	base := uint64(packet[6])<<25 | uint64(packet[7])<<17 | uint64(packet[8])<<9 | uint64(packet[9])<<1 | uint64(packet[10])>>7
+	extension := uint64(packet[10]&0x01)<<8 | uint64(packet[11])
+
+	return base*300 + extension, true
+}
+
+// Stream types in a PMT that carry video jsmpeg or an HLS player can decode.
+const (
+	streamTypeMPEG1Video = 0x01
+	streamTypeMPEG2Video = 0x02
+	streamTypeH264       = 0x1b
+	streamTypeHEVC       = 0x24
+)
+
+// packetPayloadStart reports whether a PES packet or table section starts in
+// the packet.
+func packetPayloadStart(packet []byte) bool {
+	return packet[1]&0x40 != 0
+}
+
+// packetPayload returns the payload of a transport stream packet after its
+// adaptation field.
+func packetPayload(packet []byte) []byte {
+	if len(packet) < tsPacketSize || packet[3]&0x10 == 0 {
+		return nil
+	}
+	offset := 4
+	if packet[3]&0x20 != 0 {
+		offset += 1 + int(packet[4])
+	}
+	if offset >= tsPacketSize {
+		return nil
+	}
+
+	return packet[offset:tsPacketSize]
+}
+
+// packetSection returns the PSI section starting in the packet, without its
+// CRC, if it has the given table ID and fits in the packet.
+func packetSection(packet []byte, tableID byte) []byte {
+	payload := packetPayload(packet)
+	if !packetPayloadStart(packet) || len(payload) == 0 {
+		return nil
+	}
+	start := 1 + int(payload[0])
+	if start+3 > len(payload) || payload[start] != tableID {
+		return nil
+	}
+
+	length := int(payload[start+1]&0x0f)<<8 | int(payload[start+2])
+	end := start + 3 + length - 4
+	if length < 9 || end > len(payload) {
+		return nil
+	}
+
+	return payload[start:end]
+}
+
+// parsePAT returns the PMT PID of the first program in a PAT packet.
+func parsePAT(packet []byte) (uint16, bool) {
+	section := packetSection(packet, 0x00)
+	for i := 8; i+4 <= len(section); i += 4 {
+		program := uint16(section[i])<<8 | uint16(section[i+1])
+		if program != 0 {
+			return uint16(section[i+2]&0x1f)<<8 | uint16(section[i+3]), true
+		}
+	}
+
+	return 0, false
+}
+
+// parsePMT returns the PID and stream type of the first video stream in a
+// PMT packet.
+func parsePMT(packet []byte) (uint16, byte, bool) {
+	section := packetSection(packet, 0x02)
+	if len(section) < 12 {
+		return 0, 0, false
+	}
+
+	i := 12 + (int(section[10]&0x0f)<<8 | int(section[11]))
+	for i+5 <= len(section) {
+		streamType := section[i]
+		pid := uint16(section[i+1]&0x1f)<<8 | uint16(section[i+2])
+		switch streamType {
+		case streamTypeMPEG1Video, streamTypeMPEG2Video, streamTypeH264, streamTypeHEVC:
+			return pid, streamType, true
+		}
+		i += 5 + (int(section[i+3]&0x0f)<<8 | int(section[i+4]))
+	}
+
+	return 0, 0, false
+}
+
+// parsePMTStreams returns the stream type of every elementary stream in a
+// PMT packet by PID.
+func parsePMTStreams(packet []byte) (map[uint16]byte, bool) {
+	section := packetSection(packet, 0x02)
+	if len(section) < 12 {
+		return nil, false
+	}
+
+	streams := make(map[uint16]byte)
+	i := 12 + (int(section[10]&0x0f)<<8 | int(section[11]))
+	for i+5 <= len(section) {
+		pid := uint16(section[i+1]&0x1f)<<8 | uint16(section[i+2])
+		streams[pid] = section[i]
+		i += 5 + (int(section[i+3]&0x0f)<<8 | int(section[i+4]))
+	}
+
+	return streams, true
+}
+
+// isKeyframe reports whether the PES packet starting in packet begins a
+// picture a decoder can start from: an MPEG video sequence header, or an
+// H.264 or HEVC parameter set or IDR picture.
+func isKeyframe(packet []byte, streamType byte) bool {
+	payload := packetPayload(packet)
+	if !packetPayloadStart(packet) || len(payload) < 9 || payload[0] != 0 || payload[1] != 0 || payload[2] != 1 {
+		return false
+	}
+
+	es := payload[min(9+int(payload[8]), len(payload)):]
+	for i := 0; i+3 < len(es); i++ {
+		if es[i] != 0 || es[i+1] != 0 || es[i+2] != 1 {
+			continue
+		}
+		code := es[i+3]
+		switch streamType {
+		case streamTypeMPEG1Video, streamTypeMPEG2Video:
+			if code == 0xb3 {
+				return true
+			}
+		case streamTypeH264:
+			if nal := code & 0x1f; nal == 5 || nal == 7 {
+				return true
+			}
+		case streamTypeHEVC:
+			if nal := code >> 1 & 0x3f; nal >= 16 && nal <= 21 || nal == 32 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// MPEG-1/2 picture_coding_type of an intra-coded picture.
+const mpegPictureI = 1
+
+// mpegPictureType returns the picture_coding_type of the first MPEG-1/2
+// picture header in the PES packet starting in packet.
+func mpegPictureType(packet []byte) (byte, bool) {
+	payload := packetPayload(packet)
+	if !packetPayloadStart(packet) || len(payload) < 9 || payload[0] != 0 || payload[1] != 0 || payload[2] != 1 {
+		return 0, false
+	}
+
+	es := payload[min(9+int(payload[8]), len(payload)):]
+	for i := 0; i+5 < len(es); i++ {
+		if es[i] == 0 && es[i+1] == 0 && es[i+2] == 1 && es[i+3] == 0x00 {
+			return es[i+5] >> 3 & 0x07, true
+		}
+	}
+
+	return 0, false
+}
+
+// tsPackets calls fn with every whole packet of data, starting with the
+// bytes held in pending from the previous call, and keeps a packet split at
+// the end in pending. Bytes before a sync byte are skipped.
+func tsPackets(pending *[]byte, data []byte, fn func(packet []byte)) {
+	if len(*pending) > 0 {
+		data = append(*pending, data...)
+		*pending = nil
+	}
+
+	for len(data) > 0 {
+		if data[0] != tsSyncByte {
+			skip := bytes.IndexByte(data, tsSyncByte)
+			if skip < 0 {
+				return
+			}
+			data = data[skip:]
+		}
+		if len(data) < tsPacketSize {
+			*pending = append([]byte{}, data...)
+			return
+		}
+
+		fn(data[:tsPacketSize])
+		data = data[tsPacketSize:]
+	}
+}
+
+// VideoPESReader collects the PES packets of the first video stream of an
+// MPEG-TS stream and hands each complete one to fn with its decode and
+// presentation timestamps. Video PES packets rarely carry a length, so one
+// ends where the next starts.
+type VideoPESReader struct {
+	fn func(streamType byte, dts, pts int64, es []byte)
+
+	pending    []byte
+	pmtPID     uint16
+	pid        uint16
+	streamType byte
+	pes        []byte
+}
+
+func NewVideoPESReader(fn func(streamType byte, dts, pts int64, es []byte)) *VideoPESReader {
+	return &VideoPESReader{fn: fn}
+}
+
+func (r *VideoPESReader) Write(data []byte) {
+	tsPackets(&r.pending, data, r.packet)
+}
+
+func (r *VideoPESReader) packet(packet []byte) {
+	pid := packetPID(packet)
+	switch {
+	case pid == 0:
+		if pmtPID, ok := parsePAT(packet); ok {
+			r.pmtPID = pmtPID
+		}
+	case pid == r.pmtPID && r.pmtPID != 0:
+		if videoPID, streamType, ok := parsePMT(packet); ok {
+			r.pid = videoPID
+			r.streamType = streamType
+		}
+	case pid == r.pid && r.pid != 0:
+		payload := packetPayload(packet)
+		if packetPayloadStart(packet) {
+			r.flush()
+			r.pes = append([]byte{}, payload...)
+		} else if r.pes != nil {
+			r.pes = append(r.pes, payload...)
+		}
+	}
+}
+
+func (r *VideoPESReader) flush() {
+	pes := r.pes
+	r.pes = nil
+	if len(pes) < 14 || pes[0] != 0 || pes[1] != 0 || pes[2] != 1 || pes[7]&0x80 == 0 {
+		return
+	}
+	start := 9 + int(pes[8])
+	if start > len(pes) {
+		return
+	}
+
+	pts := pesTimestamp(pes[9:])
+	dts := pts
+	if pes[7]&0xc0 == 0xc0 && len(pes) >= 19 {
+		dts = pesTimestamp(pes[14:])
+	}
+	r.fn(r.streamType, dts, pts, pes[start:])
+}
+
+// pesTimestamp reads a 33 bit PTS or DTS field.
+func pesTimestamp(b []byte) int64 {
+	return int64(b[0]>>1&0x07)<<30 | int64(b[1])<<22 | int64(b[2]>>1)<<14 | int64(b[3])<<7 | int64(b[4]>>1)
+}
+
+// wrap33 maps the difference of two 33 bit timestamps to -2^32..2^32.
+func wrap33(d int64) int64 {
+	d &= 1<<33 - 1
+	if d >= 1<<32 {
+		d -= 1 << 33
+	}
+	return d
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	tsPacketSize = 188
+	tsSyncByte   = 0x47
+
+	// udpIdleTimeout is how long a UDP publisher may stay silent before its
+	// stream is free for another publisher.
+	udpIdleTimeout = 5 * time.Second
+)
+
+// SMPTE 2022-1 sends column FEC two ports above the media and row FEC four
+// ports above.
+var fecPortOffsets = []int{2, 4}
+
+// UDPIngest receives raw MPEG-TS datagrams, as sent by
+// "ffmpeg -f mpegts udp://host:port", or MPEG-TS over RTP and broadcasts them
+// to one stream. The first sender holds the stream until it goes quiet;
+// datagrams from other addresses are dropped in the meantime.
+//
+// With FEC, lost RTP packets are rebuilt from the SMPTE 2022-1 FEC the
+// publisher sends to the FEC ports, as long as that happens within the
+// jitter buffer delay.
+type UDPIngest struct {
+	addr     string
+	stream   string
+	jitter   *JitterBuffer // nil for raw MPEG-TS
+	fec      *FECDecoder   // nil without FEC
+	handler  *IncomingStreamHandler
+	conn     *net.UDPConn
+	fecConns []*net.UDPConn
+
+	lock    sync.Mutex // guards the rest against the FEC readers
+	source  string
+	session *PublishSession
+	pending []byte
+	logger  *log.Logger
+}
+
+// NewUDPIngests returns the raw UDP and the RTP listeners that are configured.
+func NewUDPIngests(params *Params, handler *IncomingStreamHandler) []*UDPIngest {
+	ingests := []*UDPIngest{}
+	if params.udpIngest != "" {
+		ingests = append(ingests, &UDPIngest{
+			addr:    params.udpIngest,
+			stream:  params.udpStream,
+			handler: handler,
+			logger:  params.logger,
+		})
+	}
+	if params.rtpIngest != "" {
+		ingest := &UDPIngest{
+			addr:    params.rtpIngest,
+			stream:  params.rtpStream,
+			jitter:  NewJitterBuffer(params.rtpJitter),
+			handler: handler,
+			logger:  params.logger,
+		}
+		if params.rtpFEC {
+			ingest.fec = NewFECDecoder()
+		}
+		ingests = append(ingests, ingest)
+	}
+
+	return ingests
+}
+
+// Listen opens the UDP socket so Run can start receiving.
+func (u *UDPIngest) Listen() error {
+	addr, err := net.ResolveUDPAddr("udp", u.addr)
+	if err != nil {
+		return err
+	}
+
+	u.conn, err = net.ListenUDP("udp", addr)
+	if err != nil || u.fec == nil {
+		return err
+	}
+
+	port := u.conn.LocalAddr().(*net.UDPAddr).Port
+	for _, offset := range fecPortOffsets {
+		fecAddr := *addr
+		fecAddr.Port = port + offset
+		conn, err := net.ListenUDP("udp", &fecAddr)
+		if err != nil {
+			u.Close()
+			return err
+		}
+		u.fecConns = append(u.fecConns, conn)
+	}
+
+	return nil
+}
+
+func (u *UDPIngest) Run() {
+	kind := "UDP"
+	if u.jitter != nil {
+		kind = "RTP"
+	}
+	u.logger.Printf("%s ingest listening at %s (stream %s)\n", kind, u.conn.LocalAddr(), u.stream)
+	for _, conn := range u.fecConns {
+		u.logger.Printf("FEC ingest listening at %s (stream %s)\n", conn.LocalAddr(), u.stream)
+		go u.runFEC(conn)
+	}
+	defer func() {
+		u.lock.Lock()
+		u.release()
+		u.lock.Unlock()
+	}()
+
+	buf := make([]byte, 65536)
+	lastRead := time.Now()
+	for {
+		deadline := lastRead.Add(udpIdleTimeout)
+		if u.jitter != nil {
+			u.lock.Lock()
+			if flush, ok := u.jitter.Deadline(); ok && flush.Before(deadline) {
+				deadline = flush
+			}
+			u.lock.Unlock()
+		}
+		u.conn.SetReadDeadline(deadline)
+
+		n, from, err := u.conn.ReadFromUDP(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err, ok := err.(net.Error); ok && err.Timeout() {
+			u.lock.Lock()
+			if u.jitter != nil && u.session != nil && !u.session.Superseded() {
+				u.broadcast(u.jitter.Pop(time.Now()))
+			}
+			if time.Since(lastRead) >= udpIdleTimeout {
+				u.release()
+				lastRead = time.Now()
+			}
+			u.lock.Unlock()
+			continue
+		}
+		if err != nil {
+			u.logger.Printf("%s ingest read failed: %v\n", kind, err)
+			continue
+		}
+
+		if u.receive(buf[:n], from) {
+			lastRead = time.Now()
+		}
+	}
+}
+
+// receive handles a datagram on the media port and reports whether it came
+// from the publisher.
+func (u *UDPIngest) receive(datagram []byte, from *net.UDPAddr) bool {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	if u.session == nil && !u.acquire(from) {
+		return false
+	}
+	if from.String() != u.source || u.session.Superseded() {
+		return false
+	}
+
+	if u.jitter == nil {
+		u.broadcast([][]byte{datagram})
+		return true
+	}
+
+	packet, err := ParseRTP(datagram)
+	if err != nil {
+		return true
+	}
+	now := time.Now()
+	if u.fec != nil {
+		u.fec.Add(packet)
+	}
+	u.jitter.Push(packet, now)
+	u.broadcast(u.jitter.Pop(now))
+
+	return true
+}
+
+// runFEC reads FEC packets from the publisher's host on conn and puts the
+// media packets they recover into the jitter buffer.
+func (u *UDPIngest) runFEC(conn *net.UDPConn) {
+	buf := make([]byte, 65536)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			u.logger.Printf("FEC ingest read failed: %v\n", err)
+			continue
+		}
+
+		fec, err := ParseFEC(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		u.lock.Lock()
+		if u.session != nil && !u.session.Superseded() && fromHost(from, u.source) {
+			now := time.Now()
+			for _, packet := range u.fec.Repair(fec) {
+				u.jitter.Push(packet, now)
+			}
+			u.broadcast(u.jitter.Pop(now))
+		}
+		u.lock.Unlock()
+	}
+}
+
+// fromHost reports whether addr has the IP of source, an address in
+// host:port form.
+func fromHost(addr *net.UDPAddr, source string) bool {
+	host, _, err := net.SplitHostPort(source)
+	return err == nil && addr.IP.Equal(net.ParseIP(host))
+}
+
+func (u *UDPIngest) broadcast(payloads [][]byte) {
+	for _, payload := range payloads {
+		if data := u.packets(payload); len(data) > 0 {
+			u.session.meter.Add(len(data))
+			u.handler.Broadcast(u.session, data)
+		}
+	}
+}
+
+// acquire makes from the publisher of the stream if it is allowed to.
+func (u *UDPIngest) acquire(from *net.UDPAddr) bool {
+	u.handler.secretsLock.RLock()
+	access := u.handler.access
+	u.handler.secretsLock.RUnlock()
+
+	if !access.AllowsIP(from.IP, u.stream) {
+		return false
+	}
+
+	session, err := u.handler.publisherLock.AcquireAddr(u.stream, from.String(), nil, false)
+	if err != nil {
+		return false
+	}
+
+	u.handler.publishers.Add(1)
+	u.logger.Printf("IncomingStream connected: %s (stream %s, UDP)\n", from, u.stream)
+	u.session = session
+	u.source = from.String()
+	u.pending = nil
+	if u.jitter != nil {
+		u.jitter = NewJitterBuffer(u.jitter.delay)
+	}
+	if u.fec != nil {
+		u.fec = NewFECDecoder()
+	}
+
+	return true
+}
+
+func (u *UDPIngest) release() {
+	if u.session == nil {
+		return
+	}
+
+	u.handler.endPublish(u.session)
+	u.session = nil
+	u.source = ""
+}
+
+// packets appends datagram to the bytes left over from the previous one and
+// returns the complete TS packets, skipping ahead to the next sync byte when
+// the stream got out of step.
+func (u *UDPIngest) packets(datagram []byte) []byte {
+	u.pending = append(u.pending, datagram...)
+	for len(u.pending) > 0 && u.pending[0] != tsSyncByte {
+		u.pending = u.pending[1:]
+	}
+
+	n := len(u.pending) / tsPacketSize * tsPacketSize
+	data := make([]byte, n)
+	copy(data, u.pending[:n])
+	u.pending = append(u.pending[:0], u.pending[n:]...)
+
+	return data
+}
+
+func (u *UDPIngest) Close() error {
+	for _, conn := range u.fecConns {
+		conn.Close()
+	}
+	return u.conn.Close()
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/webtransport-go"
+
+	"log"
+	"net/http"
+	"sync"
+)
+
+// Delivery modes of a WebTransport viewer.
+const (
+	webTransportStream   = "stream"
+	webTransportDatagram = "datagram"
+)
+
+// datagramPackets is the number of TS packets sent in one datagram, which
+// keeps datagrams below the usual QUIC limit of about 1200 bytes.
+const datagramPackets = 6
+
+// webTransportQueue bounds the chunks waiting for a WebTransport viewer; a
+// viewer that falls this far behind is disconnected.
+const webTransportQueue = 512
+
+// WebTransportServer delivers streams over WebTransport (HTTP/3) as an
+// alternative to WebSocket. A viewer opening /wt/{stream} gets the MPEG-TS
+// on one unidirectional stream, or with ?mode=datagram as unreliable
+// datagrams of whole TS packets, which never wait for a lost one to be sent
+// again.
+type WebTransportServer struct {
+	hub *WebSocketHandler
+	srv *webtransport.Server
+
+	sessions map[string]map[*webTransportViewer]bool // stream -> viewers
+	lock     sync.Mutex
+
+	logger *log.Logger
+}
+
+type webTransportViewer struct {
+	session *webtransport.Session
+	mode    string
+	queue   chan *Buffer
+}
+
+// NewWebTransportServer returns nil when WebTransport is disabled.
+func NewWebTransportServer(params *Params, hub *WebSocketHandler) *WebTransportServer {
+	addr := params.WebTransportAddr()
+	if addr == "" {
+		return nil
+	}
+
+	s := &WebTransportServer{
+		hub:      hub,
+		sessions: make(map[string]map[*webTransportViewer]bool),
+		logger:   params.logger,
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/wt/{stream}", s.ServeWebTransport)
+	h3 := &http3.Server{
+		Addr:      addr,
+		TLSConfig: http3.ConfigureTLSConfig(params.tlsConfig),
+		Handler:   r,
+	}
+	webtransport.ConfigureHTTP3Server(h3)
+	s.srv = &webtransport.Server{
+		H3: h3,
+		CheckOrigin: func(r *http.Request) bool {
+			hub.settingsLock.RLock()
+			upgrader := hub.upgrader
+			hub.settingsLock.RUnlock()
+
+			return upgrader.CheckOrigin(r)
+		},
+	}
+
+	return s
+}
+
+// Run serves WebTransport viewers until Close.
+func (s *WebTransportServer) Run() {
+	s.logger.Println("WebTransport listening at " + s.srv.H3.Addr)
+	if err := s.srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		s.logger.Printf("WebTransport stopped: %v\n", err)
+	}
+}
+
+// Close disconnects every viewer and stops the server.
+func (s *WebTransportServer) Close() {
+	s.srv.Close()
+}
+
+func (s *WebTransportServer) ServeWebTransport(w http.ResponseWriter, r *http.Request) {
+	stream := streamName(r)
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = webTransportStream
+	}
+	if mode != webTransportStream && mode != webTransportDatagram {
+		http.Error(w, "Unknown mode", http.StatusBadRequest)
+		return
+	}
+	if !s.hub.allowHTTPViewer(w, r, stream) {
+		return
+	}
+
+	ip, ok := s.hub.acquireHTTPViewer(w, r)
+	if !ok {
+		return
+	}
+	defer s.hub.limiter.Release(ip)
+
+	session, err := s.srv.Upgrade(w, r)
+	if err != nil {
+		s.logger.Printf("WebTransport upgrade of %s failed: %v\n", r.RemoteAddr, err)
+		return
+	}
+
+	viewer := &webTransportViewer{
+		session: session,
+		mode:    mode,
+		queue:   make(chan *Buffer, webTransportQueue),
+	}
+	s.add(stream, viewer)
+	defer func() {
+		s.remove(stream, viewer)
+		viewer.release()
+	}()
+	s.logger.Printf("WebTransport viewer %s connected to stream %s (%s)\n", r.RemoteAddr, stream, mode)
+
+	if err := viewer.run(); err != nil {
+		s.logger.Printf("WebTransport viewer %s: %v\n", r.RemoteAddr, err)
+	}
+	session.CloseWithError(0, "")
+}
+
+func (s *WebTransportServer) add(stream string, viewer *webTransportViewer) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	viewers, ok := s.sessions[stream]
+	if !ok {
+		viewers = make(map[*webTransportViewer]bool)
+		s.sessions[stream] = viewers
+	}
+	viewers[viewer] = true
+}
+
+func (s *WebTransportServer) remove(stream string, viewer *webTransportViewer) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.sessions[stream], viewer)
+	if len(s.sessions[stream]) == 0 {
+		delete(s.sessions, stream)
+	}
+}
+
+// Write queues data broadcast on stream for its viewers. A viewer whose
+// queue is full is disconnected rather than holding up the publisher.
+func (s *WebTransportServer) Write(stream string, data *Buffer) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for viewer := range s.sessions[stream] {
+		data.Retain()
+		select {
+		case viewer.queue <- data:
+		default:
+			data.Release()
+			delete(s.sessions[stream], viewer)
+			viewer.session.CloseWithError(1, "too slow")
+		}
+	}
+}
+
+// run sends the queued data until the session ends.
+func (v *webTransportViewer) run() error {
+	ctx := v.session.Context()
+
+	if v.mode == webTransportDatagram {
+		for {
+			select {
+			case data := <-v.queue:
+				err := v.sendDatagrams(data.Bytes())
+				data.Release()
+				if err != nil {
+					return err
+				}
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+
+	stream, err := v.session.OpenUniStreamSync(ctx)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	for {
+		select {
+		case data := <-v.queue:
+			_, err := stream.Write(data.Bytes())
+			data.Release()
+			if err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// release drops the data still queued for a viewer that was removed, so its
+// Buffers go back to the pool.
+func (v *webTransportViewer) release() {
+	for {
+		select {
+		case data := <-v.queue:
+			data.Release()
+		default:
+			return
+		}
+	}
+}
+
+// sendDatagrams sends data in datagrams of whole TS packets.
+func (v *webTransportViewer) sendDatagrams(data []byte) error {
+	for len(data) > 0 {
+		n := min(len(data), datagramPackets*tsPacketSize)
+		if err := v.session.SendDatagram(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/pion/webrtc/v4"
+	"github.com/pion/webrtc/v4/pkg/media"
+
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits of a WHEP offer.
+const (
+	maxWHEPOfferSize  = 64 << 10
+	whepGatherTimeout = 10 * time.Second
+)
+
+// WHEPServer plays streams to WebRTC viewers negotiating through WHEP, the
+// WebRTC-HTTP Egress Protocol. The H.264 video of a stream is sent as
+// published, so browsers decode it in hardware with sub-second latency;
+// audio and non-H.264 video are not sent. A viewer posts its SDP offer to
+// /whep/{stream} and deletes the returned session URL when it leaves.
+type WHEPServer struct {
+	hub    *WebSocketHandler
+	api    *webrtc.API
+	config webrtc.Configuration
+
+	sessions map[string]*WHEPSession
+	streams  map[string]*whepStream
+	lock     sync.Mutex
+
+	logger *log.Logger
+}
+
+// WHEPSession is one WebRTC viewer.
+type WHEPSession struct {
+	id     string
+	stream string
+	ip     string
+	pc     *webrtc.PeerConnection
+	track  *webrtc.TrackLocalStaticSample
+	synced bool // has been sent a keyframe
+}
+
+// whepStream turns the MPEG-TS of a stream watched over WebRTC into samples.
+type whepStream struct {
+	reader   *VideoPESReader
+	sessions map[*WHEPSession]bool
+	rawDTS   int64
+	hasDTS   bool
+	duration time.Duration
+	lock     sync.Mutex
+}
+
+// NewWHEPServer returns nil when WHEP is disabled.
+func NewWHEPServer(params *Params, hub *WebSocketHandler) *WHEPServer {
+	if !params.whep {
+		return nil
+	}
+
+	settings := webrtc.SettingEngine{}
+	if params.whepPublicIP != "" {
+		settings.SetNAT1To1IPs([]string{params.whepPublicIP}, webrtc.ICECandidateTypeHost)
+	}
+
+	config := webrtc.Configuration{}
+	for _, url := range strings.Split(params.whepICEServers, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			config.ICEServers = append(config.ICEServers, webrtc.ICEServer{URLs: []string{url}})
+		}
+	}
+
+	return &WHEPServer{
+		hub:      hub,
+		api:      webrtc.NewAPI(webrtc.WithSettingEngine(settings)),
+		config:   config,
+		sessions: make(map[string]*WHEPSession),
+		streams:  make(map[string]*whepStream),
+		logger:   params.logger,
+	}
+}
+
+// Write adds data broadcast on stream, unless it has no WebRTC viewers.
+func (w *WHEPServer) Write(stream string, data []byte) {
+	w.lock.Lock()
+	s := w.streams[stream]
+	w.lock.Unlock()
+
+	if s != nil {
+		s.lock.Lock()
+		s.reader.Write(data)
+		s.lock.Unlock()
+	}
+}
+
+// frame sends one access unit to the viewers of s, starting every viewer at
+// a keyframe. A frame lasts as long as the step from the previous one.
+func (s *whepStream) frame(streamType byte, dts, pts int64, es []byte) {
+	if streamType != streamTypeH264 {
+		return
+	}
+
+	if s.hasDTS {
+		if step := wrap33(dts - s.rawDTS); step > 0 && step <= fmp4MaxFrameGap {
+			s.duration = time.Duration(step) * time.Second / fmp4Timescale
+		}
+	}
+	s.rawDTS = dts
+	s.hasDTS = true
+
+	keyframe := false
+	for _, nal := range splitNALs(es) {
+		if nal[0]&0x1f == nalIDR {
+			keyframe = true
+		}
+	}
+
+	for session := range s.sessions {
+		if !session.synced {
+			if !keyframe {
+				continue
+			}
+			session.synced = true
+		}
+		session.track.WriteSample(media.Sample{Data: es, Duration: s.duration})
+	}
+}
+
+// newWHEPSessionID returns a random session ID. The session URL needs no
+// further authentication to be deleted, so the ID must not be guessable.
+func newWHEPSessionID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+
+	return hex.EncodeToString(buf)
+}
+
+func (w *WHEPServer) add(session *WHEPSession) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	session.id = newWHEPSessionID()
+	w.sessions[session.id] = session
+
+	s, ok := w.streams[session.stream]
+	if !ok {
+		s = &whepStream{sessions: make(map[*WHEPSession]bool), duration: time.Second / 25}
+		s.reader = NewVideoPESReader(s.frame)
+		w.streams[session.stream] = s
+	}
+	s.lock.Lock()
+	s.sessions[session] = true
+	s.lock.Unlock()
+}
+
+// remove ends a session and reports whether it was still running.
+func (w *WHEPServer) remove(session *WHEPSession) bool {
+	w.lock.Lock()
+	if w.sessions[session.id] != session {
+		w.lock.Unlock()
+		return false
+	}
+	delete(w.sessions, session.id)
+	if s := w.streams[session.stream]; s != nil {
+		s.lock.Lock()
+		delete(s.sessions, session)
+		if len(s.sessions) == 0 {
+			delete(w.streams, session.stream)
+		}
+		s.lock.Unlock()
+	}
+	w.lock.Unlock()
+
+	session.pc.Close()
+	w.hub.limiter.Release(session.ip)
+	w.logger.Printf("WHEP session %s left stream %s\n", session.id, session.stream)
+
+	return true
+}
+
+// Close ends every session.
+func (w *WHEPServer) Close() {
+	w.lock.Lock()
+	sessions := []*WHEPSession{}
+	for _, session := range w.sessions {
+		sessions = append(sessions, session)
+	}
+	w.lock.Unlock()
+
+	for _, session := range sessions {
+		w.remove(session)
+	}
+}
+
+// whepRoutes serves the WHEP endpoint when WebRTC playback is enabled.
+func (h *WebSocketHandler) whepRoutes(r *mux.Router) {
+	if h.whep == nil {
+		return
+	}
+
+	r.HandleFunc("/whep/{stream}", h.ServeWHEP).Methods("POST", "OPTIONS")
+	r.HandleFunc("/whep/{stream}/{id}", h.ServeWHEPSession).Methods("DELETE", "PATCH", "OPTIONS")
+}
+
+// ServeWHEP answers a viewer's SDP offer and starts a session.
+func (h *WebSocketHandler) ServeWHEP(w http.ResponseWriter, r *http.Request) {
+	if !h.allowCORS(w, r, "POST, DELETE, PATCH, OPTIONS") || r.Method == "OPTIONS" {
+		return
+	}
+
+	stream := streamName(r)
+	if !h.allowHTTPViewer(w, r, stream) {
+		return
+	}
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/sdp") {
+		http.Error(w, "Expected application/sdp", http.StatusUnsupportedMediaType)
+		return
+	}
+	offer, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWHEPOfferSize))
+	if err != nil {
+		http.Error(w, "Invalid offer", http.StatusBadRequest)
+		return
+	}
+
+	ip, ok := h.acquireHTTPViewer(w, r)
+	if !ok {
+		return
+	}
+
+	session, answer, err := h.whep.negotiate(r, stream, ip, string(offer))
+	if err != nil {
+		h.limiter.Release(ip)
+		h.logger.Printf("WHEP offer from %s rejected: %v\n", r.RemoteAddr, err)
+		http.Error(w, "Negotiation failed", http.StatusBadRequest)
+		return
+	}
+	h.logger.Printf("WHEP session %s of %s started on stream %s\n", session.id, r.RemoteAddr, stream)
+
+	w.Header().Set("Content-Type", "application/sdp")
+	w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+session.id)
+	w.WriteHeader(http.StatusCreated)
+	io.WriteString(w, answer)
+}
+
+// negotiate sets up a peer connection for offer and returns the answer with
+// every ICE candidate, since sessions do not take trickled candidates.
+func (w *WHEPServer) negotiate(r *http.Request, stream, ip, offer string) (*WHEPSession, string, error) {
+	pc, err := w.api.NewPeerConnection(w.config)
+	if err != nil {
+		return nil, "", err
+	}
+
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264}, "video", stream)
+	if err != nil {
+		pc.Close()
+		return nil, "", err
+	}
+	sender, err := pc.AddTrack(track)
+	if err != nil {
+		pc.Close()
+		return nil, "", err
+	}
+	go func() {
+		// Read the viewer's RTCP so the interceptors keep working.
+		buf := make([]byte, 1500)
+		for {
+			if _, _, err := sender.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer}); err != nil {
+		pc.Close()
+		return nil, "", err
+	}
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		pc.Close()
+		return nil, "", err
+	}
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(answer); err != nil {
+		pc.Close()
+		return nil, "", err
+	}
+	select {
+	case <-gathered:
+	case <-time.After(whepGatherTimeout):
+	case <-r.Context().Done():
+		pc.Close()
+		return nil, "", r.Context().Err()
+	}
+
+	session := &WHEPSession{stream: stream, ip: ip, pc: pc, track: track}
+	w.add(session)
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		if state == webrtc.PeerConnectionStateFailed || state == webrtc.PeerConnectionStateClosed {
+			w.remove(session)
+		}
+	})
+
+	return session, pc.LocalDescription().SDP, nil
+}
+
+// ServeWHEPSession ends a session on DELETE. Trickled candidates are not
+// supported.
+func (h *WebSocketHandler) ServeWHEPSession(w http.ResponseWriter, r *http.Request) {
+	if !h.allowCORS(w, r, "POST, DELETE, PATCH, OPTIONS") || r.Method == "OPTIONS" {
+		return
+	}
+	if r.Method == "PATCH" {
+		http.Error(w, "Trickle ICE is not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	h.whep.lock.Lock()
+	session := h.whep.sessions[mux.Vars(r)["id"]]
+	h.whep.lock.Unlock()
+	if session == nil || session.stream != streamName(r) || !h.whep.remove(session) {
+		http.NotFound(w, r)
+		return
+	}
+}
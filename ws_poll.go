@@ -0,0 +1,404 @@
+package main
+
+import (
+	"github.com/gorilla/websocket"
+
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// WebSocket backends for viewers. gorilla gives every viewer a goroutine
+// reading its connection and buffers of -readbuffer and
+// -writebuffer; poll has one goroutine per server watch every viewer's
+// socket with epoll and writes frames straight to the socket, for servers
+// with tens of thousands of mostly idle viewers. poll needs Linux and plain
+// TCP: viewers over TLS, and servers elsewhere, use gorilla.
+const (
+	wsBackendGorilla = "gorilla"
+	wsBackendPoll    = "poll"
+)
+
+func validWSBackend(backend string) error {
+	switch backend {
+	case wsBackendGorilla, wsBackendPoll:
+		return nil
+	}
+	return fmt.Errorf("unknown WebSocket backend %q, expected %s or %s", backend, wsBackendGorilla, wsBackendPoll)
+}
+
+// pollControlTimeout bounds writing the answer to a viewer's ping or close
+// frame, which the poller hands to a goroutine of its own.
+const pollControlTimeout = time.Second
+
+// maxControlPayload is the largest payload of a control frame, RFC 6455 5.5.
+const maxControlPayload = 125
+
+var (
+	errPollProtocol  = errors.New("websocket: protocol error")
+	errPollCloseSent = errors.New("websocket: close sent")
+	errPollClosed    = errors.New("websocket: closed by the viewer")
+)
+
+// viewerConn is the WebSocket connection of a viewer as its Client uses it.
+// *websocket.Conn is one, *pollConn the other.
+type viewerConn interface {
+	RemoteAddr() net.Addr
+	WriteMessage(messageType int, data []byte) error
+	WriteControl(messageType int, data []byte, deadline time.Time) error
+	SetWriteDeadline(t time.Time) error
+	SetReadDeadline(t time.Time) error
+	Close() error
+}
+
+// upgrade completes the WebSocket handshake of a viewer of stream with the
+// hub's backend.
+func (h *WebSocketHandler) upgrade(w http.ResponseWriter, r *http.Request, upgrader *websocket.Upgrader, responseHeader http.Header, stream string) (viewerConn, error) {
+	if h.poller != nil && r.TLS == nil {
+		ws, err := h.poller.Upgrade(w, r, responseHeader, upgrader.CheckOrigin)
+		if err != nil {
+			return nil, err
+		}
+		h.tuneSocket(ws)
+		return ws, nil
+	}
+
+	ws, err := upgrader.Upgrade(w, r, responseHeader)
+	if err != nil {
+		return nil, err
+	}
+	h.applyCompression(ws, stream)
+	h.tuneSocket(ws)
+	return ws, nil
+}
+
+// pollConn is a viewer's WebSocket connection under the poll backend. The
+// poller reads it when the viewer sends something, which is seldom more than
+// a pong; the viewer's writer writes each frame with one writev, without a
+// buffer of its own.
+type pollConn struct {
+	conn   net.Conn
+	raw    syscall.RawConn
+	fd     int
+	poller *Poller
+	client *Client
+
+	writeLock     sync.Mutex
+	writeDeadline time.Time
+	closeSent     bool
+	header        [10]byte
+	buffers       [2][]byte
+
+	readDeadline atomic.Int64 // Unix nanoseconds, 0 for none
+
+	// Only the poller touches these.
+	frame []byte // the start of a frame split across reads
+	skip  int    // payload of a data frame still to be discarded
+
+	done sync.Once
+}
+
+// Upgrade completes the WebSocket handshake of r itself and returns the
+// connection, which starts being read once it is watched. Extensions such
+// as compression are never negotiated.
+func (p *Poller) Upgrade(w http.ResponseWriter, r *http.Request, responseHeader http.Header, checkOrigin func(*http.Request) bool) (*pollConn, error) {
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return nil, fmt.Errorf("websocket: not a WebSocket handshake from %s", r.RemoteAddr)
+	}
+	if r.Header.Get("Sec-Websocket-Version") != "13" {
+		w.Header().Set("Sec-Websocket-Version", "13")
+		http.Error(w, "Upgrade Required", http.StatusUpgradeRequired)
+		return nil, fmt.Errorf("websocket: unsupported version from %s", r.RemoteAddr)
+	}
+	key := r.Header.Get("Sec-Websocket-Key")
+	if key == "" {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return nil, fmt.Errorf("websocket: no key from %s", r.RemoteAddr)
+	}
+	if !checkOrigin(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil, fmt.Errorf("websocket: origin %q not allowed", r.Header.Get("Origin"))
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, fmt.Errorf("websocket: response cannot be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	if rw.Reader.Buffered() > 0 {
+		conn.Close()
+		return nil, fmt.Errorf("websocket: %s sent data before the handshake completed", r.RemoteAddr)
+	}
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		conn.Close()
+		return nil, fmt.Errorf("websocket: %T cannot be polled", conn)
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	var response strings.Builder
+	response.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: ")
+	response.WriteString(acceptKey(key))
+	response.WriteString("\r\n")
+	responseHeader.Write(&response)
+	response.WriteString("\r\n")
+
+	conn.SetDeadline(time.Now().Add(pollControlTimeout))
+	if _, err := conn.Write([]byte(response.String())); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	return &pollConn{conn: conn, raw: raw, poller: p}, nil
+}
+
+// acceptKey is the Sec-WebSocket-Accept answering key, RFC 6455 4.2.2.
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// headerHasToken reports whether the comma separated header name of h lists
+// token, in any case.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// watch has the poller read the connection for client, whose readDone closes
+// once the viewer goes away, like after ReadHandler.
+func (p *pollConn) watch(client *Client) {
+	p.client = client
+	client.extendReadDeadline()
+	if err := p.poller.add(p); err != nil {
+		client.logger.Printf("Client %s cannot be polled: %v\n", client.id, err)
+		p.end()
+	}
+}
+
+// end stops reading the connection and tells the client. The connection is
+// left to the client to close.
+func (p *pollConn) end() {
+	p.done.Do(func() {
+		p.poller.remove(p)
+		if p.client != nil {
+			close(p.client.readDone)
+		}
+	})
+}
+
+func (p *pollConn) RemoteAddr() net.Addr {
+	return p.conn.RemoteAddr()
+}
+
+func (p *pollConn) SetReadDeadline(t time.Time) error {
+	if t.IsZero() {
+		p.readDeadline.Store(0)
+	} else {
+		p.readDeadline.Store(t.UnixNano())
+	}
+	return nil
+}
+
+// expired reports whether the read deadline passed by now.
+func (p *pollConn) expired(now time.Time) bool {
+	deadline := p.readDeadline.Load()
+	return deadline != 0 && now.UnixNano() > deadline
+}
+
+func (p *pollConn) SetWriteDeadline(t time.Time) error {
+	p.writeLock.Lock()
+	defer p.writeLock.Unlock()
+
+	p.writeDeadline = t
+	return nil
+}
+
+func (p *pollConn) WriteMessage(messageType int, data []byte) error {
+	p.writeLock.Lock()
+	defer p.writeLock.Unlock()
+
+	return p.writeFrame(messageType, data, p.writeDeadline)
+}
+
+func (p *pollConn) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	p.writeLock.Lock()
+	defer p.writeLock.Unlock()
+
+	return p.writeFrame(messageType, data, deadline)
+}
+
+// writeFrame writes one unfragmented frame with the write lock held. The
+// message types of the websocket package are the frame opcodes.
+func (p *pollConn) writeFrame(opcode int, payload []byte, deadline time.Time) error {
+	if p.closeSent {
+		return errPollCloseSent
+	}
+	if opcode == websocket.CloseMessage {
+		p.closeSent = true
+	}
+
+	p.header[0] = 0x80 | byte(opcode)
+	n := 2
+	switch size := len(payload); {
+	case size <= maxControlPayload:
+		p.header[1] = byte(size)
+	case size <= 0xffff:
+		p.header[1] = 126
+		binary.BigEndian.PutUint16(p.header[2:], uint16(size))
+		n = 4
+	default:
+		p.header[1] = 127
+		binary.BigEndian.PutUint64(p.header[2:], uint64(size))
+		n = 10
+	}
+
+	p.conn.SetWriteDeadline(deadline)
+	p.buffers = [2][]byte{p.header[:n], payload}
+	buffers := net.Buffers(p.buffers[:])
+	_, err := buffers.WriteTo(p.conn)
+	p.buffers = [2][]byte{}
+	return err
+}
+
+// Close stops the poller reading the connection and closes it.
+func (p *pollConn) Close() error {
+	p.end()
+	return p.conn.Close()
+}
+
+// consume parses what the viewer sent, handling control frames and
+// discarding data frames. It returns an error when reading is over,
+// errPollClosed once the viewer sent its close frame.
+func (p *pollConn) consume(data []byte) error {
+	if len(p.frame) > 0 {
+		p.frame = append(p.frame, data...)
+		data = p.frame
+	}
+
+	for len(data) > 0 {
+		if p.skip > 0 {
+			n := min(p.skip, len(data))
+			p.skip -= n
+			data = data[n:]
+			continue
+		}
+
+		header, size, mask, ok, err := parseFrameHeader(data)
+		if err != nil {
+			return err
+		}
+		opcode := int(data[0] & 0x0f)
+		if !ok || (opcode&0x08 != 0 && len(data) < header+size) {
+			// Wait for the rest of the header, or of the control frame.
+			p.frame = append([]byte(nil), data...)
+			return nil
+		}
+		data = data[header:]
+		p.client.extendReadDeadline()
+
+		if opcode&0x08 == 0 {
+			p.skip = size
+			continue
+		}
+		payload := data[:size]
+		data = data[size:]
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+		if err := p.control(opcode, payload); err != nil {
+			return err
+		}
+	}
+
+	p.frame = nil
+	return nil
+}
+
+// parseFrameHeader returns the header and payload sizes of the frame data
+// starts with and its mask, with ok false while data is shorter than the
+// header.
+func parseFrameHeader(data []byte) (header, size int, mask [4]byte, ok bool, err error) {
+	if len(data) < 2 {
+		return 0, 0, mask, false, nil
+	}
+	if data[0]&0x70 != 0 || data[1]&0x80 == 0 {
+		// Reserved bits without an extension, or an unmasked client frame.
+		return 0, 0, mask, false, errPollProtocol
+	}
+	opcode := data[0] & 0x0f
+	control := opcode&0x08 != 0
+
+	header = 2
+	length := uint64(data[1] & 0x7f)
+	switch length {
+	case 126:
+		header = 4
+	case 127:
+		header = 10
+	}
+	if control && (header != 2 || data[0]&0x80 == 0) {
+		// Control frames are short and never fragmented.
+		return 0, 0, mask, false, errPollProtocol
+	}
+	if len(data) < header+4 {
+		return 0, 0, mask, false, nil
+	}
+	switch header {
+	case 4:
+		length = uint64(binary.BigEndian.Uint16(data[2:]))
+	case 10:
+		length = binary.BigEndian.Uint64(data[2:])
+	}
+	if length > 1<<31 {
+		return 0, 0, mask, false, errPollProtocol
+	}
+	copy(mask[:], data[header:])
+	return header + 4, int(length), mask, true, nil
+}
+
+// control answers a ping, takes note of a pong and answers a close frame,
+// after which the connection is done.
+func (p *pollConn) control(opcode int, payload []byte) error {
+	switch opcode {
+	case websocket.PingMessage:
+		pong := append([]byte(nil), payload...)
+		go p.WriteControl(websocket.PongMessage, pong, time.Now().Add(pollControlTimeout))
+	case websocket.PongMessage:
+	case websocket.CloseMessage:
+		code := websocket.CloseNoStatusReceived
+		if len(payload) >= 2 {
+			code = int(binary.BigEndian.Uint16(payload))
+		}
+		go p.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, ""), time.Now().Add(pollControlTimeout))
+		return errPollClosed
+	default:
+		return errPollProtocol
+	}
+	return nil
+}